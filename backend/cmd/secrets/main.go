@@ -0,0 +1,68 @@
+// secrets 是管理加密憑證檔案 (secrets.type 為 "file") 的命令列工具。
+//
+//	SECRETS_FILE_MASTER_KEY=... go run ./cmd/secrets set kv/report-scheduler/kibana-prod -token xxx
+//	SECRETS_FILE_MASTER_KEY=... go run ./cmd/secrets set kv/report-scheduler/grafana -username admin -password xxx
+//	SECRETS_FILE_MASTER_KEY=... go run ./cmd/secrets delete kv/report-scheduler/grafana
+//	SECRETS_FILE_MASTER_KEY=... go run ./cmd/secrets list
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"report-scheduler/backend/internal/config"
+	"report-scheduler/backend/internal/secrets"
+)
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "用法: secrets <set|delete|list> [ref] [-username 使用者] [-password 密碼] [-token token]")
+	os.Exit(2)
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+	}
+	cfg, err := config.LoadConfig(".")
+	if err != nil {
+		log.Fatalf("無法讀取設定檔: %v", err)
+	}
+	m, err := secrets.NewFileSecretsManager(cfg.Secrets.File.Path, cfg.Secrets.File.MasterKey)
+	if err != nil {
+		log.Fatalf("無法開啟憑證檔案: %v", err)
+	}
+
+	switch command := os.Args[1]; command {
+	case "list":
+		for _, ref := range m.Refs() {
+			fmt.Println(ref)
+		}
+	case "set", "delete":
+		if len(os.Args) < 3 {
+			usage()
+		}
+		ref := os.Args[2]
+		if command == "delete" {
+			if err := m.DeleteCredentials(ref); err != nil {
+				log.Fatalf("無法刪除憑證: %v", err)
+			}
+			return
+		}
+
+		fs := flag.NewFlagSet("set", flag.ExitOnError)
+		var creds secrets.Credentials
+		fs.StringVar(&creds.Username, "username", "", "使用者名稱")
+		fs.StringVar(&creds.Password, "password", "", "密碼")
+		fs.StringVar(&creds.Token, "token", "", "API token")
+		fs.Parse(os.Args[3:])
+		if creds == (secrets.Credentials{}) {
+			log.Fatalf("至少需要指定 -username、-password 或 -token 其中之一")
+		}
+		if err := m.SetCredentials(ref, creds); err != nil {
+			log.Fatalf("無法儲存憑證: %v", err)
+		}
+	default:
+		usage()
+	}
+}
@@ -2,16 +2,21 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
 	"os"
 	"os/signal"
 	"report-scheduler/backend/internal/api"
+	"report-scheduler/backend/internal/artifacts"
 	"report-scheduler/backend/internal/config"
+	"report-scheduler/backend/internal/delivery"
+	"report-scheduler/backend/internal/discovery"
 	"report-scheduler/backend/internal/generator"
 	"report-scheduler/backend/internal/models"
 	"report-scheduler/backend/internal/queue"
+	"report-scheduler/backend/internal/retention"
 	"report-scheduler/backend/internal/scheduler"
 	"report-scheduler/backend/internal/secrets"
 	"report-scheduler/backend/internal/store"
@@ -24,47 +29,198 @@ import (
 	"github.com/go-chi/chi/v5/middleware"
 )
 
-func newProcessFunc(s store.Store, genFactory *generator.Factory) worker.ProcessFunc {
-	return func(task *queue.Task) error {
+func newProcessFunc(s store.Store, genFactory *generator.Factory, artifactStore artifacts.Store, signer *artifacts.Signer, sender delivery.Sender, maxAttachmentSize int64, limiter *worker.DataSourceLimiter) worker.ProcessFunc {
+	return func(ctx context.Context, task *queue.Task) error {
 		startTime := time.Now()
 		log.Printf("任務 %s: 開始處理 (來自排程 %s)", task.ID, task.ScheduleID)
 
-		schedule, err := s.GetScheduleByID(context.Background(), task.ScheduleID)
+		schedule, err := s.GetScheduleByID(ctx, task.ScheduleID)
 		if err != nil || schedule == nil {
-			return fmt.Errorf("處理任務 %s 時找不到對應的排程 %s", task.ID, task.ScheduleID)
+			return fmt.Errorf("%w: 處理任務 %s 時找不到對應的排程 %s", worker.ErrNoRetry, task.ID, task.ScheduleID)
 		}
 
 		var lastErr error
 		var reportURLs []string
-		for _, reportID := range task.ReportIDs {
-			reportDef, _ := s.GetReportDefinitionByID(context.Background(), reportID)
-			dataSource, _ := s.GetDataSourceByID(context.Background(), reportDef.DataSourceID)
-			gen, _ := genFactory.GetGenerator(dataSource.Type)
-			result, err := gen.Generate(task, dataSource, reportDef)
+		var attachments []delivery.Attachment
+		var reportDefs []models.ReportDefinition
+		var fileLinks []string
+		var results []models.ReportResult
+		// 本機的暫存檔在寄出郵件後就不再需要，檔案已另外存入檔案儲存
+		defer func() {
+			for _, attachment := range attachments {
+				os.Remove(attachment.FilePath)
+			}
+		}()
+		// generateReport 產生並上傳單一報表，已上傳的檔案記錄在 result.Artifacts 中
+		generateReport := func(reportID string, result *models.ReportResult) error {
+			reportDef, err := s.GetReportDefinitionByID(ctx, reportID)
+			if err != nil || reportDef == nil {
+				return fmt.Errorf("找不到報表定義 %s", reportID)
+			}
+			result.ReportName = reportDef.Name
+			if from, to, ok, err := generator.ResolveReportTimeRange(task, reportDef); err != nil {
+				return err
+			} else if ok {
+				result.TimeFrom, result.TimeTo = &from, &to
+			}
+			dataSource, err := s.GetDataSourceByID(ctx, reportDef.DataSourceID)
+			if err != nil || dataSource == nil {
+				return fmt.Errorf("找不到報表 %s 的資料來源 %s", reportDef.Name, reportDef.DataSourceID)
+			}
+			gen, err := genFactory.GetGenerator(dataSource.Type)
+			if err != nil {
+				return err
+			}
+			reportDefs = append(reportDefs, *reportDef)
+			// 限制同一個資料來源同時產生的報表數量，避免多個 Worker 同時壓垮同一台 Kibana
+			release, err := limiter.Acquire(ctx, dataSource.ID)
 			if err != nil {
+				return err
+			}
+			generated, err := gen.Generate(ctx, task, dataSource, reportDef)
+			release()
+			if err != nil {
+				return err
+			}
+			// 無法合併到 PDF 的輸出 (例如 CSV) 以獨立的檔案附上
+			outputs := append([]generator.GenerateResult{*generated}, generated.Extra...)
+			first := len(attachments)
+			for _, output := range outputs {
+				attachments = append(attachments, delivery.Attachment{FilePath: output.FilePath, MimeType: output.MimeType})
+			}
+			for i, output := range outputs {
+				key := artifacts.NewKey(task.ID, output.FilePath, task.CreatedAt)
+				size, err := artifacts.PutFile(ctx, artifactStore, key, output.FilePath, output.MimeType)
+				if err != nil {
+					return err
+				}
+				result.Artifacts = append(result.Artifacts, models.ReportArtifact{Key: key, MimeType: output.MimeType, Size: size})
+				link := signer.URL(key)
+				attachments[first+i].Link = link
+				fileLinks = append(fileLinks, link)
+			}
+			return nil
+		}
+
+		succeeded := 0
+		var failures []string
+		for _, reportID := range task.ReportIDs {
+			// 任務被取消或超過執行期限時，不再產生剩下的報表
+			if err := ctx.Err(); err != nil {
+				lastErr = err
+				break
+			}
+			reportStart := time.Now()
+			result := models.ReportResult{ReportID: reportID, Status: models.LogStatusSuccess, Artifacts: models.ReportArtifacts{}}
+			attachmentCount, linkCount, defCount := len(attachments), len(fileLinks), len(reportDefs)
+			if err := generateReport(reportID, &result); err != nil {
+				log.Printf("任務 %s: 報表 %s 產生失敗: %v", task.ID, reportID, err)
 				lastErr = err
-				continue
+				result.Status = models.LogStatusFailed
+				result.ErrorMessage = err.Error()
+				name := result.ReportName
+				if name == "" {
+					name = reportID
+				}
+				failures = append(failures, fmt.Sprintf("%s: %v", name, err))
+				// 失敗的報表不放進郵件，避免寄出不完整的檔案
+				for _, attachment := range attachments[attachmentCount:] {
+					os.Remove(attachment.FilePath)
+				}
+				attachments, fileLinks, reportDefs = attachments[:attachmentCount], fileLinks[:linkCount], reportDefs[:defCount]
+			} else {
+				succeeded++
+			}
+			result.Duration = time.Since(reportStart).Milliseconds()
+			// 失敗前已上傳的檔案也要記錄下來，讓保留期限到期時一併清除
+			for _, artifact := range result.Artifacts {
+				reportURLs = append(reportURLs, artifact.Key)
 			}
-			reportURLs = append(reportURLs, result.FilePath)
+			results = append(results, result)
+		}
+
+		// 所有報表都產生成功後才寄送郵件，避免收件者收到不完整的報表。
+		// 不再重試時若仍有部分報表成功，則寄出這些報表，並以 partial 狀態在郵件中說明失敗的報表
+		status := models.LogStatusSuccess
+		if lastErr != nil {
+			status = models.LogStatusFailed
+			if ctx.Err() == nil && succeeded > 0 && !task.CanRetry() {
+				status = models.LogStatusPartial
+			}
+		}
+		msg := &delivery.Message{
+			Recipients:  schedule.Recipients,
+			Attachments: attachments,
+		}
+		if status != models.LogStatusFailed && len(msg.AllRecipients()) > 0 {
+			var err error
+			msg.Subject, msg.Body, err = delivery.RenderMessage(schedule.EmailSubject, schedule.EmailBody, &delivery.TemplateData{
+				Schedule:     schedule,
+				Reports:      reportDefs,
+				TriggerTime:  task.Reference(),
+				Status:       status,
+				ErrorMessage: strings.Join(failures, "\n"),
+				FileLinks:    fileLinks,
+			})
+			if err != nil {
+				// 未知變數會原樣保留，仍然寄出郵件，只記錄警告
+				log.Printf("警告：任務 %s 的郵件樣板有誤: %v", task.ID, err)
+			}
+			if err := msg.LinkOversizedAttachments(maxAttachmentSize); err != nil {
+				lastErr = errors.Join(lastErr, err)
+			} else if err := sender.Send(ctx, msg); err != nil {
+				lastErr = errors.Join(lastErr, fmt.Errorf("寄送郵件失敗: %w", err))
+			}
+		}
+
+		// 服務停止時被中止的執行不算一次嘗試：Worker 會將任務歸還佇列，之後從頭執行，
+		// 因此不寫入歷史紀錄，並清除這次已上傳的檔案
+		if lastErr != nil && errors.Is(context.Cause(ctx), worker.ErrShuttingDown) {
+			log.Printf("任務 %s: 因服務停止而中止，不記錄此次執行", task.ID)
+			for _, key := range reportURLs {
+				if err := artifactStore.Delete(context.WithoutCancel(ctx), key); err != nil {
+					log.Printf("警告：無法刪除中止的任務 %s 已上傳的檔案 %s: %v", task.ID, key, err)
+				}
+			}
+			return lastErr
 		}
 
 		duration := time.Since(startTime)
+		reference := task.Reference()
 		logEntry := &models.HistoryLog{
 			ScheduleID:        task.ScheduleID,
 			ScheduleName:      schedule.Name,
 			TriggerTime:       task.CreatedAt,
+			ReferenceTime:     &reference,
 			ExecutionDuration: duration.Milliseconds(),
 			Recipients:        schedule.Recipients,
+			ReportURL:         strings.Join(reportURLs, ", "),
+			Reports:           results,
 		}
 
 		if lastErr != nil {
+			// 還有剩餘重試次數時記錄為重試中，由 Worker 負責延遲後重新執行；被取消的任務不會重試。
+			// 不再重試時，若只有部分報表成功則記錄為部分成功
 			logEntry.Status = models.LogStatusFailed
-			logEntry.ErrorMessage = lastErr.Error()
+			if errors.Is(context.Cause(ctx), worker.ErrTaskCancelled) {
+				logEntry.ErrorMessage = fmt.Sprintf("第 %d 次嘗試已被取消", task.Attempt+1)
+			} else {
+				if task.CanRetry() {
+					logEntry.Status = models.LogStatusRetrying
+				}
+				logEntry.ErrorMessage = fmt.Sprintf("第 %d 次嘗試失敗: %v", task.Attempt+1, lastErr)
+			}
+			if logEntry.Status == models.LogStatusFailed && succeeded > 0 && succeeded < len(task.ReportIDs) {
+				logEntry.Status = models.LogStatusPartial
+			}
 		} else {
 			logEntry.Status = models.LogStatusSuccess
-			logEntry.ReportURL = strings.Join(reportURLs, ", ")
 		}
-		return s.CreateHistoryLog(context.Background(), logEntry)
+		// 任務被取消時仍要留下歷史紀錄
+		if err := s.CreateHistoryLog(context.WithoutCancel(ctx), logEntry); err != nil {
+			return err
+		}
+		return lastErr
 	}
 }
 
@@ -79,15 +235,41 @@ func main() {
 		defer logFile.Close()
 	}
 
-	cfg, _ := config.LoadConfig(".")
+	cfg, err := config.LoadConfig(".")
+	if err != nil {
+		log.Fatalf("無法讀取設定: %v", err)
+	}
 	dbStore, _ := store.NewStore(cfg)
-	secretsManager := secrets.NewMockSecretsManager()
-	taskQueue := queue.NewInMemoryQueue(100)
+	secretsManager, err := secrets.NewSecretsManager(cfg)
+	if err != nil {
+		log.Fatalf("無法建立憑證管理服務: %v", err)
+	}
+	mailSender, err := delivery.NewSender(cfg)
+	if err != nil {
+		log.Fatalf("無法建立郵件寄送服務: %v", err)
+	}
+	taskQueue, err := queue.NewQueue(cfg)
+	if err != nil {
+		log.Fatalf("無法建立任務佇列: %v", err)
+	}
+	artifactStore, err := artifacts.NewStore(cfg)
+	if err != nil {
+		log.Fatalf("無法建立檔案儲存: %v", err)
+	}
+	linkSigner, err := artifacts.NewSigner(cfg.Artifacts)
+	if err != nil {
+		log.Fatalf("無法建立下載連結簽署器: %v", err)
+	}
+	if cfg.Artifacts.SigningKey == "" {
+		log.Printf("警告：未設定 artifacts.signing_key，下載連結在伺服器重新啟動後將會失效")
+	}
+	janitor := retention.NewJanitor(dbStore, artifactStore, cfg.Retention)
 	genFactory := generator.NewFactory(dbStore, secretsManager)
 	appScheduler := scheduler.NewScheduler(dbStore, taskQueue)
-	processFunc := newProcessFunc(dbStore, genFactory)
-	appWorker := worker.NewWorker(taskQueue, processFunc)
-	apiHandler := api.NewAPIHandler(dbStore, secretsManager, taskQueue)
+	processFunc := newProcessFunc(dbStore, genFactory, artifactStore, linkSigner, mailSender, cfg.SMTP.MaxAttachmentSize, worker.NewDataSourceLimiter(cfg.Worker))
+	appWorker := worker.NewWorkerPool(taskQueue, processFunc, cfg.Worker)
+	appWorker.Store = dbStore
+	apiHandler := api.NewAPIHandler(dbStore, secretsManager, taskQueue, appScheduler, artifactStore, linkSigner, janitor, appWorker, discovery.NewService(secretsManager))
 
 	r := chi.NewRouter()
 	r.Use(middleware.RequestID, middleware.RealIP, middleware.Logger, middleware.Recoverer)
@@ -118,19 +300,30 @@ func main() {
 		r.Route("/schedules", func(r chi.Router) {
 			r.Get("/", apiHandler.GetSchedules)
 			r.Post("/", apiHandler.CreateSchedule)
+			r.Get("/next-runs", apiHandler.PreviewNextRuns)
 			r.Route("/{scheduleID}", func(r chi.Router) {
 				r.Get("/", apiHandler.GetScheduleByID)
 				r.Put("/", apiHandler.UpdateSchedule)
 				r.Delete("/", apiHandler.DeleteSchedule)
 				r.Post("/trigger", apiHandler.TriggerSchedule)
+				r.Post("/preview-email", apiHandler.PreviewScheduleEmail)
+				r.Get("/next-runs", apiHandler.GetScheduleNextRuns)
 			})
 		})
 		r.Route("/history", func(r chi.Router) {
 			r.Get("/", apiHandler.GetHistory)
 			r.Post("/{log_id}/resend", apiHandler.ResendHistoryLog)
 		})
+		r.Route("/tasks", func(r chi.Router) {
+			r.Get("/", apiHandler.GetTasks)
+			r.Route("/{id}", func(r chi.Router) {
+				r.Get("/", apiHandler.GetTaskByID)
+				r.Post("/cancel", apiHandler.CancelTask)
+			})
+		})
+		r.Post("/retention/run", apiHandler.RunRetention)
 		// 檔案服務路由
-		r.Get("/files/{filename}", apiHandler.ServeFile)
+		r.Get("/files/*", apiHandler.ServeFile)
 	})
 
 	// Frontend static file serving
@@ -139,6 +332,7 @@ func main() {
 
 	// Start background services
 	appWorker.Start()
+	janitor.Start()
 	go func() {
 		if err := appScheduler.Start(); err != nil {
 			log.Fatalf("Scheduler failed to start: %v", err)
@@ -167,6 +361,7 @@ func main() {
 	<-schedulerCtx.Done()
 
 	appWorker.Stop()
+	janitor.Stop()
 	taskQueue.Close()
 	dbStore.Close()
 
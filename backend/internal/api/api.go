@@ -2,29 +2,49 @@ package api
 
 import (
 	"encoding/json"
+	"errors"
+	"io"
+	"mime"
 	"net/http"
-	"os"
-	"path/filepath"
+	"path"
+	"report-scheduler/backend/internal/artifacts"
+	"report-scheduler/backend/internal/discovery"
 	"report-scheduler/backend/internal/queue"
+	"report-scheduler/backend/internal/retention"
+	"report-scheduler/backend/internal/scheduler"
 	"report-scheduler/backend/internal/secrets"
 	"report-scheduler/backend/internal/store"
+	"report-scheduler/backend/internal/worker"
+	"strconv"
 
 	"github.com/go-chi/chi/v5"
 )
 
 // APIHandler 是一個包含所有應用程式依賴的結構
 type APIHandler struct {
-	Store   store.Store
-	Secrets secrets.SecretsManager
-	Queue   queue.Queue
+	Store     store.Store
+	Secrets   secrets.SecretsManager
+	Queue     queue.Queue
+	Scheduler *scheduler.Scheduler
+	Artifacts artifacts.Store
+	Signer    *artifacts.Signer
+	Janitor   *retention.Janitor
+	Worker    *worker.Worker
+	Discovery *discovery.Service
 }
 
 // NewAPIHandler 建立並回傳一個新的 APIHandler
-func NewAPIHandler(s store.Store, sm secrets.SecretsManager, q queue.Queue) *APIHandler {
+func NewAPIHandler(s store.Store, sm secrets.SecretsManager, q queue.Queue, sch *scheduler.Scheduler, as artifacts.Store, signer *artifacts.Signer, janitor *retention.Janitor, wk *worker.Worker, disc *discovery.Service) *APIHandler {
 	return &APIHandler{
-		Store:   s,
-		Secrets: sm,
-		Queue:   q,
+		Store:     s,
+		Secrets:   sm,
+		Queue:     q,
+		Scheduler: sch,
+		Artifacts: as,
+		Signer:    signer,
+		Janitor:   janitor,
+		Worker:    wk,
+		Discovery: disc,
 	}
 }
 
@@ -46,22 +66,40 @@ func (h *APIHandler) respondWithJSON(w http.ResponseWriter, code int, payload in
 	w.Write(response)
 }
 
-// ServeFile 處理提供暫存檔案的請求
+// ServeFile 處理下載已儲存報表檔案的請求，路徑的其餘部分即為檔案的 key。
+// 請求必須帶有 Signer 產生的 expires 與 signature 參數。
 func (h *APIHandler) ServeFile(w http.ResponseWriter, r *http.Request) {
-	filename := chi.URLParam(r, "filename")
-	if filename == "" {
+	key := chi.URLParam(r, "*")
+	if key == "" {
 		h.respondWithError(w, http.StatusBadRequest, "缺少檔案名稱")
 		return
 	}
+	if err := artifacts.ValidateKey(key); err != nil {
+		h.respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	query := r.URL.Query()
+	if err := h.Signer.Verify(key, query.Get("expires"), query.Get("signature")); err != nil {
+		h.respondWithError(w, http.StatusForbidden, err.Error())
+		return
+	}
 
-	// 警告：這是一個簡化的實作。在正式產品中，需要更嚴格的路徑清理和安全檢查，
-	// 以防止目錄遍歷攻擊 (directory traversal)。
-	filePath := filepath.Join(os.TempDir(), filename)
-
-	if _, err := os.Stat(filePath); os.IsNotExist(err) {
+	body, info, err := h.Artifacts.Open(r.Context(), key)
+	if errors.Is(err, artifacts.ErrNotFound) {
 		h.respondWithError(w, http.StatusNotFound, "找不到指定的檔案")
 		return
 	}
+	if err != nil {
+		h.respondWithError(w, http.StatusInternalServerError, "無法讀取檔案: "+err.Error())
+		return
+	}
+	defer body.Close()
 
-	http.ServeFile(w, r, filePath)
+	w.Header().Set("Content-Type", info.ContentType)
+	if info.Size >= 0 {
+		w.Header().Set("Content-Length", strconv.FormatInt(info.Size, 10))
+	}
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("inline", map[string]string{"filename": path.Base(key)}))
+	w.WriteHeader(http.StatusOK)
+	io.Copy(w, body)
 }
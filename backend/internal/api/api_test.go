@@ -0,0 +1,94 @@
+package api
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"report-scheduler/backend/internal/artifacts"
+	"report-scheduler/backend/internal/config"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/require"
+)
+
+func TestServeFile(t *testing.T) {
+	artifactStore, err := artifacts.NewLocalStore(t.TempDir())
+	require.NoError(t, err)
+	key := "reports/2024/05/06/task-1/report.pdf"
+	require.NoError(t, artifactStore.Put(context.Background(), key, strings.NewReader("%PDF-1.7"), 8, "application/pdf"))
+
+	// 儲存區外放一個不應該被讀到的檔案
+	secretFile, err := os.CreateTemp("", "secret-*.txt")
+	require.NoError(t, err)
+	secretFile.WriteString("secret")
+	secretFile.Close()
+	defer os.Remove(secretFile.Name())
+
+	signer, err := artifacts.NewSigner(config.ArtifactsConfig{SigningKey: "test-signing-key", LinkTTL: time.Hour})
+	require.NoError(t, err)
+	apiHandler := &APIHandler{Artifacts: artifactStore, Signer: signer}
+	r := chi.NewRouter()
+	r.Get("/api/v1/files/*", apiHandler.ServeFile)
+	server := httptest.NewServer(r)
+	defer server.Close()
+
+	t.Run("serves stored artifact", func(t *testing.T) {
+		resp, err := http.Get(server.URL + signer.URL(key))
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		require.Equal(t, "application/pdf", resp.Header.Get("Content-Type"))
+		require.Equal(t, "8", resp.Header.Get("Content-Length"))
+		require.Contains(t, resp.Header.Get("Content-Disposition"), "report.pdf")
+		body, _ := io.ReadAll(resp.Body)
+		require.Equal(t, "%PDF-1.7", string(body))
+	})
+
+	t.Run("missing artifact", func(t *testing.T) {
+		resp, err := http.Get(server.URL + signer.URL("reports/2024/05/06/task-1/missing.pdf"))
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		require.Equal(t, http.StatusNotFound, resp.StatusCode)
+	})
+
+	t.Run("rejects unsigned and tampered links", func(t *testing.T) {
+		signed, err := url.Parse(signer.URL(key))
+		require.NoError(t, err)
+		query := signed.Query()
+
+		expired := url.Values{"expires": {"1700000000"}, "signature": {query.Get("signature")}}
+		otherKey := strings.Replace(key, "task-1", "task-2", 1)
+		for _, target := range []string{
+			"/api/v1/files/" + key,
+			"/api/v1/files/" + key + "?expires=" + query.Get("expires"),
+			"/api/v1/files/" + key + "?" + expired.Encode(),
+			"/api/v1/files/" + otherKey + "?" + signed.RawQuery,
+		} {
+			resp, err := http.Get(server.URL + target)
+			require.NoError(t, err)
+			resp.Body.Close()
+			require.Equal(t, http.StatusForbidden, resp.StatusCode, target)
+		}
+	})
+
+	t.Run("rejects path traversal", func(t *testing.T) {
+		for _, target := range []string{
+			"/api/v1/files/..%2F..%2F" + strings.TrimPrefix(secretFile.Name(), "/"),
+			"/api/v1/files/reports/%2E%2E/%2E%2E/x",
+			"/api/v1/files/reports%5C..%5Cx",
+		} {
+			resp, err := http.Get(server.URL + target + "?" + url.Values{"expires": {"9999999999"}, "signature": {"x"}}.Encode())
+			require.NoError(t, err)
+			body, _ := io.ReadAll(resp.Body)
+			resp.Body.Close()
+			require.NotEqual(t, http.StatusOK, resp.StatusCode, target)
+			require.NotContains(t, string(body), "secret", target)
+		}
+	})
+}
@@ -2,9 +2,13 @@ package api
 
 import (
 	"encoding/json"
+	"errors"
 	"log"
 	"net/http"
+	"report-scheduler/backend/internal/discovery"
 	"report-scheduler/backend/internal/models"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/go-chi/chi/v5"
@@ -68,6 +72,8 @@ func (h *APIHandler) UpdateDataSource(w http.ResponseWriter, r *http.Request) {
 		h.respondWithError(w, http.StatusInternalServerError, "無法更新資料來源")
 		return
 	}
+	// 網址或憑證可能已經改變，之前查到的元素不再可靠
+	h.Discovery.Invalidate(id)
 	h.respondWithJSON(w, http.StatusOK, map[string]string{"message": "資料來源 " + id + " 已成功更新"})
 }
 
@@ -78,6 +84,7 @@ func (h *APIHandler) DeleteDataSource(w http.ResponseWriter, r *http.Request) {
 		h.respondWithError(w, http.StatusInternalServerError, "無法刪除資料來源")
 		return
 	}
+	h.Discovery.Invalidate(id)
 	h.respondWithJSON(w, http.StatusOK, map[string]string{"message": "資料來源 " + id + " 已成功刪除"})
 }
 
@@ -129,25 +136,64 @@ func (h *APIHandler) ValidateDataSource(w http.ResponseWriter, r *http.Request)
 	h.respondWithJSON(w, http.StatusOK, map[string]string{"status": "verified", "message": "資料來源連線驗證成功"})
 }
 
-// GetDataSourceElements 處理獲取資料來源下可用元素的請求 (模擬)
+// GetDataSourceElements 處理搜尋資料來源下可用元素的請求，回傳一頁結果與符合條件的總數。
+// 查詢參數：`q` 依標題搜尋、`type` 篩選元素類型 (可重複或以逗號分隔)、`space` 指定 Kibana space，
+// 並以 `page`、`per_page` 分頁；結果會短暫快取，`refresh=true` 會略過快取重新查詢。
 func (h *APIHandler) GetDataSourceElements(w http.ResponseWriter, r *http.Request) {
-	dataSourceID := chi.URLParam(r, "datasourceID")
-	log.Printf("正在為資料來源 %s 獲取可用元素...", dataSourceID)
-
-	if dataSourceID == "ds-4" {
-		demoElements := []models.AvailableElement{
-			{ID: "security-detection-rule-monitoring-default", Type: "dashboard", Title: "[Demo] Security Detection Rule Monitoring"},
-			{ID: "kubernetes-f4dc26db-1b53-4ea2-a78b-1bfab8ea267c", Type: "dashboard", Title: "[Demo] Kubernetes Overview"},
-			{ID: "elastic_agent-0600ffa0-6b5e-11ed-98de-67bdecd21824", Type: "dashboard", Title: "[Demo] Elastic Agent Overview"},
-		}
-		h.respondWithJSON(w, http.StatusOK, demoElements)
+	id := chi.URLParam(r, "datasourceID")
+	ds, err := h.Store.GetDataSourceByID(r.Context(), id)
+	if err != nil {
+		h.respondWithError(w, http.StatusInternalServerError, "無法獲取資料來源: "+err.Error())
 		return
 	}
+	if ds == nil {
+		h.respondWithError(w, http.StatusNotFound, "找不到指定的資料來源")
+		return
+	}
+
+	query := r.URL.Query()
+	q := discovery.Query{
+		Text:    query.Get("q"),
+		Space:   query.Get("space"),
+		Page:    1,
+		PerPage: discovery.DefaultPerPage,
+	}
+	for _, value := range query["type"] {
+		for _, t := range strings.Split(value, ",") {
+			if t = strings.TrimSpace(t); t != "" {
+				q.Types = append(q.Types, models.ReportElementType(t))
+			}
+		}
+	}
+	if value := query.Get("page"); value != "" {
+		page, err := strconv.Atoi(value)
+		if err != nil || page < 1 {
+			h.respondWithError(w, http.StatusBadRequest, "'page' 必須是大於 0 的整數")
+			return
+		}
+		q.Page = page
+	}
+	if value := query.Get("per_page"); value != "" {
+		perPage, err := strconv.Atoi(value)
+		if err != nil || perPage < 1 || perPage > discovery.MaxPerPage {
+			h.respondWithError(w, http.StatusBadRequest, "'per_page' 必須介於 1 到 "+strconv.Itoa(discovery.MaxPerPage)+" 之間")
+			return
+		}
+		q.PerPage = perPage
+	}
+	if query.Get("refresh") == "true" {
+		h.Discovery.Invalidate(ds.ID)
+	}
 
-	defaultMockElements := []models.AvailableElement{
-		{ID: "kibana:dashboard:722b74f0-b882-11e8-a6d9-e546fe2bba5f", Type: "dashboard", Title: "[eCommerce] Revenue Dashboard"},
-		{ID: "kibana:visualization:89382180-b883-11e8-a6d9-e546fe2bba5f", Type: "visualization", Title: "[Flights] Flight Count and Average Ticket Price"},
-		{ID: "kibana:dashboard:a5419300-b883-11e8-a6d9-e546fe2bba5f", Type: "dashboard", Title: "[Logs] Web Traffic"},
+	page, err := h.Discovery.Discover(r.Context(), ds, q)
+	if errors.Is(err, discovery.ErrInvalidQuery) {
+		h.respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err != nil {
+		log.Printf("無法從資料來源 %s 獲取可用元素: %v", ds.ID, err)
+		h.respondWithError(w, http.StatusBadGateway, "無法從資料來源獲取可用元素: "+err.Error())
+		return
 	}
-	h.respondWithJSON(w, http.StatusOK, defaultMockElements)
+	h.respondWithJSON(w, http.StatusOK, page)
 }
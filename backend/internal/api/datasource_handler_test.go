@@ -7,11 +7,17 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"report-scheduler/backend/internal/artifacts"
 	"report-scheduler/backend/internal/config"
+	"report-scheduler/backend/internal/discovery"
 	"report-scheduler/backend/internal/models"
 	"report-scheduler/backend/internal/queue"
+	"report-scheduler/backend/internal/retention"
+	"report-scheduler/backend/internal/scheduler"
 	"report-scheduler/backend/internal/secrets"
 	"report-scheduler/backend/internal/store"
+	"report-scheduler/backend/internal/worker"
+	"sync/atomic"
 	"testing"
 
 	"github.com/go-chi/chi/v5"
@@ -39,7 +45,19 @@ func newTestHandler(t *testing.T) (http.Handler, store.Store, queue.Queue, func(
 	secretsManager := secrets.NewMockSecretsManager()
 	taskQueue := queue.NewInMemoryQueue(10)
 
-	apiHandler := NewAPIHandler(dbStore, secretsManager, taskQueue)
+	appScheduler := scheduler.NewScheduler(dbStore, taskQueue)
+
+	artifactStore, err := artifacts.NewLocalStore(tempDir + "/artifacts")
+	require.NoError(t, err)
+
+	linkSigner, err := artifacts.NewSigner(config.ArtifactsConfig{SigningKey: "test-signing-key"})
+	require.NoError(t, err)
+
+	// 測試用的 Worker 不會啟動，只用來記錄被取消的任務；需要處理任務的測試會自行啟動 Worker
+	appWorker := worker.NewWorker(taskQueue, nil)
+	appWorker.Store = dbStore
+
+	apiHandler := NewAPIHandler(dbStore, secretsManager, taskQueue, appScheduler, artifactStore, linkSigner, retention.NewJanitor(dbStore, artifactStore, config.RetentionConfig{}), appWorker, discovery.NewService(secretsManager))
 	r := chi.NewRouter()
 
 	// 路由設定必須跟 main.go 完全一樣
@@ -52,6 +70,7 @@ func newTestHandler(t *testing.T) (http.Handler, store.Store, queue.Queue, func(
 				r.Put("/", apiHandler.UpdateDataSource)
 				r.Delete("/", apiHandler.DeleteDataSource)
 				r.Post("/validate", apiHandler.ValidateDataSource)
+				r.Get("/elements", apiHandler.GetDataSourceElements)
 			})
 		})
 		r.Route("/reports", func(r chi.Router) {
@@ -66,11 +85,14 @@ func newTestHandler(t *testing.T) (http.Handler, store.Store, queue.Queue, func(
 		r.Route("/schedules", func(r chi.Router) {
 			r.Get("/", apiHandler.GetSchedules)
 			r.Post("/", apiHandler.CreateSchedule)
+			r.Get("/next-runs", apiHandler.PreviewNextRuns)
 			r.Route("/{scheduleID}", func(r chi.Router) {
 				r.Get("/", apiHandler.GetScheduleByID)
 				r.Put("/", apiHandler.UpdateSchedule)
 				r.Delete("/", apiHandler.DeleteSchedule)
 				r.Post("/trigger", apiHandler.TriggerSchedule)
+				r.Post("/preview-email", apiHandler.PreviewScheduleEmail)
+				r.Get("/next-runs", apiHandler.GetScheduleNextRuns)
 			})
 		})
 
@@ -79,6 +101,16 @@ func newTestHandler(t *testing.T) (http.Handler, store.Store, queue.Queue, func(
 			r.Get("/", apiHandler.GetHistory)
 			r.Post("/{log_id}/resend", apiHandler.ResendHistoryLog)
 		})
+		r.Route("/tasks", func(r chi.Router) {
+			r.Get("/", apiHandler.GetTasks)
+			r.Route("/{id}", func(r chi.Router) {
+				r.Get("/", apiHandler.GetTaskByID)
+				r.Post("/cancel", apiHandler.CancelTask)
+			})
+		})
+		r.Post("/retention/run", apiHandler.RunRetention)
+
+		r.Get("/files/*", apiHandler.ServeFile)
 	})
 
 	cleanup := func() {
@@ -202,4 +234,64 @@ func TestDatasourceAPI_WithRealDB(t *testing.T) {
 		json.NewDecoder(resp.Body).Decode(&validatedDS)
 		require.Equal(t, models.Verified, validatedDS.Status, "資料來源狀態應更新為 verified")
 	})
+
+	// 8. 測試從 Kibana 即時搜尋可用元素
+	t.Run("discover elements", func(t *testing.T) {
+		var requests atomic.Int32
+		kibana := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			requests.Add(1)
+			if r.URL.Query().Get("search") == "broken*" {
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+			require.Equal(t, "/s/ops/api/saved_objects/_find", r.URL.Path)
+			w.Write([]byte(`{"page":1,"per_page":10,"total":1,"saved_objects":[{"id":"dash-1","type":"dashboard","attributes":{"title":"Web Traffic"}}]}`))
+		}))
+		defer kibana.Close()
+
+		dsJSON := `{"name": "Kibana to Discover", "type": "kibana", "url": "` + kibana.URL + `", "auth_type": "none", "status": "verified"}`
+		resp, err := http.Post(server.URL+"/api/v1/datasources", "application/json", bytes.NewBuffer([]byte(dsJSON)))
+		require.NoError(t, err)
+		var ds models.DataSource
+		json.NewDecoder(resp.Body).Decode(&ds)
+		resp.Body.Close()
+
+		getElements := func(dsID, query string) (int, *discovery.Page) {
+			resp, err := http.Get(server.URL + "/api/v1/datasources/" + dsID + "/elements" + query)
+			require.NoError(t, err)
+			defer resp.Body.Close()
+			var page discovery.Page
+			if resp.StatusCode == http.StatusOK {
+				require.NoError(t, json.NewDecoder(resp.Body).Decode(&page))
+			}
+			return resp.StatusCode, &page
+		}
+
+		const query = "?q=traffic&type=dashboard,lens&space=ops&per_page=10"
+		status, page := getElements(ds.ID, query)
+		require.Equal(t, http.StatusOK, status)
+		require.Equal(t, 1, page.Total)
+		require.Equal(t, []models.AvailableElement{{ID: "dash-1", Type: "dashboard", Title: "Web Traffic"}}, page.Elements)
+
+		// 相同的查詢使用快取，refresh 或修改資料來源後重新查詢
+		getElements(ds.ID, query)
+		require.Equal(t, int32(1), requests.Load())
+		getElements(ds.ID, query+"&refresh=true")
+		require.Equal(t, int32(2), requests.Load())
+		req, _ := http.NewRequest(http.MethodPut, server.URL+"/api/v1/datasources/"+ds.ID, bytes.NewBufferString(dsJSON))
+		resp, err = http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		resp.Body.Close()
+		getElements(ds.ID, query)
+		require.Equal(t, int32(3), requests.Load())
+
+		status, _ = getElements(ds.ID, "?q=broken")
+		require.Equal(t, http.StatusBadGateway, status)
+		status, _ = getElements(ds.ID, "?type=panel")
+		require.Equal(t, http.StatusBadRequest, status, "Kibana 沒有 Grafana 面板")
+		status, _ = getElements(ds.ID, "?per_page=101")
+		require.Equal(t, http.StatusBadRequest, status)
+		status, _ = getElements("missing", "")
+		require.Equal(t, http.StatusNotFound, status)
+	})
 }
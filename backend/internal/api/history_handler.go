@@ -3,6 +3,7 @@ package api
 import (
 	"fmt"
 	"net/http"
+	"report-scheduler/backend/internal/artifacts"
 	"report-scheduler/backend/internal/queue"
 	"time"
 
@@ -30,6 +31,19 @@ func (h *APIHandler) GetHistory(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// 為每個報表檔案附上有期限的簽章下載連結
+	for i := range logs {
+		for _, key := range artifacts.SplitKeys(logs[i].ReportURL) {
+			logs[i].ReportLinks = append(logs[i].ReportLinks, h.Signer.URL(key))
+		}
+		for j := range logs[i].Reports {
+			for k := range logs[i].Reports[j].Artifacts {
+				artifact := &logs[i].Reports[j].Artifacts[k]
+				artifact.Link = h.Signer.URL(artifact.Key)
+			}
+		}
+	}
+
 	h.respondWithJSON(w, http.StatusOK, logs)
 }
 
@@ -66,18 +80,18 @@ func (h *APIHandler) ResendHistoryLog(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// 3. 建立一個新的任務並推入佇列
-	// 規格要求使用原始的 TriggerTime 作為時間基準，但在此 MVP 中，我們重新建立一個新的任務
-	task := &queue.Task{
-		ID:         fmt.Sprintf("resend-%s-%d", logEntry.ID, time.Now().Unix()),
-		ScheduleID: schedule.ID,
-		ReportIDs:  schedule.ReportIDs,
-		CreatedAt:  time.Now(), // 使用當前時間作為新的觸發時間
-	}
+	// 以原始執行的時間基準解析時間範圍，讓重新產生的報表涵蓋與原本相同的時間區間。
+	// 重試或重寄的任務建立時間會晚於原本的執行，因此不能使用 TriggerTime
+	task := queue.NewScheduleTask(fmt.Sprintf("resend-%s-%d", logEntry.ID, time.Now().Unix()), schedule)
+	task.ReferenceTime = logEntry.Reference()
 
-	if err := h.Queue.Enqueue(ctx, task); err != nil {
+	if err := h.Scheduler.Dispatch(ctx, task, schedule.Name); err != nil {
 		h.respondWithError(w, http.StatusInternalServerError, fmt.Sprintf("無法將重寄任務加入佇列: %v", err))
 		return
 	}
 
-	h.respondWithJSON(w, http.StatusAccepted, map[string]string{"message": "已成功將重寄任務加入佇列"})
+	h.respondWithJSON(w, http.StatusAccepted, map[string]string{
+		"message": "已成功將重寄任務加入佇列",
+		"task_id": task.ID,
+	})
 }
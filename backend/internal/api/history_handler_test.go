@@ -5,7 +5,10 @@ import (
 	"encoding/json"
 	"net/http"
 	"net/http/httptest"
+	"report-scheduler/backend/internal/generator"
 	"report-scheduler/backend/internal/models"
+	"report-scheduler/backend/internal/queue"
+	"strings"
 	"testing"
 	"time"
 
@@ -25,12 +28,15 @@ func TestHistoryAPI_WithRealDB(t *testing.T) {
 	err := dbStore.CreateSchedule(context.Background(), schedule)
 	require.NoError(t, err)
 
-	// 2. 為這個 schedule 手動建立兩筆歷史紀錄
+	// 2. 為這個 schedule 手動建立兩筆歷史紀錄，第二筆只有部分報表成功
+	to := time.Now().UTC().Truncate(time.Second)
+	from := to.Add(-24 * time.Hour)
 	logEntry1 := &models.HistoryLog{
 		ScheduleID:   schedule.ID,
 		ScheduleName: schedule.Name,
 		TriggerTime:  time.Now().Add(-1 * time.Hour),
 		Status:       models.LogStatusSuccess,
+		ReportURL:    "reports/2024/05/06/task-1/a.pdf, reports/2024/05/06/task-1/b.csv",
 	}
 	err = dbStore.CreateHistoryLog(context.Background(), logEntry1)
 	require.NoError(t, err)
@@ -39,8 +45,21 @@ func TestHistoryAPI_WithRealDB(t *testing.T) {
 		ScheduleID:   schedule.ID,
 		ScheduleName: schedule.Name,
 		TriggerTime:  time.Now(),
-		Status:       models.LogStatusFailed,
+		Status:       models.LogStatusPartial,
 		ErrorMessage: "something went wrong",
+		ReportURL:    "reports/2024/05/06/task-2/a.pdf",
+		Reports: []models.ReportResult{
+			{
+				ReportID:   "report-a",
+				ReportName: "報表 A",
+				Status:     models.LogStatusSuccess,
+				Duration:   1200,
+				TimeFrom:   &from,
+				TimeTo:     &to,
+				Artifacts:  models.ReportArtifacts{{Key: "reports/2024/05/06/task-2/a.pdf", MimeType: "application/pdf", Size: 2048}},
+			},
+			{ReportID: "report-b", ReportName: "報表 B", Status: models.LogStatusFailed, ErrorMessage: "something went wrong", Duration: 300},
+		},
 	}
 	err = dbStore.CreateHistoryLog(context.Background(), logEntry2)
 	require.NoError(t, err)
@@ -69,6 +88,39 @@ func TestHistoryAPI_WithRealDB(t *testing.T) {
 		// 驗證回傳的順序是依照 trigger_time 降序排列
 		require.Equal(t, logEntry2.ID, logs[0].ID)
 		require.Equal(t, logEntry1.ID, logs[1].ID)
+
+		// 每個報表檔案都附上簽章下載連結
+		require.Len(t, logs[0].ReportLinks, 1)
+		require.Len(t, logs[1].ReportLinks, 2)
+		require.Empty(t, logs[1].Reports)
+
+		// 每份報表的結果依原本的順序回傳，成功的報表保留檔案資訊
+		require.Equal(t, models.LogStatusPartial, logs[0].Status)
+		require.Len(t, logs[0].Reports, 2)
+		succeeded, failed := logs[0].Reports[0], logs[0].Reports[1]
+		require.Equal(t, logEntry2.ID, succeeded.HistoryLogID)
+		require.Equal(t, "report-a", succeeded.ReportID)
+		require.Equal(t, models.LogStatusSuccess, succeeded.Status)
+		require.Equal(t, int64(1200), succeeded.Duration)
+		require.True(t, from.Equal(*succeeded.TimeFrom))
+		require.True(t, to.Equal(*succeeded.TimeTo))
+		require.Len(t, succeeded.Artifacts, 1)
+		require.Equal(t, "application/pdf", succeeded.Artifacts[0].MimeType)
+		require.Equal(t, int64(2048), succeeded.Artifacts[0].Size)
+		require.True(t, strings.HasPrefix(succeeded.Artifacts[0].Link, "/api/v1/files/reports/2024/05/06/task-2/a.pdf?"))
+		require.Equal(t, "report-b", failed.ReportID)
+		require.Equal(t, models.LogStatusFailed, failed.Status)
+		require.Equal(t, "something went wrong", failed.ErrorMessage)
+		require.Nil(t, failed.TimeFrom)
+		require.Empty(t, failed.Artifacts)
+		require.True(t, strings.HasPrefix(logs[1].ReportLinks[0], "/api/v1/files/reports/2024/05/06/task-1/a.pdf?"))
+		require.Contains(t, logs[1].ReportLinks[1], "signature=")
+
+		// 簽章有效，因此回應的是找不到檔案而不是拒絕存取
+		linkResp, err := http.Get(server.URL + logs[1].ReportLinks[0])
+		require.NoError(t, err)
+		linkResp.Body.Close()
+		require.Equal(t, http.StatusNotFound, linkResp.StatusCode)
 	})
 
 	t.Run("get history for non-existent schedule id", func(t *testing.T) {
@@ -103,12 +155,60 @@ func TestHistoryAPI_WithRealDB(t *testing.T) {
 		err = json.NewDecoder(resp.Body).Decode(&result)
 		require.NoError(t, err)
 		require.Equal(t, "已成功將重寄任務加入佇列", result["message"])
+		require.NotEmpty(t, result["task_id"])
 
 		// 驗證任務已加入佇列
 		task, err := q.Dequeue(context.Background())
 		require.NoError(t, err)
 		require.NotNil(t, task)
 		require.Equal(t, schedule.ID, task.ScheduleID)
+		// 重寄以原始的觸發時間作為時間基準
+		require.True(t, logEntry1.TriggerTime.Equal(task.ReferenceTime), "want %s, got %s", logEntry1.TriggerTime, task.ReferenceTime)
+		require.True(t, task.CreatedAt.After(task.ReferenceTime))
+		require.Equal(t, result["task_id"], task.ID)
+	})
+
+	t.Run("resending a resent entry reproduces the same window", func(t *testing.T) {
+		report := &models.ReportDefinition{ID: "report-window", TimeRange: "now-7d"}
+		resend := func(logID string) *queue.Task {
+			resp, err := http.Post(server.URL+"/api/v1/history/"+logID+"/resend", "application/json", nil)
+			require.NoError(t, err)
+			resp.Body.Close()
+			require.Equal(t, http.StatusAccepted, resp.StatusCode)
+			task, err := q.Dequeue(context.Background())
+			require.NoError(t, err)
+			return task
+		}
+		// record 模擬 Worker 執行任務後寫入的歷史紀錄，觸發時間是任務的建立時間
+		record := func(task *queue.Task, triggerTime time.Time) *models.HistoryLog {
+			reference := task.Reference()
+			entry := &models.HistoryLog{ScheduleID: schedule.ID, ScheduleName: schedule.Name, TriggerTime: triggerTime, ReferenceTime: &reference, Status: models.LogStatusSuccess}
+			require.NoError(t, dbStore.CreateHistoryLog(context.Background(), entry))
+			return entry
+		}
+		window := func(task *queue.Task) (time.Time, time.Time) {
+			from, to, ok, err := generator.ResolveReportTimeRange(task, report)
+			require.NoError(t, err)
+			require.True(t, ok)
+			return from, to
+		}
+
+		// 原始的執行在重試後才成功，紀錄的觸發時間晚於解析時間範圍時的基準
+		reference := time.Now().Add(-3 * time.Hour).UTC().Truncate(time.Millisecond)
+		original := record(&queue.Task{ReferenceTime: reference}, reference.Add(time.Hour))
+		wantFrom, wantTo := window(&queue.Task{ReferenceTime: reference})
+
+		first := resend(original.ID)
+		require.True(t, reference.Equal(first.ReferenceTime), "want %s, got %s", reference, first.ReferenceTime)
+		from, to := window(first)
+		require.True(t, wantFrom.Equal(from) && wantTo.Equal(to))
+
+		// 重寄產生的紀錄再次重寄，時間範圍仍與原始報表相同
+		resent := record(first, first.CreatedAt)
+		second := resend(resent.ID)
+		from, to = window(second)
+		require.True(t, wantFrom.Equal(from), "want %s, got %s", wantFrom, from)
+		require.True(t, wantTo.Equal(to), "want %s, got %s", wantTo, to)
 	})
 
 	t.Run("resend non-existent history log", func(t *testing.T) {
@@ -3,10 +3,12 @@ package api
 import (
 	"encoding/json"
 	"net/http"
-	"path/filepath"
+	"os"
+	"report-scheduler/backend/internal/artifacts"
 	"report-scheduler/backend/internal/generator"
 	"report-scheduler/backend/internal/models"
 	"report-scheduler/backend/internal/queue"
+	"report-scheduler/backend/internal/timerange"
 	"time"
 
 	"github.com/go-chi/chi/v5"
@@ -33,6 +35,10 @@ func (h *APIHandler) CreateReportDefinition(w http.ResponseWriter, r *http.Reque
 	}
 	defer r.Body.Close()
 
+	if !h.validateReportDefinition(w, r, &rd) {
+		return
+	}
+
 	if err := h.Store.CreateReportDefinition(r.Context(), &rd); err != nil {
 		h.respondWithError(w, http.StatusInternalServerError, "無法建立報表定義")
 		return
@@ -40,6 +46,35 @@ func (h *APIHandler) CreateReportDefinition(w http.ResponseWriter, r *http.Reque
 	h.respondWithJSON(w, http.StatusCreated, rd)
 }
 
+// validateReportDefinition 在寫入資料庫之前檢查報表定義，無效時回應錯誤並回傳 false
+func (h *APIHandler) validateReportDefinition(w http.ResponseWriter, r *http.Request, rd *models.ReportDefinition) bool {
+	if err := timerange.Validate(rd.TimeRange); err != nil {
+		h.respondWithError(w, http.StatusBadRequest, "無效的時間範圍: "+err.Error())
+		return false
+	}
+
+	needsAPIURL := false
+	for _, element := range rd.Elements {
+		if element.Type == models.SavedSearchType && element.Format == models.FormatXLSX {
+			needsAPIURL = true
+		}
+	}
+	if !needsAPIURL {
+		return true
+	}
+	// XLSX 只能直接從 Elasticsearch 匯出，Kibana 的報表 API 無法產生
+	ds, err := h.Store.GetDataSourceByID(r.Context(), rd.DataSourceID)
+	if err != nil {
+		h.respondWithError(w, http.StatusInternalServerError, "無法獲取資料來源: "+err.Error())
+		return false
+	}
+	if ds == nil || ds.APIURL == "" {
+		h.respondWithError(w, http.StatusBadRequest, "XLSX 格式需要資料來源設定 Elasticsearch URL")
+		return false
+	}
+	return true
+}
+
 // GetReportDefinitionByID 處理根據 ID 獲取單一報表定義的請求
 func (h *APIHandler) GetReportDefinitionByID(w http.ResponseWriter, r *http.Request) {
 	id := chi.URLParam(r, "reportID")
@@ -65,6 +100,10 @@ func (h *APIHandler) UpdateReportDefinition(w http.ResponseWriter, r *http.Reque
 	}
 	defer r.Body.Close()
 
+	if !h.validateReportDefinition(w, r, &rd) {
+		return
+	}
+
 	if err := h.Store.UpdateReportDefinition(r.Context(), id, &rd); err != nil {
 		h.respondWithError(w, http.StatusInternalServerError, "無法更新報表定義")
 		return
@@ -119,12 +158,30 @@ func (h *APIHandler) GenerateReport(w http.ResponseWriter, r *http.Request) {
 		CreatedAt: time.Now(),
 	}
 
-	result, err := gen.Generate(fakeTask, dataSource, reportDef)
+	result, err := gen.Generate(r.Context(), fakeTask, dataSource, reportDef)
 	if err != nil {
 		h.respondWithError(w, http.StatusInternalServerError, "產生報表失敗: "+err.Error())
 		return
 	}
 
-	previewURL := "/api/v1/files/" + filepath.Base(result.FilePath)
+	// 將產生的檔案存入檔案儲存，並移除本機的暫存檔
+	outputs := append([]generator.GenerateResult{*result}, result.Extra...)
+	defer func() {
+		for _, output := range outputs {
+			os.Remove(output.FilePath)
+		}
+	}()
+	var previewURL string
+	for _, output := range outputs {
+		key := artifacts.NewKey(fakeTask.ID, output.FilePath, fakeTask.CreatedAt)
+		if _, err := artifacts.PutFile(ctx, h.Artifacts, key, output.FilePath, output.MimeType); err != nil {
+			h.respondWithError(w, http.StatusInternalServerError, "無法儲存報表檔案: "+err.Error())
+			return
+		}
+		if previewURL == "" {
+			previewURL = h.Signer.URL(key)
+		}
+	}
+
 	h.respondWithJSON(w, http.StatusOK, map[string]string{"preview_url": previewURL})
 }
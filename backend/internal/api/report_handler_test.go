@@ -55,6 +55,8 @@ func TestReportAPI_WithRealDB(t *testing.T) {
 			"name": "Daily Sales Report",
 			"datasource_id": "` + createdDS.ID + `",
 			"time_range": "now-24h",
+			"cover_page": true,
+			"table_of_contents": true,
 			"elements": ` + string(elementsJSON) + `
 		}`)
 
@@ -71,6 +73,49 @@ func TestReportAPI_WithRealDB(t *testing.T) {
 		require.Equal(t, "viz-1", createdReport.Elements[0].ID)
 	})
 
+	// 無法解析的時間範圍在儲存時就會被拒絕
+	t.Run("reject invalid time range", func(t *testing.T) {
+		for _, timeRange := range []string{"last fortnight", "now-3x", "now to now-1d"} {
+			reportJSON := []byte(`{"name": "Bad Range", "datasource_id": "` + createdDS.ID + `", "time_range": "` + timeRange + `", "elements": []}`)
+			resp, err := http.Post(server.URL+"/api/v1/reports", "application/json", bytes.NewBuffer(reportJSON))
+			require.NoError(t, err)
+			resp.Body.Close()
+			require.Equal(t, http.StatusBadRequest, resp.StatusCode, timeRange)
+		}
+
+		req, _ := http.NewRequest(http.MethodPut, server.URL+"/api/v1/reports/"+createdReport.ID, bytes.NewBufferString(`{"name": "x", "time_range": "now-1Q"}`))
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		resp.Body.Close()
+		require.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	})
+
+	// XLSX 只能從 Elasticsearch 匯出，資料來源必須設定 Elasticsearch URL
+	t.Run("reject xlsx without elasticsearch url", func(t *testing.T) {
+		xlsxElements := `[{"id": "search-1", "type": "saved_search", "format": "xlsx"}]`
+		reportJSON := []byte(`{"name": "XLSX", "datasource_id": "` + createdDS.ID + `", "time_range": "now-24h", "elements": ` + xlsxElements + `}`)
+		resp, err := http.Post(server.URL+"/api/v1/reports", "application/json", bytes.NewBuffer(reportJSON))
+		require.NoError(t, err)
+		var body map[string]string
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&body))
+		resp.Body.Close()
+		require.Equal(t, http.StatusBadRequest, resp.StatusCode)
+		require.Contains(t, body["error"], "Elasticsearch URL")
+
+		esJSON := `{"name": "ES DS", "type": "kibana", "url": "http://ds.test", "api_url": "http://es.test:9200", "auth_type": "none", "status": "verified"}`
+		resp, err = http.Post(server.URL+"/api/v1/datasources", "application/json", bytes.NewBufferString(esJSON))
+		require.NoError(t, err)
+		var esDS models.DataSource
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&esDS))
+		resp.Body.Close()
+
+		reportJSON = []byte(`{"name": "XLSX", "datasource_id": "` + esDS.ID + `", "time_range": "now-24h", "elements": ` + xlsxElements + `}`)
+		resp, err = http.Post(server.URL+"/api/v1/reports", "application/json", bytes.NewBuffer(reportJSON))
+		require.NoError(t, err)
+		resp.Body.Close()
+		require.Equal(t, http.StatusCreated, resp.StatusCode)
+	})
+
 	// 3. 透過 ID 取得剛剛建立的 report
 	t.Run("get created report by id", func(t *testing.T) {
 		require.NotEmpty(t, createdReport.ID, "created report ID should not be empty")
@@ -83,6 +128,8 @@ func TestReportAPI_WithRealDB(t *testing.T) {
 		err = json.NewDecoder(resp.Body).Decode(&fetchedReport)
 		require.NoError(t, err)
 		require.Equal(t, createdReport.ID, fetchedReport.ID)
+		require.True(t, fetchedReport.CoverPage)
+		require.True(t, fetchedReport.TableOfContents)
 	})
 
 	// 4. 刪除剛剛建立的 report
@@ -0,0 +1,26 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+)
+
+// RunRetention 處理立即執行資料保留清理的請求。
+// 查詢參數 `dry_run=true` 時只回報會被清除的執行紀錄與報表檔案，不會實際刪除。
+func (h *APIHandler) RunRetention(w http.ResponseWriter, r *http.Request) {
+	dryRun := false
+	if value := r.URL.Query().Get("dry_run"); value != "" {
+		var err error
+		if dryRun, err = strconv.ParseBool(value); err != nil {
+			h.respondWithError(w, http.StatusBadRequest, "無效的 'dry_run' 查詢參數")
+			return
+		}
+	}
+
+	report, err := h.Janitor.Run(r.Context(), dryRun)
+	if err != nil {
+		h.respondWithError(w, http.StatusInternalServerError, "清理執行紀錄失敗: "+err.Error())
+		return
+	}
+	h.respondWithJSON(w, http.StatusOK, report)
+}
@@ -0,0 +1,71 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"report-scheduler/backend/internal/models"
+	"report-scheduler/backend/internal/retention"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRetentionAPI_WithRealDB(t *testing.T) {
+	handler, dbStore, _, cleanup := newTestHandler(t)
+	defer cleanup()
+
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	retentionDays := 30
+	schedule := &models.Schedule{Name: "Retention", CronSpec: "0 0 9 * * *", Timezone: "UTC", RetentionDays: &retentionDays}
+	require.NoError(t, dbStore.CreateSchedule(context.Background(), schedule))
+	expired := &models.HistoryLog{ScheduleID: schedule.ID, ScheduleName: schedule.Name, TriggerTime: time.Now().AddDate(0, 0, -31), Status: models.LogStatusSuccess}
+	require.NoError(t, dbStore.CreateHistoryLog(context.Background(), expired))
+	recent := &models.HistoryLog{ScheduleID: schedule.ID, ScheduleName: schedule.Name, TriggerTime: time.Now().AddDate(0, 0, -29), Status: models.LogStatusSuccess}
+	require.NoError(t, dbStore.CreateHistoryLog(context.Background(), recent))
+
+	run := func(query string) (*http.Response, *retention.Report) {
+		resp, err := http.Post(server.URL+"/api/v1/retention/run"+query, "application/json", nil)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		var report retention.Report
+		if resp.StatusCode == http.StatusOK {
+			require.NoError(t, json.NewDecoder(resp.Body).Decode(&report))
+		}
+		return resp, &report
+	}
+
+	t.Run("dry run", func(t *testing.T) {
+		resp, report := run("?dry_run=true")
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		require.True(t, report.DryRun)
+		require.Len(t, report.HistoryLogs, 1)
+		require.Equal(t, expired.ID, report.HistoryLogs[0].ID)
+		require.Zero(t, report.DeletedLogs)
+
+		logs, err := dbStore.GetHistoryLogs(context.Background(), schedule.ID)
+		require.NoError(t, err)
+		require.Len(t, logs, 2)
+	})
+
+	t.Run("invalid dry_run", func(t *testing.T) {
+		resp, _ := run("?dry_run=maybe")
+		require.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	})
+
+	t.Run("run", func(t *testing.T) {
+		resp, report := run("")
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		require.False(t, report.DryRun)
+		require.Equal(t, 1, report.DeletedLogs)
+
+		logs, err := dbStore.GetHistoryLogs(context.Background(), schedule.ID)
+		require.NoError(t, err)
+		require.Len(t, logs, 1)
+		require.Equal(t, recent.ID, logs[0].ID)
+	})
+}
@@ -2,9 +2,18 @@ package api
 
 import (
 	"encoding/json"
+	"fmt"
+	"io"
+	"log"
 	"net/http"
+	"net/mail"
+	"report-scheduler/backend/internal/artifacts"
+	"report-scheduler/backend/internal/delivery"
 	"report-scheduler/backend/internal/models"
 	"report-scheduler/backend/internal/queue"
+	"report-scheduler/backend/internal/scheduler"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/go-chi/chi/v5"
@@ -32,13 +41,59 @@ func (h *APIHandler) CreateSchedule(w http.ResponseWriter, r *http.Request) {
 	}
 	defer r.Body.Close()
 
+	if err := validateSchedule(&s); err != nil {
+		h.respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
 	if err := h.Store.CreateSchedule(r.Context(), &s); err != nil {
 		h.respondWithError(w, http.StatusInternalServerError, "無法建立排程")
 		return
 	}
+	if err := h.Scheduler.AddSchedule(s); err != nil {
+		log.Printf("錯誤：無法將新排程 '%s' (ID: %s) 加入排程器: %v", s.Name, s.ID, err)
+	}
 	h.respondWithJSON(w, http.StatusCreated, s)
 }
 
+// validateSchedule 在寫入資料庫之前檢查排程設定是否有效
+func validateSchedule(s *models.Schedule) error {
+	loc, err := s.Location()
+	if err != nil {
+		return err
+	}
+	if _, err := scheduler.ParseSpec(s.CronSpec, loc); err != nil {
+		return fmt.Errorf("無效的 Cron 規格 '%s': %v", s.CronSpec, err)
+	}
+	if s.MaxRetries != nil && (*s.MaxRetries < 0 || *s.MaxRetries > maxScheduleRetries) {
+		return fmt.Errorf("重試次數必須介於 0 到 %d 之間", maxScheduleRetries)
+	}
+	if s.RetryDelaySeconds != nil && *s.RetryDelaySeconds < 0 {
+		return fmt.Errorf("重試間隔不可為負數")
+	}
+	if s.RetentionDays != nil && *s.RetentionDays < 1 {
+		return fmt.Errorf("保留天數至少為 1 天")
+	}
+	for key := range s.Parameters {
+		// 參數名稱會成為 Grafana 的 var-<名稱> 與 Kibana 的欄位名稱
+		if strings.TrimSpace(key) == "" || strings.ContainsAny(key, " \t&=#?") {
+			return fmt.Errorf("無效的動態參數名稱 '%s'", key)
+		}
+	}
+	for _, list := range [][]string{s.Recipients.To, s.Recipients.Cc, s.Recipients.Bcc} {
+		for _, addr := range list {
+			// 收件者會寫入郵件標頭，必須是單一且合法的地址
+			if _, err := mail.ParseAddress(addr); err != nil {
+				return fmt.Errorf("無效的收件者地址 '%s'", addr)
+			}
+		}
+	}
+	return nil
+}
+
+// maxScheduleRetries 是單一排程允許設定的最大重試次數
+const maxScheduleRetries = 10
+
 // GetScheduleByID 處理根據 ID 獲取單一排程的請求
 func (h *APIHandler) GetScheduleByID(w http.ResponseWriter, r *http.Request) {
 	id := chi.URLParam(r, "scheduleID")
@@ -64,10 +119,27 @@ func (h *APIHandler) UpdateSchedule(w http.ResponseWriter, r *http.Request) {
 	}
 	defer r.Body.Close()
 
+	if err := validateSchedule(&s); err != nil {
+		h.respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
 	if err := h.Store.UpdateSchedule(r.Context(), id, &s); err != nil {
 		h.respondWithError(w, http.StatusInternalServerError, "無法更新排程")
 		return
 	}
+
+	// 以資料庫中的最新狀態重新註冊排程，讓 cron 規格與啟用狀態的變更立即生效
+	updated, err := h.Store.GetScheduleByID(r.Context(), id)
+	if err != nil {
+		h.respondWithError(w, http.StatusInternalServerError, "無法獲取更新後的排程: "+err.Error())
+		return
+	}
+	if updated == nil {
+		h.Scheduler.RemoveSchedule(id)
+	} else if err := h.Scheduler.ReplaceSchedule(*updated); err != nil {
+		log.Printf("錯誤：無法重新註冊排程 '%s' (ID: %s): %v", updated.Name, updated.ID, err)
+	}
 	h.respondWithJSON(w, http.StatusOK, map[string]string{"message": "排程 " + id + " 已成功更新"})
 }
 
@@ -78,6 +150,7 @@ func (h *APIHandler) DeleteSchedule(w http.ResponseWriter, r *http.Request) {
 		h.respondWithError(w, http.StatusInternalServerError, "無法刪除排程")
 		return
 	}
+	h.Scheduler.RemoveSchedule(id)
 	h.respondWithJSON(w, http.StatusOK, map[string]string{"message": "排程 " + id + " 已成功刪除"})
 }
 
@@ -95,14 +168,9 @@ func (h *APIHandler) TriggerSchedule(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	task := &queue.Task{
-		ID:         uuid.New().String(),
-		ScheduleID: schedule.ID,
-		ReportIDs:  schedule.ReportIDs,
-		CreatedAt:  time.Now(),
-	}
+	task := queue.NewScheduleTask(uuid.New().String(), schedule)
 
-	if err := h.Queue.Enqueue(r.Context(), task); err != nil {
+	if err := h.Scheduler.Dispatch(r.Context(), task, schedule.Name); err != nil {
 		h.respondWithError(w, http.StatusInternalServerError, "無法將任務推入佇列: "+err.Error())
 		return
 	}
@@ -112,3 +180,137 @@ func (h *APIHandler) TriggerSchedule(w http.ResponseWriter, r *http.Request) {
 		"task_id": task.ID,
 	})
 }
+
+// previewEmailRequest 是預覽郵件 API 的選填請求內容，
+// 讓前端可以在儲存之前預覽尚未存檔的主旨與內文。
+type previewEmailRequest struct {
+	EmailSubject *string    `json:"email_subject,omitempty"`
+	EmailBody    *string    `json:"email_body,omitempty"`
+	TriggerTime  *time.Time `json:"trigger_time,omitempty"`
+}
+
+// PreviewScheduleEmail 使用排程的郵件樣板產生預覽內容，不會實際寄出郵件
+func (h *APIHandler) PreviewScheduleEmail(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	scheduleID := chi.URLParam(r, "scheduleID")
+
+	// 請求內容為選填，空的 body 代表直接使用已儲存的樣板
+	var req previewEmailRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+		h.respondWithError(w, http.StatusBadRequest, "無效的請求內容")
+		return
+	}
+	defer r.Body.Close()
+
+	schedule, err := h.Store.GetScheduleByID(ctx, scheduleID)
+	if err != nil {
+		h.respondWithError(w, http.StatusInternalServerError, "無法獲取排程: "+err.Error())
+		return
+	}
+	if schedule == nil {
+		h.respondWithError(w, http.StatusNotFound, "找不到指定的排程")
+		return
+	}
+
+	subjectTmpl, bodyTmpl := schedule.EmailSubject, schedule.EmailBody
+	if req.EmailSubject != nil {
+		subjectTmpl = *req.EmailSubject
+	}
+	if req.EmailBody != nil {
+		bodyTmpl = *req.EmailBody
+	}
+	triggerTime := time.Now()
+	if req.TriggerTime != nil {
+		triggerTime = *req.TriggerTime
+	}
+
+	data := &delivery.TemplateData{
+		Schedule:    schedule,
+		TriggerTime: triggerTime,
+		Status:      models.LogStatusSuccess,
+	}
+	for _, reportID := range schedule.ReportIDs {
+		reportDef, err := h.Store.GetReportDefinitionByID(ctx, reportID)
+		if err != nil {
+			h.respondWithError(w, http.StatusInternalServerError, "無法獲取報表定義: "+err.Error())
+			return
+		}
+		if reportDef == nil {
+			continue
+		}
+		data.Reports = append(data.Reports, *reportDef)
+		// 預覽時尚未產生任何檔案，以明顯的示意文字代替下載連結，避免產生看似可用卻無法下載的連結
+		data.FileLinks = append(data.FileLinks, fmt.Sprintf("<%s.pdf 的下載連結>", artifacts.FileName(reportDef.Name)))
+	}
+
+	subject, body, err := delivery.RenderMessage(subjectTmpl, bodyTmpl, data)
+	if err != nil {
+		h.respondWithError(w, http.StatusBadRequest, "無法產生郵件預覽: "+err.Error())
+		return
+	}
+	h.respondWithJSON(w, http.StatusOK, map[string]string{
+		"subject": subject,
+		"body":    body,
+	})
+}
+
+const (
+	defaultNextRunsCount = 5
+	maxNextRunsCount     = 100
+)
+
+// GetScheduleNextRuns 回傳已儲存排程接下來的觸發時間
+func (h *APIHandler) GetScheduleNextRuns(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "scheduleID")
+	s, err := h.Store.GetScheduleByID(r.Context(), id)
+	if err != nil {
+		h.respondWithError(w, http.StatusInternalServerError, "無法獲取排程: "+err.Error())
+		return
+	}
+	if s == nil {
+		h.respondWithError(w, http.StatusNotFound, "找不到指定的排程")
+		return
+	}
+	h.respondWithNextRuns(w, r, s.CronSpec, s.Timezone)
+}
+
+// PreviewNextRuns 根據查詢參數中的 cron_spec 與 timezone 預覽觸發時間，
+// 讓前端在儲存排程之前就能驗證 Cron 表達式。
+func (h *APIHandler) PreviewNextRuns(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	if q.Get("cron_spec") == "" {
+		h.respondWithError(w, http.StatusBadRequest, "缺少 'cron_spec' 查詢參數")
+		return
+	}
+	h.respondWithNextRuns(w, r, q.Get("cron_spec"), q.Get("timezone"))
+}
+
+func (h *APIHandler) respondWithNextRuns(w http.ResponseWriter, r *http.Request, cronSpec, timezone string) {
+	count := defaultNextRunsCount
+	if v := r.URL.Query().Get("count"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxNextRunsCount {
+			h.respondWithError(w, http.StatusBadRequest, fmt.Sprintf("'count' 必須是 1 到 %d 之間的整數", maxNextRunsCount))
+			return
+		}
+		count = n
+	}
+
+	s := &models.Schedule{CronSpec: cronSpec, Timezone: timezone}
+	loc, err := s.Location()
+	if err != nil {
+		h.respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	cronSchedule, err := scheduler.ParseSpec(cronSpec, loc)
+	if err != nil {
+		h.respondWithError(w, http.StatusBadRequest, fmt.Sprintf("無效的 Cron 規格 '%s': %v", cronSpec, err))
+		return
+	}
+
+	h.respondWithJSON(w, http.StatusOK, map[string]interface{}{
+		"cron_spec": cronSpec,
+		"timezone":  loc.String(),
+		"next_runs": scheduler.NextRuns(cronSchedule, time.Now(), count, loc),
+	})
+}
@@ -9,10 +9,13 @@ import (
 	"log"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
 	"os"
+	"report-scheduler/backend/internal/config"
 	"report-scheduler/backend/internal/generator"
 	"report-scheduler/backend/internal/models"
 	"report-scheduler/backend/internal/queue"
+	"report-scheduler/backend/internal/scheduler"
 	"report-scheduler/backend/internal/secrets"
 	"report-scheduler/backend/internal/store"
 	"report-scheduler/backend/internal/worker"
@@ -20,12 +23,13 @@ import (
 	"testing"
 	"time"
 
+	"github.com/go-chi/chi/v5"
 	"github.com/stretchr/testify/require"
 )
 
 // newTestProcessFunc is a helper for tests, mimicking the one in main.go
 func newTestProcessFunc(s store.Store, genFactory *generator.Factory) worker.ProcessFunc {
-	return func(task *queue.Task) error {
+	return func(ctx context.Context, task *queue.Task) error {
 		startTime := time.Now()
 		log.Printf("測試 Worker: 開始處理任務 %s", task.ID)
 
@@ -58,7 +62,7 @@ func newTestProcessFunc(s store.Store, genFactory *generator.Factory) worker.Pro
 				continue
 			}
 
-			result, err := gen.Generate(task, dataSource, reportDef)
+			result, err := gen.Generate(ctx, task, dataSource, reportDef)
 			if err != nil {
 				log.Printf("任務 %s: 錯誤：產生報表 '%s' 失敗: %v", task.ID, reportDef.Name, err)
 				lastErr = err
@@ -94,10 +98,16 @@ func TestEndToEndTriggerWithReportGeneration(t *testing.T) {
 	defer cleanup()
 
 	// 1. 建立一個模擬外部服務的 http server (Mock Kibana)
+	// 建立報表工作後回傳下載路徑，再由下載路徑提供報表內容
 	mockKibana := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		require.Equal(t, "POST", r.Method)
 		require.Equal(t, "ApiKey mock-api-token-12345", r.Header.Get("Authorization"))
-		w.WriteHeader(http.StatusOK)
+		if r.Method == http.MethodPost {
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte(`{"path":"/api/reporting/jobs/download/e2e-job","job":{"id":"e2e-job","status":"pending"}}`))
+			return
+		}
+		require.Equal(t, "/api/reporting/jobs/download/e2e-job", r.URL.Path)
+		w.Header().Set("Content-Type", "application/pdf")
 		w.Write([]byte("dummy-pdf-content"))
 	}))
 	defer mockKibana.Close()
@@ -107,6 +117,7 @@ func TestEndToEndTriggerWithReportGeneration(t *testing.T) {
 	genFactory := generator.NewFactory(dbStore, secretsManager)
 	processFunc := newTestProcessFunc(dbStore, genFactory)
 	appWorker := worker.NewWorker(taskQueue, processFunc)
+	appWorker.Store = dbStore
 	appWorker.Start()
 	defer appWorker.Stop()
 
@@ -134,7 +145,7 @@ func TestEndToEndTriggerWithReportGeneration(t *testing.T) {
 	resp.Body.Close()
 
 	// c. 建立排程
-	scheduleJSON := []byte(fmt.Sprintf(`{"name": "E2E Schedule", "report_ids": ["%s"]}`, createdReport.ID))
+	scheduleJSON := []byte(fmt.Sprintf(`{"name": "E2E Schedule", "cron_spec": "0 9 * * 1-5", "report_ids": ["%s"]}`, createdReport.ID))
 	resp, err = http.Post(server.URL+"/api/v1/schedules", "application/json", bytes.NewBuffer(scheduleJSON))
 	require.NoError(t, err)
 	require.Equal(t, http.StatusCreated, resp.StatusCode)
@@ -147,8 +158,28 @@ func TestEndToEndTriggerWithReportGeneration(t *testing.T) {
 	resp, err = http.Post(triggerURL, "application/json", nil)
 	require.NoError(t, err)
 	require.Equal(t, http.StatusAccepted, resp.StatusCode)
+	var triggered map[string]string
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&triggered))
 	resp.Body.Close()
 
+	// 以觸發時回傳的 task_id 輪詢任務直到結束
+	var task models.Task
+	require.Eventually(t, func() bool {
+		resp, err := http.Get(server.URL + "/api/v1/tasks/" + triggered["task_id"])
+		if err != nil { return false }
+		defer resp.Body.Close()
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&task))
+		return task.Status.IsFinal()
+	}, 5*time.Second, 50*time.Millisecond)
+	require.Equal(t, models.TaskStatusSucceeded, task.Status)
+	require.Equal(t, createdSchedule.ID, task.ScheduleID)
+	require.Equal(t, createdSchedule.Name, task.ScheduleName)
+	require.Contains(t, task.WorkerID, "#1")
+	require.NotNil(t, task.StartedAt)
+	require.NotNil(t, task.FinishedAt)
+	require.False(t, task.FinishedAt.Before(*task.StartedAt))
+
 	// 6. 驗證結果
 	require.Eventually(t, func() bool {
 		historyURL := server.URL + "/api/v1/history?schedule_id=" + createdSchedule.ID
@@ -175,3 +206,360 @@ func TestEndToEndTriggerWithReportGeneration(t *testing.T) {
 		return true
 	}, 5*time.Second, 100*time.Millisecond, "expected worker to create a history log with a valid report file")
 }
+
+func TestPreviewScheduleEmail(t *testing.T) {
+	handler, dbStore, _, cleanup := newTestHandler(t)
+	defer cleanup()
+
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	schedule := &models.Schedule{
+		Name:         "Preview Schedule",
+		CronSpec:     "0 9 * * 1-5",
+		Timezone:     "Asia/Taipei",
+		EmailSubject: "[每日報表] {{report_name}} - {{date}}",
+		EmailBody:    "{{schedule_name}} 報表期間: {{time_range}}",
+		ReportIDs:    []string{"report-1"}, // 種子資料中的報表
+	}
+	require.NoError(t, dbStore.CreateSchedule(context.Background(), schedule))
+
+	t.Run("render saved templates", func(t *testing.T) {
+		body := []byte(`{"trigger_time": "2024-03-04T20:30:00Z"}`)
+		resp, err := http.Post(server.URL+"/api/v1/schedules/"+schedule.ID+"/preview-email", "application/json", bytes.NewBuffer(body))
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+
+		var result map[string]string
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&result))
+		require.Equal(t, "[每日報表] Elastic Agent 狀態儀表板 - 2024-03-05", result["subject"])
+		require.Equal(t, "Preview Schedule 報表期間: 2024-02-27 04:30 ~ 2024-03-05 04:30", result["body"])
+	})
+
+	t.Run("render unsaved template overrides", func(t *testing.T) {
+		body := []byte(`{"email_subject": "{{schedule_name}}", "email_body": "{{report_count}}"}`)
+		resp, err := http.Post(server.URL+"/api/v1/schedules/"+schedule.ID+"/preview-email", "application/json", bytes.NewBuffer(body))
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+
+		var result map[string]string
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&result))
+		require.Equal(t, "Preview Schedule", result["subject"])
+		require.Equal(t, "1", result["body"])
+	})
+
+	t.Run("file links are unsigned placeholders", func(t *testing.T) {
+		body := []byte(`{"email_body": "{{file_links}}", "trigger_time": "2024-03-04T20:30:00Z"}`)
+		resp, err := http.Post(server.URL+"/api/v1/schedules/"+schedule.ID+"/preview-email", "application/json", bytes.NewBuffer(body))
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+
+		var result map[string]string
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&result))
+		require.Equal(t, "<Elastic Agent 狀態儀表板.pdf 的下載連結>", result["body"])
+		require.NotContains(t, result["body"], "signature")
+	})
+
+	t.Run("unknown variable is rejected", func(t *testing.T) {
+		body := []byte(`{"email_subject": "{{no_such_var}}"}`)
+		resp, err := http.Post(server.URL+"/api/v1/schedules/"+schedule.ID+"/preview-email", "application/json", bytes.NewBuffer(body))
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		require.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	})
+
+	t.Run("schedule not found", func(t *testing.T) {
+		resp, err := http.Post(server.URL+"/api/v1/schedules/non-existent/preview-email", "application/json", nil)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		require.Equal(t, http.StatusNotFound, resp.StatusCode)
+	})
+}
+
+func TestScheduleAPI_HotReloadsScheduler(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "test-db-")
+	require.NoError(t, err)
+	defer os.RemoveAll(tempDir)
+
+	dbStore, err := store.NewStore(config.Config{Database: config.DBConfig{Type: "sqlite", Path: tempDir + "/test.db"}})
+	require.NoError(t, err)
+	defer dbStore.Close()
+	taskQueue := queue.NewInMemoryQueue(10)
+	defer taskQueue.Close()
+	appScheduler := scheduler.NewScheduler(dbStore, taskQueue)
+
+	apiHandler := NewAPIHandler(dbStore, secrets.NewMockSecretsManager(), taskQueue, appScheduler, nil, nil, nil, nil, nil)
+	r := chi.NewRouter()
+	r.Post("/schedules", apiHandler.CreateSchedule)
+	r.Put("/schedules/{scheduleID}", apiHandler.UpdateSchedule)
+	r.Delete("/schedules/{scheduleID}", apiHandler.DeleteSchedule)
+	server := httptest.NewServer(r)
+	defer server.Close()
+
+	// 建立後立即註冊
+	resp, err := http.Post(server.URL+"/schedules", "application/json", bytes.NewBufferString(`{"name": "Hot", "cron_spec": "0 0 9 * * *", "is_enabled": true}`))
+	require.NoError(t, err)
+	require.Equal(t, http.StatusCreated, resp.StatusCode)
+	var created models.Schedule
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&created))
+	resp.Body.Close()
+	firstEntry, ok := appScheduler.EntryID(created.ID)
+	require.True(t, ok, "新建立的排程應立即註冊到排程器")
+
+	put := func(body string) {
+		req, _ := http.NewRequest(http.MethodPut, server.URL+"/schedules/"+created.ID, bytes.NewBufferString(body))
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		resp.Body.Close()
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+	}
+
+	// 更新 cron 規格後會取得新的 Entry
+	put(`{"name": "Hot", "cron_spec": "0 0 10 * * *", "is_enabled": true}`)
+	secondEntry, ok := appScheduler.EntryID(created.ID)
+	require.True(t, ok)
+	require.NotEqual(t, firstEntry, secondEntry)
+
+	// 停用後移除
+	put(`{"name": "Hot", "cron_spec": "0 0 10 * * *", "is_enabled": false}`)
+	_, ok = appScheduler.EntryID(created.ID)
+	require.False(t, ok, "停用的排程應從排程器移除")
+
+	// 重新啟用後再次註冊
+	put(`{"name": "Hot", "cron_spec": "0 0 10 * * *", "is_enabled": true}`)
+	_, ok = appScheduler.EntryID(created.ID)
+	require.True(t, ok)
+
+	// 刪除後移除
+	req, _ := http.NewRequest(http.MethodDelete, server.URL+"/schedules/"+created.ID, nil)
+	resp, err = http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	resp.Body.Close()
+	_, ok = appScheduler.EntryID(created.ID)
+	require.False(t, ok, "刪除的排程應從排程器移除")
+}
+
+func TestScheduleAPI_RejectsInvalidTimezone(t *testing.T) {
+	handler, dbStore, _, cleanup := newTestHandler(t)
+	defer cleanup()
+
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	resp, err := http.Post(server.URL+"/api/v1/schedules", "application/json", bytes.NewBufferString(`{"name": "Bad TZ", "cron_spec": "0 0 9 * * *", "timezone": "Asia/Nowhere"}`))
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	schedule := &models.Schedule{Name: "Good TZ", CronSpec: "0 0 9 * * *", Timezone: "Asia/Taipei"}
+	require.NoError(t, dbStore.CreateSchedule(context.Background(), schedule))
+
+	req, _ := http.NewRequest(http.MethodPut, server.URL+"/api/v1/schedules/"+schedule.ID, bytes.NewBufferString(`{"name": "Good TZ", "cron_spec": "0 0 9 * * *", "timezone": "Not/AZone"}`))
+	resp, err = http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	stored, err := dbStore.GetScheduleByID(context.Background(), schedule.ID)
+	require.NoError(t, err)
+	require.Equal(t, "Asia/Taipei", stored.Timezone, "無效的更新不應寫入資料庫")
+}
+
+func TestScheduleAPI_CronValidationAndNextRuns(t *testing.T) {
+	handler, dbStore, _, cleanup := newTestHandler(t)
+	defer cleanup()
+
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	t.Run("invalid cron spec is rejected on create", func(t *testing.T) {
+		for _, body := range []string{
+			`{"name": "No Spec"}`,
+			`{"name": "Bad Spec", "cron_spec": "every morning"}`,
+		} {
+			resp, err := http.Post(server.URL+"/api/v1/schedules", "application/json", bytes.NewBufferString(body))
+			require.NoError(t, err)
+			resp.Body.Close()
+			require.Equal(t, http.StatusBadRequest, resp.StatusCode, body)
+		}
+	})
+
+	t.Run("five and six field specs are accepted", func(t *testing.T) {
+		for _, spec := range []string{"0 9 * * 1-5", "0 0 9 * * 1-5", "@weekly"} {
+			body := fmt.Sprintf(`{"name": "Valid", "cron_spec": %q, "timezone": "Asia/Taipei"}`, spec)
+			resp, err := http.Post(server.URL+"/api/v1/schedules", "application/json", bytes.NewBufferString(body))
+			require.NoError(t, err)
+			resp.Body.Close()
+			require.Equal(t, http.StatusCreated, resp.StatusCode, spec)
+		}
+	})
+
+	type nextRunsResponse struct {
+		CronSpec string      `json:"cron_spec"`
+		Timezone string      `json:"timezone"`
+		NextRuns []time.Time `json:"next_runs"`
+	}
+
+	t.Run("next runs of stored schedule", func(t *testing.T) {
+		schedule := &models.Schedule{Name: "Weekdays", CronSpec: "0 9 * * 1-5", Timezone: "Asia/Taipei"}
+		require.NoError(t, dbStore.CreateSchedule(context.Background(), schedule))
+
+		resp, err := http.Get(server.URL + "/api/v1/schedules/" + schedule.ID + "/next-runs?count=7")
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+
+		var result nextRunsResponse
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&result))
+		require.Equal(t, "Asia/Taipei", result.Timezone)
+		require.Len(t, result.NextRuns, 7)
+		taipei, _ := time.LoadLocation("Asia/Taipei")
+		for i, run := range result.NextRuns {
+			local := run.In(taipei)
+			require.Equal(t, 9, local.Hour())
+			require.NotEqual(t, time.Saturday, local.Weekday())
+			require.NotEqual(t, time.Sunday, local.Weekday())
+			_, offset := run.Zone()
+			require.Equal(t, 8*3600, offset, "回傳時間應以排程時區表示")
+			if i > 0 {
+				require.True(t, run.After(result.NextRuns[i-1]))
+			}
+		}
+	})
+
+	t.Run("spec only preview", func(t *testing.T) {
+		resp, err := http.Get(server.URL + "/api/v1/schedules/next-runs?cron_spec=" + url.QueryEscape("@hourly") + "&timezone=UTC")
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+
+		var result nextRunsResponse
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&result))
+		require.Len(t, result.NextRuns, 5) // 預設數量
+		require.Equal(t, time.Hour, result.NextRuns[1].Sub(result.NextRuns[0]))
+	})
+
+	t.Run("bad preview requests", func(t *testing.T) {
+		for _, query := range []string{
+			"",
+			"?cron_spec=" + url.QueryEscape("bad spec"),
+			"?cron_spec=" + url.QueryEscape("0 9 * * *") + "&timezone=Nowhere/City",
+			"?cron_spec=" + url.QueryEscape("0 9 * * *") + "&count=0",
+			"?cron_spec=" + url.QueryEscape("0 9 * * *") + "&count=1000",
+		} {
+			resp, err := http.Get(server.URL + "/api/v1/schedules/next-runs" + query)
+			require.NoError(t, err)
+			resp.Body.Close()
+			require.Equal(t, http.StatusBadRequest, resp.StatusCode, query)
+		}
+	})
+}
+
+func TestScheduleAPI_RetryPolicy(t *testing.T) {
+	handler, _, _, cleanup := newTestHandler(t)
+	defer cleanup()
+
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	resp, err := http.Post(server.URL+"/api/v1/schedules", "application/json", bytes.NewBufferString(`{"name": "Retry", "cron_spec": "0 9 * * *", "max_retries": 5, "retry_delay_seconds": 60}`))
+	require.NoError(t, err)
+	require.Equal(t, http.StatusCreated, resp.StatusCode)
+	var created models.Schedule
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&created))
+	resp.Body.Close()
+
+	resp, err = http.Get(server.URL + "/api/v1/schedules/" + created.ID)
+	require.NoError(t, err)
+	var fetched models.Schedule
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&fetched))
+	resp.Body.Close()
+	maxRetries, delay := fetched.RetryPolicy()
+	require.Equal(t, 5, maxRetries)
+	require.Equal(t, time.Minute, delay)
+
+	// 未設定時使用預設值
+	resp, err = http.Post(server.URL+"/api/v1/schedules", "application/json", bytes.NewBufferString(`{"name": "Default Retry", "cron_spec": "0 9 * * *"}`))
+	require.NoError(t, err)
+	var defaults models.Schedule
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&defaults))
+	resp.Body.Close()
+	maxRetries, delay = defaults.RetryPolicy()
+	require.Equal(t, models.DefaultMaxRetries, maxRetries)
+	require.Equal(t, models.DefaultRetryDelay, delay)
+
+	for _, body := range []string{
+		`{"name": "Bad", "cron_spec": "0 9 * * *", "max_retries": -1}`,
+		`{"name": "Bad", "cron_spec": "0 9 * * *", "max_retries": 100}`,
+		`{"name": "Bad", "cron_spec": "0 9 * * *", "retry_delay_seconds": -5}`,
+		`{"name": "Bad", "cron_spec": "0 9 * * *", "retention_days": 0}`,
+		`{"name": "Bad", "cron_spec": "0 9 * * *", "parameters": {"": "APAC"}}`,
+		`{"name": "Bad", "cron_spec": "0 9 * * *", "parameters": {"a&b": "APAC"}}`,
+	} {
+		resp, err := http.Post(server.URL+"/api/v1/schedules", "application/json", bytes.NewBufferString(body))
+		require.NoError(t, err)
+		resp.Body.Close()
+		require.Equal(t, http.StatusBadRequest, resp.StatusCode, body)
+	}
+}
+
+func TestScheduleAPI_Parameters(t *testing.T) {
+	handler, _, q, cleanup := newTestHandler(t)
+	defer cleanup()
+
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	resp, err := http.Post(server.URL+"/api/v1/schedules", "application/json", bytes.NewBufferString(`{"name": "APAC", "cron_spec": "0 9 * * *", "parameters": {"region": "APAC"}}`))
+	require.NoError(t, err)
+	require.Equal(t, http.StatusCreated, resp.StatusCode)
+	var created models.Schedule
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&created))
+	resp.Body.Close()
+
+	resp, err = http.Get(server.URL + "/api/v1/schedules/" + created.ID)
+	require.NoError(t, err)
+	var fetched models.Schedule
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&fetched))
+	resp.Body.Close()
+	require.Equal(t, models.Parameters{"region": "APAC"}, fetched.Parameters)
+
+	// 觸發時參數隨任務傳給 Worker
+	resp, err = http.Post(server.URL+"/api/v1/schedules/"+created.ID+"/trigger", "application/json", nil)
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusAccepted, resp.StatusCode)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	task, err := q.Dequeue(ctx)
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{"region": "APAC"}, task.Parameters)
+}
+
+func TestScheduleAPI_RejectsInvalidRecipients(t *testing.T) {
+	handler, _, _, cleanup := newTestHandler(t)
+	defer cleanup()
+
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	for _, recipients := range []string{
+		`{"to": ["ops@example.com\r\nBcc: victim@example.com"]}`,
+		`{"to": ["ops@example.com"], "cc": ["not-an-address"]}`,
+		`{"to": ["a@example.com, b@example.com"]}`,
+	} {
+		resp, err := http.Post(server.URL+"/api/v1/schedules", "application/json", bytes.NewBufferString(`{"name": "Bad", "cron_spec": "0 9 * * *", "recipients": `+recipients+`}`))
+		require.NoError(t, err)
+		resp.Body.Close()
+		require.Equal(t, http.StatusBadRequest, resp.StatusCode, recipients)
+	}
+
+	resp, err := http.Post(server.URL+"/api/v1/schedules", "application/json", bytes.NewBufferString(`{"name": "Good", "cron_spec": "0 9 * * *", "recipients": {"to": ["Ops Team <ops@example.com>"], "bcc": ["audit@example.com"]}}`))
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusCreated, resp.StatusCode)
+}
@@ -0,0 +1,108 @@
+package api
+
+import (
+	"net/http"
+	"report-scheduler/backend/internal/models"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// 任務列表預設與最多回傳的筆數
+const (
+	defaultTaskLimit = 50
+	maxTaskLimit     = 500
+)
+
+// GetTasks 處理獲取任務列表的請求，依推入佇列的時間由新到舊排序。
+// 可以用查詢參數 `schedule_id`、`status` 篩選，並以 `limit` 指定回傳筆數。
+func (h *APIHandler) GetTasks(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	filter := models.TaskFilter{
+		ScheduleID: query.Get("schedule_id"),
+		Status:     models.TaskStatus(query.Get("status")),
+		Limit:      defaultTaskLimit,
+	}
+	if filter.Status != "" && !filter.Status.IsValid() {
+		h.respondWithError(w, http.StatusBadRequest, "無效的 'status' 查詢參數")
+		return
+	}
+	if value := query.Get("limit"); value != "" {
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit < 1 || limit > maxTaskLimit {
+			h.respondWithError(w, http.StatusBadRequest, "'limit' 必須介於 1 到 "+strconv.Itoa(maxTaskLimit)+" 之間")
+			return
+		}
+		filter.Limit = limit
+	}
+
+	tasks, err := h.Store.GetTasks(r.Context(), filter)
+	if err != nil {
+		h.respondWithError(w, http.StatusInternalServerError, "無法獲取任務列表")
+		return
+	}
+	if tasks == nil {
+		tasks = []models.Task{}
+	}
+	h.respondWithJSON(w, http.StatusOK, tasks)
+}
+
+// GetTaskByID 處理獲取單一任務狀態的請求，手動觸發後可以用來輪詢任務直到結束
+func (h *APIHandler) GetTaskByID(w http.ResponseWriter, r *http.Request) {
+	task, err := h.Store.GetTaskByID(r.Context(), chi.URLParam(r, "id"))
+	if err != nil {
+		h.respondWithError(w, http.StatusInternalServerError, "無法獲取任務: "+err.Error())
+		return
+	}
+	if task == nil {
+		h.respondWithError(w, http.StatusNotFound, "找不到指定的任務")
+		return
+	}
+	h.respondWithJSON(w, http.StatusOK, task)
+}
+
+// CancelTask 處理取消任務的請求。
+// 執行中的任務會立即中止進行中的報表請求，且不會再重試；尚在佇列中 (包含等待重試) 的任務會在被取出時略過。
+func (h *APIHandler) CancelTask(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	taskID := chi.URLParam(r, "id")
+	if h.Worker == nil {
+		h.respondWithError(w, http.StatusServiceUnavailable, "Worker 服務未啟用")
+		return
+	}
+
+	task, err := h.Store.GetTaskByID(ctx, taskID)
+	if err != nil {
+		h.respondWithError(w, http.StatusInternalServerError, "無法獲取任務: "+err.Error())
+		return
+	}
+	if task == nil {
+		h.respondWithError(w, http.StatusNotFound, "找不到指定的任務")
+		return
+	}
+	if task.Status.IsFinal() {
+		h.respondWithError(w, http.StatusConflict, "任務已經結束，狀態為 "+string(task.Status))
+		return
+	}
+
+	if h.Worker.Cancel(taskID) {
+		// 執行中的任務需要等進行中的請求結束後才會真正停止，最終狀態由 Worker 記錄
+		h.respondWithJSON(w, http.StatusAccepted, map[string]string{"task_id": taskID, "status": "cancelling"})
+		return
+	}
+
+	// 只有仍在佇列中的任務可以直接標記為已取消，任何 Worker 取出時都會略過。
+	// 以條件式更新避免覆寫在這段期間被其他 Worker 取出執行或已經結束的任務
+	cancelled, err := h.Store.CancelQueuedTask(ctx, taskID, time.Now())
+	if err != nil {
+		h.respondWithError(w, http.StatusInternalServerError, "無法更新任務狀態: "+err.Error())
+		return
+	}
+	if !cancelled {
+		h.respondWithError(w, http.StatusConflict, "任務正在其他 Worker 上執行或已經結束，無法取消")
+		return
+	}
+	h.Worker.CancelQueued(taskID)
+	h.respondWithJSON(w, http.StatusAccepted, map[string]string{"task_id": taskID, "status": string(models.TaskStatusCancelled)})
+}
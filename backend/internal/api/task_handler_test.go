@@ -0,0 +1,196 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"report-scheduler/backend/internal/config"
+	"report-scheduler/backend/internal/models"
+	"report-scheduler/backend/internal/queue"
+	"report-scheduler/backend/internal/scheduler"
+	"report-scheduler/backend/internal/store"
+	"report-scheduler/backend/internal/worker"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTaskAPI_WithRealDB(t *testing.T) {
+	dbStore, err := store.NewStore(config.Config{Database: config.DBConfig{Type: "sqlite", Path: t.TempDir() + "/test.db"}})
+	require.NoError(t, err)
+	defer dbStore.Close()
+	taskQueue := queue.NewInMemoryQueue(10)
+	defer taskQueue.Close()
+	appScheduler := scheduler.NewScheduler(dbStore, taskQueue)
+
+	// slow 排程的任務會一直執行到被取消，broken 排程的任務會失敗且不重試
+	started := make(chan string, 1)
+	appWorker := worker.NewWorkerPool(taskQueue, func(ctx context.Context, task *queue.Task) error {
+		switch task.ScheduleID {
+		case "slow":
+			started <- task.ID
+			<-ctx.Done()
+			return ctx.Err()
+		case "broken":
+			return fmt.Errorf("%w: Kibana 無法連線", worker.ErrNoRetry)
+		}
+		return nil
+	}, config.WorkerConfig{Concurrency: 2})
+	appWorker.Store = dbStore
+	appWorker.Start()
+	defer appWorker.Stop()
+
+	apiHandler := NewAPIHandler(dbStore, nil, taskQueue, appScheduler, nil, nil, nil, appWorker, nil)
+	r := chi.NewRouter()
+	r.Route("/api/v1/tasks", func(r chi.Router) {
+		r.Get("/", apiHandler.GetTasks)
+		r.Route("/{id}", func(r chi.Router) {
+			r.Get("/", apiHandler.GetTaskByID)
+			r.Post("/cancel", apiHandler.CancelTask)
+		})
+	})
+	server := httptest.NewServer(r)
+	defer server.Close()
+
+	getTask := func(id string) (int, *models.Task) {
+		resp, err := http.Get(server.URL + "/api/v1/tasks/" + id)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		var task models.Task
+		if resp.StatusCode == http.StatusOK {
+			require.NoError(t, json.NewDecoder(resp.Body).Decode(&task))
+		}
+		return resp.StatusCode, &task
+	}
+	waitForStatus := func(id string, status models.TaskStatus) *models.Task {
+		var task *models.Task
+		require.Eventually(t, func() bool {
+			_, task = getTask(id)
+			return task.Status == status
+		}, 2*time.Second, 10*time.Millisecond, "task %s never became %s", id, status)
+		return task
+	}
+	cancel := func(id string) (int, map[string]string) {
+		resp, err := http.Post(server.URL+"/api/v1/tasks/"+id+"/cancel", "application/json", nil)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		var body map[string]string
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&body))
+		return resp.StatusCode, body
+	}
+	dispatch := func(scheduleID string) *queue.Task {
+		task := queue.NewScheduleTask(scheduleID+"-"+fmt.Sprint(time.Now().UnixNano()), &models.Schedule{ID: scheduleID, Name: "排程 " + scheduleID})
+		require.NoError(t, appScheduler.Dispatch(t.Context(), task, "排程 "+scheduleID))
+		return task
+	}
+
+	t.Run("task runs to completion", func(t *testing.T) {
+		task := dispatch("ok")
+		record := waitForStatus(task.ID, models.TaskStatusSucceeded)
+		require.Equal(t, "ok", record.ScheduleID)
+		require.Equal(t, "排程 ok", record.ScheduleName)
+		require.NotEmpty(t, record.WorkerID)
+		require.NotNil(t, record.StartedAt)
+		require.NotNil(t, record.FinishedAt)
+		require.Empty(t, record.ErrorMessage)
+	})
+
+	t.Run("failure is recorded", func(t *testing.T) {
+		task := dispatch("broken")
+		record := waitForStatus(task.ID, models.TaskStatusFailed)
+		require.Contains(t, record.ErrorMessage, "Kibana 無法連線")
+		require.NotNil(t, record.FinishedAt)
+
+		status, _ := cancel(task.ID)
+		require.Equal(t, http.StatusConflict, status, "已結束的任務不能取消")
+	})
+
+	t.Run("running task is cancelled", func(t *testing.T) {
+		task := dispatch("slow")
+		<-started
+		waitForStatus(task.ID, models.TaskStatusRunning)
+
+		status, body := cancel(task.ID)
+		require.Equal(t, http.StatusAccepted, status)
+		require.Equal(t, "cancelling", body["status"])
+		record := waitForStatus(task.ID, models.TaskStatusCancelled)
+		require.Contains(t, record.ErrorMessage, "context canceled")
+	})
+
+	t.Run("queued task is cancelled", func(t *testing.T) {
+		// 只建立紀錄而不推入佇列，模擬仍在佇列中等待的任務
+		queued := &models.Task{ID: "task-queued", ScheduleID: "ok", Status: models.TaskStatusQueued}
+		require.NoError(t, dbStore.CreateTask(t.Context(), queued))
+
+		status, body := cancel(queued.ID)
+		require.Equal(t, http.StatusAccepted, status)
+		require.Equal(t, "cancelled", body["status"])
+		_, record := getTask(queued.ID)
+		require.Equal(t, models.TaskStatusCancelled, record.Status)
+
+		// 之後被取出時會直接略過
+		require.NoError(t, taskQueue.Enqueue(t.Context(), &queue.Task{ID: queued.ID, ScheduleID: "slow"}))
+		next := dispatch("ok")
+		waitForStatus(next.ID, models.TaskStatusSucceeded)
+		require.Empty(t, started, "已取消的任務不應被執行")
+	})
+
+	t.Run("task running on another worker is not cancelled", func(t *testing.T) {
+		// 紀錄為執行中但不在這個 Worker 上，模擬由其他程序執行的任務
+		remote := &models.Task{ID: "task-remote", ScheduleID: "ok", Status: models.TaskStatusRunning}
+		require.NoError(t, dbStore.CreateTask(t.Context(), remote))
+
+		status, _ := cancel(remote.ID)
+		require.Equal(t, http.StatusConflict, status)
+		_, record := getTask(remote.ID)
+		require.Equal(t, models.TaskStatusRunning, record.Status)
+
+		// 回應 409 的取消不應生效：任務之後在這個 Worker 上重試時仍會執行
+		require.NoError(t, taskQueue.Enqueue(t.Context(), &queue.Task{ID: remote.ID, ScheduleID: "ok"}))
+		waitForStatus(remote.ID, models.TaskStatusSucceeded)
+	})
+
+	t.Run("unknown task", func(t *testing.T) {
+		status, _ := getTask("missing")
+		require.Equal(t, http.StatusNotFound, status)
+		status, _ = cancel("missing")
+		require.Equal(t, http.StatusNotFound, status)
+	})
+
+	t.Run("list tasks", func(t *testing.T) {
+		list := func(query string) (int, []models.Task) {
+			resp, err := http.Get(server.URL + "/api/v1/tasks" + query)
+			require.NoError(t, err)
+			defer resp.Body.Close()
+			var tasks []models.Task
+			if resp.StatusCode == http.StatusOK {
+				require.NoError(t, json.NewDecoder(resp.Body).Decode(&tasks))
+			}
+			return resp.StatusCode, tasks
+		}
+
+		status, tasks := list("")
+		require.Equal(t, http.StatusOK, status)
+		require.Len(t, tasks, 6)
+		for i := 1; i < len(tasks); i++ {
+			require.False(t, tasks[i].QueuedAt.After(tasks[i-1].QueuedAt), "應依推入佇列的時間由新到舊排序")
+		}
+
+		_, tasks = list("?schedule_id=ok&status=succeeded")
+		require.Len(t, tasks, 3)
+		_, tasks = list("?status=cancelled&limit=1")
+		require.Len(t, tasks, 1)
+		_, tasks = list("?schedule_id=none")
+		require.NotNil(t, tasks)
+		require.Empty(t, tasks)
+
+		status, _ = list("?status=done")
+		require.Equal(t, http.StatusBadRequest, status)
+		status, _ = list("?limit=0")
+		require.Equal(t, http.StatusBadRequest, status)
+	})
+}
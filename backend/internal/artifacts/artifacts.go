@@ -0,0 +1,127 @@
+// Package artifacts 負責儲存產生的報表檔案。
+// 檔案以 key (例如 "reports/2024/05/06/<任務 ID>/report.pdf") 識別，
+// 歷史紀錄中只保存 key，實際內容可以放在本機目錄或 S3 相容的物件儲存中。
+package artifacts
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"os"
+	"path"
+	"report-scheduler/backend/internal/config"
+	"strings"
+	"time"
+)
+
+// ErrNotFound 表示指定的 key 不存在
+var ErrNotFound = errors.New("找不到指定的檔案")
+
+// ObjectInfo 描述一個已儲存的檔案
+type ObjectInfo struct {
+	Key         string
+	ContentType string
+	Size        int64
+	ModTime     time.Time
+}
+
+// Store 是報表檔案儲存的介面，符合 Factory Provider 模式
+type Store interface {
+	// Put 將 r 的內容儲存為 key，size 是內容的位元組數
+	Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) error
+	// Open 讀取 key 的內容，呼叫端必須關閉回傳的 ReadCloser。key 不存在時回傳 ErrNotFound。
+	Open(ctx context.Context, key string) (io.ReadCloser, *ObjectInfo, error)
+	// Delete 刪除 key，key 不存在時不視為錯誤
+	Delete(ctx context.Context, key string) error
+}
+
+// NewStore 是檔案儲存的工廠函式，根據設定檔中的 artifacts.type 決定要回傳哪一種實作
+func NewStore(cfg config.Config) (Store, error) {
+	switch cfg.Artifacts.Type {
+	case "", "local":
+		root := cfg.Artifacts.Path
+		if root == "" {
+			root = "./data/artifacts"
+		}
+		return NewLocalStore(root)
+	case "s3":
+		return NewS3Store(cfg.Artifacts.S3)
+	default:
+		return nil, fmt.Errorf("不支援的檔案儲存類型: %s", cfg.Artifacts.Type)
+	}
+}
+
+// NewKey 產生報表檔案的 key，依日期與任務分目錄存放
+func NewKey(taskID, filename string, t time.Time) string {
+	return fmt.Sprintf("reports/%s/%s/%s", t.Format("2006/01/02"), taskID, FileName(filename))
+}
+
+// FileName 回傳檔案在 key 中使用的名稱，只保留路徑的最後一段
+func FileName(filename string) string {
+	return path.Base(filename)
+}
+
+// SplitKeys 拆開歷史紀錄 ReportURL 欄位中以 ", " 分隔的多個 key
+func SplitKeys(reportURL string) []string {
+	var keys []string
+	for _, key := range strings.Split(reportURL, ",") {
+		if key = strings.TrimSpace(key); key != "" {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+// ValidateKey 確認 key 是正規化後的相對路徑，拒絕 ".."、絕對路徑與反斜線，避免存取到儲存區以外的檔案
+func ValidateKey(key string) error {
+	if key == "" || strings.HasPrefix(key, "/") || strings.Contains(key, "\\") || path.Clean(key) != key {
+		return fmt.Errorf("無效的檔案 key: %q", key)
+	}
+	for _, segment := range strings.Split(key, "/") {
+		if segment == ".." || segment == "." {
+			return fmt.Errorf("無效的檔案 key: %q", key)
+		}
+	}
+	return nil
+}
+
+// PutFile 將本機檔案上傳為 key，並回傳檔案大小 (bytes)
+func PutFile(ctx context.Context, s Store, key, filePath, contentType string) (int64, error) {
+	f, err := os.Open(filePath)
+	if err != nil {
+		return 0, fmt.Errorf("無法開啟檔案 %s: %w", filePath, err)
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return 0, fmt.Errorf("無法讀取檔案資訊 %s: %w", filePath, err)
+	}
+	if contentType == "" {
+		contentType = ContentTypeForKey(key)
+	}
+	if err := s.Put(ctx, key, f, info.Size(), contentType); err != nil {
+		return 0, fmt.Errorf("無法儲存檔案 %s: %w", key, err)
+	}
+	return info.Size(), nil
+}
+
+// ContentTypeForKey 依副檔名推測檔案的 MIME 類型
+func ContentTypeForKey(key string) string {
+	switch strings.ToLower(path.Ext(key)) {
+	case ".pdf":
+		return "application/pdf"
+	case ".png":
+		return "image/png"
+	case ".csv":
+		return "text/csv"
+	case ".xlsx":
+		return "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"
+	}
+	if contentType := mime.TypeByExtension(path.Ext(key)); contentType != "" {
+		return contentType
+	}
+	return "application/octet-stream"
+}
@@ -0,0 +1,92 @@
+package artifacts
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/url"
+	"report-scheduler/backend/internal/config"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// FilesPath 是下載報表檔案的 API 路徑，後面接檔案的 key
+const FilesPath = "/api/v1/files/"
+
+// defaultLinkTTL 是未設定 link_ttl 時下載連結的有效期間
+const defaultLinkTTL = 7 * 24 * time.Hour
+
+var (
+	// ErrInvalidSignature 表示下載連結沒有簽章，或簽章與檔案 key 不符
+	ErrInvalidSignature = errors.New("無效的下載連結簽章")
+	// ErrLinkExpired 表示下載連結已超過有效期間
+	ErrLinkExpired = errors.New("下載連結已過期")
+)
+
+// Signer 產生與驗證下載連結。連結以 HMAC-SHA256 簽署檔案的 key 與到期時間，
+// 因此不能拿來下載其他檔案，也不能延長有效期間。
+type Signer struct {
+	secret    []byte
+	ttl       time.Duration
+	publicURL string
+	// now 可以在測試中替換
+	now func() time.Time
+}
+
+// NewSigner 根據設定建立 Signer。未設定 signing_key 時會隨機產生金鑰，重新啟動後舊連結即失效。
+func NewSigner(cfg config.ArtifactsConfig) (*Signer, error) {
+	secret := []byte(cfg.SigningKey)
+	if len(secret) == 0 {
+		secret = make([]byte, 32)
+		if _, err := rand.Read(secret); err != nil {
+			return nil, fmt.Errorf("無法產生下載連結的簽署金鑰: %w", err)
+		}
+	}
+	ttl := cfg.LinkTTL
+	if ttl <= 0 {
+		ttl = defaultLinkTTL
+	}
+	return &Signer{
+		secret:    secret,
+		ttl:       ttl,
+		publicURL: strings.TrimRight(cfg.PublicURL, "/"),
+		now:       time.Now,
+	}, nil
+}
+
+// URL 回傳 key 的簽章下載連結，有設定 public_url 時為完整網址
+func (s *Signer) URL(key string) string {
+	expires := strconv.FormatInt(s.now().Add(s.ttl).Unix(), 10)
+	query := url.Values{}
+	query.Set("expires", expires)
+	query.Set("signature", s.signature(key, expires))
+	return s.publicURL + FilesPath + (&url.URL{Path: key}).EscapedPath() + "?" + query.Encode()
+}
+
+// Verify 確認 expires 與 signature 是 key 的有效簽章，且連結尚未過期
+func (s *Signer) Verify(key, expires, signature string) error {
+	if expires == "" || signature == "" {
+		return ErrInvalidSignature
+	}
+	if !hmac.Equal([]byte(signature), []byte(s.signature(key, expires))) {
+		return ErrInvalidSignature
+	}
+	unix, err := strconv.ParseInt(expires, 10, 64)
+	if err != nil {
+		return ErrInvalidSignature
+	}
+	if !s.now().Before(time.Unix(unix, 0)) {
+		return ErrLinkExpired
+	}
+	return nil
+}
+
+func (s *Signer) signature(key, expires string) string {
+	mac := hmac.New(sha256.New, s.secret)
+	mac.Write([]byte(key + "\n" + expires))
+	return hex.EncodeToString(mac.Sum(nil))
+}
@@ -1,7 +1,9 @@
 package config
 
 import (
+	"fmt"
 	"strings"
+	"time"
 
 	"github.com/spf13/viper"
 )
@@ -12,9 +14,143 @@ type DBConfig struct {
 	Path string `mapstructure:"path"`
 }
 
+// SMTPConfig 存放郵件寄送 (SMTP) 相關的設定
+type SMTPConfig struct {
+	Host     string `mapstructure:"host"`
+	Port     int    `mapstructure:"port"`
+	Username string `mapstructure:"username"`
+	Password string `mapstructure:"password"`
+	From     string `mapstructure:"from"`
+	// TLSMode 可為 "none" (明文)、"starttls" (連線後升級) 或 "tls" (直接以 TLS 連線)
+	TLSMode string `mapstructure:"tls_mode"`
+	// InsecureSkipVerify 僅供測試環境使用，會略過伺服器憑證驗證
+	InsecureSkipVerify bool `mapstructure:"insecure_skip_verify"`
+	// MaxAttachmentSize 是單一附件的大小上限 (位元組)，超過的檔案只在內文中提供下載連結，0 表示使用預設的 10 MB
+	MaxAttachmentSize int64 `mapstructure:"max_attachment_size"`
+}
+
+// DefaultVisibilityTimeout 是持久化佇列未設定 visibility_timeout 時的租約時間
+const DefaultVisibilityTimeout = 45 * time.Minute
+
+// LeaseMargin 是租約時間至少要比任務執行時間上限多出的時間，
+// 讓逾時的任務有時間中止請求、寫入歷史紀錄並確認完成
+const LeaseMargin = 5 * time.Minute
+
+// QueueConfig 存放任務佇列相關的設定
+type QueueConfig struct {
+	// Type 可為 "memory" (預設)、"sqlite" 或 "redis"
+	Type string `mapstructure:"type"`
+	// Size 是記憶體佇列的緩衝大小
+	Size int `mapstructure:"size"`
+	// VisibilityTimeout 是任務被取出後的租約時間，超過時間未確認完成的任務會重新被取出。
+	// 租約不會在執行期間延長，因此必須比 worker.task_timeout 至少長 LeaseMargin
+	VisibilityTimeout time.Duration `mapstructure:"visibility_timeout"`
+	// PollInterval 是持久化佇列在沒有任務時重新查詢的間隔
+	PollInterval time.Duration `mapstructure:"poll_interval"`
+	// InstanceID 識別共用 SQLite 佇列的每個程序，預設為主機名稱。
+	// 啟動時會立即收回同一個 InstanceID 上次中斷時留下的租約，因此同一台主機上的多個程序必須設定不同的值，
+	// 而且重新啟動後必須維持相同
+	InstanceID string `mapstructure:"instance_id"`
+	// Redis 是 Type 為 "redis" 時使用的連線設定
+	Redis RedisConfig `mapstructure:"redis"`
+}
+
+// RedisConfig 存放 Redis 連線相關的設定
+type RedisConfig struct {
+	Addr     string `mapstructure:"addr"`
+	Password string `mapstructure:"password"`
+	DB       int    `mapstructure:"db"`
+	// KeyPrefix 是佇列使用的 key 前綴，讓多個環境可以共用同一個 Redis。
+	// 沒有包含 {} 時會自動以 {} 包住作為 hash tag，讓佇列的 key 在 Redis Cluster 中位於同一個 slot
+	KeyPrefix string `mapstructure:"key_prefix"`
+}
+
+// WorkerConfig 存放 Worker 池相關的設定
+type WorkerConfig struct {
+	// Concurrency 是同時處理任務的 Worker 數量，預設為 1
+	Concurrency int `mapstructure:"concurrency"`
+	// ShutdownTimeout 是停止服務時等待進行中任務完成的時間上限，0 表示一直等到完成
+	ShutdownTimeout time.Duration `mapstructure:"shutdown_timeout"`
+	// TaskTimeout 是單次執行任務 (產生並寄送所有報表) 的時間上限，逾時會中止進行中的請求，0 表示不限制
+	TaskTimeout time.Duration `mapstructure:"task_timeout"`
+	// DataSourceConcurrency 是每個資料來源同時產生報表的數量上限，0 表示不限制
+	DataSourceConcurrency int `mapstructure:"datasource_concurrency"`
+	// DataSourceLimits 以資料來源 ID 個別覆寫 DataSourceConcurrency
+	DataSourceLimits map[string]int `mapstructure:"datasource_limits"`
+}
+
+// ArtifactsConfig 存放產生的報表檔案的儲存設定
+type ArtifactsConfig struct {
+	// Type 可為 "local" (預設，儲存在本機目錄) 或 "s3" (S3 相容的物件儲存，例如 MinIO)
+	Type string `mapstructure:"type"`
+	// Path 是 Type 為 "local" 時儲存檔案的目錄
+	Path string `mapstructure:"path"`
+	// S3 是 Type 為 "s3" 時使用的連線設定
+	S3 S3Config `mapstructure:"s3"`
+	// SigningKey 是簽署下載連結的 HMAC 金鑰，留空時每次啟動會隨機產生，重新啟動後舊連結即失效
+	SigningKey string `mapstructure:"signing_key"`
+	// LinkTTL 是下載連結的有效期間，預設為 7 天
+	LinkTTL time.Duration `mapstructure:"link_ttl"`
+	// PublicURL 是對外的服務網址 (例如 https://reports.example.com)，用於產生郵件中的完整連結
+	PublicURL string `mapstructure:"public_url"`
+}
+
+// S3Config 存放 S3 相容物件儲存的連線設定
+type S3Config struct {
+	// Endpoint 是服務的網址，例如 https://s3.ap-northeast-1.amazonaws.com 或 http://minio:9000
+	Endpoint  string `mapstructure:"endpoint"`
+	Region    string `mapstructure:"region"`
+	Bucket    string `mapstructure:"bucket"`
+	AccessKey string `mapstructure:"access_key"`
+	SecretKey string `mapstructure:"secret_key"`
+	// Prefix 會加在所有物件 key 的前面，讓多個環境可以共用同一個 bucket
+	Prefix string `mapstructure:"prefix"`
+}
+
+// RetentionConfig 存放執行紀錄與報表檔案的保留設定
+type RetentionConfig struct {
+	// Days 是執行紀錄的保留天數，預設為 90 天 (規格 8.2)，排程可以個別覆寫
+	Days int `mapstructure:"days"`
+	// Interval 是背景清理的執行間隔，預設為 1 小時
+	Interval time.Duration `mapstructure:"interval"`
+}
+
+// SecretsConfig 存放憑證管理相關的設定
+type SecretsConfig struct {
+	// Type 可為 "mock" (預設，僅供開發測試)、"file" (加密的本機檔案) 或 "vault" (HashiCorp Vault KV v2)
+	Type  string            `mapstructure:"type"`
+	File  FileSecretsConfig `mapstructure:"file"`
+	Vault VaultConfig       `mapstructure:"vault"`
+}
+
+// FileSecretsConfig 存放加密憑證檔案的設定
+type FileSecretsConfig struct {
+	Path string `mapstructure:"path"`
+	// MasterKey 是加密憑證檔案的主金鑰，建議以環境變數 SECRETS_FILE_MASTER_KEY 提供，不要寫在設定檔中
+	MasterKey string `mapstructure:"master_key"`
+}
+
+// VaultConfig 存放 HashiCorp Vault 的連線設定
+type VaultConfig struct {
+	// Address 是 Vault 的網址，例如 https://vault.example.com:8200，未設定時使用環境變數 VAULT_ADDR
+	Address string `mapstructure:"address"`
+	// Token 是存取 Vault 的 token，未設定時使用環境變數 VAULT_TOKEN
+	Token     string `mapstructure:"token"`
+	Namespace string `mapstructure:"namespace"`
+	// Mount 是 KV v2 secrets engine 的掛載路徑。未設定時以 CredentialsRef 的第一段作為掛載路徑，
+	// 例如 "kv/report-scheduler/kibana-prod" 會讀取 kv 下的 report-scheduler/kibana-prod
+	Mount string `mapstructure:"mount"`
+}
+
 // Config 是整個應用程式的設定結構
 type Config struct {
-	Database DBConfig `mapstructure:"database"`
+	Database  DBConfig        `mapstructure:"database"`
+	SMTP      SMTPConfig      `mapstructure:"smtp"`
+	Queue     QueueConfig     `mapstructure:"queue"`
+	Worker    WorkerConfig    `mapstructure:"worker"`
+	Artifacts ArtifactsConfig `mapstructure:"artifacts"`
+	Retention RetentionConfig `mapstructure:"retention"`
+	Secrets   SecretsConfig   `mapstructure:"secrets"`
 }
 
 // LoadConfig 從設定檔或環境變數中讀取設定。
@@ -27,6 +163,10 @@ func LoadConfig(path string) (config Config, err error) {
 	// 這允許我們透過環境變數來覆蓋設定，例如 DATABASE.PATH
 	viper.AutomaticEnv()
 	viper.SetEnvKeyReplacer(strings.NewReplacer(".", "_"))
+	// 金鑰類的設定通常只以環境變數提供，設定檔中沒有對應的 key 時 AutomaticEnv 不會生效，因此明確綁定
+	for _, key := range []string{"secrets.file.master_key", "secrets.vault.token", "artifacts.signing_key"} {
+		viper.BindEnv(key)
+	}
 
 	err = viper.ReadInConfig()
 	if err != nil {
@@ -36,6 +176,28 @@ func LoadConfig(path string) (config Config, err error) {
 		}
 	}
 
-	err = viper.Unmarshal(&config)
+	if err = viper.Unmarshal(&config); err != nil {
+		return
+	}
+	err = config.Validate()
 	return
 }
+
+// Validate 檢查設定之間的相依關係
+func (c Config) Validate() error {
+	// 持久化佇列以租約判斷任務是否中斷，租約比任務先到期會讓執行中的任務被其他 Worker 重複取出，
+	// 造成重複寄送郵件
+	if c.Queue.Type == "sqlite" || c.Queue.Type == "redis" {
+		visibility := c.Queue.VisibilityTimeout
+		if visibility <= 0 {
+			visibility = DefaultVisibilityTimeout
+		}
+		if c.Worker.TaskTimeout <= 0 {
+			return fmt.Errorf("使用 %s 佇列時必須設定 worker.task_timeout，否則任務可能執行超過 queue.visibility_timeout (%s) 而被重複取出", c.Queue.Type, visibility)
+		}
+		if visibility < c.Worker.TaskTimeout+LeaseMargin {
+			return fmt.Errorf("queue.visibility_timeout (%s) 必須比 worker.task_timeout (%s) 至少長 %s，否則執行較久的任務會在完成前被重複取出", visibility, c.Worker.TaskTimeout, LeaseMargin)
+		}
+	}
+	return nil
+}
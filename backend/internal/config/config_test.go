@@ -0,0 +1,34 @@
+package config
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestConfig_Validate(t *testing.T) {
+	testCases := []struct {
+		name        string
+		queue       QueueConfig
+		taskTimeout time.Duration
+		wantErr     string
+	}{
+		{"memory queue has no lease", QueueConfig{Type: "memory"}, 0, ""},
+		{"default visibility timeout", QueueConfig{Type: "sqlite"}, 30 * time.Minute, ""},
+		{"enough margin", QueueConfig{Type: "redis", VisibilityTimeout: time.Hour}, 55 * time.Minute, ""},
+		{"lease expires with the task", QueueConfig{Type: "sqlite", VisibilityTimeout: 30 * time.Minute}, 30 * time.Minute, "至少長 5m0s"},
+		{"margin too small", QueueConfig{Type: "redis", VisibilityTimeout: 34 * time.Minute}, 30 * time.Minute, "至少長 5m0s"},
+		{"unlimited task", QueueConfig{Type: "sqlite"}, 0, "必須設定 worker.task_timeout"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := Config{Queue: tc.queue, Worker: WorkerConfig{TaskTimeout: tc.taskTimeout}}.Validate()
+			if tc.wantErr == "" {
+				require.NoError(t, err)
+				return
+			}
+			require.ErrorContains(t, err, tc.wantErr)
+		})
+	}
+}
@@ -0,0 +1,99 @@
+package delivery
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"report-scheduler/backend/internal/config"
+	"report-scheduler/backend/internal/models"
+)
+
+// Attachment 代表郵件中的一個附件檔案
+type Attachment struct {
+	FilePath string
+	MimeType string
+	// FileName 是收件者看到的檔名，留空時使用 FilePath 的檔名
+	FileName string
+	// Link 是檔案的下載連結，附件超過大小上限時改以連結提供
+	Link string
+}
+
+// DefaultMaxAttachmentSize 是未設定 smtp.max_attachment_size 時單一附件的大小上限
+const DefaultMaxAttachmentSize int64 = 10 << 20
+
+// Message 代表一封待寄送的郵件
+type Message struct {
+	Recipients  models.Recipients
+	Subject     string
+	Body        string
+	Attachments []Attachment
+}
+
+// LinkOversizedAttachments 將超過 maxSize 且有下載連結的附件從郵件中移除，改在內文最後列出連結。
+// 郵件伺服器通常會限制郵件大小，大型的匯出檔直接附上會讓整封郵件被拒收。maxSize 為 0 時使用預設值。
+func (m *Message) LinkOversizedAttachments(maxSize int64) error {
+	if maxSize <= 0 {
+		maxSize = DefaultMaxAttachmentSize
+	}
+	var kept []Attachment
+	var links []string
+	for _, att := range m.Attachments {
+		info, err := os.Stat(att.FilePath)
+		if err != nil {
+			return fmt.Errorf("讀取附件 %s 失敗: %w", att.FilePath, err)
+		}
+		if info.Size() <= maxSize || att.Link == "" {
+			kept = append(kept, att)
+			continue
+		}
+		links = append(links, att.Link)
+	}
+	m.Attachments = kept
+	if len(links) > 0 {
+		m.Body += "\n\n以下檔案超過附件大小上限，請由連結下載：\n"
+		for _, link := range links {
+			m.Body += link + "\n"
+		}
+	}
+	return nil
+}
+
+// AllRecipients 回傳 To、Cc、Bcc 合併後的所有收件地址
+func (m *Message) AllRecipients() []string {
+	var all []string
+	all = append(all, m.Recipients.To...)
+	all = append(all, m.Recipients.Cc...)
+	all = append(all, m.Recipients.Bcc...)
+	return all
+}
+
+// Sender 是郵件寄送的介面，符合 Factory Provider 模式，
+// 未來可以新增 SendGrid、Amazon SES 等實作而不需修改呼叫端。
+type Sender interface {
+	Send(ctx context.Context, msg *Message) error
+}
+
+// NewSender 是郵件寄送層的工廠函式。
+// 若設定檔中沒有指定 SMTP 主機，則回傳只記錄日誌的 LogSender，方便在開發環境中執行。
+func NewSender(cfg config.Config) (Sender, error) {
+	if cfg.SMTP.Host == "" {
+		log.Println("警告：未設定 SMTP 主機，郵件將只會記錄到日誌中而不會實際寄出")
+		return NewLogSender(), nil
+	}
+	return NewSMTPSender(cfg.SMTP)
+}
+
+// LogSender 是一個不實際寄送郵件、只記錄郵件內容的 Sender 實作
+type LogSender struct{}
+
+// NewLogSender 建立一個新的 LogSender
+func NewLogSender() *LogSender {
+	return &LogSender{}
+}
+
+// Send 實作 Sender 介面
+func (s *LogSender) Send(ctx context.Context, msg *Message) error {
+	log.Printf("[Delivery] 模擬寄送郵件: 收件者=%v, 主旨=%q, 附件數=%d", msg.AllRecipients(), msg.Subject, len(msg.Attachments))
+	return nil
+}
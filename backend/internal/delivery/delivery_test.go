@@ -0,0 +1,44 @@
+package delivery
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestMessage_LinkOversizedAttachments(t *testing.T) {
+	dir := t.TempDir()
+	small := filepath.Join(dir, "small.pdf")
+	large := filepath.Join(dir, "large.csv")
+	unlinked := filepath.Join(dir, "unlinked.csv")
+	require.NoError(t, os.WriteFile(small, make([]byte, 10), 0644))
+	require.NoError(t, os.WriteFile(large, make([]byte, 100), 0644))
+	require.NoError(t, os.WriteFile(unlinked, make([]byte, 100), 0644))
+
+	msg := &Message{
+		Body: "您好",
+		Attachments: []Attachment{
+			{FilePath: small, Link: "https://reports.example.com/small"},
+			{FilePath: large, Link: "https://reports.example.com/large"},
+			// 沒有下載連結的檔案只能照常附上
+			{FilePath: unlinked},
+		},
+	}
+	require.NoError(t, msg.LinkOversizedAttachments(50))
+	require.Equal(t, []Attachment{{FilePath: small, Link: "https://reports.example.com/small"}, {FilePath: unlinked}}, msg.Attachments)
+	require.Equal(t, "您好\n\n以下檔案超過附件大小上限，請由連結下載：\nhttps://reports.example.com/large\n", msg.Body)
+
+	t.Run("default limit keeps small files", func(t *testing.T) {
+		msg := &Message{Body: "x", Attachments: []Attachment{{FilePath: large, Link: "https://reports.example.com/large"}}}
+		require.NoError(t, msg.LinkOversizedAttachments(0))
+		require.Len(t, msg.Attachments, 1)
+		require.Equal(t, "x", msg.Body)
+	})
+
+	t.Run("missing file", func(t *testing.T) {
+		msg := &Message{Attachments: []Attachment{{FilePath: filepath.Join(dir, "missing.pdf"), Link: "x"}}}
+		require.Error(t, msg.LinkOversizedAttachments(50))
+	})
+}
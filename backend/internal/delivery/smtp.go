@@ -0,0 +1,337 @@
+package delivery
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"report-scheduler/backend/internal/config"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// TLS 模式
+const (
+	TLSModeNone     = "none"
+	TLSModeStartTLS = "starttls"
+	TLSModeTLS      = "tls"
+)
+
+// SMTPSender 透過標準 SMTP 伺服器寄送郵件
+type SMTPSender struct {
+	cfg     config.SMTPConfig
+	timeout time.Duration
+}
+
+// NewSMTPSender 根據設定建立一個新的 SMTPSender
+func NewSMTPSender(cfg config.SMTPConfig) (*SMTPSender, error) {
+	if cfg.Host == "" {
+		return nil, errors.New("未設定 SMTP 主機")
+	}
+	if cfg.From == "" {
+		return nil, errors.New("未設定 SMTP 寄件者地址")
+	}
+	switch cfg.TLSMode {
+	case "":
+		cfg.TLSMode = TLSModeStartTLS
+	case TLSModeNone, TLSModeStartTLS, TLSModeTLS:
+	default:
+		return nil, fmt.Errorf("不支援的 SMTP TLS 模式: %s", cfg.TLSMode)
+	}
+	if cfg.Port == 0 {
+		switch cfg.TLSMode {
+		case TLSModeTLS:
+			cfg.Port = 465
+		case TLSModeStartTLS:
+			cfg.Port = 587
+		default:
+			cfg.Port = 25
+		}
+	}
+	return &SMTPSender{cfg: cfg, timeout: 30 * time.Second}, nil
+}
+
+// Send 實作 Sender 介面
+func (s *SMTPSender) Send(ctx context.Context, msg *Message) error {
+	if len(msg.AllRecipients()) == 0 {
+		return errors.New("郵件沒有任何收件者")
+	}
+	rcpts, err := parseAddresses(msg.AllRecipients())
+	if err != nil {
+		return err
+	}
+
+	// 附件在寫入時才以串流讀取，連線前先確認標頭與附件都沒有問題
+	if _, err := mimeHeaders(s.cfg.From, msg, ""); err != nil {
+		return fmt.Errorf("建立郵件內容失敗: %w", err)
+	}
+	for _, att := range msg.Attachments {
+		if _, err := os.Stat(att.FilePath); err != nil {
+			return fmt.Errorf("讀取附件 %s 失敗: %w", att.FilePath, err)
+		}
+	}
+
+	client, err := s.dial(ctx)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	if s.cfg.TLSMode == TLSModeStartTLS {
+		if ok, _ := client.Extension("STARTTLS"); !ok {
+			return errors.New("SMTP 伺服器不支援 STARTTLS")
+		}
+		if err := client.StartTLS(s.tlsConfig()); err != nil {
+			return fmt.Errorf("SMTP STARTTLS 失敗: %w", err)
+		}
+	}
+
+	if s.cfg.Username != "" {
+		auth := smtp.PlainAuth("", s.cfg.Username, s.cfg.Password, s.cfg.Host)
+		if err := client.Auth(auth); err != nil {
+			return fmt.Errorf("SMTP 認證失敗: %w", err)
+		}
+	}
+
+	from, err := mail.ParseAddress(s.cfg.From)
+	if err != nil {
+		return fmt.Errorf("無效的寄件者地址 '%s': %w", s.cfg.From, err)
+	}
+	if err := client.Mail(from.Address); err != nil {
+		return fmt.Errorf("SMTP MAIL FROM 失敗: %w", err)
+	}
+	for _, rcpt := range rcpts {
+		if err := client.Rcpt(rcpt.Address); err != nil {
+			return fmt.Errorf("SMTP RCPT TO <%s> 失敗: %w", rcpt.Address, err)
+		}
+	}
+
+	wc, err := client.Data()
+	if err != nil {
+		return fmt.Errorf("SMTP DATA 失敗: %w", err)
+	}
+	if err := writeMIMEMessage(wc, s.cfg.From, msg); err != nil {
+		// 不送出結束標記，直接關閉連線讓伺服器捨棄不完整的郵件
+		return fmt.Errorf("寫入郵件內容失敗: %w", err)
+	}
+	if err := wc.Close(); err != nil {
+		return fmt.Errorf("SMTP 伺服器拒絕郵件: %w", err)
+	}
+	return client.Quit()
+}
+
+// dial 依照 TLS 模式建立與 SMTP 伺服器的連線
+func (s *SMTPSender) dial(ctx context.Context) (*smtp.Client, error) {
+	addr := net.JoinHostPort(s.cfg.Host, strconv.Itoa(s.cfg.Port))
+	dialer := &net.Dialer{Timeout: s.timeout}
+
+	var conn net.Conn
+	var err error
+	if s.cfg.TLSMode == TLSModeTLS {
+		tlsDialer := &tls.Dialer{NetDialer: dialer, Config: s.tlsConfig()}
+		conn, err = tlsDialer.DialContext(ctx, "tcp", addr)
+	} else {
+		conn, err = dialer.DialContext(ctx, "tcp", addr)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("無法連線到 SMTP 伺服器 %s: %w", addr, err)
+	}
+
+	// 整個 SMTP 對話都受 context 的期限與固定的逾時時間限制
+	deadline := time.Now().Add(s.timeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	conn.SetDeadline(deadline)
+
+	client, err := smtp.NewClient(conn, s.cfg.Host)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("SMTP 握手失敗: %w", err)
+	}
+	return client, nil
+}
+
+func (s *SMTPSender) tlsConfig() *tls.Config {
+	return &tls.Config{
+		ServerName:         s.cfg.Host,
+		InsecureSkipVerify: s.cfg.InsecureSkipVerify,
+	}
+}
+
+// writeMIMEMessage 將 Message 組成含附件的 multipart/mixed MIME 郵件並寫入 w。
+// 附件以串流的方式編碼，不會將整個檔案讀入記憶體。
+func writeMIMEMessage(w io.Writer, from string, msg *Message) error {
+	mw := multipart.NewWriter(w)
+	headers, err := mimeHeaders(from, msg, mw.Boundary())
+	if err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, strings.Join(headers, "\r\n")+"\r\n\r\n"); err != nil {
+		return err
+	}
+
+	// 郵件內文
+	bodyPart, err := mw.CreatePart(textproto.MIMEHeader{
+		"Content-Type":              {"text/plain; charset=UTF-8"},
+		"Content-Transfer-Encoding": {"base64"},
+	})
+	if err != nil {
+		return err
+	}
+	if err := writeBase64(bodyPart, strings.NewReader(msg.Body)); err != nil {
+		return err
+	}
+
+	// 附件
+	for _, att := range msg.Attachments {
+		if err := writeAttachment(mw, att); err != nil {
+			return err
+		}
+	}
+	return mw.Close()
+}
+
+// writeAttachment 將一個附件檔案寫成 multipart 中的一個 part
+func writeAttachment(mw *multipart.Writer, att Attachment) error {
+	f, err := os.Open(att.FilePath)
+	if err != nil {
+		return fmt.Errorf("讀取附件 %s 失敗: %w", att.FilePath, err)
+	}
+	defer f.Close()
+
+	name := att.FileName
+	if name == "" {
+		name = filepath.Base(att.FilePath)
+	}
+	mimeType := att.MimeType
+	if mimeType == "" {
+		mimeType = "application/octet-stream"
+	}
+	part, err := mw.CreatePart(textproto.MIMEHeader{
+		"Content-Type":              {mime.FormatMediaType(mimeType, map[string]string{"name": name})},
+		"Content-Disposition":       {mime.FormatMediaType("attachment", map[string]string{"filename": name})},
+		"Content-Transfer-Encoding": {"base64"},
+	})
+	if err != nil {
+		return err
+	}
+	if err := writeBase64(part, f); err != nil {
+		return fmt.Errorf("讀取附件 %s 失敗: %w", att.FilePath, err)
+	}
+	return nil
+}
+
+// mimeHeaders 產生郵件的標頭，boundary 是 multipart 內容的分隔字串。
+// Bcc 收件者刻意不寫入標頭，只在 RCPT TO 階段使用。
+func mimeHeaders(from string, msg *Message, boundary string) ([]string, error) {
+	// 地址一律經過解析後重新格式化，收件者中夾帶的換行字元無法注入額外的標頭
+	fromAddr, err := mail.ParseAddress(from)
+	if err != nil {
+		return nil, fmt.Errorf("無效的寄件者地址 '%s': %w", from, err)
+	}
+	to, err := formatAddressList(msg.Recipients.To)
+	if err != nil {
+		return nil, err
+	}
+	headers := []string{
+		"From: " + fromAddr.String(),
+		"To: " + to,
+	}
+	if len(msg.Recipients.Cc) > 0 {
+		cc, err := formatAddressList(msg.Recipients.Cc)
+		if err != nil {
+			return nil, err
+		}
+		headers = append(headers, "Cc: "+cc)
+	}
+	headers = append(headers,
+		"Subject: "+mime.QEncoding.Encode("utf-8", msg.Subject),
+		"Date: "+time.Now().Format(time.RFC1123Z),
+		fmt.Sprintf("Message-ID: <%s@report-scheduler>", uuid.New().String()),
+		"MIME-Version: 1.0",
+		fmt.Sprintf("Content-Type: multipart/mixed; boundary=%q", boundary),
+	)
+	return headers, nil
+}
+
+// parseAddresses 以 net/mail 解析每一個地址
+func parseAddresses(addrs []string) ([]*mail.Address, error) {
+	parsed := make([]*mail.Address, 0, len(addrs))
+	for _, addr := range addrs {
+		a, err := mail.ParseAddress(addr)
+		if err != nil {
+			return nil, fmt.Errorf("無效的郵件地址 '%s': %w", addr, err)
+		}
+		parsed = append(parsed, a)
+	}
+	return parsed, nil
+}
+
+// formatAddressList 將地址格式化為郵件標頭使用的逗號分隔清單
+func formatAddressList(addrs []string) (string, error) {
+	parsed, err := parseAddresses(addrs)
+	if err != nil {
+		return "", err
+	}
+	formatted := make([]string, len(parsed))
+	for i, a := range parsed {
+		formatted[i] = a.String()
+	}
+	return strings.Join(formatted, ", "), nil
+}
+
+// base64LineLength 是 base64 內容每行的字元數 (RFC 2045)
+const base64LineLength = 76
+
+// writeBase64 將 r 的內容以每行 76 個字元的 base64 格式串流寫入 w
+func writeBase64(w io.Writer, r io.Reader) error {
+	enc := base64.NewEncoder(base64.StdEncoding, &lineWrapper{w: w})
+	if _, err := io.Copy(enc, r); err != nil {
+		return err
+	}
+	if err := enc.Close(); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\r\n")
+	return err
+}
+
+// lineWrapper 在寫入的內容中每 base64LineLength 個字元插入一個 CRLF
+type lineWrapper struct {
+	w    io.Writer
+	line int // 目前這一行已寫入的字元數
+}
+
+func (l *lineWrapper) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		if l.line == base64LineLength {
+			if _, err := io.WriteString(l.w, "\r\n"); err != nil {
+				return written, err
+			}
+			l.line = 0
+		}
+		n, err := l.w.Write(p[:min(len(p), base64LineLength-l.line)])
+		written += n
+		l.line += n
+		if err != nil {
+			return written, err
+		}
+		p = p[n:]
+	}
+	return written, nil
+}
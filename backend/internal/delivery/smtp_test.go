@@ -0,0 +1,279 @@
+package delivery
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net"
+	"net/mail"
+	"os"
+	"report-scheduler/backend/internal/config"
+	"report-scheduler/backend/internal/models"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// receivedMail 是假 SMTP 伺服器收到的一封郵件
+type receivedMail struct {
+	From string
+	To   []string
+	Data string
+	Auth string
+}
+
+// fakeSMTPServer 是一個只支援最基本指令的本機 SMTP 伺服器，用於測試
+type fakeSMTPServer struct {
+	listener net.Listener
+	mu       sync.Mutex
+	mails    []receivedMail
+}
+
+func newFakeSMTPServer(t *testing.T) *fakeSMTPServer {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	s := &fakeSMTPServer{listener: l}
+	go s.serve()
+	t.Cleanup(func() { l.Close() })
+	return s
+}
+
+func (s *fakeSMTPServer) port() int {
+	return s.listener.Addr().(*net.TCPAddr).Port
+}
+
+func (s *fakeSMTPServer) received() []receivedMail {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]receivedMail(nil), s.mails...)
+}
+
+func (s *fakeSMTPServer) serve() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		go s.handle(conn)
+	}
+}
+
+func (s *fakeSMTPServer) handle(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	reply := func(line string) { io.WriteString(conn, line+"\r\n") }
+
+	var cur receivedMail
+	reply("220 fake.smtp ESMTP ready")
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		line = strings.TrimRight(line, "\r\n")
+		cmd := strings.ToUpper(line)
+		switch {
+		case strings.HasPrefix(cmd, "EHLO"), strings.HasPrefix(cmd, "HELO"):
+			reply("250-fake.smtp")
+			reply("250 AUTH PLAIN")
+		case strings.HasPrefix(cmd, "AUTH PLAIN"):
+			cur.Auth = strings.TrimSpace(line[len("AUTH PLAIN"):])
+			reply("235 2.7.0 Authentication successful")
+		case strings.HasPrefix(cmd, "MAIL FROM:"):
+			cur.From = strings.Trim(line[len("MAIL FROM:"):], "<> ")
+			reply("250 OK")
+		case strings.HasPrefix(cmd, "RCPT TO:"):
+			cur.To = append(cur.To, strings.Trim(line[len("RCPT TO:"):], "<> "))
+			reply("250 OK")
+		case cmd == "DATA":
+			reply("354 End data with <CR><LF>.<CR><LF>")
+			var sb strings.Builder
+			for {
+				dl, err := r.ReadString('\n')
+				if err != nil {
+					return
+				}
+				if dl == ".\r\n" {
+					break
+				}
+				sb.WriteString(strings.TrimPrefix(dl, "."))
+			}
+			cur.Data = sb.String()
+			s.mu.Lock()
+			s.mails = append(s.mails, cur)
+			s.mu.Unlock()
+			cur = receivedMail{}
+			reply("250 OK: queued")
+		case cmd == "QUIT":
+			reply("221 Bye")
+			return
+		default:
+			reply("250 OK")
+		}
+	}
+}
+
+func TestSMTPSender_Send(t *testing.T) {
+	server := newFakeSMTPServer(t)
+
+	attachment, err := os.CreateTemp("", "report-*.pdf")
+	require.NoError(t, err)
+	attachment.WriteString("dummy-pdf-content")
+	attachment.Close()
+	defer os.Remove(attachment.Name())
+
+	sender, err := NewSMTPSender(config.SMTPConfig{
+		Host:     "127.0.0.1",
+		Port:     server.port(),
+		Username: "user",
+		Password: "secret",
+		From:     "scheduler@example.com",
+		TLSMode:  TLSModeNone,
+	})
+	require.NoError(t, err)
+
+	msg := &Message{
+		Recipients: models.Recipients{
+			To:  []string{"to@example.com"},
+			Cc:  []string{"cc@example.com"},
+			Bcc: []string{"bcc@example.com"},
+		},
+		Subject: "[每日報表] 營運日報",
+		Body:    "您好，附件為今日的營運報表。",
+		Attachments: []Attachment{
+			{FilePath: attachment.Name(), MimeType: "application/pdf", FileName: "daily.pdf"},
+		},
+	}
+	require.NoError(t, sender.Send(context.Background(), msg))
+
+	mails := server.received()
+	require.Len(t, mails, 1)
+	got := mails[0]
+	require.Equal(t, "scheduler@example.com", got.From)
+	require.ElementsMatch(t, []string{"to@example.com", "cc@example.com", "bcc@example.com"}, got.To)
+
+	authRaw, err := base64.StdEncoding.DecodeString(got.Auth)
+	require.NoError(t, err)
+	require.Equal(t, "\x00user\x00secret", string(authRaw))
+
+	parsed, err := mail.ReadMessage(strings.NewReader(got.Data))
+	require.NoError(t, err)
+	toList, err := parsed.Header.AddressList("To")
+	require.NoError(t, err)
+	require.Equal(t, []*mail.Address{{Address: "to@example.com"}}, toList)
+	ccList, err := parsed.Header.AddressList("Cc")
+	require.NoError(t, err)
+	require.Equal(t, []*mail.Address{{Address: "cc@example.com"}}, ccList)
+	require.Empty(t, parsed.Header.Get("Bcc"), "Bcc 不應出現在郵件標頭中")
+
+	subject, err := new(mime.WordDecoder).DecodeHeader(parsed.Header.Get("Subject"))
+	require.NoError(t, err)
+	require.Equal(t, "[每日報表] 營運日報", subject)
+
+	mediaType, params, err := mime.ParseMediaType(parsed.Header.Get("Content-Type"))
+	require.NoError(t, err)
+	require.Equal(t, "multipart/mixed", mediaType)
+
+	mr := multipart.NewReader(parsed.Body, params["boundary"])
+	bodyPart, err := mr.NextPart()
+	require.NoError(t, err)
+	bodyContent, _ := io.ReadAll(base64.NewDecoder(base64.StdEncoding, bodyPart))
+	require.Equal(t, "您好，附件為今日的營運報表。", string(bodyContent))
+
+	attPart, err := mr.NextPart()
+	require.NoError(t, err)
+	require.Equal(t, "daily.pdf", attPart.FileName())
+	attContent, _ := io.ReadAll(base64.NewDecoder(base64.StdEncoding, attPart))
+	require.Equal(t, "dummy-pdf-content", string(attContent))
+
+	_, err = mr.NextPart()
+	require.Equal(t, io.EOF, err)
+}
+
+func TestWriteBase64(t *testing.T) {
+	data := make([]byte, 1000)
+	for i := range data {
+		data[i] = byte(i)
+	}
+	var out strings.Builder
+	require.NoError(t, writeBase64(&out, strings.NewReader(string(data))))
+
+	lines := strings.Split(strings.TrimSuffix(out.String(), "\r\n"), "\r\n")
+	for _, line := range lines[:len(lines)-1] {
+		require.Len(t, line, base64LineLength)
+	}
+	require.LessOrEqual(t, len(lines[len(lines)-1]), base64LineLength)
+	decoded, err := base64.StdEncoding.DecodeString(strings.Join(lines, ""))
+	require.NoError(t, err)
+	require.Equal(t, data, decoded)
+
+	out.Reset()
+	require.NoError(t, writeBase64(&out, strings.NewReader("")))
+	require.Equal(t, "\r\n", out.String())
+}
+
+func TestSMTPSender_Errors(t *testing.T) {
+	t.Run("no recipients", func(t *testing.T) {
+		server := newFakeSMTPServer(t)
+		sender, err := NewSMTPSender(config.SMTPConfig{Host: "127.0.0.1", Port: server.port(), From: "a@example.com", TLSMode: TLSModeNone})
+		require.NoError(t, err)
+		err = sender.Send(context.Background(), &Message{Subject: "x"})
+		require.Error(t, err)
+		require.Empty(t, server.received())
+	})
+
+	t.Run("header injection in recipient is rejected", func(t *testing.T) {
+		server := newFakeSMTPServer(t)
+		sender, err := NewSMTPSender(config.SMTPConfig{Host: "127.0.0.1", Port: server.port(), From: "a@example.com", TLSMode: TLSModeNone})
+		require.NoError(t, err)
+		err = sender.Send(context.Background(), &Message{
+			Recipients: models.Recipients{To: []string{"to@example.com\r\nBcc: victim@example.com"}},
+			Subject:    "x",
+		})
+		require.ErrorContains(t, err, "無效的郵件地址")
+		require.Empty(t, server.received())
+	})
+
+	t.Run("missing attachment fails before connecting", func(t *testing.T) {
+		server := newFakeSMTPServer(t)
+		sender, err := NewSMTPSender(config.SMTPConfig{Host: "127.0.0.1", Port: server.port(), From: "a@example.com", TLSMode: TLSModeNone})
+		require.NoError(t, err)
+		err = sender.Send(context.Background(), &Message{
+			Recipients:  models.Recipients{To: []string{"to@example.com"}},
+			Attachments: []Attachment{{FilePath: "/nonexistent/report.pdf"}},
+		})
+		require.ErrorContains(t, err, "讀取附件")
+		require.Empty(t, server.received())
+	})
+
+	t.Run("starttls not supported by server", func(t *testing.T) {
+		server := newFakeSMTPServer(t)
+		sender, err := NewSMTPSender(config.SMTPConfig{Host: "127.0.0.1", Port: server.port(), From: "a@example.com", TLSMode: TLSModeStartTLS})
+		require.NoError(t, err)
+		err = sender.Send(context.Background(), &Message{Recipients: models.Recipients{To: []string{"to@example.com"}}})
+		require.ErrorContains(t, err, "STARTTLS")
+	})
+
+	t.Run("invalid tls mode", func(t *testing.T) {
+		_, err := NewSMTPSender(config.SMTPConfig{Host: "127.0.0.1", From: "a@example.com", TLSMode: "ssl3"})
+		require.Error(t, err)
+	})
+
+	t.Run("default port follows tls mode", func(t *testing.T) {
+		sender, err := NewSMTPSender(config.SMTPConfig{Host: "smtp.example.com", From: "a@example.com", TLSMode: TLSModeTLS})
+		require.NoError(t, err)
+		require.Equal(t, 465, sender.cfg.Port)
+	})
+}
+
+func TestNewSender_FallsBackToLogSender(t *testing.T) {
+	sender, err := NewSender(config.Config{})
+	require.NoError(t, err)
+	require.IsType(t, &LogSender{}, sender)
+	require.NoError(t, sender.Send(context.Background(), &Message{Subject: "dev"}))
+}
@@ -0,0 +1,130 @@
+package delivery
+
+import (
+	"fmt"
+	"regexp"
+	"report-scheduler/backend/internal/models"
+	"report-scheduler/backend/internal/timerange"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// placeholderPattern 比對 {{variable}} 形式的樣板變數，允許變數名稱前後有空白
+var placeholderPattern = regexp.MustCompile(`\{\{\s*([a-zA-Z0-9_]+)\s*\}\}`)
+
+// timeRangeLayout 是 time_range 變數中時間的格式
+const timeRangeLayout = "2006-01-02 15:04"
+
+// TemplateData 是郵件主旨與內文樣板可以使用的資料
+type TemplateData struct {
+	Schedule *models.Schedule
+	Reports  []models.ReportDefinition
+	// TriggerTime 是本次執行的時間基準點，報表的相對時間範圍以它解析
+	TriggerTime time.Time
+	// Status 是本次執行的狀態。郵件只在報表全部成功 (success) 或重試用盡後仍有部分成功 (partial) 時寄出，
+	// 因此樣板中只會出現這兩種值
+	Status models.LogStatus
+	// ErrorMessage 是 partial 時失敗報表的錯誤訊息，每個報表一行
+	ErrorMessage string
+	// FileLinks 是本次執行產生的報表檔案下載連結
+	FileLinks []string
+}
+
+// Variables 將 TemplateData 展開為樣板變數名稱與值的對照表。
+// 所有與時間相關的變數都會轉換到排程設定的時區，time_range 是報表實際涵蓋的絕對時間範圍。
+func (d *TemplateData) Variables() (map[string]string, error) {
+	loc := time.Local
+	vars := map[string]string{}
+	if d.Schedule != nil {
+		var err error
+		if loc, err = d.Schedule.Location(); err != nil {
+			return nil, err
+		}
+		vars["schedule_id"] = d.Schedule.ID
+		vars["schedule_name"] = d.Schedule.Name
+		vars["cron_spec"] = d.Schedule.CronSpec
+	}
+
+	var names, ranges []string
+	for _, r := range d.Reports {
+		names = append(names, r.Name)
+		if r.TimeRange == "" {
+			continue
+		}
+		from, to, err := timerange.Resolve(r.TimeRange, d.TriggerTime, loc)
+		if err != nil {
+			return nil, fmt.Errorf("報表 %s 的時間範圍無效: %w", r.Name, err)
+		}
+		ranges = append(ranges, from.In(loc).Format(timeRangeLayout)+" ~ "+to.In(loc).Format(timeRangeLayout))
+	}
+	vars["report_name"] = strings.Join(names, ", ")
+	vars["report_count"] = strconv.Itoa(len(d.Reports))
+	vars["time_range"] = strings.Join(uniqueStrings(ranges), ", ")
+
+	t := d.TriggerTime.In(loc)
+	vars["date"] = t.Format("2006-01-02")
+	vars["time"] = t.Format("15:04")
+	vars["datetime"] = t.Format("2006-01-02 15:04:05")
+	vars["trigger_time"] = t.Format(time.RFC3339)
+	vars["timezone"] = loc.String()
+
+	vars["status"] = string(d.Status)
+	vars["error_message"] = d.ErrorMessage
+	vars["file_links"] = strings.Join(d.FileLinks, "\n")
+	vars["file_count"] = strconv.Itoa(len(d.FileLinks))
+	return vars, nil
+}
+
+// Render 將樣板中的 {{variable}} 替換為對應的值。
+// 未知的變數會原樣保留在輸出中，並以錯誤的形式回報，讓呼叫端決定是否要中止。
+func Render(tmpl string, data *TemplateData) (string, error) {
+	vars, err := data.Variables()
+	if err != nil {
+		return "", err
+	}
+
+	unknown := map[string]bool{}
+	out := placeholderPattern.ReplaceAllStringFunc(tmpl, func(m string) string {
+		name := placeholderPattern.FindStringSubmatch(m)[1]
+		if v, ok := vars[name]; ok {
+			return v
+		}
+		unknown[name] = true
+		return m
+	})
+
+	if len(unknown) > 0 {
+		var names []string
+		for name := range unknown {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return out, fmt.Errorf("未知的樣板變數: %s", strings.Join(names, ", "))
+	}
+	return out, nil
+}
+
+// RenderMessage 使用排程的郵件樣板產生主旨與內文
+func RenderMessage(subjectTmpl, bodyTmpl string, data *TemplateData) (subject, body string, err error) {
+	if subject, err = Render(subjectTmpl, data); err != nil {
+		return subject, "", fmt.Errorf("郵件主旨: %w", err)
+	}
+	if body, err = Render(bodyTmpl, data); err != nil {
+		return subject, body, fmt.Errorf("郵件內文: %w", err)
+	}
+	return subject, body, nil
+}
+
+func uniqueStrings(in []string) []string {
+	seen := map[string]bool{}
+	var out []string
+	for _, s := range in {
+		if !seen[s] {
+			seen[s] = true
+			out = append(out, s)
+		}
+	}
+	return out
+}
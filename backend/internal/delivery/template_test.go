@@ -0,0 +1,69 @@
+package delivery
+
+import (
+	"report-scheduler/backend/internal/models"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRender(t *testing.T) {
+	data := &TemplateData{
+		Schedule: &models.Schedule{ID: "sch-1", Name: "每日營運日報", Timezone: "Asia/Taipei"},
+		Reports: []models.ReportDefinition{
+			{Name: "網站流量", TimeRange: "now-7d"},
+			{Name: "訂單統計", TimeRange: "now-7d"},
+		},
+		// UTC 20:30 在台北時區已經是隔天
+		TriggerTime: time.Date(2024, 3, 4, 20, 30, 0, 0, time.UTC),
+		Status:      models.LogStatusSuccess,
+		FileLinks:   []string{"/api/v1/files/a.pdf", "/api/v1/files/b.pdf"},
+	}
+
+	t.Run("spec example", func(t *testing.T) {
+		out, err := Render("[每日報表] {{report_name}} - {{date}}", data)
+		require.NoError(t, err)
+		require.Equal(t, "[每日報表] 網站流量, 訂單統計 - 2024-03-05", out)
+	})
+
+	t.Run("all variables", func(t *testing.T) {
+		out, err := Render("{{ schedule_name }}|{{time}}|{{timezone}}|{{time_range}}|{{status}}|{{file_count}}\n{{file_links}}", data)
+		require.NoError(t, err)
+		require.Equal(t, "每日營運日報|04:30|Asia/Taipei|2024-02-27 04:30 ~ 2024-03-05 04:30|success|2\n/api/v1/files/a.pdf\n/api/v1/files/b.pdf", out)
+	})
+
+	t.Run("time range is resolved per report", func(t *testing.T) {
+		mixed := *data
+		mixed.Reports = []models.ReportDefinition{
+			{Name: "昨日", TimeRange: "now-1d/d"},
+			{Name: "本週", TimeRange: "now/w to now"},
+			{Name: "不限", TimeRange: ""},
+		}
+		out, err := Render("{{time_range}}", &mixed)
+		require.NoError(t, err)
+		require.Equal(t, "2024-03-04 00:00 ~ 2024-03-04 23:59, 2024-03-04 00:00 ~ 2024-03-05 04:30", out)
+	})
+
+	t.Run("partial run", func(t *testing.T) {
+		partial := *data
+		partial.Status = models.LogStatusPartial
+		partial.ErrorMessage = "訂單統計: Kibana 回應逾時"
+		out, err := Render("{{status}}\n{{error_message}}", &partial)
+		require.NoError(t, err)
+		require.Equal(t, "partial\n訂單統計: Kibana 回應逾時", out)
+	})
+
+	t.Run("unknown variables are kept and reported", func(t *testing.T) {
+		out, err := Render("Hi {{customer}} {{date}}", data)
+		require.ErrorContains(t, err, "customer")
+		require.Equal(t, "Hi {{customer}} 2024-03-05", out)
+	})
+
+	t.Run("invalid timezone", func(t *testing.T) {
+		bad := *data
+		bad.Schedule = &models.Schedule{Timezone: "Mars/Olympus"}
+		_, err := Render("{{date}}", &bad)
+		require.Error(t, err)
+	})
+}
@@ -0,0 +1,214 @@
+package discovery
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"report-scheduler/backend/internal/models"
+	"report-scheduler/backend/internal/secrets"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 分頁與快取的預設值
+const (
+	DefaultPerPage  = 20
+	MaxPerPage      = 100
+	DefaultCacheTTL = 30 * time.Second
+)
+
+// ErrInvalidQuery 表示查詢條件不適用於此資料來源，例如 Grafana 不支援的元素類型
+var ErrInvalidQuery = errors.New("無效的查詢條件")
+
+// Query 是搜尋資料來源中可用元素的條件
+type Query struct {
+	// Text 依標題搜尋，空值表示不篩選
+	Text string
+	// Types 篩選元素類型，空值表示資料來源支援的所有類型
+	Types []models.ReportElementType
+	// Space 是 Kibana space，Grafana 會忽略此欄位
+	Space string
+	// Page 從 1 開始
+	Page    int
+	PerPage int
+}
+
+// Page 是一頁搜尋結果
+type Page struct {
+	Elements []models.AvailableElement `json:"elements"`
+	Total    int                       `json:"total"`
+	Page     int                       `json:"page"`
+	PerPage  int                       `json:"per_page"`
+}
+
+// supportedTypes 是各資料來源可以產生報表的元素類型
+var supportedTypes = map[models.DataSourceType][]models.ReportElementType{
+	models.Kibana:  {models.DashboardType, models.VisualizationType, models.LensType, models.SavedSearchType},
+	models.Grafana: {models.DashboardType, models.PanelType},
+}
+
+// cacheEntry 是一筆快取的查詢結果
+type cacheEntry struct {
+	value   interface{}
+	expires time.Time
+}
+
+// Service 負責從 Kibana 與 Grafana 即時查詢可以加入報表的元素。
+// 查詢結果會依資料來源快取 TTL 的時間，避免使用者在表單中翻頁或輸入時重複請求。
+type Service struct {
+	Secrets secrets.SecretsManager
+	Client  *http.Client
+	// TTL 是快取保留的時間，0 表示不快取
+	TTL time.Duration
+
+	mu    sync.Mutex
+	cache map[string]map[string]cacheEntry // 資料來源 ID -> 查詢 -> 結果
+	now   func() time.Time
+}
+
+// NewService 建立一個新的 Service
+func NewService(sm secrets.SecretsManager) *Service {
+	return &Service{
+		Secrets: sm,
+		Client:  &http.Client{Timeout: 30 * time.Second},
+		TTL:     DefaultCacheTTL,
+		cache:   make(map[string]map[string]cacheEntry),
+		now:     time.Now,
+	}
+}
+
+// Discover 依查詢條件回傳資料來源中的一頁可用元素。
+// 回傳的結果可能來自快取，呼叫端不應修改它。
+func (s *Service) Discover(ctx context.Context, ds *models.DataSource, q Query) (*Page, error) {
+	if q.Page < 1 {
+		q.Page = 1
+	}
+	if q.PerPage < 1 {
+		q.PerPage = DefaultPerPage
+	}
+	if q.PerPage > MaxPerPage {
+		q.PerPage = MaxPerPage
+	}
+	types, err := resolveTypes(ds.Type, q.Types)
+	if err != nil {
+		return nil, err
+	}
+	q.Types = types
+
+	typeNames := make([]string, len(types))
+	for i, t := range types {
+		typeNames[i] = string(t)
+	}
+	key := fmt.Sprintf("page|%s|%s|%s|%d|%d", q.Space, strings.TrimSpace(q.Text), strings.Join(typeNames, ","), q.Page, q.PerPage)
+	value, err := s.cached(ds.ID, key, func() (interface{}, error) {
+		switch ds.Type {
+		case models.Kibana:
+			return s.discoverKibana(ctx, ds, q)
+		default:
+			return s.discoverGrafana(ctx, ds, q)
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	return value.(*Page), nil
+}
+
+// Invalidate 清除資料來源的所有快取，資料來源被修改或使用者要求重新整理時使用
+func (s *Service) Invalidate(dataSourceID string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.cache, dataSourceID)
+}
+
+// cached 回傳快取中尚未過期的結果，沒有時呼叫 load 並將成功的結果存入快取。
+// load 執行期間不會持有鎖，同時間的相同查詢可能會各自請求一次。
+func (s *Service) cached(dataSourceID, key string, load func() (interface{}, error)) (interface{}, error) {
+	now := s.now()
+	s.mu.Lock()
+	if entry, ok := s.cache[dataSourceID][key]; ok && now.Before(entry.expires) {
+		s.mu.Unlock()
+		return entry.value, nil
+	}
+	s.mu.Unlock()
+
+	value, err := load()
+	if err != nil || s.TTL <= 0 {
+		return value, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	entries := s.cache[dataSourceID]
+	if entries == nil {
+		entries = make(map[string]cacheEntry)
+		s.cache[dataSourceID] = entries
+	}
+	// 順便清除已過期的查詢，避免不同的搜尋字串讓快取無限制地成長
+	for k, entry := range entries {
+		if !now.Before(entry.expires) {
+			delete(entries, k)
+		}
+	}
+	entries[key] = cacheEntry{value: value, expires: now.Add(s.TTL)}
+	return value, nil
+}
+
+// resolveTypes 檢查查詢的元素類型是否為資料來源所支援，未指定時回傳所有支援的類型
+func resolveTypes(dsType models.DataSourceType, requested []models.ReportElementType) ([]models.ReportElementType, error) {
+	supported, ok := supportedTypes[dsType]
+	if !ok {
+		return nil, fmt.Errorf("%w: 不支援的資料來源類型 %s", ErrInvalidQuery, dsType)
+	}
+	if len(requested) == 0 {
+		return supported, nil
+	}
+
+	seen := make(map[models.ReportElementType]bool)
+	var types []models.ReportElementType
+	for _, t := range requested {
+		found := false
+		for _, st := range supported {
+			if t == st {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("%w: %s 資料來源不支援元素類型 '%s'", ErrInvalidQuery, dsType, t)
+		}
+		if !seen[t] {
+			seen[t] = true
+			types = append(types, t)
+		}
+	}
+	// 排序後作為快取的 key，讓相同條件不同順序的查詢共用快取
+	sort.Slice(types, func(i, j int) bool { return types[i] < types[j] })
+	return types, nil
+}
+
+// newRequest 建立一個 GET 請求，並依資料來源的認證方式加上標頭。
+// tokenScheme 是 API Token 在 Authorization 標頭中的前綴，Kibana 為 ApiKey，Grafana 為 Bearer。
+func (s *Service) newRequest(ctx context.Context, ds *models.DataSource, requestURL, tokenScheme string) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, requestURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("無法建立請求: %w", err)
+	}
+	if ds.AuthType != models.AuthNone {
+		creds, err := s.Secrets.GetCredentials(ds.CredentialsRef)
+		if err != nil {
+			return nil, fmt.Errorf("無法獲取憑證 for ref %s: %w", ds.CredentialsRef, err)
+		}
+		switch ds.AuthType {
+		case models.APIToken:
+			req.Header.Set("Authorization", tokenScheme+" "+creds.Token)
+		case models.BasicAuth:
+			req.SetBasicAuth(creds.Username, creds.Password)
+		}
+	}
+	req.Header.Set("Accept", "application/json")
+	return req, nil
+}
@@ -0,0 +1,220 @@
+package discovery
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"report-scheduler/backend/internal/models"
+	"report-scheduler/backend/internal/secrets"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// newFakeKibana 建立一個假的 Kibana，記錄收到的 _find 請求並回傳固定的已儲存物件
+func newFakeKibana(t *testing.T, received *[]*http.Request) *httptest.Server {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		*received = append(*received, r)
+		if r.Header.Get("Authorization") != "ApiKey kibana-key" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"page": 2, "per_page": 3, "total": 7,
+			"saved_objects": []map[string]interface{}{
+				{"id": "dash-1", "type": "dashboard", "attributes": map[string]string{"title": "[Logs] Web Traffic"}},
+				{"id": "lens-1", "type": "lens", "attributes": map[string]string{"title": "CPU 使用率"}},
+				{"id": "search-1", "type": "search", "attributes": map[string]string{"title": "錯誤日誌"}},
+				{"id": "map-1", "type": "map", "attributes": map[string]string{"title": "不支援的物件"}},
+			},
+		})
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestDiscover_Kibana(t *testing.T) {
+	var received []*http.Request
+	srv := newFakeKibana(t, &received)
+	sm := &secrets.MockSecretsManager{CredsToReturn: &secrets.Credentials{Token: "kibana-key"}}
+	ds := &models.DataSource{ID: "ds-kibana", Type: models.Kibana, URL: srv.URL + "/", AuthType: models.APIToken, CredentialsRef: "kv/kibana"}
+
+	page, err := NewService(sm).Discover(t.Context(), ds, Query{
+		Text:    "web traf",
+		Types:   []models.ReportElementType{models.SavedSearchType, models.DashboardType, models.LensType},
+		Space:   "ops",
+		Page:    2,
+		PerPage: 3,
+	})
+	require.NoError(t, err)
+
+	require.Len(t, received, 1)
+	req := received[0]
+	require.Equal(t, "/s/ops/api/saved_objects/_find", req.URL.Path)
+	params := req.URL.Query()
+	require.ElementsMatch(t, []string{"dashboard", "lens", "search"}, params["type"])
+	require.Equal(t, "web* traf*", params.Get("search"))
+	require.Equal(t, "title", params.Get("search_fields"))
+	require.Equal(t, "AND", params.Get("default_search_operator"))
+	require.Equal(t, "2", params.Get("page"))
+	require.Equal(t, "3", params.Get("per_page"))
+
+	// 不支援的物件類型不會被列出，總數沿用 Kibana 的回應
+	require.Equal(t, 7, page.Total)
+	require.Equal(t, 2, page.Page)
+	require.Equal(t, 3, page.PerPage)
+	require.Equal(t, []models.AvailableElement{
+		{ID: "dash-1", Type: "dashboard", Title: "[Logs] Web Traffic"},
+		{ID: "lens-1", Type: "lens", Title: "CPU 使用率"},
+		{ID: "search-1", Type: "saved_search", Title: "錯誤日誌"},
+	}, page.Elements)
+
+	t.Run("default space and all types", func(t *testing.T) {
+		received = nil
+		_, err := NewService(sm).Discover(t.Context(), ds, Query{Space: "default"})
+		require.NoError(t, err)
+		require.Equal(t, "/api/saved_objects/_find", received[0].URL.Path)
+		params := received[0].URL.Query()
+		require.ElementsMatch(t, []string{"dashboard", "visualization", "lens", "search"}, params["type"])
+		require.NotContains(t, params, "search")
+		require.Equal(t, "1", params.Get("page"))
+		require.Equal(t, "20", params.Get("per_page"))
+	})
+
+	t.Run("upstream error", func(t *testing.T) {
+		badCreds := &secrets.MockSecretsManager{CredsToReturn: &secrets.Credentials{Token: "wrong"}}
+		_, err := NewService(badCreds).Discover(t.Context(), ds, Query{})
+		require.ErrorContains(t, err, "401")
+	})
+}
+
+func TestDiscover_Grafana(t *testing.T) {
+	var searches, dashboards atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if user, pass, ok := r.BasicAuth(); !ok || user != "admin" || pass != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		switch r.URL.Path {
+		case "/api/search":
+			searches.Add(1)
+			require.Equal(t, "dash-db", r.URL.Query().Get("type"))
+			w.Write([]byte(`[{"uid":"abc","title":"Node Exporter"},{"uid":"def","title":"Nginx"}]`))
+		case "/api/dashboards/uid/abc":
+			dashboards.Add(1)
+			w.Write([]byte(`{"dashboard":{"panels":[
+				{"id":1,"title":"CPU","type":"timeseries"},
+				{"id":2,"title":"Disk","type":"row","panels":[{"id":3,"title":"Disk IO","type":"timeseries"}]},
+				{"id":4,"title":"","type":"stat"}
+			]}}`))
+		case "/api/dashboards/uid/def":
+			dashboards.Add(1)
+			w.Write([]byte(`{"dashboard":{"panels":[{"id":7,"title":"Requests","type":"timeseries"}]}}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+	sm := &secrets.MockSecretsManager{CredsToReturn: &secrets.Credentials{Username: "admin", Password: "secret"}}
+	ds := &models.DataSource{ID: "ds-grafana", Type: models.Grafana, URL: srv.URL, AuthType: models.BasicAuth, CredentialsRef: "kv/grafana"}
+	s := NewService(sm)
+
+	t.Run("dashboards only", func(t *testing.T) {
+		page, err := s.Discover(t.Context(), ds, Query{Types: []models.ReportElementType{models.DashboardType}})
+		require.NoError(t, err)
+		require.Equal(t, 2, page.Total)
+		require.Equal(t, []models.AvailableElement{
+			{ID: "abc", Type: "dashboard", Title: "Node Exporter"},
+			{ID: "def", Type: "dashboard", Title: "Nginx"},
+		}, page.Elements)
+		require.Zero(t, dashboards.Load(), "只列出儀表板時不需要讀取儀表板內容")
+	})
+
+	t.Run("panels are searched and paginated locally", func(t *testing.T) {
+		page, err := s.Discover(t.Context(), ds, Query{Types: []models.ReportElementType{models.PanelType}, PerPage: 2})
+		require.NoError(t, err)
+		require.Equal(t, 4, page.Total)
+		require.Equal(t, []models.AvailableElement{
+			{ID: "abc/1", Type: "panel", Title: "Node Exporter / CPU"},
+			{ID: "abc/3", Type: "panel", Title: "Node Exporter / Disk IO"},
+		}, page.Elements)
+
+		page, err = s.Discover(t.Context(), ds, Query{Types: []models.ReportElementType{models.PanelType}, Page: 2, PerPage: 2})
+		require.NoError(t, err)
+		require.Equal(t, []models.AvailableElement{
+			{ID: "abc/4", Type: "panel", Title: "Node Exporter / 面板 4"},
+			{ID: "def/7", Type: "panel", Title: "Nginx / Requests"},
+		}, page.Elements)
+
+		page, err = s.Discover(t.Context(), ds, Query{Types: []models.ReportElementType{models.PanelType}, Page: 3, PerPage: 2})
+		require.NoError(t, err)
+		require.Equal(t, 4, page.Total)
+		require.NotNil(t, page.Elements)
+		require.Empty(t, page.Elements)
+
+		// 翻頁時共用快取的目錄，每個儀表板只讀取一次
+		require.Equal(t, int32(2), dashboards.Load())
+	})
+
+	t.Run("text matches dashboards and panels", func(t *testing.T) {
+		page, err := s.Discover(t.Context(), ds, Query{Text: "NGINX"})
+		require.NoError(t, err)
+		require.Equal(t, []models.AvailableElement{
+			{ID: "def", Type: "dashboard", Title: "Nginx"},
+			{ID: "def/7", Type: "panel", Title: "Nginx / Requests"},
+		}, page.Elements)
+	})
+
+	t.Run("unsupported type", func(t *testing.T) {
+		_, err := s.Discover(t.Context(), ds, Query{Types: []models.ReportElementType{models.SavedSearchType}})
+		require.ErrorIs(t, err, ErrInvalidQuery)
+	})
+}
+
+func TestDiscover_Cache(t *testing.T) {
+	var requests atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		w.Write([]byte(`{"page":1,"per_page":20,"total":0,"saved_objects":[]}`))
+	}))
+	defer srv.Close()
+	ds := &models.DataSource{ID: "ds-kibana", Type: models.Kibana, URL: srv.URL, AuthType: models.AuthNone}
+
+	now := time.Date(2024, 5, 6, 9, 0, 0, 0, time.UTC)
+	s := NewService(nil)
+	s.now = func() time.Time { return now }
+	discover := func(q Query) {
+		t.Helper()
+		_, err := s.Discover(t.Context(), ds, q)
+		require.NoError(t, err)
+	}
+
+	discover(Query{Text: "web"})
+	discover(Query{Text: "web"})
+	require.Equal(t, int32(1), requests.Load(), "相同的查詢應該使用快取")
+
+	// 元素類型的順序不影響快取
+	discover(Query{Types: []models.ReportElementType{models.LensType, models.DashboardType}})
+	discover(Query{Types: []models.ReportElementType{models.DashboardType, models.LensType}})
+	require.Equal(t, int32(2), requests.Load())
+
+	discover(Query{Text: "web", Page: 2})
+	require.Equal(t, int32(3), requests.Load(), "不同頁數是不同的查詢")
+
+	now = now.Add(DefaultCacheTTL)
+	discover(Query{Text: "web"})
+	require.Equal(t, int32(4), requests.Load(), "快取過期後應重新查詢")
+
+	s.Invalidate(ds.ID)
+	discover(Query{Text: "web"})
+	require.Equal(t, int32(5), requests.Load(), "清除快取後應重新查詢")
+
+	// 其他資料來源不會共用快取
+	other := *ds
+	other.ID = "ds-other"
+	_, err := s.Discover(t.Context(), &other, Query{Text: "web"})
+	require.NoError(t, err)
+	require.Equal(t, int32(6), requests.Load())
+}
@@ -0,0 +1,147 @@
+package discovery
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"report-scheduler/backend/internal/models"
+	"strconv"
+	"strings"
+)
+
+// grafanaSearchLimit 是 Grafana /api/search 單次最多回傳的儀表板數量
+const grafanaSearchLimit = 5000
+
+// grafanaSearchHit 是 Grafana /api/search 回傳的一個儀表板
+type grafanaSearchHit struct {
+	UID   string `json:"uid"`
+	Title string `json:"title"`
+}
+
+// grafanaPanel 是儀表板 JSON 中的一個面板，收合的 row 會把面板放在自己的 panels 中
+type grafanaPanel struct {
+	ID     int            `json:"id"`
+	Title  string         `json:"title"`
+	Type   string         `json:"type"`
+	Panels []grafanaPanel `json:"panels"`
+}
+
+// discoverGrafana 從 Grafana 搜尋儀表板與面板。
+// Grafana 的搜尋無法比對面板標題，因此先取得完整的目錄 (會被快取)，再於本地篩選與分頁。
+func (s *Service) discoverGrafana(ctx context.Context, ds *models.DataSource, q Query) (*Page, error) {
+	includeDashboards, includePanels := false, false
+	for _, t := range q.Types {
+		switch t {
+		case models.DashboardType:
+			includeDashboards = true
+		case models.PanelType:
+			includePanels = true
+		}
+	}
+
+	value, err := s.cached(ds.ID, fmt.Sprintf("grafana-catalog|%t", includePanels), func() (interface{}, error) {
+		return s.grafanaCatalog(ctx, ds, includePanels)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	text := strings.ToLower(strings.TrimSpace(q.Text))
+	var matched []models.AvailableElement
+	for _, element := range value.([]models.AvailableElement) {
+		if element.Type == string(models.DashboardType) && !includeDashboards {
+			continue
+		}
+		if text != "" && !strings.Contains(strings.ToLower(element.Title), text) {
+			continue
+		}
+		matched = append(matched, element)
+	}
+
+	page := &Page{Elements: []models.AvailableElement{}, Total: len(matched), Page: q.Page, PerPage: q.PerPage}
+	if start := (q.Page - 1) * q.PerPage; start < len(matched) {
+		end := min(start+q.PerPage, len(matched))
+		page.Elements = append(page.Elements, matched[start:end]...)
+	}
+	return page, nil
+}
+
+// grafanaCatalog 列出所有儀表板，includePanels 為 true 時每個儀表板後面接著它的面板
+func (s *Service) grafanaCatalog(ctx context.Context, ds *models.DataSource, includePanels bool) ([]models.AvailableElement, error) {
+	baseURL := strings.TrimRight(ds.URL, "/")
+	params := url.Values{}
+	params.Set("type", "dash-db")
+	params.Set("limit", strconv.Itoa(grafanaSearchLimit))
+
+	var hits []grafanaSearchHit
+	if err := s.getGrafanaJSON(ctx, ds, baseURL+"/api/search?"+params.Encode(), &hits); err != nil {
+		return nil, err
+	}
+
+	catalog := []models.AvailableElement{}
+	for _, hit := range hits {
+		catalog = append(catalog, models.AvailableElement{ID: hit.UID, Type: string(models.DashboardType), Title: hit.Title})
+		if !includePanels {
+			continue
+		}
+
+		var dashboard struct {
+			Dashboard struct {
+				Panels []grafanaPanel `json:"panels"`
+			} `json:"dashboard"`
+		}
+		if err := s.getGrafanaJSON(ctx, ds, baseURL+"/api/dashboards/uid/"+url.PathEscape(hit.UID), &dashboard); err != nil {
+			return nil, err
+		}
+		for _, panel := range flattenGrafanaPanels(dashboard.Dashboard.Panels) {
+			title := panel.Title
+			if title == "" {
+				title = fmt.Sprintf("面板 %d", panel.ID)
+			}
+			catalog = append(catalog, models.AvailableElement{
+				// 與產生報表時相同的 "<儀表板 UID>/<面板 ID>" 格式
+				ID:    hit.UID + "/" + strconv.Itoa(panel.ID),
+				Type:  string(models.PanelType),
+				Title: hit.Title + " / " + title,
+			})
+		}
+	}
+	return catalog, nil
+}
+
+// flattenGrafanaPanels 展開 row 中的面板，row 本身無法渲染因此不會列出
+func flattenGrafanaPanels(panels []grafanaPanel) []grafanaPanel {
+	var flat []grafanaPanel
+	for _, panel := range panels {
+		if panel.Type == "row" {
+			flat = append(flat, flattenGrafanaPanels(panel.Panels)...)
+			continue
+		}
+		flat = append(flat, panel)
+	}
+	return flat
+}
+
+// getGrafanaJSON 向 Grafana 發出 GET 請求並解析 JSON 回應
+func (s *Service) getGrafanaJSON(ctx context.Context, ds *models.DataSource, requestURL string, v interface{}) error {
+	req, err := s.newRequest(ctx, ds, requestURL, "Bearer")
+	if err != nil {
+		return err
+	}
+	resp, err := s.Client.Do(req)
+	if err != nil {
+		return fmt.Errorf("請求 Grafana API 失敗: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return fmt.Errorf("Grafana API 回應非 200 狀態: %d, body: %s", resp.StatusCode, string(body))
+	}
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return fmt.Errorf("無法解析 Grafana API 的回應: %w", err)
+	}
+	return nil
+}
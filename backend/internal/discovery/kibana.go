@@ -0,0 +1,101 @@
+package discovery
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"report-scheduler/backend/internal/models"
+	"strconv"
+	"strings"
+)
+
+// kibanaSavedObjectTypes 是元素類型對應的 Kibana 已儲存物件類型
+var kibanaSavedObjectTypes = map[models.ReportElementType]string{
+	models.DashboardType:     "dashboard",
+	models.VisualizationType: "visualization",
+	models.LensType:          "lens",
+	models.SavedSearchType:   "search",
+}
+
+// kibanaFindResponse 是 Kibana saved objects _find API 的回應
+type kibanaFindResponse struct {
+	Page         int `json:"page"`
+	PerPage      int `json:"per_page"`
+	Total        int `json:"total"`
+	SavedObjects []struct {
+		ID         string `json:"id"`
+		Type       string `json:"type"`
+		Attributes struct {
+			Title string `json:"title"`
+		} `json:"attributes"`
+	} `json:"saved_objects"`
+}
+
+// buildKibanaFindURL 建構指定 space 中 saved objects _find API 的 URL，搜尋與分頁都交由 Kibana 處理
+func buildKibanaFindURL(ds *models.DataSource, q Query) string {
+	var spacePrefix string
+	if q.Space != "" && q.Space != "default" {
+		spacePrefix = "/s/" + url.PathEscape(q.Space)
+	}
+
+	params := url.Values{}
+	for _, t := range q.Types {
+		params.Add("type", kibanaSavedObjectTypes[t])
+	}
+	params.Set("fields", "title")
+	params.Set("page", strconv.Itoa(q.Page))
+	params.Set("per_page", strconv.Itoa(q.PerPage))
+	// 每個字詞都以前綴比對，輸入到一半的字也能找到物件
+	if words := strings.Fields(q.Text); len(words) > 0 {
+		for i, word := range words {
+			words[i] = word + "*"
+		}
+		params.Set("search", strings.Join(words, " "))
+		params.Set("search_fields", "title")
+		params.Set("default_search_operator", "AND")
+	}
+	return strings.TrimRight(ds.URL, "/") + spacePrefix + "/api/saved_objects/_find?" + params.Encode()
+}
+
+// discoverKibana 從 Kibana 的已儲存物件中搜尋儀表板、視覺化、Lens 與已儲存的搜尋
+func (s *Service) discoverKibana(ctx context.Context, ds *models.DataSource, q Query) (*Page, error) {
+	req, err := s.newRequest(ctx, ds, buildKibanaFindURL(ds, q), "ApiKey")
+	if err != nil {
+		return nil, err
+	}
+	// 與產生報表時相同，繞過 demo.elastic.co 的 cookie 同意頁面
+	req.AddCookie(&http.Cookie{Name: "_iub-error", Value: "y"})
+
+	resp, err := s.Client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("請求 Kibana 已儲存物件 API 失敗: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return nil, fmt.Errorf("Kibana 已儲存物件 API 回應非 200 狀態: %d, body: %s", resp.StatusCode, string(body))
+	}
+
+	var found kibanaFindResponse
+	if err := json.NewDecoder(resp.Body).Decode(&found); err != nil {
+		return nil, fmt.Errorf("無法解析 Kibana 已儲存物件 API 的回應: %w", err)
+	}
+
+	page := &Page{Elements: []models.AvailableElement{}, Total: found.Total, Page: q.Page, PerPage: q.PerPage}
+	for _, obj := range found.SavedObjects {
+		var elementType models.ReportElementType
+		for t, savedObjectType := range kibanaSavedObjectTypes {
+			if savedObjectType == obj.Type {
+				elementType = t
+			}
+		}
+		if elementType == "" {
+			continue
+		}
+		page.Elements = append(page.Elements, models.AvailableElement{ID: obj.ID, Type: string(elementType), Title: obj.Attributes.Title})
+	}
+	return page, nil
+}
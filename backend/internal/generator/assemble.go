@@ -0,0 +1,273 @@
+package generator
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"report-scheduler/backend/internal/models"
+	"report-scheduler/backend/internal/queue"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/pdfcpu/pdfcpu/pkg/api"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu"
+)
+
+// elementGenerator 是各資料來源產生器共用的介面，負責產生報表中的單一元素
+type elementGenerator interface {
+	generateElement(ctx context.Context, task *queue.Task, ds *models.DataSource, report *models.ReportDefinition, element models.ReportElement) (*GenerateResult, error)
+}
+
+// 目錄頁的版面配置
+const (
+	tocTopY        = 740.0
+	tocLineSpacing = 22.0
+	tocEntriesPage = 30
+	tocMarginX     = 60.0
+)
+
+// assembledElement 記錄已產生的元素及其在合併後文件中的位置
+type assembledElement struct {
+	title     string
+	filePath  string
+	pageCount int
+	startPage int
+}
+
+// assembleReport 依 Order 順序逐一產生報表的每個元素，並合併成單一份 PDF。
+// 只有一個元素且不需要封面與目錄時，直接回傳該元素的輸出 (保留其原本的格式)；
+// 無法合併的輸出 (例如 CSV) 會放在結果的 Extra 中。所有元素都無法合併但需要封面或目錄時，
+// 仍會產生只有封面與目錄的 PDF，不會因此略過。
+func assembleReport(ctx context.Context, g elementGenerator, task *queue.Task, ds *models.DataSource, report *models.ReportDefinition) (*GenerateResult, error) {
+	if len(report.Elements) == 0 {
+		return nil, fmt.Errorf("報表 '%s' 中沒有任何元素", report.Name)
+	}
+
+	elements := make([]models.ReportElement, len(report.Elements))
+	copy(elements, report.Elements)
+	sort.SliceStable(elements, func(i, j int) bool { return elements[i].Order < elements[j].Order })
+
+	if len(elements) == 1 && !report.CoverPage && !report.TableOfContents {
+		return g.generateElement(ctx, task, ds, report, elements[0])
+	}
+
+	// 所有中間檔案在結束時刪除，只保留合併後的結果
+	var tempFiles []string
+	defer func() {
+		for _, path := range tempFiles {
+			os.Remove(path)
+		}
+	}()
+
+	parts := make([]assembledElement, 0, len(elements))
+	var extras []GenerateResult
+	for _, element := range elements {
+		title := element.Title
+		if title == "" {
+			title = element.ID
+		}
+
+		// 每個元素開始前檢查是否已被取消，避免在已中止的任務上繼續送出請求
+		if err := ctx.Err(); err != nil {
+			removeResults(extras)
+			return nil, err
+		}
+		result, err := g.generateElement(ctx, task, ds, report, element)
+		if err != nil {
+			removeResults(extras)
+			return nil, fmt.Errorf("產生元素 '%s' 失敗: %w", title, err)
+		}
+
+		pdfPath := result.FilePath
+		switch result.MimeType {
+		case "application/pdf":
+			tempFiles = append(tempFiles, result.FilePath)
+		case "image/png":
+			pdfPath = strings.TrimSuffix(result.FilePath, ".png") + ".pdf"
+			tempFiles = append(tempFiles, result.FilePath, pdfPath)
+			if err := imageToPDF(result.FilePath, pdfPath); err != nil {
+				removeResults(extras)
+				return nil, err
+			}
+		default:
+			// 例如已儲存搜尋的 CSV，無法放進 PDF 中，改為獨立的檔案
+			extras = append(extras, *result)
+			continue
+		}
+
+		pageCount, err := api.PageCountFile(pdfPath)
+		if err != nil {
+			removeResults(extras)
+			return nil, fmt.Errorf("無法讀取元素 '%s' 的 PDF: %w", title, err)
+		}
+		parts = append(parts, assembledElement{title: title, filePath: pdfPath, pageCount: pageCount})
+	}
+
+	// 沒有任何可以合併的元素，也不需要封面與目錄時，直接回傳各自的檔案
+	if len(parts) == 0 && !report.CoverPage && !report.TableOfContents {
+		return &GenerateResult{FilePath: extras[0].FilePath, MimeType: extras[0].MimeType, Extra: extras[1:]}, nil
+	}
+
+	// 計算每個元素在合併後文件中的起始頁碼
+	page := 1
+	if report.CoverPage {
+		page++
+	}
+	if report.TableOfContents {
+		page += max(1, (len(parts)+tocEntriesPage-1)/tocEntriesPage)
+	}
+	for i := range parts {
+		parts[i].startPage = page
+		page += parts[i].pageCount
+	}
+
+	var inputs []string
+	if report.CoverPage {
+		cover, err := coverPage(task, report)
+		if err != nil {
+			removeResults(extras)
+			return nil, err
+		}
+		coverPath, err := writeTempTextPDF(task, cover)
+		if err != nil {
+			removeResults(extras)
+			return nil, err
+		}
+		tempFiles = append(tempFiles, coverPath)
+		inputs = append(inputs, coverPath)
+	}
+	if report.TableOfContents {
+		tocPath, err := writeTempTextPDF(task, tableOfContentsPages(parts)...)
+		if err != nil {
+			removeResults(extras)
+			return nil, err
+		}
+		tempFiles = append(tempFiles, tocPath)
+		inputs = append(inputs, tocPath)
+	}
+	for _, part := range parts {
+		inputs = append(inputs, part.filePath)
+	}
+
+	outFile, err := os.CreateTemp("", fmt.Sprintf("report-%s-*.pdf", task.ID))
+	if err != nil {
+		removeResults(extras)
+		return nil, fmt.Errorf("建立暫存檔案失敗: %w", err)
+	}
+	outFile.Close()
+	if err := api.MergeCreateFile(inputs, outFile.Name(), false, nil); err != nil {
+		os.Remove(outFile.Name())
+		removeResults(extras)
+		return nil, fmt.Errorf("合併 PDF 失敗: %w", err)
+	}
+
+	// 為每個元素加上書籤，方便在閱讀器中跳轉
+	if len(parts) > 0 {
+		bookmarks := make([]pdfcpu.Bookmark, len(parts))
+		for i, part := range parts {
+			bookmarks[i] = pdfcpu.Bookmark{Title: part.title, PageFrom: part.startPage}
+		}
+		if err := api.AddBookmarksFile(outFile.Name(), "", bookmarks, true, nil); err != nil {
+			log.Printf("[Generator] 警告：無法為報表 '%s' 加入書籤: %v", report.Name, err)
+		}
+	}
+
+	log.Printf("[Generator] 已將報表 '%s' 的 %d 個元素合併至 %s", report.Name, len(parts), outFile.Name())
+	return &GenerateResult{
+		FilePath: outFile.Name(),
+		MimeType: "application/pdf",
+		Extra:    extras,
+	}, nil
+}
+
+// removeResults 刪除已產生但因為錯誤而不會被使用的檔案
+func removeResults(results []GenerateResult) {
+	for _, result := range results {
+		os.Remove(result.FilePath)
+	}
+}
+
+// writeTempTextPDF 將文字頁面寫入一個新的暫存 PDF 檔案
+func writeTempTextPDF(task *queue.Task, pages ...[]pdfTextLine) (string, error) {
+	f, err := os.CreateTemp("", fmt.Sprintf("report-%s-*.pdf", task.ID))
+	if err != nil {
+		return "", fmt.Errorf("建立暫存檔案失敗: %w", err)
+	}
+	f.Close()
+	if err := writeTextPDF(f.Name(), pages); err != nil {
+		os.Remove(f.Name())
+		return "", fmt.Errorf("產生 PDF 頁面失敗: %w", err)
+	}
+	return f.Name(), nil
+}
+
+// centered 回傳一行水平置中的文字
+func centered(text string, size, y float64) pdfTextLine {
+	text = fitText(text, size, a4Width-2*tocMarginX)
+	return pdfTextLine{Text: text, Size: size, X: (a4Width - textWidth(text, size)) / 2, Y: y}
+}
+
+// coverPage 產生封面頁：報表名稱、描述、實際涵蓋的時間範圍與報表基準時間，時間都以任務的時區顯示
+func coverPage(task *queue.Task, report *models.ReportDefinition) ([]pdfTextLine, error) {
+	const layout = "2006-01-02 15:04"
+	loc := task.Location()
+
+	lines := []pdfTextLine{centered(report.Name, 28, 520)}
+	y := 470.0
+	if report.Description != "" {
+		lines = append(lines, centered(report.Description, 14, y))
+		y -= 40
+	}
+	from, to, ok, err := ResolveReportTimeRange(task, report)
+	if err != nil {
+		return nil, err
+	}
+	if ok {
+		lines = append(lines, centered("時間範圍："+from.In(loc).Format(layout)+" ~ "+to.In(loc).Format(layout), 12, y))
+		y -= 24
+	}
+	lines = append(lines, centered("報表時間："+task.Reference().In(loc).Format(layout)+" ("+loc.String()+")", 12, y))
+	return lines, nil
+}
+
+// tableOfContentsPages 產生目錄頁，每一行為元素標題與其起始頁碼，超過一頁時自動換頁。
+// 沒有任何元素放入 PDF 時，目錄頁只說明內容以附件提供。
+func tableOfContentsPages(parts []assembledElement) [][]pdfTextLine {
+	const titleSize, entrySize = 20.0, 12.0
+	rightX := a4Width - tocMarginX
+
+	if len(parts) == 0 {
+		return [][]pdfTextLine{{
+			{Text: "目錄", Size: titleSize, X: tocMarginX, Y: tocTopY + 40},
+			{Text: "本報表的內容皆以附件提供。", Size: entrySize, X: tocMarginX, Y: tocTopY},
+		}}
+	}
+
+	var pages [][]pdfTextLine
+	for start := 0; start < len(parts); start += tocEntriesPage {
+		lines := []pdfTextLine{{Text: "目錄", Size: titleSize, X: tocMarginX, Y: tocTopY + 40}}
+		end := min(start+tocEntriesPage, len(parts))
+		for i, part := range parts[start:end] {
+			y := tocTopY - float64(i)*tocLineSpacing
+			pageNumber := strconv.Itoa(part.startPage)
+			pageX := rightX - textWidth(pageNumber, entrySize)
+
+			title := fitText(fmt.Sprintf("%d. %s", start+i+1, part.title), entrySize, pageX-tocMarginX-4*entrySize)
+			titleEnd := tocMarginX + textWidth(title, entrySize)
+
+			// 以點線連接標題與頁碼
+			dotWidth := textWidth(".", entrySize)
+			dots := strings.Repeat(".", max(0, int((pageX-titleEnd-entrySize)/dotWidth)))
+
+			lines = append(lines,
+				pdfTextLine{Text: title, Size: entrySize, X: tocMarginX, Y: y},
+				pdfTextLine{Text: dots, Size: entrySize, X: pageX - entrySize/2 - textWidth(dots, entrySize), Y: y},
+				pdfTextLine{Text: pageNumber, Size: entrySize, X: pageX, Y: y},
+			)
+		}
+		pages = append(pages, lines)
+	}
+	return pages
+}
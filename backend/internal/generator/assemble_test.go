@@ -0,0 +1,213 @@
+package generator
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"image/png"
+	"os"
+	"report-scheduler/backend/internal/models"
+	"report-scheduler/backend/internal/queue"
+	"testing"
+	"time"
+
+	"github.com/pdfcpu/pdfcpu/pkg/api"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeElementGenerator 依元素的 Format 產生假的輸出：
+// pdf 會產生 pages[元素 ID] 頁的 PDF，png 會產生一張圖片，csv 會產生一個文字檔。
+type fakeElementGenerator struct {
+	t     *testing.T
+	pages map[string]int
+	fail  string
+	calls []string
+}
+
+func (f *fakeElementGenerator) generateElement(ctx context.Context, task *queue.Task, ds *models.DataSource, report *models.ReportDefinition, element models.ReportElement) (*GenerateResult, error) {
+	f.calls = append(f.calls, element.ID)
+	if element.ID == f.fail {
+		return nil, errors.New("渲染失敗")
+	}
+
+	dir := f.t.TempDir()
+	switch element.Format {
+	case models.FormatPNG:
+		path := dir + "/" + element.ID + ".png"
+		file, err := os.Create(path)
+		require.NoError(f.t, err)
+		require.NoError(f.t, png.Encode(file, image.NewRGBA(image.Rect(0, 0, 40, 20))))
+		file.Close()
+		return &GenerateResult{FilePath: path, MimeType: "image/png"}, nil
+	case models.FormatCSV:
+		path := dir + "/" + element.ID + ".csv"
+		require.NoError(f.t, os.WriteFile(path, []byte("a,b\n"), 0644))
+		return &GenerateResult{FilePath: path, MimeType: "text/csv"}, nil
+	default:
+		path := dir + "/" + element.ID + ".pdf"
+		pages := make([][]pdfTextLine, f.pages[element.ID])
+		for i := range pages {
+			pages[i] = []pdfTextLine{{Text: fmt.Sprintf("%s 第 %d 頁", element.ID, i+1), Size: 12, X: 50, Y: 700}}
+		}
+		require.NoError(f.t, writeTextPDF(path, pages))
+		return &GenerateResult{FilePath: path, MimeType: "application/pdf"}, nil
+	}
+}
+
+func TestAssembleReport(t *testing.T) {
+	task := &queue.Task{ID: "task-assemble", CreatedAt: time.Date(2024, 5, 1, 9, 0, 0, 0, time.UTC)}
+
+	t.Run("merges elements in order with cover and table of contents", func(t *testing.T) {
+		gen := &fakeElementGenerator{t: t, pages: map[string]int{"first": 2, "third": 3}}
+		report := &models.ReportDefinition{
+			Name:            "每週營運報表",
+			Description:     "業務、流量與錯誤率總覽",
+			TimeRange:       "now-7d",
+			CoverPage:       true,
+			TableOfContents: true,
+			Elements: models.ReportElements{
+				{ID: "third", Title: "錯誤率", Order: 3},
+				{ID: "first", Title: "業務總覽", Order: 1},
+				{ID: "second", Title: "Traffic", Order: 2, Format: models.FormatPNG},
+			},
+		}
+
+		result, err := assembleReport(t.Context(), gen, task, &models.DataSource{}, report)
+		require.NoError(t, err)
+		defer os.Remove(result.FilePath)
+
+		require.Equal(t, "application/pdf", result.MimeType)
+		require.Equal(t, []string{"first", "second", "third"}, gen.calls)
+
+		// 封面 1 頁 + 目錄 1 頁 + 元素 2 + 1 + 3 頁
+		pageCount, err := api.PageCountFile(result.FilePath)
+		require.NoError(t, err)
+		require.Equal(t, 8, pageCount)
+
+		f, err := os.Open(result.FilePath)
+		require.NoError(t, err)
+		defer f.Close()
+		bookmarks, err := api.Bookmarks(f, nil)
+		require.NoError(t, err)
+		require.Len(t, bookmarks, 3)
+		require.Equal(t, "業務總覽", bookmarks[0].Title)
+		require.Equal(t, 3, bookmarks[0].PageFrom)
+		require.Equal(t, "Traffic", bookmarks[1].Title)
+		require.Equal(t, 5, bookmarks[1].PageFrom)
+		require.Equal(t, "錯誤率", bookmarks[2].Title)
+		require.Equal(t, 6, bookmarks[2].PageFrom)
+	})
+
+	t.Run("merges without cover or table of contents", func(t *testing.T) {
+		gen := &fakeElementGenerator{t: t, pages: map[string]int{"a": 1, "b": 1}}
+		report := &models.ReportDefinition{Elements: models.ReportElements{{ID: "a"}, {ID: "b", Order: 1}}}
+
+		result, err := assembleReport(t.Context(), gen, task, &models.DataSource{}, report)
+		require.NoError(t, err)
+		defer os.Remove(result.FilePath)
+
+		pageCount, err := api.PageCountFile(result.FilePath)
+		require.NoError(t, err)
+		require.Equal(t, 2, pageCount)
+	})
+
+	t.Run("single element keeps its own output", func(t *testing.T) {
+		gen := &fakeElementGenerator{t: t}
+		report := &models.ReportDefinition{Elements: models.ReportElements{{ID: "only", Format: models.FormatPNG}}}
+
+		result, err := assembleReport(t.Context(), gen, task, &models.DataSource{}, report)
+		require.NoError(t, err)
+		require.Equal(t, "image/png", result.MimeType)
+	})
+
+	t.Run("keeps csv outputs as separate files", func(t *testing.T) {
+		gen := &fakeElementGenerator{t: t, pages: map[string]int{"a": 1}}
+		result, err := assembleReport(t.Context(), gen, task, &models.DataSource{}, &models.ReportDefinition{
+			Elements: models.ReportElements{{ID: "a"}, {ID: "c", Format: models.FormatCSV, Order: 1}},
+		})
+		require.NoError(t, err)
+		defer os.Remove(result.FilePath)
+		require.Equal(t, "application/pdf", result.MimeType)
+		require.Len(t, result.Extra, 1)
+		require.Equal(t, "text/csv", result.Extra[0].MimeType)
+		require.FileExists(t, result.Extra[0].FilePath, "無法合併的檔案不應該被當作暫存檔刪除")
+
+		result, err = assembleReport(t.Context(), gen, task, &models.DataSource{}, &models.ReportDefinition{
+			Elements: models.ReportElements{{ID: "c", Format: models.FormatCSV}, {ID: "d", Format: models.FormatCSV, Order: 1}},
+		})
+		require.NoError(t, err)
+		require.Equal(t, "text/csv", result.MimeType)
+		require.Len(t, result.Extra, 1)
+	})
+
+	t.Run("cover and table of contents are kept without mergeable elements", func(t *testing.T) {
+		gen := &fakeElementGenerator{t: t}
+		result, err := assembleReport(t.Context(), gen, task, &models.DataSource{}, &models.ReportDefinition{
+			Name:            "搜尋匯出",
+			CoverPage:       true,
+			TableOfContents: true,
+			Elements:        models.ReportElements{{ID: "c", Format: models.FormatCSV}, {ID: "d", Format: models.FormatCSV, Order: 1}},
+		})
+		require.NoError(t, err)
+		defer os.Remove(result.FilePath)
+		require.Equal(t, "application/pdf", result.MimeType)
+		require.Len(t, result.Extra, 2)
+
+		pageCount, err := api.PageCountFile(result.FilePath)
+		require.NoError(t, err)
+		require.Equal(t, 2, pageCount)
+	})
+
+	t.Run("errors", func(t *testing.T) {
+		gen := &fakeElementGenerator{t: t, pages: map[string]int{"a": 1}, fail: "b"}
+		_, err := assembleReport(t.Context(), gen, task, &models.DataSource{}, &models.ReportDefinition{
+			Elements: models.ReportElements{{ID: "a"}, {ID: "b", Title: "壞掉的元素", Order: 1}},
+		})
+		require.ErrorContains(t, err, "壞掉的元素")
+
+		_, err = assembleReport(t.Context(), gen, task, &models.DataSource{}, &models.ReportDefinition{Name: "空報表"})
+		require.ErrorContains(t, err, "沒有任何元素")
+	})
+}
+
+func TestTableOfContentsPages(t *testing.T) {
+	parts := make([]assembledElement, tocEntriesPage+5)
+	for i := range parts {
+		parts[i] = assembledElement{title: fmt.Sprintf("元素 %d", i+1), startPage: i + 3}
+	}
+
+	pages := tableOfContentsPages(parts)
+	require.Len(t, pages, 2, "超過一頁的目錄應該自動換頁")
+	// 每頁有標題，每個項目各有標題、點線與頁碼三行
+	require.Len(t, pages[0], 1+tocEntriesPage*3)
+	require.Len(t, pages[1], 1+5*3)
+	require.Equal(t, fmt.Sprintf("%d. 元素 %d", tocEntriesPage+1, tocEntriesPage+1), pages[1][1].Text)
+	require.Equal(t, fmt.Sprint(tocEntriesPage+3), pages[1][3].Text)
+}
+
+func TestCoverPage(t *testing.T) {
+	task := &queue.Task{
+		ID:            "task-cover",
+		Timezone:      "Asia/Taipei",
+		CreatedAt:     time.Date(2024, 5, 1, 9, 0, 0, 0, time.UTC),
+		ReferenceTime: time.Date(2024, 5, 1, 1, 0, 0, 0, time.UTC),
+	}
+	report := &models.ReportDefinition{Name: "每週營運報表", TimeRange: "now-7d/d to now"}
+
+	lines, err := coverPage(task, report)
+	require.NoError(t, err)
+	var texts []string
+	for _, line := range lines {
+		texts = append(texts, line.Text)
+	}
+	// 時間範圍以基準時間解析並以任務的時區顯示
+	require.Equal(t, []string{
+		"每週營運報表",
+		"時間範圍：2024-04-24 00:00 ~ 2024-05-01 09:00",
+		"報表時間：2024-05-01 09:00 (Asia/Taipei)",
+	}, texts)
+
+	_, err = coverPage(task, &models.ReportDefinition{Name: "壞掉的範圍", TimeRange: "now-7x"})
+	require.Error(t, err)
+}
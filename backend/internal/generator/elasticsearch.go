@@ -0,0 +1,662 @@
+package generator
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"report-scheduler/backend/internal/models"
+	"report-scheduler/backend/internal/queue"
+	"report-scheduler/backend/internal/secrets"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ElasticsearchGenerator 直接向 DataSource.APIURL 指向的 Elasticsearch 查詢已儲存搜尋的資料並匯出成 CSV 或 XLSX。
+// 搜尋的索引模式、查詢、篩選與欄位從 Kibana 的 saved objects API 取得，
+// 資料以 point-in-time 加上 search_after 逐頁讀取並直接寫入檔案，因此可以處理遠大於記憶體的結果。
+type ElasticsearchGenerator struct {
+	Secrets secrets.SecretsManager
+	Client  *http.Client
+	// PageSize 是每次查詢取回的文件數
+	PageSize int
+	// KeepAlive 是 point-in-time 在兩次查詢之間保留的時間
+	KeepAlive string
+}
+
+// NewElasticsearchGenerator 建立一個新的 ElasticsearchGenerator
+func NewElasticsearchGenerator(sm secrets.SecretsManager) *ElasticsearchGenerator {
+	return &ElasticsearchGenerator{
+		Secrets:   sm,
+		Client:    &http.Client{Timeout: 60 * time.Second},
+		PageSize:  1000,
+		KeepAlive: "2m",
+	}
+}
+
+// savedSearch 是從 Kibana 解析出的已儲存搜尋
+type savedSearch struct {
+	Index     string
+	TimeField string
+	Columns   []string
+	Sort      [][2]string
+	Query     map[string]interface{}
+}
+
+// savedObject 是 Kibana saved objects API 的回應
+type savedObject struct {
+	Attributes struct {
+		Title                 string          `json:"title"`
+		TimeFieldName         string          `json:"timeFieldName"`
+		Columns               []string        `json:"columns"`
+		Sort                  json.RawMessage `json:"sort"`
+		KibanaSavedObjectMeta struct {
+			SearchSourceJSON string `json:"searchSourceJSON"`
+		} `json:"kibanaSavedObjectMeta"`
+	} `json:"attributes"`
+	References []struct {
+		Name string `json:"name"`
+		Type string `json:"type"`
+		ID   string `json:"id"`
+	} `json:"references"`
+}
+
+// searchSource 是已儲存搜尋中 searchSourceJSON 的內容
+type searchSource struct {
+	Query struct {
+		Query    interface{} `json:"query"`
+		Language string      `json:"language"`
+	} `json:"query"`
+	Filter       []map[string]interface{} `json:"filter"`
+	IndexRefName string                   `json:"indexRefName"`
+	// 舊版的已儲存搜尋直接記錄索引模式的 ID
+	Index string `json:"index"`
+}
+
+// newRequest 建立一個送往 Kibana 或 Elasticsearch 的請求，body 不為 nil 時會編碼成 JSON
+func (g *ElasticsearchGenerator) newRequest(ctx context.Context, method, requestURL string, ds *models.DataSource, body interface{}) (*http.Request, error) {
+	var reader io.Reader
+	if body != nil {
+		payload, err := json.Marshal(body)
+		if err != nil {
+			return nil, fmt.Errorf("無法編碼請求內容: %w", err)
+		}
+		reader = bytes.NewReader(payload)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, requestURL, reader)
+	if err != nil {
+		return nil, fmt.Errorf("無法建立請求: %w", err)
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	// Kibana 與 Elasticsearch 使用同一組憑證
+	if ds.AuthType != models.AuthNone {
+		creds, err := g.Secrets.GetCredentials(ds.CredentialsRef)
+		if err != nil {
+			return nil, fmt.Errorf("無法獲取 Elasticsearch 憑證 for ref %s: %w", ds.CredentialsRef, err)
+		}
+
+		switch ds.AuthType {
+		case models.APIToken:
+			req.Header.Set("Authorization", "ApiKey "+creds.Token)
+		case models.BasicAuth:
+			req.SetBasicAuth(creds.Username, creds.Password)
+		}
+	}
+	req.Header.Set("kbn-xsrf", "true")
+	return req, nil
+}
+
+// doJSON 送出請求並將 200 的回應解析到 out 中
+func (g *ElasticsearchGenerator) doJSON(req *http.Request, out interface{}) error {
+	resp, err := g.Client.Do(req)
+	if err != nil {
+		return fmt.Errorf("請求 %s 失敗: %w", req.URL.Path, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return fmt.Errorf("%s 回應非 200 狀態: %d, body: %s", req.URL.Path, resp.StatusCode, string(body))
+	}
+	if out == nil {
+		return nil
+	}
+	decoder := json.NewDecoder(resp.Body)
+	decoder.UseNumber()
+	if err := decoder.Decode(out); err != nil {
+		return fmt.Errorf("無法解析 %s 的回應: %w", req.URL.Path, err)
+	}
+	return nil
+}
+
+// getSavedObject 從 Kibana 取得指定類型的 saved object
+func (g *ElasticsearchGenerator) getSavedObject(ctx context.Context, ds *models.DataSource, report *models.ReportDefinition, objectType, id string) (*savedObject, error) {
+	var spacePrefix string
+	if report.Space != "" && report.Space != "default" {
+		spacePrefix = "/s/" + url.PathEscape(report.Space)
+	}
+	objectURL := fmt.Sprintf("%s%s/api/saved_objects/%s/%s", strings.TrimRight(ds.URL, "/"), spacePrefix, objectType, url.PathEscape(id))
+
+	req, err := g.newRequest(ctx, http.MethodGet, objectURL, ds, nil)
+	if err != nil {
+		return nil, err
+	}
+	var object savedObject
+	if err := g.doJSON(req, &object); err != nil {
+		return nil, fmt.Errorf("無法取得 Kibana %s '%s': %w", objectType, id, err)
+	}
+	return &object, nil
+}
+
+// resolveSavedSearch 取得已儲存搜尋及其索引模式，並轉換為 Elasticsearch 查詢
+func (g *ElasticsearchGenerator) resolveSavedSearch(ctx context.Context, ds *models.DataSource, report *models.ReportDefinition, id string) (*savedSearch, error) {
+	search, err := g.getSavedObject(ctx, ds, report, "search", id)
+	if err != nil {
+		return nil, err
+	}
+
+	var source searchSource
+	if raw := search.Attributes.KibanaSavedObjectMeta.SearchSourceJSON; raw != "" {
+		if err := json.Unmarshal([]byte(raw), &source); err != nil {
+			return nil, fmt.Errorf("無法解析已儲存搜尋 '%s' 的 searchSourceJSON: %w", id, err)
+		}
+	}
+
+	indexPatternID := source.Index
+	for _, ref := range search.References {
+		if ref.Name == source.IndexRefName && ref.Type == "index-pattern" {
+			indexPatternID = ref.ID
+		}
+	}
+	if indexPatternID == "" {
+		return nil, fmt.Errorf("已儲存搜尋 '%s' 沒有索引模式", id)
+	}
+	indexPattern, err := g.getSavedObject(ctx, ds, report, "index-pattern", indexPatternID)
+	if err != nil {
+		return nil, err
+	}
+
+	query, err := buildSearchQuery(source)
+	if err != nil {
+		return nil, fmt.Errorf("已儲存搜尋 '%s': %w", id, err)
+	}
+
+	resolved := &savedSearch{
+		Index:     indexPattern.Attributes.Title,
+		TimeField: indexPattern.Attributes.TimeFieldName,
+		Sort:      parseSavedSort(search.Attributes.Sort),
+		Query:     query,
+	}
+	for _, column := range search.Attributes.Columns {
+		if column != "_source" {
+			resolved.Columns = append(resolved.Columns, column)
+		}
+	}
+	// 與 Discover 相同，有時間欄位時放在第一欄
+	if resolved.TimeField != "" && len(resolved.Columns) > 0 && !containsString(resolved.Columns, resolved.TimeField) {
+		resolved.Columns = append([]string{resolved.TimeField}, resolved.Columns...)
+	}
+	if len(resolved.Sort) == 0 && resolved.TimeField != "" {
+		resolved.Sort = [][2]string{{resolved.TimeField, "desc"}}
+	}
+	return resolved, nil
+}
+
+// parseSavedSort 解析已儲存搜尋的排序，新版為 [["欄位","desc"]]，舊版為 ["欄位","desc"]
+func parseSavedSort(raw json.RawMessage) [][2]string {
+	var sorts [][2]string
+	if json.Unmarshal(raw, &sorts) == nil {
+		return sorts
+	}
+	var single [2]string
+	if json.Unmarshal(raw, &single) == nil && single[0] != "" {
+		return [][2]string{single}
+	}
+	return nil
+}
+
+// buildSearchQuery 將已儲存搜尋的查詢與篩選轉換為 Elasticsearch 的 bool 查詢
+func buildSearchQuery(source searchSource) (map[string]interface{}, error) {
+	var must, filter, mustNot []interface{}
+
+	queryText, _ := source.Query.Query.(string)
+	if strings.TrimSpace(queryText) != "" {
+		switch source.Query.Language {
+		case "lucene":
+			must = append(must, map[string]interface{}{"query_string": map[string]interface{}{"query": queryText}})
+		case "kuery", "":
+			converted, err := kqlToQueryString(queryText)
+			if err != nil {
+				return nil, err
+			}
+			must = append(must, map[string]interface{}{"query_string": map[string]interface{}{"query": converted}})
+		default:
+			return nil, fmt.Errorf("不支援的查詢語言: %s", source.Query.Language)
+		}
+	} else if dsl, ok := source.Query.Query.(map[string]interface{}); ok && len(dsl) > 0 {
+		// 舊版的 lucene 查詢可能直接是 DSL
+		must = append(must, dsl)
+	}
+
+	for _, f := range source.Filter {
+		meta, _ := f["meta"].(map[string]interface{})
+		if disabled, _ := meta["disabled"].(bool); disabled {
+			continue
+		}
+
+		// 新版篩選把 DSL 放在 query 欄位，舊版則直接放在篩選物件上 (例如 range、exists)
+		clause, ok := f["query"].(map[string]interface{})
+		if !ok {
+			clause = map[string]interface{}{}
+			for key, value := range f {
+				if key != "meta" && key != "$state" {
+					clause[key] = value
+				}
+			}
+		}
+		if len(clause) == 0 {
+			continue
+		}
+
+		if negate, _ := meta["negate"].(bool); negate {
+			mustNot = append(mustNot, clause)
+		} else {
+			filter = append(filter, clause)
+		}
+	}
+
+	boolQuery := map[string]interface{}{}
+	if len(must) > 0 {
+		boolQuery["must"] = must
+	}
+	if len(filter) > 0 {
+		boolQuery["filter"] = filter
+	}
+	if len(mustNot) > 0 {
+		boolQuery["must_not"] = mustNot
+	}
+	return map[string]interface{}{"bool": boolQuery}, nil
+}
+
+// query_string 中有特殊意義、在 KQL 中卻只是一般字元的符號，轉換時以反斜線跳脫。
+// queryStringPrefixOperators 只有出現在字詞開頭時才是運算子，例如 web-1 不需要跳脫。
+const (
+	queryStringReserved        = `=&|{}[]^~?/`
+	queryStringPrefixOperators = `+-!`
+)
+
+// kqlToQueryString 將 KQL 轉換為 Elasticsearch query_string 語法：
+// 布林運算子 and/or/not 轉為大寫，"欄位 >= 值" 轉為 "欄位:>=值"，引號中的內容與 KQL 的跳脫字元保持不變，
+// query_string 的保留字元會被跳脫。無法等價轉換的語法 (巢狀欄位查詢、欄位名稱中的萬用字元)
+// 會回傳錯誤，不會產生結果不同的查詢。
+func kqlToQueryString(kql string) (string, error) {
+	var out strings.Builder
+	runes := []rune(kql)
+	depth := 0
+	for i := 0; i < len(runes); {
+		r := runes[i]
+		switch {
+		case r == '"':
+			// 複製整段引號字串
+			j := i + 1
+			for j < len(runes) && runes[j] != '"' {
+				if runes[j] == '\\' {
+					j++
+				}
+				j++
+			}
+			if j >= len(runes) {
+				return "", fmt.Errorf("KQL 查詢的引號沒有結束: %s", kql)
+			}
+			out.WriteString(string(runes[i : j+1]))
+			i = j + 1
+		case r == '{' || r == '}':
+			return "", fmt.Errorf("不支援的 KQL 語法: 巢狀欄位查詢 (%s)", kql)
+		case r == '(' || r == ')':
+			if r == '(' {
+				depth++
+			} else if depth--; depth < 0 {
+				return "", fmt.Errorf("KQL 查詢的括號不成對: %s", kql)
+			}
+			out.WriteRune(r)
+			i++
+		case r == '<' || r == '>':
+			// 範圍運算子：去掉前後的空白並接在冒號後
+			op := string(r)
+			i++
+			if i < len(runes) && runes[i] == '=' {
+				op += "="
+				i++
+			}
+			trimmed := strings.TrimRight(out.String(), " ")
+			out.Reset()
+			out.WriteString(trimmed + ":" + op)
+			for i < len(runes) && runes[i] == ' ' {
+				i++
+			}
+		case r == ':':
+			out.WriteRune(r)
+			i++
+			for i < len(runes) && runes[i] == ' ' {
+				i++
+			}
+		case isKQLWordRune(r):
+			var word strings.Builder
+			hasWildcard := false
+			j := i
+			for j < len(runes) && isKQLWordRune(runes[j]) {
+				switch c := runes[j]; {
+				case c == '\\' && j+1 < len(runes):
+					// KQL 與 query_string 的跳脫方式相同
+					word.WriteRune(c)
+					word.WriteRune(runes[j+1])
+					j += 2
+					continue
+				case c == '{' || c == '}':
+					return "", fmt.Errorf("不支援的 KQL 語法: 巢狀欄位查詢 (%s)", kql)
+				case c == '*':
+					hasWildcard = true
+				case strings.ContainsRune(queryStringReserved, c), j == i && strings.ContainsRune(queryStringPrefixOperators, c):
+					word.WriteRune('\\')
+				}
+				word.WriteRune(runes[j])
+				j++
+			}
+			if hasWildcard && isKQLFieldName(runes, j) {
+				return "", fmt.Errorf("不支援的 KQL 語法: 欄位名稱中的萬用字元 '%s'", string(runes[i:j]))
+			}
+			text := word.String()
+			switch strings.ToLower(text) {
+			case "and", "or", "not":
+				text = strings.ToUpper(text)
+			}
+			out.WriteString(text)
+			i = j
+		default:
+			out.WriteRune(r)
+			i++
+		}
+	}
+	if depth != 0 {
+		return "", fmt.Errorf("KQL 查詢的括號不成對: %s", kql)
+	}
+	return out.String(), nil
+}
+
+func isKQLWordRune(r rune) bool {
+	return r != ' ' && r != '"' && r != ':' && r != '<' && r != '>' && r != '(' && r != ')'
+}
+
+// isKQLFieldName 表示結束於 end 的字詞後面接著冒號或範圍運算子，也就是一個欄位名稱
+func isKQLFieldName(runes []rune, end int) bool {
+	for end < len(runes) && runes[end] == ' ' {
+		end++
+	}
+	return end < len(runes) && (runes[end] == ':' || runes[end] == '<' || runes[end] == '>')
+}
+
+func containsString(values []string, target string) bool {
+	for _, value := range values {
+		if value == target {
+			return true
+		}
+	}
+	return false
+}
+
+// generateElement 將一個已儲存的搜尋匯出成 CSV (預設) 或 XLSX
+func (g *ElasticsearchGenerator) generateElement(ctx context.Context, task *queue.Task, ds *models.DataSource, report *models.ReportDefinition, element models.ReportElement) (*GenerateResult, error) {
+	if ds.APIURL == "" {
+		return nil, fmt.Errorf("資料來源 '%s' 未設定 Elasticsearch API URL", ds.Name)
+	}
+	if element.Type != models.SavedSearchType {
+		return nil, fmt.Errorf("Elasticsearch 匯出只支援已儲存的搜尋，不支援: %s", element.Type)
+	}
+	format := element.Format
+	if format == "" {
+		format = models.FormatCSV
+	}
+	if format != models.FormatCSV && format != models.FormatXLSX {
+		return nil, fmt.Errorf("Elasticsearch 匯出不支援的輸出格式: %s", format)
+	}
+
+	search, err := g.resolveSavedSearch(ctx, ds, report, element.ID)
+	if err != nil {
+		return nil, err
+	}
+	from, to, ok, err := ResolveReportTimeRange(task, report)
+	if err != nil {
+		return nil, err
+	}
+	if ok && search.TimeField != "" {
+		boolQuery := search.Query["bool"].(map[string]interface{})
+		filter, _ := boolQuery["filter"].([]interface{})
+		boolQuery["filter"] = append(filter, map[string]interface{}{
+			"range": map[string]interface{}{
+				search.TimeField: map[string]interface{}{
+					"gte":    from.UTC().Format(time.RFC3339Nano),
+					"lte":    to.UTC().Format(time.RFC3339Nano),
+					"format": "strict_date_optional_time",
+				},
+			},
+		})
+	}
+
+	if clauses := parameterClauses(task.Parameters); len(clauses) > 0 {
+		boolQuery := search.Query["bool"].(map[string]interface{})
+		filter, _ := boolQuery["filter"].([]interface{})
+		boolQuery["filter"] = append(filter, clauses...)
+	}
+
+	mimeType := mimeTypeForFormat(format)
+	file, err := os.CreateTemp("", fmt.Sprintf("report-%s-*%s", task.ID, extensionForMimeType(mimeType)))
+	if err != nil {
+		return nil, fmt.Errorf("建立暫存檔案失敗: %w", err)
+	}
+	defer file.Close()
+
+	var writer rowWriter
+	if format == models.FormatXLSX {
+		writer, err = newXLSXRowWriter(file)
+	} else {
+		writer = newCSVRowWriter(file)
+	}
+	if err == nil {
+		var rows int
+		rows, err = g.export(ctx, ds, search, writer)
+		if err == nil {
+			err = writer.Close()
+		}
+		log.Printf("[Generator] Elasticsearch: 已從 '%s' 匯出 %d 筆資料", search.Index, rows)
+	}
+	if err == nil {
+		err = file.Close()
+	}
+	if err != nil {
+		os.Remove(file.Name())
+		return nil, err
+	}
+
+	log.Printf("[Generator] Elasticsearch: 成功產生報告，檔案儲存於 %s", file.Name())
+	return &GenerateResult{FilePath: file.Name(), MimeType: mimeType}, nil
+}
+
+// searchResponse 是使用 point-in-time 查詢時的回應
+type searchResponse struct {
+	PitID string `json:"pit_id"`
+	Hits  struct {
+		Hits []struct {
+			Source map[string]interface{} `json:"_source"`
+			Sort   []json.RawMessage      `json:"sort"`
+		} `json:"hits"`
+	} `json:"hits"`
+}
+
+// export 開啟 point-in-time 後以 search_after 逐頁讀取所有符合的文件並寫入 writer，回傳寫入的資料筆數
+func (g *ElasticsearchGenerator) export(ctx context.Context, ds *models.DataSource, search *savedSearch, writer rowWriter) (int, error) {
+	apiURL := strings.TrimRight(ds.APIURL, "/")
+
+	// 1. 開啟 point-in-time，讓分頁期間看到的資料保持一致
+	req, err := g.newRequest(ctx, http.MethodPost, fmt.Sprintf("%s/%s/_pit?keep_alive=%s", apiURL, url.PathEscape(search.Index), g.KeepAlive), ds, nil)
+	if err != nil {
+		return 0, err
+	}
+	var pit struct {
+		ID string `json:"id"`
+	}
+	if err := g.doJSON(req, &pit); err != nil {
+		return 0, fmt.Errorf("無法開啟 point-in-time: %w", err)
+	}
+	pitID := pit.ID
+	defer func() {
+		// 任務被取消時仍要關閉 point-in-time，避免在 Elasticsearch 上佔用資源直到 keep_alive 到期
+		req, err := g.newRequest(context.WithoutCancel(ctx), http.MethodDelete, apiURL+"/_pit", ds, map[string]string{"id": pitID})
+		if err == nil {
+			err = g.doJSON(req, nil)
+		}
+		if err != nil {
+			log.Printf("[Generator] Elasticsearch: 警告：無法關閉 point-in-time: %v", err)
+		}
+	}()
+
+	// 以 _shard_doc 作為最後的排序條件，確保 search_after 的分頁不會重複或遺漏
+	sortClauses := make([]interface{}, 0, len(search.Sort)+1)
+	for _, s := range search.Sort {
+		sortClauses = append(sortClauses, map[string]interface{}{s[0]: map[string]string{"order": s[1]}})
+	}
+	sortClauses = append(sortClauses, map[string]string{"_shard_doc": "asc"})
+
+	// 2. 逐頁查詢並寫出，記憶體中同時只保留一頁的資料
+	columns := search.Columns
+	var searchAfter []json.RawMessage
+	rows := 0
+	for {
+		body := map[string]interface{}{
+			"size":             g.PageSize,
+			"query":            search.Query,
+			"pit":              map[string]string{"id": pitID, "keep_alive": g.KeepAlive},
+			"sort":             sortClauses,
+			"track_total_hits": false,
+		}
+		if len(search.Columns) > 0 {
+			body["_source"] = search.Columns
+		}
+		if searchAfter != nil {
+			body["search_after"] = searchAfter
+		}
+
+		req, err := g.newRequest(ctx, http.MethodPost, apiURL+"/_search", ds, body)
+		if err != nil {
+			return rows, err
+		}
+		var page searchResponse
+		if err := g.doJSON(req, &page); err != nil {
+			return rows, fmt.Errorf("查詢 Elasticsearch 失敗: %w", err)
+		}
+		if page.PitID != "" {
+			pitID = page.PitID
+		}
+
+		for _, hit := range page.Hits.Hits {
+			fields := map[string]interface{}{}
+			flattenSource("", hit.Source, fields)
+
+			// 已儲存搜尋沒有指定欄位時，以第一筆文件的欄位作為表頭
+			if columns == nil {
+				columns = sourceColumns(fields, search.TimeField)
+			}
+			if rows == 0 {
+				if err := writer.WriteRow(columns); err != nil {
+					return rows, err
+				}
+			}
+
+			values := make([]string, len(columns))
+			for i, column := range columns {
+				values[i] = formatFieldValue(fields[column])
+			}
+			if err := writer.WriteRow(values); err != nil {
+				return rows, err
+			}
+			rows++
+		}
+
+		if len(page.Hits.Hits) < g.PageSize {
+			break
+		}
+		searchAfter = page.Hits.Hits[len(page.Hits.Hits)-1].Sort
+	}
+
+	// 沒有任何資料時仍輸出已知的表頭
+	if rows == 0 && len(columns) > 0 {
+		if err := writer.WriteRow(columns); err != nil {
+			return rows, err
+		}
+	}
+	return rows, nil
+}
+
+// flattenSource 將巢狀的 _source 攤平為以點分隔的欄位名稱，陣列保持原樣
+func flattenSource(prefix string, source map[string]interface{}, out map[string]interface{}) {
+	for key, value := range source {
+		name := key
+		if prefix != "" {
+			name = prefix + "." + key
+		}
+		if nested, ok := value.(map[string]interface{}); ok {
+			flattenSource(name, nested, out)
+			continue
+		}
+		out[name] = value
+	}
+}
+
+// sourceColumns 回傳排序後的欄位名稱，時間欄位排在第一欄
+func sourceColumns(fields map[string]interface{}, timeField string) []string {
+	columns := make([]string, 0, len(fields))
+	for name := range fields {
+		if name != timeField {
+			columns = append(columns, name)
+		}
+	}
+	sort.Strings(columns)
+	if _, ok := fields[timeField]; ok && timeField != "" {
+		columns = append([]string{timeField}, columns...)
+	}
+	return columns
+}
+
+// formatFieldValue 將欄位值轉換為儲存格中的文字，陣列以逗號分隔，物件輸出為 JSON
+func formatFieldValue(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case json.Number:
+		return v.String()
+	case bool:
+		if v {
+			return "true"
+		}
+		return "false"
+	case []interface{}:
+		parts := make([]string, len(v))
+		for i, item := range v {
+			parts[i] = formatFieldValue(item)
+		}
+		return strings.Join(parts, ", ")
+	default:
+		encoded, _ := json.Marshal(v)
+		return string(encoded)
+	}
+}
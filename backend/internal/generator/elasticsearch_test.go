@@ -0,0 +1,318 @@
+package generator
+
+import (
+	"archive/zip"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"report-scheduler/backend/internal/models"
+	"report-scheduler/backend/internal/queue"
+	"report-scheduler/backend/internal/secrets"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// fakeElasticsearch 同時模擬 Kibana 的 saved objects API 與 Elasticsearch 的 point-in-time 查詢。
+// 每筆文件的排序值就是它的索引，search_after 依此決定下一頁的起點。
+type fakeElasticsearch struct {
+	*httptest.Server
+	docs int
+
+	mu        sync.Mutex
+	searches  []map[string]interface{}
+	closedPIT string
+}
+
+const fakeSearchSource = `{"query":{"query":"host: web-* and not http.status >= 500","language":"kuery"},` +
+	`"filter":[` +
+	`{"meta":{"disabled":false,"negate":false},"query":{"match_phrase":{"env":"prod"}}},` +
+	`{"meta":{"disabled":true,"negate":false},"query":{"match_phrase":{"env":"dev"}}},` +
+	`{"meta":{"disabled":false,"negate":true},"exists":{"field":"debug"}}],` +
+	`"indexRefName":"kibanaSavedObjectMeta.searchSourceJSON.index"}`
+
+func newFakeElasticsearch(t *testing.T, docs int, columns []string) *fakeElasticsearch {
+	es := &fakeElasticsearch{docs: docs}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /s/ops/api/saved_objects/search/search-1", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"attributes": map[string]interface{}{
+				"columns": columns,
+				"sort":    [][]string{{"@timestamp", "asc"}},
+				"kibanaSavedObjectMeta": map[string]string{
+					"searchSourceJSON": fakeSearchSource,
+				},
+			},
+			"references": []map[string]string{
+				{"name": "kibanaSavedObjectMeta.searchSourceJSON.index", "type": "index-pattern", "id": "logs"},
+			},
+		})
+	})
+	mux.HandleFunc("GET /s/ops/api/saved_objects/index-pattern/logs", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"attributes":{"title":"logs-*","timeFieldName":"@timestamp"}}`))
+	})
+	mux.HandleFunc("POST /logs-*/_pit", func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "2m", r.URL.Query().Get("keep_alive"))
+		w.Write([]byte(`{"id":"pit-0"}`))
+	})
+	mux.HandleFunc("POST /_search", func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]interface{}
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&body))
+		es.mu.Lock()
+		es.searches = append(es.searches, body)
+		page := len(es.searches)
+		es.mu.Unlock()
+
+		pit := body["pit"].(map[string]interface{})
+		require.Equal(t, fmt.Sprintf("pit-%d", page-1), pit["id"], "每次查詢都應該使用最新的 pit_id")
+
+		start := 0
+		if after, ok := body["search_after"].([]interface{}); ok {
+			start = int(after[0].(float64)) + 1
+		}
+		size := int(body["size"].(float64))
+		hits := []map[string]interface{}{}
+		for i := start; i < es.docs && i < start+size; i++ {
+			hits = append(hits, map[string]interface{}{
+				"_source": map[string]interface{}{
+					"@timestamp": fmt.Sprintf("2024-05-01T00:00:%02dZ", i%60),
+					"host":       fmt.Sprintf("web-%d", i),
+					"http":       map[string]interface{}{"status": 200},
+					"tags":       []string{"a", "b"},
+				},
+				"sort": []int{i, 0},
+			})
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"pit_id": fmt.Sprintf("pit-%d", page),
+			"hits":   map[string]interface{}{"hits": hits},
+		})
+	})
+	mux.HandleFunc("DELETE /_pit", func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]string
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&body))
+		es.mu.Lock()
+		es.closedPIT = body["id"]
+		es.mu.Unlock()
+		w.Write([]byte(`{"succeeded":true,"num_freed":1}`))
+	})
+	es.Server = httptest.NewServer(mux)
+	t.Cleanup(es.Close)
+	return es
+}
+
+func TestElasticsearchGenerator_Export(t *testing.T) {
+	task := &queue.Task{ID: "task-es"}
+	report := &models.ReportDefinition{Name: "錯誤日誌", Space: "ops", TimeRange: "now-24h"}
+	element := models.ReportElement{ID: "search-1", Type: models.SavedSearchType}
+
+	newGenerator := func() *ElasticsearchGenerator {
+		g := NewElasticsearchGenerator(secrets.NewMockSecretsManager())
+		g.PageSize = 100
+		return g
+	}
+
+	t.Run("streams every page to csv", func(t *testing.T) {
+		es := newFakeElasticsearch(t, 250, []string{"host", "http.status"})
+		ds := &models.DataSource{URL: es.URL, APIURL: es.URL, AuthType: models.AuthNone}
+
+		result, err := newGenerator().generateElement(t.Context(), task, ds, report, element)
+		require.NoError(t, err)
+		defer os.Remove(result.FilePath)
+		require.Equal(t, "text/csv", result.MimeType)
+		require.True(t, strings.HasSuffix(result.FilePath, ".csv"))
+
+		f, err := os.Open(result.FilePath)
+		require.NoError(t, err)
+		defer f.Close()
+		records, err := csv.NewReader(f).ReadAll()
+		require.NoError(t, err)
+		require.Len(t, records, 251)
+		require.Equal(t, []string{"@timestamp", "host", "http.status"}, records[0], "時間欄位應該放在第一欄")
+		require.Equal(t, []string{"2024-05-01T00:00:00Z", "web-0", "200"}, records[1])
+		require.Equal(t, "web-249", records[250][1])
+
+		// 250 筆資料以每頁 100 筆查詢需要 3 次，並在結束時關閉最新的 point-in-time
+		require.Len(t, es.searches, 3)
+		require.Equal(t, "pit-3", es.closedPIT)
+
+		first := es.searches[0]
+		require.NotContains(t, first, "search_after")
+		require.Equal(t, []interface{}{"@timestamp", "host", "http.status"}, first["_source"])
+		sortClauses := first["sort"].([]interface{})
+		require.Equal(t, map[string]interface{}{"@timestamp": map[string]interface{}{"order": "asc"}}, sortClauses[0])
+		require.Equal(t, map[string]interface{}{"_shard_doc": "asc"}, sortClauses[1])
+
+		boolQuery := first["query"].(map[string]interface{})["bool"].(map[string]interface{})
+		must := boolQuery["must"].([]interface{})
+		require.Equal(t, "host:web-* AND NOT http.status:>=500", must[0].(map[string]interface{})["query_string"].(map[string]interface{})["query"])
+		filters := boolQuery["filter"].([]interface{})
+		require.Len(t, filters, 2, "停用的篩選不應該套用")
+		require.Equal(t, map[string]interface{}{"match_phrase": map[string]interface{}{"env": "prod"}}, filters[0])
+		require.Contains(t, filters[1].(map[string]interface{})["range"], "@timestamp")
+		require.Equal(t, []interface{}{map[string]interface{}{"exists": map[string]interface{}{"field": "debug"}}}, boolQuery["must_not"])
+
+		require.Equal(t, []interface{}{float64(99), float64(0)}, es.searches[1]["search_after"])
+	})
+
+	t.Run("schedule parameters filter the export", func(t *testing.T) {
+		es := newFakeElasticsearch(t, 5, []string{"host"})
+		ds := &models.DataSource{URL: es.URL, APIURL: es.URL, AuthType: models.AuthNone}
+		filtered := &queue.Task{ID: "task-es-params", Parameters: map[string]string{"region": "APAC", "env": "prod"}}
+
+		result, err := newGenerator().generateElement(t.Context(), filtered, ds, report, element)
+		require.NoError(t, err)
+		defer os.Remove(result.FilePath)
+
+		filters := es.searches[0]["query"].(map[string]interface{})["bool"].(map[string]interface{})["filter"].([]interface{})
+		require.Len(t, filters, 4)
+		require.Equal(t, map[string]interface{}{"match_phrase": map[string]interface{}{"env": "prod"}}, filters[2])
+		require.Equal(t, map[string]interface{}{"match_phrase": map[string]interface{}{"region": "APAC"}}, filters[3])
+	})
+
+	t.Run("columns come from the documents when the search has none", func(t *testing.T) {
+		es := newFakeElasticsearch(t, 3, []string{"_source"})
+		ds := &models.DataSource{URL: es.URL, APIURL: es.URL, AuthType: models.AuthNone}
+
+		result, err := newGenerator().generateElement(t.Context(), task, ds, report, element)
+		require.NoError(t, err)
+		defer os.Remove(result.FilePath)
+
+		content, err := os.ReadFile(result.FilePath)
+		require.NoError(t, err)
+		lines := strings.Split(strings.TrimSpace(string(content)), "\n")
+		require.Len(t, lines, 4)
+		require.Equal(t, "@timestamp,host,http.status,tags", lines[0])
+		require.Equal(t, `2024-05-01T00:00:02Z,web-2,200,"a, b"`, lines[3])
+		require.NotContains(t, es.searches[0], "_source")
+	})
+
+	t.Run("xlsx", func(t *testing.T) {
+		es := newFakeElasticsearch(t, 5, []string{"host"})
+		ds := &models.DataSource{URL: es.URL, APIURL: es.URL, AuthType: models.AuthNone}
+
+		xlsxElement := element
+		xlsxElement.Format = models.FormatXLSX
+		result, err := newGenerator().generateElement(t.Context(), task, ds, report, xlsxElement)
+		require.NoError(t, err)
+		defer os.Remove(result.FilePath)
+		require.Equal(t, xlsxMimeType, result.MimeType)
+		require.True(t, strings.HasSuffix(result.FilePath, ".xlsx"))
+
+		sheet := readXLSXSheet(t, result.FilePath)
+		require.Equal(t, 6, strings.Count(sheet, "<row "))
+		require.Contains(t, sheet, `<c r="B1" t="inlineStr"><is><t xml:space="preserve">host</t></is></c>`)
+		require.Contains(t, sheet, `<c r="B6" t="inlineStr"><is><t xml:space="preserve">web-4</t></is></c>`)
+	})
+
+	t.Run("errors", func(t *testing.T) {
+		_, err := newGenerator().generateElement(t.Context(), task, &models.DataSource{Name: "kibana"}, report, element)
+		require.ErrorContains(t, err, "未設定 Elasticsearch API URL")
+
+		ds := &models.DataSource{URL: "http://unused", APIURL: "http://unused", AuthType: models.AuthNone}
+		pdfElement := element
+		pdfElement.Format = models.FormatPDF
+		_, err = newGenerator().generateElement(t.Context(), task, ds, report, pdfElement)
+		require.ErrorContains(t, err, "不支援的輸出格式")
+	})
+}
+
+func TestKibanaGenerator_RoutesSavedSearchToElasticsearch(t *testing.T) {
+	es := newFakeElasticsearch(t, 2, []string{"host"})
+	ds := &models.DataSource{URL: es.URL, APIURL: es.URL, AuthType: models.AuthNone}
+	report := &models.ReportDefinition{
+		Name:     "搜尋匯出",
+		Space:    "ops",
+		Elements: models.ReportElements{{ID: "search-1", Type: models.SavedSearchType}},
+	}
+
+	result, err := newTestKibanaGenerator(secrets.NewMockSecretsManager()).Generate(t.Context(), &queue.Task{ID: "task-route"}, ds, report)
+	require.NoError(t, err)
+	defer os.Remove(result.FilePath)
+	require.Equal(t, "text/csv", result.MimeType)
+	require.Len(t, es.searches, 1)
+}
+
+func TestKQLToQueryString(t *testing.T) {
+	testCases := map[string]string{
+		`status: 500`:                          `status:500`,
+		`host:web-1 or host:web-2`:             `host:web-1 OR host:web-2`,
+		`not response.code >= 400`:             `NOT response.code:>=400`,
+		`bytes < 100 and bytes>10`:             `bytes:<100 AND bytes:>10`,
+		`message: "error and warning"`:         `message:"error and warning"`,
+		`tags:(prod or staging) AND x: "a\"b"`: `tags:(prod OR staging) AND x:"a\"b"`,
+		`android`:                              `android`,
+		`url.path: /api/v1 and host: web-*`:    `url.path:\/api\/v1 AND host:web-*`,
+		`message: a\:b and code: -1`:           `message:a\:b AND code:\-1`,
+		`response: *`:                          `response:*`,
+	}
+	for kql, want := range testCases {
+		got, err := kqlToQueryString(kql)
+		require.NoError(t, err, kql)
+		require.Equal(t, want, got, kql)
+	}
+
+	unsupported := map[string]string{
+		`items:{ name: banana and stock > 9 }`: "巢狀欄位查詢",
+		`machine.os*: win`:                     "萬用字元",
+		`message: "unterminated`:               "引號",
+		`(a or b`:                              "括號",
+		`a or b)`:                              "括號",
+	}
+	for kql, message := range unsupported {
+		_, err := kqlToQueryString(kql)
+		require.ErrorContains(t, err, message, kql)
+	}
+}
+
+func TestXLSXRowWriter(t *testing.T) {
+	require.Equal(t, "A", xlsxColumnName(0))
+	require.Equal(t, "Z", xlsxColumnName(25))
+	require.Equal(t, "AA", xlsxColumnName(26))
+	require.Equal(t, "AZ", xlsxColumnName(51))
+	require.Equal(t, "BA", xlsxColumnName(52))
+
+	path := t.TempDir() + "/out.xlsx"
+	f, err := os.Create(path)
+	require.NoError(t, err)
+	w, err := newXLSXRowWriter(f)
+	require.NoError(t, err)
+	require.NoError(t, w.WriteRow([]string{"名稱", "<a & b>"}))
+	require.NoError(t, w.Close())
+	require.NoError(t, f.Close())
+
+	sheet := readXLSXSheet(t, path)
+	require.Contains(t, sheet, "名稱")
+	require.Contains(t, sheet, "&lt;a &amp; b&gt;")
+
+	w.rows = xlsxMaxRows
+	require.ErrorContains(t, w.WriteRow([]string{"x"}), strconv.Itoa(xlsxMaxRows))
+}
+
+// readXLSXSheet 讀取 XLSX 檔案中第一個工作表的 XML
+func readXLSXSheet(t *testing.T, path string) string {
+	r, err := zip.OpenReader(path)
+	require.NoError(t, err)
+	defer r.Close()
+
+	var names []string
+	for _, file := range r.File {
+		names = append(names, file.Name)
+	}
+	require.Contains(t, names, "[Content_Types].xml")
+	require.Contains(t, names, "xl/workbook.xml")
+
+	sheet, err := r.Open("xl/worksheets/sheet1.xml")
+	require.NoError(t, err)
+	defer sheet.Close()
+	content, err := io.ReadAll(sheet)
+	require.NoError(t, err)
+	return string(content)
+}
@@ -1,6 +1,7 @@
 package generator
 
 import (
+	"context"
 	"fmt"
 	"report-scheduler/backend/internal/models"
 	"report-scheduler/backend/internal/queue"
@@ -12,12 +13,44 @@ import (
 type GenerateResult struct {
 	FilePath string
 	MimeType string
+	// Extra 是無法合併到主要檔案中的其他輸出 (例如多元素報表中的 CSV)，以獨立檔案提供
+	Extra []GenerateResult
 	// 可以加入檔案大小、錯誤訊息等
 }
 
-// Generator 是報表產生器的介面，定義了所有產生器都必須實作的方法
+// mimeTypeForFormat 回傳輸出格式對應的 MIME 類型
+func mimeTypeForFormat(format models.OutputFormat) string {
+	switch format {
+	case models.FormatPNG:
+		return "image/png"
+	case models.FormatCSV:
+		return "text/csv"
+	case models.FormatXLSX:
+		return xlsxMimeType
+	default:
+		return "application/pdf"
+	}
+}
+
+// extensionForMimeType 回傳報表檔案的副檔名
+func extensionForMimeType(mimeType string) string {
+	switch mimeType {
+	case "image/png":
+		return ".png"
+	case "text/csv":
+		return ".csv"
+	case xlsxMimeType:
+		return ".xlsx"
+	default:
+		return ".pdf"
+	}
+}
+
+// Generator 是報表產生器的介面，定義了所有產生器都必須實作的方法。
+// 報表的相對時間範圍以 task.Reference() 作為 now 計算，而不是產生報表時的目前時間。
+// ctx 被取消或逾時時，進行中的請求與輪詢會立即中止並回傳 ctx 的錯誤。
 type Generator interface {
-	Generate(task *queue.Task, ds *models.DataSource, report *models.ReportDefinition) (*GenerateResult, error)
+	Generate(ctx context.Context, task *queue.Task, ds *models.DataSource, report *models.ReportDefinition) (*GenerateResult, error)
 }
 
 // Factory 用於根據資料來源類型建立對應的 Generator
@@ -40,8 +73,8 @@ func (f *Factory) GetGenerator(dsType models.DataSourceType) (Generator, error)
 	switch dsType {
 	case models.Kibana:
 		return NewKibanaGenerator(f.Secrets), nil
-	// case models.Grafana:
-	// 	return NewGrafanaGenerator(f.Secrets), nil
+	case models.Grafana:
+		return NewGrafanaGenerator(f.Secrets), nil
 	default:
 		return nil, fmt.Errorf("不支援的資料來源類型: %s", dsType)
 	}
@@ -0,0 +1,172 @@
+package generator
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"report-scheduler/backend/internal/models"
+	"report-scheduler/backend/internal/queue"
+	"report-scheduler/backend/internal/secrets"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 未指定尺寸時的預設截圖大小 (像素)
+const (
+	grafanaDashboardWidth  = 1600
+	grafanaDashboardHeight = 1200
+	grafanaPanelWidth      = 1000
+	grafanaPanelHeight     = 500
+)
+
+// GrafanaGenerator 負責透過 Grafana Image Renderer 的 /render 端點產生報表
+type GrafanaGenerator struct {
+	Secrets secrets.SecretsManager
+	Client  *http.Client
+}
+
+// NewGrafanaGenerator 建立一個新的 GrafanaGenerator
+func NewGrafanaGenerator(sm secrets.SecretsManager) *GrafanaGenerator {
+	return &GrafanaGenerator{
+		Secrets: sm,
+		// 渲染整個儀表板可能需要一段時間
+		Client: &http.Client{Timeout: 2 * time.Minute},
+	}
+}
+
+// buildGrafanaRenderURL 根據元素類型建構 Grafana 的 /render URL。
+// 儀表板使用 /render/d/<uid>，單一面板使用 /render/d-solo/<uid>?panelId=<id>。
+func buildGrafanaRenderURL(task *queue.Task, ds *models.DataSource, report *models.ReportDefinition, element models.ReportElement) (string, error) {
+	params := url.Values{}
+	var path string
+	switch element.Type {
+	case models.DashboardType:
+		path = "/render/d/" + url.PathEscape(element.ID)
+		params.Set("width", strconv.Itoa(grafanaDashboardWidth))
+		params.Set("height", strconv.Itoa(grafanaDashboardHeight))
+	case models.PanelType:
+		uid, panelID, ok := strings.Cut(element.ID, "/")
+		if !ok || uid == "" || panelID == "" {
+			return "", fmt.Errorf("Grafana 面板 ID 格式錯誤 '%s'，應為 '<儀表板 UID>/<面板 ID>'", element.ID)
+		}
+		path = "/render/d-solo/" + url.PathEscape(uid)
+		params.Set("panelId", panelID)
+		params.Set("width", strconv.Itoa(grafanaPanelWidth))
+		params.Set("height", strconv.Itoa(grafanaPanelHeight))
+	default:
+		return "", fmt.Errorf("Grafana 不支援的元素類型: %s", element.Type)
+	}
+
+	// 處理時間範圍：在排程的時區中解析後以絕對時間 (毫秒) 傳給 Grafana
+	from, to, ok, err := ResolveReportTimeRange(task, report)
+	if err != nil {
+		return "", err
+	}
+	if ok {
+		params.Set("from", strconv.FormatInt(from.UnixMilli(), 10))
+		params.Set("to", strconv.FormatInt(to.UnixMilli(), 10))
+		if task.Timezone != "" {
+			params.Set("tz", task.Location().String())
+		}
+	}
+
+	// 排程的動態參數對應到儀表板變數
+	for key, value := range task.Parameters {
+		params.Set("var-"+key, value)
+	}
+
+	return strings.TrimRight(ds.URL, "/") + path + "?" + params.Encode(), nil
+}
+
+// Generate 實作報表產生邏輯，報表中的每個元素會分別產生後再合併
+func (g *GrafanaGenerator) Generate(ctx context.Context, task *queue.Task, ds *models.DataSource, report *models.ReportDefinition) (*GenerateResult, error) {
+	log.Printf("[Generator] Grafana: 正在為報表 '%s' 產生報告...", report.Name)
+	return assembleReport(ctx, g, task, ds, report)
+}
+
+// generateElement 產生報表中的單一元素
+func (g *GrafanaGenerator) generateElement(ctx context.Context, task *queue.Task, ds *models.DataSource, report *models.ReportDefinition, element models.ReportElement) (*GenerateResult, error) {
+	format := element.Format
+	if format == "" {
+		format = models.FormatPDF
+	}
+	if format != models.FormatPDF && format != models.FormatPNG {
+		return nil, fmt.Errorf("Grafana 不支援的輸出格式: %s", format)
+	}
+
+	// 1. 建構 URL
+	renderURL, err := buildGrafanaRenderURL(task, ds, report, element)
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("[Generator] Grafana: 準備請求 URL: %s", renderURL)
+
+	// 2. 建立 HTTP 請求並設定認證
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, renderURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("無法建立請求: %w", err)
+	}
+	if ds.AuthType != models.AuthNone {
+		creds, err := g.Secrets.GetCredentials(ds.CredentialsRef)
+		if err != nil {
+			return nil, fmt.Errorf("無法獲取 Grafana 憑證 for ref %s: %w", ds.CredentialsRef, err)
+		}
+
+		switch ds.AuthType {
+		case models.APIToken:
+			req.Header.Set("Authorization", "Bearer "+creds.Token)
+		case models.BasicAuth:
+			req.SetBasicAuth(creds.Username, creds.Password)
+		}
+	}
+
+	resp, err := g.Client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("請求 Grafana 渲染 API 失敗: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return nil, fmt.Errorf("Grafana 渲染 API 回應非 200 狀態: %d, body: %s", resp.StatusCode, string(body))
+	}
+	// 未登入時 Grafana 可能會以 200 回傳登入頁面，因此需要確認回傳的是圖片
+	if contentType := resp.Header.Get("Content-Type"); !strings.HasPrefix(contentType, "image/png") {
+		return nil, fmt.Errorf("Grafana 渲染 API 回傳了非 PNG 的內容: %s", contentType)
+	}
+
+	// 3. 將圖片儲存到暫存檔案
+	pngFile, err := os.CreateTemp("", fmt.Sprintf("report-%s-*.png", task.ID))
+	if err != nil {
+		return nil, fmt.Errorf("建立暫存檔案失敗: %w", err)
+	}
+	_, err = io.Copy(pngFile, resp.Body)
+	pngFile.Close()
+	if err != nil {
+		os.Remove(pngFile.Name())
+		return nil, fmt.Errorf("寫入暫存檔案失敗: %w", err)
+	}
+
+	if format == models.FormatPNG {
+		log.Printf("[Generator] Grafana: 成功產生報告，檔案儲存於 %s", pngFile.Name())
+		return &GenerateResult{FilePath: pngFile.Name(), MimeType: "image/png"}, nil
+	}
+
+	// 4. 轉換為 PDF
+	defer os.Remove(pngFile.Name())
+	pdfPath := strings.TrimSuffix(pngFile.Name(), ".png") + ".pdf"
+	if err := imageToPDF(pngFile.Name(), pdfPath); err != nil {
+		return nil, err
+	}
+
+	log.Printf("[Generator] Grafana: 成功產生報告，檔案儲存於 %s", pdfPath)
+	return &GenerateResult{
+		FilePath: pdfPath,
+		MimeType: "application/pdf",
+	}, nil
+}
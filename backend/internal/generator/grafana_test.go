@@ -0,0 +1,192 @@
+package generator
+
+import (
+	"bytes"
+	"image"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"report-scheduler/backend/internal/models"
+	"report-scheduler/backend/internal/queue"
+	"report-scheduler/backend/internal/secrets"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// newFakeGrafana 建立一個假的 Grafana，記錄收到的請求並回傳一張 PNG 圖片
+func newFakeGrafana(t *testing.T, received *[]*http.Request) *httptest.Server {
+	var buf bytes.Buffer
+	require.NoError(t, png.Encode(&buf, image.NewRGBA(image.Rect(0, 0, 40, 20))))
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		*received = append(*received, r)
+		if user, pass, ok := r.BasicAuth(); ok && (user != "admin" || pass != "secret") {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Header().Set("Content-Type", "image/png")
+		w.Write(buf.Bytes())
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestGrafanaGenerator_Generate(t *testing.T) {
+	task := &queue.Task{ID: "task-grafana"}
+
+	t.Run("dashboard to PDF with API token", func(t *testing.T) {
+		var received []*http.Request
+		srv := newFakeGrafana(t, &received)
+		sm := &secrets.MockSecretsManager{CredsToReturn: &secrets.Credentials{Token: "glsa_token"}}
+		ds := &models.DataSource{URL: srv.URL + "/", AuthType: models.APIToken, CredentialsRef: "kv/grafana"}
+		report := &models.ReportDefinition{
+			Name:      "Grafana 儀表板",
+			TimeRange: "now-7d",
+			Elements:  models.ReportElements{{ID: "abc123", Type: models.DashboardType}},
+		}
+
+		startTime := time.Now()
+		result, err := NewGrafanaGenerator(sm).Generate(t.Context(), task, ds, report)
+		require.NoError(t, err)
+		defer os.Remove(result.FilePath)
+
+		require.Equal(t, "application/pdf", result.MimeType)
+		content, err := os.ReadFile(result.FilePath)
+		require.NoError(t, err)
+		require.True(t, bytes.HasPrefix(content, []byte("%PDF")))
+
+		require.Len(t, received, 1)
+		req := received[0]
+		require.Equal(t, "/render/d/abc123", req.URL.Path)
+		require.Equal(t, "Bearer glsa_token", req.Header.Get("Authorization"))
+		require.Equal(t, "1600", req.URL.Query().Get("width"))
+
+		from, err := strconv.ParseInt(req.URL.Query().Get("from"), 10, 64)
+		require.NoError(t, err)
+		to, err := strconv.ParseInt(req.URL.Query().Get("to"), 10, 64)
+		require.NoError(t, err)
+		require.Equal(t, int64(7*24*time.Hour/time.Millisecond), to-from)
+		require.GreaterOrEqual(t, to, startTime.UnixMilli())
+	})
+
+	t.Run("single panel to PNG with basic auth", func(t *testing.T) {
+		var received []*http.Request
+		srv := newFakeGrafana(t, &received)
+		sm := &secrets.MockSecretsManager{CredsToReturn: &secrets.Credentials{Username: "admin", Password: "secret"}}
+		ds := &models.DataSource{URL: srv.URL, AuthType: models.BasicAuth}
+		report := &models.ReportDefinition{
+			Name:      "Grafana 面板",
+			TimeRange: "now-1M/M",
+			Elements:  models.ReportElements{{ID: "abc123/4", Type: models.PanelType, Format: models.FormatPNG}},
+		}
+
+		taipeiTask := &queue.Task{ID: "task-grafana", Timezone: "Asia/Taipei"}
+		result, err := NewGrafanaGenerator(sm).Generate(t.Context(), taipeiTask, ds, report)
+		require.NoError(t, err)
+		defer os.Remove(result.FilePath)
+		require.Equal(t, "image/png", result.MimeType)
+
+		req := received[0]
+		require.Equal(t, "/render/d-solo/abc123", req.URL.Path)
+		require.Equal(t, "4", req.URL.Query().Get("panelId"))
+		require.Equal(t, "Asia/Taipei", req.URL.Query().Get("tz"))
+
+		// 上個月在排程時區中的第一毫秒到最後一毫秒
+		loc, err := time.LoadLocation("Asia/Taipei")
+		require.NoError(t, err)
+		now := time.Now().In(loc)
+		thisMonth := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, loc)
+		require.Equal(t, strconv.FormatInt(thisMonth.AddDate(0, -1, 0).UnixMilli(), 10), req.URL.Query().Get("from"))
+		require.Equal(t, strconv.FormatInt(thisMonth.UnixMilli()-1, 10), req.URL.Query().Get("to"))
+	})
+
+	t.Run("relative range is anchored on the reference time", func(t *testing.T) {
+		var received []*http.Request
+		srv := newFakeGrafana(t, &received)
+		ds := &models.DataSource{URL: srv.URL, AuthType: models.AuthNone}
+		report := &models.ReportDefinition{
+			TimeRange: "now-7d",
+			Elements:  models.ReportElements{{ID: "abc123", Type: models.DashboardType, Format: models.FormatPNG}},
+		}
+
+		// 重寄上週一的報表時，時間範圍應與當時完全相同
+		reference := time.Date(2024, 5, 6, 9, 0, 0, 0, time.UTC)
+		resend := &queue.Task{ID: "task-resend", CreatedAt: time.Now(), ReferenceTime: reference}
+		result, err := NewGrafanaGenerator(secrets.NewMockSecretsManager()).Generate(t.Context(), resend, ds, report)
+		require.NoError(t, err)
+		defer os.Remove(result.FilePath)
+
+		query := received[0].URL.Query()
+		require.Equal(t, strconv.FormatInt(reference.AddDate(0, 0, -7).UnixMilli(), 10), query.Get("from"))
+		require.Equal(t, strconv.FormatInt(reference.UnixMilli(), 10), query.Get("to"))
+	})
+
+	t.Run("schedule parameters become dashboard variables", func(t *testing.T) {
+		task := &queue.Task{ID: "task-params", Parameters: map[string]string{"region": "APAC", "env": "prod & test"}}
+		renderURL, err := buildGrafanaRenderURL(task, &models.DataSource{URL: "http://grafana.local"}, &models.ReportDefinition{},
+			models.ReportElement{ID: "abc123/4", Type: models.PanelType})
+		require.NoError(t, err)
+		require.Contains(t, renderURL, "&var-region=APAC")
+
+		u, err := url.Parse(renderURL)
+		require.NoError(t, err)
+		require.Equal(t, "prod & test", u.Query().Get("var-env"))
+		require.Equal(t, "4", u.Query().Get("panelId"))
+	})
+
+	t.Run("errors", func(t *testing.T) {
+		var received []*http.Request
+		srv := newFakeGrafana(t, &received)
+		gen := NewGrafanaGenerator(&secrets.MockSecretsManager{CredsToReturn: &secrets.Credentials{Username: "admin", Password: "wrong"}})
+
+		_, err := gen.Generate(t.Context(), task, &models.DataSource{URL: srv.URL, AuthType: models.BasicAuth}, &models.ReportDefinition{
+			Elements: models.ReportElements{{ID: "abc123", Type: models.DashboardType}},
+		})
+		require.ErrorContains(t, err, "401")
+
+		ds := &models.DataSource{URL: srv.URL, AuthType: models.AuthNone}
+		_, err = gen.Generate(t.Context(), task, ds, &models.ReportDefinition{Elements: models.ReportElements{{ID: "abc123", Type: models.PanelType}}})
+		require.ErrorContains(t, err, "面板 ID 格式錯誤")
+		_, err = gen.Generate(t.Context(), task, ds, &models.ReportDefinition{Elements: models.ReportElements{{ID: "abc123", Type: models.SavedSearchType}}})
+		require.ErrorContains(t, err, "不支援的元素類型")
+		_, err = gen.Generate(t.Context(), task, ds, &models.ReportDefinition{Elements: models.ReportElements{{ID: "abc123", Type: models.DashboardType, Format: models.FormatCSV}}})
+		require.ErrorContains(t, err, "不支援的輸出格式")
+		_, err = gen.Generate(t.Context(), task, ds, &models.ReportDefinition{})
+		require.ErrorContains(t, err, "沒有任何元素")
+		_, err = gen.Generate(t.Context(), task, ds, &models.ReportDefinition{TimeRange: "last fortnight", Elements: models.ReportElements{{ID: "abc123", Type: models.DashboardType}}})
+		require.ErrorContains(t, err, "last fortnight")
+	})
+
+	t.Run("login page is rejected", func(t *testing.T) {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "text/html")
+			w.Write([]byte("<html>login</html>"))
+		}))
+		defer srv.Close()
+
+		_, err := NewGrafanaGenerator(secrets.NewMockSecretsManager()).Generate(t.Context(), task, &models.DataSource{URL: srv.URL, AuthType: models.AuthNone}, &models.ReportDefinition{
+			Elements: models.ReportElements{{ID: "abc123", Type: models.DashboardType}},
+		})
+		require.ErrorContains(t, err, "非 PNG")
+	})
+}
+
+func TestFactory_GetGenerator(t *testing.T) {
+	f := NewFactory(nil, secrets.NewMockSecretsManager())
+
+	gen, err := f.GetGenerator(models.Grafana)
+	require.NoError(t, err)
+	require.IsType(t, &GrafanaGenerator{}, gen)
+
+	gen, err = f.GetGenerator(models.Kibana)
+	require.NoError(t, err)
+	require.IsType(t, &KibanaGenerator{}, gen)
+
+	_, err = f.GetGenerator("splunk")
+	require.Error(t, err)
+}
@@ -1,115 +1,206 @@
 package generator
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
-	"io/ioutil"
+	"io"
 	"log"
+	"mime"
 	"net/http"
 	"net/url"
-	"regexp"
+	"os"
 	"report-scheduler/backend/internal/models"
 	"report-scheduler/backend/internal/queue"
 	"report-scheduler/backend/internal/secrets"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/sakura-internet/go-rison/v4"
 )
 
-// parseTimeRange 解析相對時間字串 (例如 "now-7d") 並回傳 from 和 to 的 ISO 8601 時間
-func parseTimeRange(timeRange string) (from, to string, err error) {
-	now := time.Now()
-	to = now.Format(time.RFC3339)
-
-	re := regexp.MustCompile(`^now-(\d+)([dhm])$`)
-	matches := re.FindStringSubmatch(timeRange)
-	if len(matches) != 3 {
-		return "", "", fmt.Errorf("不支援的時間範圍格式: %s", timeRange)
-	}
+// Kibana 報表的截圖尺寸 (像素)
+const (
+	kibanaLayoutWidth  = 1600
+	kibanaLayoutHeight = 1200
+)
 
-	value, _ := strconv.Atoi(matches[1])
-	unit := matches[2]
+// kibanaJob 描述一個報表元素對應的 Kibana 報表工作
+type kibanaJob struct {
+	jobType    string // 例如 printablePdfV2、pngV2、csv_v2
+	objectType string // Kibana 的物件類型，例如 dashboard、visualization、search
+	locatorID  string
+	idParam    string // locator 參數中代表物件 ID 的欄位
+	format     models.OutputFormat
+}
 
-	var duration time.Duration
-	switch unit {
-	case "d":
-		duration = time.Duration(value) * 24 * time.Hour
-	case "h":
-		duration = time.Duration(value) * time.Hour
-	case "m":
-		duration = time.Duration(value) * time.Minute
+// resolveKibanaJob 依元素類型與輸出格式決定要使用的 Kibana 報表工作類型。
+// 儀表板與視覺化可輸出 PDF (預設) 或 PNG，已儲存的搜尋則輸出 CSV。
+func resolveKibanaJob(element models.ReportElement) (*kibanaJob, error) {
+	job := &kibanaJob{format: element.Format}
+	switch element.Type {
+	case models.DashboardType, "":
+		job.objectType, job.locatorID, job.idParam = "dashboard", "DASHBOARD_APP_LOCATOR", "dashboardId"
+	case models.VisualizationType:
+		job.objectType, job.locatorID, job.idParam = "visualization", "VISUALIZE_APP_LOCATOR", "visId"
+	case models.LensType:
+		job.objectType, job.locatorID, job.idParam = "lens", "LENS_APP_LOCATOR", "savedObjectId"
+	case models.SavedSearchType:
+		job.objectType, job.locatorID, job.idParam = "search", "DISCOVER_APP_LOCATOR", "savedSearchId"
+		if job.format == "" {
+			job.format = models.FormatCSV
+		}
+		if job.format != models.FormatCSV {
+			return nil, fmt.Errorf("已儲存的搜尋只能輸出 CSV，不支援: %s", job.format)
+		}
+		job.jobType = "csv_v2"
+		return job, nil
 	default:
-		return "", "", fmt.Errorf("不支援的時間單位: %s", unit)
+		return nil, fmt.Errorf("Kibana 不支援的元素類型: %s", element.Type)
 	}
 
-	fromTime := now.Add(-duration)
-	from = fromTime.Format(time.RFC3339)
-	return from, to, nil
+	switch job.format {
+	case models.FormatPDF, "":
+		job.format = models.FormatPDF
+		job.jobType = "printablePdfV2"
+	case models.FormatPNG:
+		job.jobType = "pngV2"
+	default:
+		return nil, fmt.Errorf("Kibana %s 不支援的輸出格式: %s", job.objectType, job.format)
+	}
+	return job, nil
 }
 
-// buildURL 根據資料來源和報表定義建構最終的 Kibana Reporting URL
-func buildURL(ds *models.DataSource, report *models.ReportDefinition) (string, error) {
-	if len(report.Elements) == 0 {
-		return "", fmt.Errorf("報表 '%s' 中沒有任何元素", report.Name)
+// buildURL 根據資料來源、報表定義和其中的一個元素建構最終的 Kibana Reporting URL。
+// 報表內容以 RISON 編碼的 jobParams 傳遞，其中以 locator 指向要產生報表的物件；
+// 排程的動態參數另外以 RISON 編碼的 _a 附在網址上。
+func buildURL(task *queue.Task, ds *models.DataSource, report *models.ReportDefinition, element models.ReportElement) (string, *kibanaJob, error) {
+	job, err := resolveKibanaJob(element)
+	if err != nil {
+		return "", nil, err
 	}
-	elementID := report.Elements[0].ID
 
 	var spacePrefix string
 	if report.Space != "" && report.Space != "default" {
 		spacePrefix = fmt.Sprintf("/s/%s", report.Space)
 	}
-	baseURL := fmt.Sprintf("%s%s/api/reporting/generate/dashboard/%s", ds.URL, spacePrefix, elementID)
+	baseURL := fmt.Sprintf("%s%s/api/reporting/generate/%s", strings.TrimRight(ds.URL, "/"), spacePrefix, job.jobType)
+
+	locatorParams := map[string]interface{}{
+		job.idParam: element.ID,
+	}
+	if job.objectType == "dashboard" {
+		locatorParams["preserveSavedFilters"] = true
+		locatorParams["useHash"] = false
+		locatorParams["viewMode"] = "view"
+	}
 
 	// 處理時間範圍
-	if report.TimeRange != "" {
-		from, to, err := parseTimeRange(report.TimeRange)
-		if err != nil {
-			log.Printf("[Generator] Kibana: 無法解析時間範圍 '%s': %v, 將忽略此參數", report.TimeRange, err)
-			return baseURL, nil
-		}
+	from, to, ok, err := ResolveReportTimeRange(task, report)
+	if err != nil {
+		return "", nil, err
+	}
+	if ok {
+		locatorParams["timeRange"] = map[string]string{"from": from.Format(time.RFC3339Nano), "to": to.Format(time.RFC3339Nano)}
+	}
+	// 排程的動態參數以 RISON 編碼的 app state (_a) 套用。locator 的 filters 就是 Kibana 開啟報表頁面時
+	// 寫入頁面網址 _a 的篩選條件，因此兩者使用相同的內容
+	appState, err := kibanaAppState(task.Parameters)
+	if err != nil {
+		return "", nil, err
+	}
+	if appState != "" {
+		locatorParams["filters"] = kibanaParameterFilters(task.Parameters)
+	}
 
-		gParam := map[string]interface{}{
-			"time": map[string]string{
-				"from": from,
-				"to":   to,
-			},
-		}
-		risonBytes, err := rison.Marshal(gParam, rison.Rison)
-		if err != nil {
-			log.Printf("[Generator] Kibana: RISON 編碼失敗: %v, 將忽略此參數", err)
-			return baseURL, nil
+	title := element.Title
+	if title == "" {
+		title = report.Name
+	}
+	jobParams := map[string]interface{}{
+		"browserTimezone": kibanaTimezone(task),
+		"objectType":      job.objectType,
+		"title":           title,
+	}
+	if ds.Version != "" {
+		jobParams["version"] = ds.Version
+	}
+
+	locator := map[string]interface{}{"id": job.locatorID, "params": locatorParams}
+	switch job.jobType {
+	case "pngV2":
+		// PNG 一次只能擷取一個畫面，locatorParams 是單一物件而不是陣列
+		jobParams["locatorParams"] = locator
+	default:
+		jobParams["locatorParams"] = []interface{}{locator}
+	}
+	if job.format != models.FormatCSV {
+		jobParams["layout"] = map[string]interface{}{
+			"id":         "preserve_layout",
+			"dimensions": map[string]int{"width": kibanaLayoutWidth, "height": kibanaLayoutHeight},
 		}
-		return fmt.Sprintf("%s?_g=%s", baseURL, url.QueryEscape(string(risonBytes))), nil
 	}
 
-	return baseURL, nil
+	risonBytes, err := rison.Marshal(jobParams, rison.Rison)
+	if err != nil {
+		return "", nil, fmt.Errorf("RISON 編碼失敗: %w", err)
+	}
+	generationURL := fmt.Sprintf("%s?jobParams=%s", baseURL, url.QueryEscape(string(risonBytes)))
+	if appState != "" {
+		generationURL += "&_a=" + url.QueryEscape(appState)
+	}
+	return generationURL, job, nil
+}
+
+// kibanaTimezone 回傳報表使用的時區名稱，排程沒有指定時使用 UTC
+func kibanaTimezone(task *queue.Task) string {
+	if task.Timezone == "" {
+		return "UTC"
+	}
+	return task.Location().String()
+}
+
+// kibanaJobResponse 是 Kibana 建立報表工作後回傳的工作描述
+type kibanaJobResponse struct {
+	Path string `json:"path"`
+	Job  struct {
+		ID      string `json:"id"`
+		JobType string `json:"jobtype"`
+		Status  string `json:"status"`
+	} `json:"job"`
 }
 
-// KibanaGenerator 負責從 Kibana 產生報表
+// KibanaGenerator 負責從 Kibana 產生報表。
+// Kibana 的報表是非同步產生的：先建立報表工作，再輪詢下載路徑直到報表完成。
 type KibanaGenerator struct {
 	Secrets secrets.SecretsManager
+	Client  *http.Client
+	// PollInterval 是第一次輪詢前的等待時間，之後每次加倍直到 MaxPollInterval，
+	// Kibana 回應的 Retry-After 也不會超過 MaxPollInterval
+	PollInterval    time.Duration
+	MaxPollInterval time.Duration
+	// Timeout 是等待單一報表完成的時間上限
+	Timeout time.Duration
+	// Elasticsearch 用於在資料來源設定了 APIURL 時直接匯出已儲存的搜尋
+	Elasticsearch *ElasticsearchGenerator
 }
 
 // NewKibanaGenerator 建立一個新的 KibanaGenerator
 func NewKibanaGenerator(sm secrets.SecretsManager) *KibanaGenerator {
 	return &KibanaGenerator{
-		Secrets: sm,
+		Secrets:         sm,
+		Client:          &http.Client{Timeout: 60 * time.Second},
+		PollInterval:    time.Second,
+		MaxPollInterval: 30 * time.Second,
+		Timeout:         10 * time.Minute,
+		Elasticsearch:   NewElasticsearchGenerator(sm),
 	}
 }
 
-// Generate 實作報表產生邏輯
-func (g *KibanaGenerator) Generate(task *queue.Task, ds *models.DataSource, report *models.ReportDefinition) (*GenerateResult, error) {
-	log.Printf("[Generator] Kibana: 正在為報表 '%s' 產生報告...", report.Name)
-
-	// 1. 建構 URL
-	generationURL, err := buildURL(ds, report)
-	if err != nil {
-		return nil, err
-	}
-	log.Printf("[Generator] Kibana: 準備請求 URL: %s", generationURL)
-
-	// 2. 建立並執行 HTTP 請求
-	req, err := http.NewRequest("POST", generationURL, nil)
+// newRequest 建立一個送往 Kibana 的請求，並在需要時加上認證標頭
+func (g *KibanaGenerator) newRequest(ctx context.Context, method, requestURL string, ds *models.DataSource) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, method, requestURL, nil)
 	if err != nil {
 		return nil, fmt.Errorf("無法建立請求: %w", err)
 	}
@@ -118,7 +209,7 @@ func (g *KibanaGenerator) Generate(task *queue.Task, ds *models.DataSource, repo
 	// 這是根據分析該頁面 HTML 後得到的策略
 	req.AddCookie(&http.Cookie{Name: "_iub-error", Value: "y"})
 
-	// 3. 只有在需要認證時才獲取憑證並設定標頭
+	// 只有在需要認證時才獲取憑證並設定標頭
 	if ds.AuthType != models.AuthNone {
 		creds, err := g.Secrets.GetCredentials(ds.CredentialsRef)
 		if err != nil {
@@ -133,42 +224,184 @@ func (g *KibanaGenerator) Generate(task *queue.Task, ds *models.DataSource, repo
 		}
 	}
 	req.Header.Set("kbn-xsrf", "true")
-	req.Header.Set("Content-Type", "application/json")
+	return req, nil
+}
+
+// Generate 實作報表產生邏輯，報表中的每個元素會分別產生後再合併
+func (g *KibanaGenerator) Generate(ctx context.Context, task *queue.Task, ds *models.DataSource, report *models.ReportDefinition) (*GenerateResult, error) {
+	log.Printf("[Generator] Kibana: 正在為報表 '%s' 產生報告...", report.Name)
+	return assembleReport(ctx, g, task, ds, report)
+}
+
+// generateElement 產生報表中的單一元素
+func (g *KibanaGenerator) generateElement(ctx context.Context, task *queue.Task, ds *models.DataSource, report *models.ReportDefinition, element models.ReportElement) (*GenerateResult, error) {
+	// Kibana 的 CSV 匯出有大小限制，設定了 Elasticsearch API URL 時改為直接查詢 Elasticsearch
+	if element.Type == models.SavedSearchType && (ds.APIURL != "" || element.Format == models.FormatXLSX) {
+		return g.Elasticsearch.generateElement(ctx, task, ds, report, element)
+	}
 
-	log.Printf("[Generator] Kibana: Request Headers: %v", req.Header) // DEBUG LOGGING
+	// 1. 建構 URL
+	generationURL, job, err := buildURL(task, ds, report, element)
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("[Generator] Kibana: 準備請求 URL: %s", generationURL)
 
-	client := &http.Client{Timeout: 60 * time.Second}
-	resp, err := client.Do(req)
+	// 2. 建立報表工作
+	created, err := g.createJob(ctx, generationURL, ds)
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("[Generator] Kibana: 已建立報表工作 %s (%s)，下載路徑: %s", created.Job.ID, job.jobType, created.Path)
+
+	// 3. 輪詢下載路徑直到報表完成，並儲存到暫存檔案
+	downloadURL, err := resolveKibanaPath(ds.URL, created.Path)
+	if err != nil {
+		return nil, err
+	}
+	result, err := g.waitForReport(ctx, downloadURL, ds, task, mimeTypeForFormat(job.format))
+	if err != nil {
+		return nil, err
+	}
+
+	log.Printf("[Generator] Kibana: 成功產生報告，檔案儲存於 %s", result.FilePath)
+	return result, nil
+}
+
+// createJob 送出產生報表的請求並解析 Kibana 回傳的工作描述
+func (g *KibanaGenerator) createJob(ctx context.Context, generationURL string, ds *models.DataSource) (*kibanaJobResponse, error) {
+	req, err := g.newRequest(ctx, http.MethodPost, generationURL, ds)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := g.Client.Do(req)
 	if err != nil {
 		return nil, fmt.Errorf("請求 Kibana API 失敗: %w", err)
 	}
 	defer resp.Body.Close()
 
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("讀取回應內容失敗: %w", err)
+	}
 	if resp.StatusCode != http.StatusOK {
-		body, _ := ioutil.ReadAll(resp.Body)
 		return nil, fmt.Errorf("Kibana API 回應非 200 狀態: %d, body: %s", resp.StatusCode, string(body))
 	}
 
-	// 4. 將回應內容儲存到暫存檔案
-	body, err := ioutil.ReadAll(resp.Body)
+	var job kibanaJobResponse
+	if err := json.Unmarshal(body, &job); err != nil || job.Path == "" {
+		return nil, fmt.Errorf("無法解析 Kibana 報表工作: %s", string(body))
+	}
+	if job.Job.Status == "failed" {
+		return nil, fmt.Errorf("Kibana 報表工作 %s 建立後即失敗", job.Job.ID)
+	}
+	return &job, nil
+}
+
+// resolveKibanaPath 將 Kibana 回傳的相對路徑轉換為完整的 URL
+func resolveKibanaPath(baseURL, path string) (string, error) {
+	base, err := url.Parse(baseURL)
 	if err != nil {
-		return nil, fmt.Errorf("讀取回應內容失敗: %w", err)
+		return "", fmt.Errorf("無效的 Kibana URL '%s': %w", baseURL, err)
+	}
+	ref, err := url.Parse(path)
+	if err != nil {
+		return "", fmt.Errorf("無效的下載路徑 '%s': %w", path, err)
+	}
+	return base.ResolveReference(ref).String(), nil
+}
+
+// waitForReport 以指數退避輪詢下載路徑。
+// Kibana 在工作尚在等待 (pending) 或處理中 (processing) 時回應 503，失敗 (failed) 時回應 500 與錯誤訊息。
+// 等待期間 ctx 被取消時立即回傳，不會等到下一次輪詢。
+func (g *KibanaGenerator) waitForReport(ctx context.Context, downloadURL string, ds *models.DataSource, task *queue.Task, expectedMimeType string) (*GenerateResult, error) {
+	deadline := time.Now().Add(g.Timeout)
+	interval := g.PollInterval
+
+	for {
+		wait := interval
+		if time.Now().Add(wait).After(deadline) {
+			return nil, fmt.Errorf("等待 Kibana 報表完成逾時 (%s)", g.Timeout)
+		}
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		case <-timer.C:
+		}
+
+		result, retryAfter, err := g.download(ctx, downloadURL, ds, task, expectedMimeType)
+		if err != nil {
+			return nil, err
+		}
+		if result != nil {
+			return result, nil
+		}
+
+		// 報表尚未完成，Kibana 有提供 Retry-After 時以其為準，
+		// 但不超過 MaxPollInterval，避免異常的值讓任務空等到逾時
+		interval *= 2
+		if retryAfter > 0 {
+			interval = retryAfter
+		}
+		if interval > g.MaxPollInterval {
+			interval = g.MaxPollInterval
+		}
 	}
+}
 
-	tmpFile, err := ioutil.TempFile("", fmt.Sprintf("report-%s-*.pdf", task.ID))
+// download 嘗試下載報表。報表尚未完成時回傳 nil 結果與建議的等待時間。
+// 回應沒有 Content-Type 時使用 expectedMimeType。
+func (g *KibanaGenerator) download(ctx context.Context, downloadURL string, ds *models.DataSource, task *queue.Task, expectedMimeType string) (*GenerateResult, time.Duration, error) {
+	req, err := g.newRequest(ctx, http.MethodGet, downloadURL, ds)
 	if err != nil {
-		return nil, fmt.Errorf("建立暫存檔案失敗: %w", err)
+		return nil, 0, err
 	}
-	defer tmpFile.Close()
 
-	if _, err := tmpFile.Write(body); err != nil {
-		return nil, fmt.Errorf("寫入暫存檔案失敗: %w", err)
+	resp, err := g.Client.Do(req)
+	if err != nil {
+		return nil, 0, fmt.Errorf("下載 Kibana 報表失敗: %w", err)
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusServiceUnavailable:
+		retryAfter, _ := strconv.Atoi(resp.Header.Get("Retry-After"))
+		log.Printf("[Generator] Kibana: 報表尚未完成，稍後重新檢查...")
+		return nil, time.Duration(retryAfter) * time.Second, nil
+	default:
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		var failure struct {
+			Message string `json:"message"`
+		}
+		if json.Unmarshal(body, &failure) == nil && failure.Message != "" {
+			return nil, 0, fmt.Errorf("Kibana 報表產生失敗 (%d): %s", resp.StatusCode, failure.Message)
+		}
+		return nil, 0, fmt.Errorf("Kibana 報表產生失敗 (%d): %s", resp.StatusCode, string(body))
 	}
 
-	log.Printf("[Generator] Kibana: 成功產生報告，檔案儲存於 %s", tmpFile.Name())
+	mimeType := expectedMimeType
+	if mediaType, _, err := mime.ParseMediaType(resp.Header.Get("Content-Type")); err == nil {
+		mimeType = mediaType
+	}
+
+	tmpFile, err := os.CreateTemp("", fmt.Sprintf("report-%s-*%s", task.ID, extensionForMimeType(mimeType)))
+	if err != nil {
+		return nil, 0, fmt.Errorf("建立暫存檔案失敗: %w", err)
+	}
+	defer tmpFile.Close()
+
+	if _, err := io.Copy(tmpFile, resp.Body); err != nil {
+		os.Remove(tmpFile.Name())
+		return nil, 0, fmt.Errorf("寫入暫存檔案失敗: %w", err)
+	}
 
 	return &GenerateResult{
 		FilePath: tmpFile.Name(),
-		MimeType: "application/pdf",
-	}, nil
+		MimeType: mimeType,
+	}, 0, nil
 }
@@ -0,0 +1,327 @@
+package generator
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"report-scheduler/backend/internal/models"
+	"report-scheduler/backend/internal/queue"
+	"report-scheduler/backend/internal/secrets"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/sakura-internet/go-rison/v4"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeKibana 模擬 Kibana 的非同步報表 API：
+// 建立工作後，下載路徑會先回應 pendingPolls 次 503，之後回傳報表或失敗訊息。
+type fakeKibana struct {
+	*httptest.Server
+	pendingPolls int32
+	fail         bool
+	retryAfter   string
+	polls        atomic.Int32
+
+	mu          sync.Mutex
+	authHeaders []string
+	jobType     string
+}
+
+func (k *fakeKibana) recordAuth(r *http.Request) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	k.authHeaders = append(k.authHeaders, r.Header.Get("Authorization"))
+}
+
+func newFakeKibana(t *testing.T, pendingPolls int32, fail bool) *fakeKibana {
+	k := &fakeKibana{pendingPolls: pendingPolls, fail: fail}
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /api/reporting/generate/", func(w http.ResponseWriter, r *http.Request) {
+		k.recordAuth(r)
+		if r.Header.Get("kbn-xsrf") == "" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		k.mu.Lock()
+		k.jobType = strings.TrimPrefix(r.URL.Path, "/api/reporting/generate/")
+		k.mu.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"path":"/api/reporting/jobs/download/job-1","job":{"id":"job-1","jobtype":"printable_pdf","status":"pending"}}`))
+	})
+	mux.HandleFunc("GET /api/reporting/jobs/download/job-1", func(w http.ResponseWriter, r *http.Request) {
+		k.recordAuth(r)
+		if k.polls.Add(1) <= k.pendingPolls {
+			if k.retryAfter != "" {
+				w.Header().Set("Retry-After", k.retryAfter)
+			}
+			w.WriteHeader(http.StatusServiceUnavailable)
+			w.Write([]byte(`{"statusCode":503,"message":"processing"}`))
+			return
+		}
+		if k.fail {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(`{"statusCode":500,"error":"Internal Server Error","message":"Reporting generation failed: browser crashed"}`))
+			return
+		}
+		k.mu.Lock()
+		jobType := k.jobType
+		k.mu.Unlock()
+		switch jobType {
+		case "csv_v2":
+			w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+			w.Write([]byte("host,count\nweb-1,42\n"))
+		case "pngV2":
+			w.Header().Set("Content-Type", "image/png")
+			w.Write([]byte("fake png"))
+		default:
+			w.Header().Set("Content-Type", "application/pdf")
+			w.Write([]byte("%PDF-1.7 fake report"))
+		}
+	})
+	k.Server = httptest.NewServer(mux)
+	t.Cleanup(k.Close)
+	return k
+}
+
+func newTestKibanaGenerator(sm secrets.SecretsManager) *KibanaGenerator {
+	g := NewKibanaGenerator(sm)
+	g.PollInterval = time.Millisecond
+	g.MaxPollInterval = 5 * time.Millisecond
+	g.Timeout = time.Second
+	return g
+}
+
+func TestKibanaGenerator_Generate(t *testing.T) {
+	task := &queue.Task{ID: "task-kibana"}
+	report := &models.ReportDefinition{
+		Name:      "Kibana 儀表板",
+		TimeRange: "now-7d",
+		Elements:  models.ReportElements{{ID: "dash-1", Type: models.DashboardType}},
+	}
+
+	t.Run("polls until the report is ready", func(t *testing.T) {
+		kibana := newFakeKibana(t, 3, false)
+		sm := &secrets.MockSecretsManager{CredsToReturn: &secrets.Credentials{Token: "kibana-key"}}
+		ds := &models.DataSource{URL: kibana.URL, AuthType: models.APIToken}
+
+		result, err := newTestKibanaGenerator(sm).Generate(t.Context(), task, ds, report)
+		require.NoError(t, err)
+		defer os.Remove(result.FilePath)
+
+		require.Equal(t, "application/pdf", result.MimeType)
+		require.True(t, strings.HasSuffix(result.FilePath, ".pdf"))
+		content, err := os.ReadFile(result.FilePath)
+		require.NoError(t, err)
+		require.Equal(t, "%PDF-1.7 fake report", string(content))
+
+		require.Equal(t, int32(4), kibana.polls.Load())
+		for _, header := range kibana.authHeaders {
+			require.Equal(t, "ApiKey kibana-key", header)
+		}
+	})
+
+	t.Run("saved search produces csv", func(t *testing.T) {
+		kibana := newFakeKibana(t, 1, false)
+		ds := &models.DataSource{URL: kibana.URL, AuthType: models.AuthNone}
+		csvReport := &models.ReportDefinition{
+			Name:     "Kibana 搜尋",
+			Elements: models.ReportElements{{ID: "search-1", Type: models.SavedSearchType}},
+		}
+
+		result, err := newTestKibanaGenerator(secrets.NewMockSecretsManager()).Generate(t.Context(), task, ds, csvReport)
+		require.NoError(t, err)
+		defer os.Remove(result.FilePath)
+
+		require.Equal(t, "csv_v2", kibana.jobType)
+		require.Equal(t, "text/csv", result.MimeType)
+		require.True(t, strings.HasSuffix(result.FilePath, ".csv"))
+	})
+
+	t.Run("retry after is capped at max poll interval", func(t *testing.T) {
+		kibana := newFakeKibana(t, 2, false)
+		kibana.retryAfter = "3600"
+		ds := &models.DataSource{URL: kibana.URL, AuthType: models.AuthNone}
+
+		startTime := time.Now()
+		result, err := newTestKibanaGenerator(secrets.NewMockSecretsManager()).Generate(t.Context(), task, ds, report)
+		require.NoError(t, err)
+		defer os.Remove(result.FilePath)
+		require.Less(t, time.Since(startTime), 500*time.Millisecond)
+		require.Equal(t, int32(3), kibana.polls.Load())
+	})
+
+	t.Run("failed job is reported", func(t *testing.T) {
+		kibana := newFakeKibana(t, 1, true)
+		ds := &models.DataSource{URL: kibana.URL, AuthType: models.AuthNone}
+
+		_, err := newTestKibanaGenerator(secrets.NewMockSecretsManager()).Generate(t.Context(), task, ds, report)
+		require.ErrorContains(t, err, "browser crashed")
+	})
+
+	t.Run("times out while processing", func(t *testing.T) {
+		kibana := newFakeKibana(t, 1000, false)
+		ds := &models.DataSource{URL: kibana.URL, AuthType: models.AuthNone}
+
+		gen := newTestKibanaGenerator(secrets.NewMockSecretsManager())
+		gen.Timeout = 50 * time.Millisecond
+		_, err := gen.Generate(t.Context(), task, ds, report)
+		require.ErrorContains(t, err, "逾時")
+		require.Greater(t, kibana.polls.Load(), int32(1))
+	})
+
+	t.Run("cancelled while polling", func(t *testing.T) {
+		kibana := newFakeKibana(t, 1000, false)
+		ds := &models.DataSource{URL: kibana.URL, AuthType: models.AuthNone}
+
+		gen := newTestKibanaGenerator(secrets.NewMockSecretsManager())
+		gen.PollInterval = time.Hour
+		gen.Timeout = 2 * time.Hour
+		ctx, cancel := context.WithCancel(t.Context())
+		time.AfterFunc(20*time.Millisecond, cancel)
+
+		startTime := time.Now()
+		_, err := gen.Generate(ctx, task, ds, report)
+		require.ErrorIs(t, err, context.Canceled)
+		require.Less(t, time.Since(startTime), time.Second, "取消後應立即停止等待")
+		require.Zero(t, kibana.polls.Load())
+	})
+
+	t.Run("rejected generate request", func(t *testing.T) {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte(`{"message":"forbidden"}`))
+		}))
+		defer srv.Close()
+
+		_, err := newTestKibanaGenerator(secrets.NewMockSecretsManager()).Generate(t.Context(), task, &models.DataSource{URL: srv.URL, AuthType: models.AuthNone}, report)
+		require.ErrorContains(t, err, "403")
+	})
+}
+
+// decodeJobParams 從 Kibana 報表 URL 中解出 RISON 編碼的 jobParams
+func decodeJobParams(t *testing.T, generationURL string) map[string]interface{} {
+	u, err := url.Parse(generationURL)
+	require.NoError(t, err)
+	var params map[string]interface{}
+	require.NoError(t, rison.Unmarshal([]byte(u.Query().Get("jobParams")), &params, rison.Rison))
+	return params
+}
+
+func TestBuildURL(t *testing.T) {
+	ds := &models.DataSource{URL: "http://kibana.local/", Version: "8.14.0"}
+	report := &models.ReportDefinition{Name: "每日報表", Space: "ops", TimeRange: "now-24h"}
+
+	testCases := []struct {
+		name          string
+		element       models.ReportElement
+		wantJobType   string
+		wantObject    string
+		wantLocator   string
+		wantIDParam   string
+		wantMimeType  string
+		singleLocator bool
+	}{
+		{"dashboard defaults to pdf", models.ReportElement{ID: "dash-1", Type: models.DashboardType}, "printablePdfV2", "dashboard", "DASHBOARD_APP_LOCATOR", "dashboardId", "application/pdf", false},
+		{"dashboard as png", models.ReportElement{ID: "dash-1", Type: models.DashboardType, Format: models.FormatPNG}, "pngV2", "dashboard", "DASHBOARD_APP_LOCATOR", "dashboardId", "image/png", true},
+		{"visualization as pdf", models.ReportElement{ID: "viz-1", Type: models.VisualizationType}, "printablePdfV2", "visualization", "VISUALIZE_APP_LOCATOR", "visId", "application/pdf", false},
+		{"visualization as png", models.ReportElement{ID: "viz-1", Type: models.VisualizationType, Format: models.FormatPNG}, "pngV2", "visualization", "VISUALIZE_APP_LOCATOR", "visId", "image/png", true},
+		{"lens as png", models.ReportElement{ID: "lens-1", Type: models.LensType, Format: models.FormatPNG}, "pngV2", "lens", "LENS_APP_LOCATOR", "savedObjectId", "image/png", true},
+		{"saved search as csv", models.ReportElement{ID: "search-1", Type: models.SavedSearchType}, "csv_v2", "search", "DISCOVER_APP_LOCATOR", "savedSearchId", "text/csv", false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			generationURL, job, err := buildURL(&queue.Task{}, ds, report, tc.element)
+			require.NoError(t, err)
+			require.True(t, strings.HasPrefix(generationURL, "http://kibana.local/s/ops/api/reporting/generate/"+tc.wantJobType+"?"))
+			require.Equal(t, tc.wantMimeType, mimeTypeForFormat(job.format))
+
+			params := decodeJobParams(t, generationURL)
+			require.Equal(t, tc.wantObject, params["objectType"])
+			require.Equal(t, "8.14.0", params["version"])
+
+			var locator map[string]interface{}
+			if tc.singleLocator {
+				locator = params["locatorParams"].(map[string]interface{})
+			} else {
+				locators := params["locatorParams"].([]interface{})
+				require.Len(t, locators, 1)
+				locator = locators[0].(map[string]interface{})
+			}
+			require.Equal(t, tc.wantLocator, locator["id"])
+			locatorParams := locator["params"].(map[string]interface{})
+			require.Equal(t, tc.element.ID, locatorParams[tc.wantIDParam])
+			require.Contains(t, locatorParams, "timeRange")
+
+			if tc.wantJobType == "csv_v2" {
+				require.NotContains(t, params, "layout", "CSV 不需要版面配置")
+			} else {
+				require.Contains(t, params, "layout")
+			}
+		})
+	}
+
+	t.Run("schedule parameters are encoded as rison app state", func(t *testing.T) {
+		task := &queue.Task{Parameters: map[string]string{"region": "APAC"}}
+		generationURL, _, err := buildURL(task, ds, report, models.ReportElement{ID: "dash-1", Type: models.DashboardType})
+		require.NoError(t, err)
+		u, err := url.Parse(generationURL)
+		require.NoError(t, err)
+		require.Equal(t, "(filters:!(('$state':(store:appState),meta:(alias:!n,disabled:!f,key:region,negate:!f,params:(query:APAC),type:phrase),query:(match_phrase:(region:APAC)))))", u.Query().Get("_a"))
+
+		// 沒有參數時不附上 _a
+		generationURL, _, err = buildURL(&queue.Task{}, ds, report, models.ReportElement{ID: "dash-1", Type: models.DashboardType})
+		require.NoError(t, err)
+		u, err = url.Parse(generationURL)
+		require.NoError(t, err)
+		require.NotContains(t, u.Query(), "_a")
+	})
+
+	t.Run("schedule parameters become app state filters", func(t *testing.T) {
+		task := &queue.Task{Parameters: map[string]string{"region": "APAC", "host.name": "web 1"}}
+		generationURL, _, err := buildURL(task, ds, report, models.ReportElement{ID: "dash-1", Type: models.DashboardType})
+		require.NoError(t, err)
+
+		// locator 的 filters 與 _a 中的篩選條件相同
+		u, err := url.Parse(generationURL)
+		require.NoError(t, err)
+		var appState map[string]interface{}
+		require.NoError(t, rison.Unmarshal([]byte(u.Query().Get("_a")), &appState, rison.Rison))
+
+		locator := decodeJobParams(t, generationURL)["locatorParams"].([]interface{})[0].(map[string]interface{})
+		filters := locator["params"].(map[string]interface{})["filters"].([]interface{})
+		require.Len(t, filters, 2)
+
+		first := filters[0].(map[string]interface{})
+		require.Equal(t, map[string]interface{}{"store": "appState"}, first["$state"])
+		meta := first["meta"].(map[string]interface{})
+		require.Equal(t, "host.name", meta["key"])
+		require.Equal(t, "phrase", meta["type"])
+		require.Equal(t, map[string]interface{}{"match_phrase": map[string]interface{}{"host.name": "web 1"}}, first["query"])
+		require.Equal(t, "region", filters[1].(map[string]interface{})["meta"].(map[string]interface{})["key"])
+		require.Equal(t, filters, appState["filters"])
+
+		// 沒有參數時不加入篩選，保留儀表板本身儲存的篩選
+		generationURL, _, err = buildURL(&queue.Task{}, ds, report, models.ReportElement{ID: "dash-1", Type: models.DashboardType})
+		require.NoError(t, err)
+		locator = decodeJobParams(t, generationURL)["locatorParams"].([]interface{})[0].(map[string]interface{})
+		require.NotContains(t, locator["params"], "filters")
+	})
+
+	t.Run("invalid combinations", func(t *testing.T) {
+		_, _, err := buildURL(&queue.Task{}, ds, report, models.ReportElement{ID: "search-1", Type: models.SavedSearchType, Format: models.FormatPDF})
+		require.ErrorContains(t, err, "只能輸出 CSV")
+
+		_, _, err = buildURL(&queue.Task{}, ds, report, models.ReportElement{ID: "dash-1", Type: models.DashboardType, Format: models.FormatCSV})
+		require.ErrorContains(t, err, "不支援的輸出格式")
+
+		_, _, err = buildURL(&queue.Task{}, ds, report, models.ReportElement{ID: "panel-1", Type: models.PanelType})
+		require.ErrorContains(t, err, "不支援的元素類型")
+	})
+}
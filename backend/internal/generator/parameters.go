@@ -0,0 +1,69 @@
+package generator
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/sakura-internet/go-rison/v4"
+)
+
+// 排程的動態參數 (規格 6.4) 會依資料來源轉換成不同的篩選方式：
+// Grafana 為儀表板變數 var-<名稱>=<值>，Kibana 為 app state (_a) 中的 phrase 篩選，
+// 直接查詢 Elasticsearch 時則是 match_phrase 查詢。
+
+// sortedParameterKeys 回傳依名稱排序的參數名稱，讓產生的 URL 與查詢固定不變
+func sortedParameterKeys(params map[string]string) []string {
+	keys := make([]string, 0, len(params))
+	for key := range params {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// kibanaParameterFilters 將參數轉換為 Kibana 的 phrase 篩選，格式與在 Discover 或儀表板上手動加入的篩選相同
+func kibanaParameterFilters(params map[string]string) []interface{} {
+	var filters []interface{}
+	for _, key := range sortedParameterKeys(params) {
+		value := params[key]
+		filters = append(filters, map[string]interface{}{
+			"$state": map[string]interface{}{"store": "appState"},
+			"meta": map[string]interface{}{
+				"alias":    nil,
+				"disabled": false,
+				"negate":   false,
+				"key":      key,
+				"type":     "phrase",
+				"params":   map[string]interface{}{"query": value},
+			},
+			"query": map[string]interface{}{
+				"match_phrase": map[string]interface{}{key: value},
+			},
+		})
+	}
+	return filters
+}
+
+// kibanaAppState 將參數編碼為 Kibana 網址中 RISON 格式的 app state (_a)，例如 (filters:!(...))。
+// 沒有參數時回傳空字串
+func kibanaAppState(params map[string]string) (string, error) {
+	if len(params) == 0 {
+		return "", nil
+	}
+	state, err := rison.Marshal(map[string]interface{}{"filters": kibanaParameterFilters(params)}, rison.Rison)
+	if err != nil {
+		return "", fmt.Errorf("無法將排程參數編碼為 Kibana app state: %w", err)
+	}
+	return string(state), nil
+}
+
+// parameterClauses 將參數轉換為 Elasticsearch 的 match_phrase 查詢
+func parameterClauses(params map[string]string) []interface{} {
+	var clauses []interface{}
+	for _, key := range sortedParameterKeys(params) {
+		clauses = append(clauses, map[string]interface{}{
+			"match_phrase": map[string]interface{}{key: params[key]},
+		})
+	}
+	return clauses
+}
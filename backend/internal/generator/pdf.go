@@ -0,0 +1,130 @@
+package generator
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"strings"
+	"unicode/utf16"
+
+	"github.com/pdfcpu/pdfcpu/pkg/api"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/types"
+)
+
+func init() {
+	// 不讓 pdfcpu 在使用者目錄中建立設定檔
+	api.DisableConfigDir()
+}
+
+// imageToPDF 將一張圖片置中放大到一頁橫向的 A4 PDF 中
+func imageToPDF(imagePath, pdfPath string) error {
+	imp, err := pdfcpu.ParseImportDetails("form:A4L, pos:c, sc:0.95 rel", types.POINTS)
+	if err != nil {
+		return err
+	}
+	if err := api.ImportImagesFile([]string{imagePath}, pdfPath, imp, nil); err != nil {
+		return fmt.Errorf("無法將圖片轉換為 PDF: %w", err)
+	}
+	return nil
+}
+
+// A4 直式頁面的尺寸 (PDF 點，1/72 英吋)
+const (
+	a4Width  = 595.0
+	a4Height = 842.0
+)
+
+// pdfTextLine 是頁面上的一行文字，座標以 PDF 點為單位，原點在頁面左下角
+type pdfTextLine struct {
+	Text string
+	Size float64
+	X, Y float64
+}
+
+// textWidth 估算文字在 MSung-Light 字型下的寬度：ASCII 為半形，其餘為全形
+func textWidth(text string, size float64) float64 {
+	var width float64
+	for _, r := range text {
+		if r < 0x80 {
+			width += size / 2
+		} else {
+			width += size
+		}
+	}
+	return width
+}
+
+// fitText 截斷超過 maxWidth 的文字並在結尾加上省略號
+func fitText(text string, size, maxWidth float64) string {
+	if textWidth(text, size) <= maxWidth {
+		return text
+	}
+	runes := []rune(text)
+	for len(runes) > 0 && textWidth(string(runes)+"…", size) > maxWidth {
+		runes = runes[:len(runes)-1]
+	}
+	return string(runes) + "…"
+}
+
+// writeTextPDF 產生只包含文字的 PDF，pages 中的每個元素代表一頁 A4 直式頁面。
+// 文字使用 PDF 閱讀器內建的 CJK 字型 (MSung-Light)，不需要嵌入字型檔即可顯示中文。
+func writeTextPDF(path string, pages [][]pdfTextLine) error {
+	var buf bytes.Buffer
+	var offsets []int
+	beginObject := func() {
+		offsets = append(offsets, buf.Len())
+		fmt.Fprintf(&buf, "%d 0 obj\n", len(offsets))
+	}
+
+	buf.WriteString("%PDF-1.7\n")
+
+	// 物件 1-5 為目錄、頁面樹與字型，之後每頁各有一個頁面物件與內容串流物件
+	const firstPageObject = 6
+	kids := make([]string, len(pages))
+	for i := range pages {
+		kids[i] = fmt.Sprintf("%d 0 R", firstPageObject+i*2)
+	}
+
+	beginObject()
+	buf.WriteString("<< /Type /Catalog /Pages 2 0 R >>\nendobj\n")
+	beginObject()
+	fmt.Fprintf(&buf, "<< /Type /Pages /Kids [%s] /Count %d >>\nendobj\n", strings.Join(kids, " "), len(pages))
+	beginObject()
+	buf.WriteString("<< /Type /Font /Subtype /Type0 /BaseFont /MSung-Light /Encoding /UniCNS-UTF16-H /DescendantFonts [4 0 R] >>\nendobj\n")
+	beginObject()
+	buf.WriteString("<< /Type /Font /Subtype /CIDFontType0 /BaseFont /MSung-Light " +
+		"/CIDSystemInfo << /Registry (Adobe) /Ordering (CNS1) /Supplement 4 >> " +
+		"/FontDescriptor 5 0 R /DW 1000 /W [1 95 500] >>\nendobj\n")
+	beginObject()
+	buf.WriteString("<< /Type /FontDescriptor /FontName /MSung-Light /Flags 6 /FontBBox [-160 -249 1015 1071] " +
+		"/ItalicAngle 0 /Ascent 880 /Descent -120 /CapHeight 880 /StemV 93 >>\nendobj\n")
+
+	for i, lines := range pages {
+		var content bytes.Buffer
+		for _, line := range lines {
+			fmt.Fprintf(&content, "BT /F1 %.1f Tf %.2f %.2f Td <", line.Size, line.X, line.Y)
+			for _, unit := range utf16.Encode([]rune(line.Text)) {
+				fmt.Fprintf(&content, "%04X", unit)
+			}
+			content.WriteString("> Tj ET\n")
+		}
+
+		beginObject()
+		fmt.Fprintf(&buf, "<< /Type /Page /Parent 2 0 R /MediaBox [0 0 %.0f %.0f] /Resources << /Font << /F1 3 0 R >> >> /Contents %d 0 R >>\nendobj\n",
+			a4Width, a4Height, firstPageObject+i*2+1)
+		beginObject()
+		fmt.Fprintf(&buf, "<< /Length %d >>\nstream\n", content.Len())
+		buf.Write(content.Bytes())
+		buf.WriteString("endstream\nendobj\n")
+	}
+
+	xrefOffset := buf.Len()
+	fmt.Fprintf(&buf, "xref\n0 %d\n0000000000 65535 f \n", len(offsets)+1)
+	for _, offset := range offsets {
+		fmt.Fprintf(&buf, "%010d 00000 n \n", offset)
+	}
+	fmt.Fprintf(&buf, "trailer\n<< /Size %d /Root 1 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(offsets)+1, xrefOffset)
+
+	return os.WriteFile(path, buf.Bytes(), 0644)
+}
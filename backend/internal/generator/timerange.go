@@ -0,0 +1,21 @@
+package generator
+
+import (
+	"report-scheduler/backend/internal/models"
+	"report-scheduler/backend/internal/queue"
+	"report-scheduler/backend/internal/timerange"
+	"time"
+)
+
+// ResolveReportTimeRange 以任務的時間基準點與時區將報表的時間範圍解析為絕對時間。
+// 報表沒有設定時間範圍時 ok 為 false。
+func ResolveReportTimeRange(task *queue.Task, report *models.ReportDefinition) (from, to time.Time, ok bool, err error) {
+	if report.TimeRange == "" {
+		return time.Time{}, time.Time{}, false, nil
+	}
+	from, to, err = timerange.Resolve(report.TimeRange, task.Reference(), task.Location())
+	if err != nil {
+		return time.Time{}, time.Time{}, false, err
+	}
+	return from, to, true, nil
+}
@@ -0,0 +1,137 @@
+package generator
+
+import (
+	"archive/zip"
+	"bufio"
+	"encoding/csv"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strconv"
+	"unicode/utf8"
+)
+
+const xlsxMimeType = "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"
+
+// Excel 工作表的限制
+const (
+	xlsxMaxRows      = 1048576
+	xlsxMaxCellChars = 32767
+)
+
+// rowWriter 以串流方式逐列寫入表格，寫完後必須呼叫 Close 才會輸出完整的檔案
+type rowWriter interface {
+	WriteRow(values []string) error
+	Close() error
+}
+
+// csvRowWriter 將資料列寫成 CSV
+type csvRowWriter struct {
+	buf *bufio.Writer
+	w   *csv.Writer
+}
+
+func newCSVRowWriter(w io.Writer) *csvRowWriter {
+	buf := bufio.NewWriter(w)
+	return &csvRowWriter{buf: buf, w: csv.NewWriter(buf)}
+}
+
+func (c *csvRowWriter) WriteRow(values []string) error {
+	return c.w.Write(values)
+}
+
+func (c *csvRowWriter) Close() error {
+	c.w.Flush()
+	if err := c.w.Error(); err != nil {
+		return err
+	}
+	return c.buf.Flush()
+}
+
+// xlsxRowWriter 直接產生只有一個工作表的 XLSX 檔案。
+// 儲存格使用 inline string，不需要共用字串表，因此可以一邊查詢一邊寫出，不必把整份資料放在記憶體中。
+type xlsxRowWriter struct {
+	zw    *zip.Writer
+	sheet *bufio.Writer
+	rows  int
+}
+
+// xlsx 檔案中除了工作表以外的固定內容
+var xlsxStaticParts = []struct{ name, content string }{
+	{"[Content_Types].xml", xml.Header + `<Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types">` +
+		`<Default Extension="rels" ContentType="application/vnd.openxmlformats-package.relationships+xml"/>` +
+		`<Default Extension="xml" ContentType="application/xml"/>` +
+		`<Override PartName="/xl/workbook.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.sheet.main+xml"/>` +
+		`<Override PartName="/xl/worksheets/sheet1.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.worksheet+xml"/>` +
+		`</Types>`},
+	{"_rels/.rels", xml.Header + `<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">` +
+		`<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/officeDocument" Target="xl/workbook.xml"/>` +
+		`</Relationships>`},
+	{"xl/workbook.xml", xml.Header + `<workbook xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main" xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships">` +
+		`<sheets><sheet name="Sheet1" sheetId="1" r:id="rId1"/></sheets>` +
+		`</workbook>`},
+	{"xl/_rels/workbook.xml.rels", xml.Header + `<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">` +
+		`<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/worksheet" Target="worksheets/sheet1.xml"/>` +
+		`</Relationships>`},
+}
+
+func newXLSXRowWriter(w io.Writer) (*xlsxRowWriter, error) {
+	zw := zip.NewWriter(w)
+	for _, part := range xlsxStaticParts {
+		f, err := zw.Create(part.name)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := io.WriteString(f, part.content); err != nil {
+			return nil, err
+		}
+	}
+
+	// 工作表必須是 zip 中最後一個檔案，之後的資料列才能持續寫入
+	f, err := zw.Create("xl/worksheets/sheet1.xml")
+	if err != nil {
+		return nil, err
+	}
+	sheet := bufio.NewWriter(f)
+	sheet.WriteString(xml.Header + `<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main"><sheetData>`)
+	return &xlsxRowWriter{zw: zw, sheet: sheet}, nil
+}
+
+func (x *xlsxRowWriter) WriteRow(values []string) error {
+	if x.rows >= xlsxMaxRows {
+		return fmt.Errorf("資料超過 XLSX 的列數上限 (%d)，請改用 CSV", xlsxMaxRows)
+	}
+	x.rows++
+
+	row := strconv.Itoa(x.rows)
+	x.sheet.WriteString(`<row r="` + row + `">`)
+	for i, value := range values {
+		if utf8.RuneCountInString(value) > xlsxMaxCellChars {
+			value = string([]rune(value)[:xlsxMaxCellChars])
+		}
+		x.sheet.WriteString(`<c r="` + xlsxColumnName(i) + row + `" t="inlineStr"><is><t xml:space="preserve">`)
+		if err := xml.EscapeText(x.sheet, []byte(value)); err != nil {
+			return err
+		}
+		x.sheet.WriteString(`</t></is></c>`)
+	}
+	_, err := x.sheet.WriteString(`</row>`)
+	return err
+}
+
+func (x *xlsxRowWriter) Close() error {
+	x.sheet.WriteString(`</sheetData></worksheet>`)
+	if err := x.sheet.Flush(); err != nil {
+		return err
+	}
+	return x.zw.Close()
+}
+
+// xlsxColumnName 將從 0 開始的欄位索引轉換為 Excel 的欄名 (A、B、...、Z、AA、...)
+func xlsxColumnName(index int) string {
+	var name []byte
+	for index++; index > 0; index = (index - 1) / 26 {
+		name = append([]byte{byte('A' + (index-1)%26)}, name...)
+	}
+	return string(name)
+}
@@ -1,6 +1,11 @@
 package models
 
-import "time"
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"time"
+)
 
 // LogStatus 定義了歷史紀錄的狀態
 type LogStatus string
@@ -9,6 +14,8 @@ const (
 	LogStatusSuccess  LogStatus = "success"
 	LogStatusFailed   LogStatus = "failed"
 	LogStatusRetrying LogStatus = "retrying"
+	// LogStatusPartial 表示部分報表產生成功、部分失敗
+	LogStatusPartial LogStatus = "partial"
 )
 
 // HistoryLog 對應到資料庫中的 history_logs 資料表
@@ -20,6 +27,76 @@ type HistoryLog struct {
 	ExecutionDuration int64      `json:"execution_duration_ms"` // 執行耗時 (毫秒)
 	Status            LogStatus  `json:"status"`
 	ErrorMessage      string     `json:"error_message,omitempty"`
-	Recipients        Recipients `json:"recipients"` // 重用 Schedule 的 Recipients 結構
-	ReportURL         string     `json:"report_url,omitempty"`
+	Recipients        Recipients `json:"recipients"`           // 重用 Schedule 的 Recipients 結構
+	ReportURL         string     `json:"report_url,omitempty"` // 報表檔案在檔案儲存中的 key，多個以 ", " 分隔
+	// ReferenceTime 是這次執行解析報表時間範圍時使用的時間基準，重試與重寄的任務會晚於此時間建立
+	ReferenceTime *time.Time `json:"reference_time,omitempty"`
+	// ReportLinks 是 API 回應時依 ReportURL 產生的簽章下載連結，不會儲存到資料庫
+	ReportLinks []string `json:"report_links,omitempty"`
+	// Reports 是這次執行中每份報表的結果，儲存在 report_results 資料表
+	Reports []ReportResult `json:"reports,omitempty"`
+}
+
+// Reference 回傳這次執行的時間基準。舊版的紀錄沒有 ReferenceTime，只能以觸發時間代替
+func (l *HistoryLog) Reference() time.Time {
+	if l.ReferenceTime != nil && !l.ReferenceTime.IsZero() {
+		return *l.ReferenceTime
+	}
+	return l.TriggerTime
+}
+
+// ReportResult 對應到資料庫中的 report_results 資料表，記錄一次執行中單一報表的結果
+type ReportResult struct {
+	ID           string    `json:"id"`
+	HistoryLogID string    `json:"history_log_id"`
+	ReportID     string    `json:"report_id"`
+	ReportName   string    `json:"report_name"`
+	Status       LogStatus `json:"status"` // success 或 failed
+	ErrorMessage string    `json:"error_message,omitempty"`
+	Duration     int64     `json:"duration_ms"` // 產生與上傳此報表的耗時 (毫秒)
+	// TimeFrom 與 TimeTo 是依觸發時間解析出的報表時間範圍，報表沒有設定時間範圍時為空
+	TimeFrom  *time.Time      `json:"time_from,omitempty"`
+	TimeTo    *time.Time      `json:"time_to,omitempty"`
+	Artifacts ReportArtifacts `json:"artifacts"`
+}
+
+// ReportArtifact 是報表產生的一個檔案，一份報表可能有多個檔案 (例如合併後的 PDF 與獨立的 CSV)
+type ReportArtifact struct {
+	Key      string `json:"key"` // 檔案在檔案儲存中的 key
+	MimeType string `json:"mime_type"`
+	Size     int64  `json:"size"`
+	// Link 是 API 回應時產生的簽章下載連結，不會儲存到資料庫
+	Link string `json:"link,omitempty"`
+}
+
+// ReportArtifacts 是一份報表的所有檔案
+type ReportArtifacts []ReportArtifact
+
+// --- JSON (un)marshalling for ReportArtifacts ---
+
+// Value 實作 driver.Valuer 介面，下載連結有期限，因此不會被儲存
+func (a ReportArtifacts) Value() (driver.Value, error) {
+	stored := make(ReportArtifacts, len(a))
+	for i, artifact := range a {
+		artifact.Link = ""
+		stored[i] = artifact
+	}
+	return json.Marshal(stored)
+}
+
+// Scan 實作 sql.Scanner 介面
+func (a *ReportArtifacts) Scan(src interface{}) error {
+	var source []byte
+	switch v := src.(type) {
+	case string:
+		source = []byte(v)
+	case []byte:
+		source = v
+	case nil:
+		*a = ReportArtifacts{}
+		return nil
+	default:
+		return errors.New("incompatible type for ReportArtifacts")
+	}
+	return json.Unmarshal(source, a)
 }
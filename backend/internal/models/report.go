@@ -14,6 +14,21 @@ const (
 	DashboardType     ReportElementType = "dashboard"
 	VisualizationType ReportElementType = "visualization"
 	SavedSearchType   ReportElementType = "saved_search"
+	// LensType 是以 Kibana Lens 建立的視覺化，與一般視覺化使用不同的 locator
+	LensType ReportElementType = "lens"
+	// PanelType 是 Grafana 儀表板中的單一面板，ID 格式為 "<儀表板 UID>/<面板 ID>"
+	PanelType ReportElementType = "panel"
+)
+
+// OutputFormat 定義了報表元素輸出的檔案格式
+type OutputFormat string
+
+const (
+	FormatPDF OutputFormat = "pdf"
+	FormatPNG OutputFormat = "png"
+	FormatCSV OutputFormat = "csv"
+	// FormatXLSX 只用於已儲存的搜尋，需要資料來源設定 Elasticsearch API URL
+	FormatXLSX OutputFormat = "xlsx"
 )
 
 // ReportElement 代表報表中的一個可排序項目
@@ -22,6 +37,8 @@ type ReportElement struct {
 	Type  ReportElementType `json:"type"`
 	Title string            `json:"title"`
 	Order int               `json:"order"`
+	// Format 為選填，未設定時由產生器依元素類型決定
+	Format OutputFormat `json:"format,omitempty"`
 }
 
 // ReportElements 是一個 ReportElement 的切片，它實作了 sql.Scanner 和 driver.Valuer
@@ -37,8 +54,11 @@ type ReportDefinition struct {
 	Space        string         `json:"space,omitempty"` // Kibana space
 	TimeRange    string         `json:"time_range"`
 	Elements     ReportElements `json:"elements"` // 使用我們的自訂類型
-	CreatedAt    time.Time      `json:"created_at"`
-	UpdatedAt    time.Time      `json:"updated_at"`
+	// 多個元素合併成單一 PDF 時，是否加入封面與目錄
+	CoverPage       bool      `json:"cover_page"`
+	TableOfContents bool      `json:"table_of_contents"`
+	CreatedAt       time.Time `json:"created_at"`
+	UpdatedAt       time.Time `json:"updated_at"`
 }
 
 // Value 實作 driver.Valuer 介面，將 ReportElements 轉為可存入資料庫的 JSON 字串
@@ -4,6 +4,7 @@ import (
 	"database/sql/driver"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"time"
 )
 
@@ -17,6 +18,10 @@ type Recipients struct {
 // ReportIDList 是一個字串陣列，用於存放報表 ID
 type ReportIDList []string
 
+// Parameters 是排程的動態參數 (規格 6.4)，例如 {"region": "APAC"}。
+// 產生報表時會依資料來源轉換為 Grafana 的 var-region=APAC 或 Kibana 的篩選條件。
+type Parameters map[string]string
+
 // Schedule 對應到資料庫中的 schedules 資料表
 type Schedule struct {
 	ID           string       `json:"id"`
@@ -28,8 +33,43 @@ type Schedule struct {
 	EmailBody    string       `json:"email_body"`
 	ReportIDs    ReportIDList `json:"report_ids"`
 	IsEnabled    bool         `json:"is_enabled"`
-	CreatedAt    time.Time    `json:"created_at"`
-	UpdatedAt    time.Time    `json:"updated_at"`
+	// MaxRetries 與 RetryDelaySeconds 為選填，未設定時使用系統預設的重試策略
+	MaxRetries        *int       `json:"max_retries,omitempty"`
+	RetryDelaySeconds *int       `json:"retry_delay_seconds,omitempty"`
+	RetentionDays     *int       `json:"retention_days,omitempty"` // 選填，覆寫系統預設的執行紀錄保留天數
+	Parameters        Parameters `json:"parameters,omitempty"`
+	CreatedAt         time.Time  `json:"created_at"`
+	UpdatedAt         time.Time  `json:"updated_at"`
+}
+
+// 預設的重試策略 (規格 6.2：重試 3 次，間隔 10 分鐘)
+const (
+	DefaultMaxRetries = 3
+	DefaultRetryDelay = 10 * time.Minute
+)
+
+// RetryPolicy 回傳排程的重試次數與重試間隔，未設定的部分使用預設值
+func (s *Schedule) RetryPolicy() (maxRetries int, delay time.Duration) {
+	maxRetries, delay = DefaultMaxRetries, DefaultRetryDelay
+	if s.MaxRetries != nil {
+		maxRetries = *s.MaxRetries
+	}
+	if s.RetryDelaySeconds != nil {
+		delay = time.Duration(*s.RetryDelaySeconds) * time.Second
+	}
+	return maxRetries, delay
+}
+
+// Location 回傳排程設定的 IANA 時區，未設定時使用伺服器的本地時區
+func (s *Schedule) Location() (*time.Location, error) {
+	if s.Timezone == "" {
+		return time.Local, nil
+	}
+	loc, err := time.LoadLocation(s.Timezone)
+	if err != nil {
+		return nil, fmt.Errorf("無效的時區 '%s': %w", s.Timezone, err)
+	}
+	return loc, nil
 }
 
 // --- JSON (un)marshalling for Recipients ---
@@ -56,6 +96,39 @@ func (r *Recipients) Scan(src interface{}) error {
 	return json.Unmarshal(source, r)
 }
 
+// --- JSON (un)marshalling for Parameters ---
+
+// Value 實作 driver.Valuer 介面
+func (p Parameters) Value() (driver.Value, error) {
+	if len(p) == 0 {
+		return "{}", nil
+	}
+	return json.Marshal(p)
+}
+
+// Scan 實作 sql.Scanner 介面
+func (p *Parameters) Scan(src interface{}) error {
+	var source []byte
+	switch v := src.(type) {
+	case string:
+		source = []byte(v)
+	case []byte:
+		source = v
+	case nil:
+		*p = nil
+		return nil
+	default:
+		return errors.New("incompatible type for Parameters")
+	}
+	if err := json.Unmarshal(source, p); err != nil {
+		return err
+	}
+	if len(*p) == 0 {
+		*p = nil
+	}
+	return nil
+}
+
 // --- JSON (un)marshalling for ReportIDList ---
 
 // Value 實作 driver.Valuer 介面
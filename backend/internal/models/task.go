@@ -0,0 +1,52 @@
+package models
+
+import "time"
+
+// TaskStatus 定義了任務在生命週期中的狀態
+type TaskStatus string
+
+const (
+	TaskStatusQueued    TaskStatus = "queued"
+	TaskStatusRunning   TaskStatus = "running"
+	TaskStatusSucceeded TaskStatus = "succeeded"
+	TaskStatusFailed    TaskStatus = "failed"
+	TaskStatusCancelled TaskStatus = "cancelled"
+)
+
+// IsValid 檢查狀態是否為已定義的值
+func (s TaskStatus) IsValid() bool {
+	switch s {
+	case TaskStatusQueued, TaskStatusRunning, TaskStatusSucceeded, TaskStatusFailed, TaskStatusCancelled:
+		return true
+	}
+	return false
+}
+
+// IsFinal 表示任務已經結束，不會再被執行
+func (s TaskStatus) IsFinal() bool {
+	return s == TaskStatusSucceeded || s == TaskStatusFailed || s == TaskStatusCancelled
+}
+
+// Task 對應到資料庫中的 tasks 資料表，記錄一個任務從推入佇列到結束的過程。
+// 失敗後等待重試的任務會回到 queued 狀態，Attempt 為下一次嘗試的次數 (從 0 開始)。
+type Task struct {
+	ID           string     `json:"id"`
+	ScheduleID   string     `json:"schedule_id"`
+	ScheduleName string     `json:"schedule_name"`
+	Status       TaskStatus `json:"status"`
+	Attempt      int        `json:"attempt"`
+	WorkerID     string     `json:"worker_id,omitempty"` // 最後一次處理此任務的 Worker
+	ErrorMessage string     `json:"error_message,omitempty"`
+	QueuedAt     time.Time  `json:"queued_at"`
+	StartedAt    *time.Time `json:"started_at,omitempty"`
+	FinishedAt   *time.Time `json:"finished_at,omitempty"`
+	UpdatedAt    time.Time  `json:"updated_at"`
+}
+
+// TaskFilter 是查詢任務列表時的篩選條件，空值表示不篩選
+type TaskFilter struct {
+	ScheduleID string
+	Status     TaskStatus
+	// Limit 是回傳的最大筆數，0 表示不限制
+	Limit int
+}
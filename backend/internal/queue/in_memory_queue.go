@@ -3,6 +3,8 @@ package queue
 import (
 	"context"
 	"errors"
+	"log"
+	"time"
 )
 
 var ErrQueueClosed = errors.New("queue is closed")
@@ -42,6 +44,27 @@ func (q *InMemoryQueue) Enqueue(ctx context.Context, task *Task) error {
 	}
 }
 
+// EnqueueAfter 在經過 delay 之後才將任務加入佇列。
+// 注意：等待中的任務只存在於記憶體中，程式重新啟動後就會遺失。
+func (q *InMemoryQueue) EnqueueAfter(ctx context.Context, task *Task, delay time.Duration) error {
+	if delay <= 0 {
+		return q.Enqueue(ctx, task)
+	}
+
+	select {
+	case <-q.done:
+		return ErrQueueClosed
+	default:
+	}
+
+	time.AfterFunc(delay, func() {
+		if err := q.Enqueue(context.Background(), task); err != nil {
+			log.Printf("錯誤：延遲任務 %s 無法加入佇列: %v", task.ID, err)
+		}
+	})
+	return nil
+}
+
 // Dequeue 從佇列中取出任務。如果佇列已關閉且為空，則回傳錯誤。
 func (q *InMemoryQueue) Dequeue(ctx context.Context) (*Task, error) {
 	select {
@@ -60,6 +83,17 @@ func (q *InMemoryQueue) Dequeue(ctx context.Context) (*Task, error) {
 	}
 }
 
+// Ack 對記憶體佇列而言不需要做任何事，任務在 Dequeue 時就已經離開佇列
+func (q *InMemoryQueue) Ack(ctx context.Context, task *Task) error {
+	return nil
+}
+
+// Release 對記憶體佇列而言不需要做任何事。任務在 Dequeue 時就已經離開佇列，
+// 而且只有在服務停止時才會被歸還，此時佇列中的任務本來就會遺失。
+func (q *InMemoryQueue) Release(ctx context.Context, task *Task) error {
+	return nil
+}
+
 // Close 關閉佇列，不再接受新的任務。
 func (q *InMemoryQueue) Close() {
 	// 使用 select 避免重複關閉 channel 導致 panic
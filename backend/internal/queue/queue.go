@@ -2,17 +2,83 @@ package queue
 
 import (
 	"context"
+	"fmt"
+	"report-scheduler/backend/internal/config"
+	"report-scheduler/backend/internal/models"
 	"time"
 )
 
 // Task 代表一個需要被 Worker 執行的報表產生任務
 type Task struct {
-	ID         string    `json:"id"`
-	ScheduleID string    `json:"schedule_id"`
+	ID         string `json:"id"`
+	ScheduleID string `json:"schedule_id"`
 	// 雖然 Schedule 中有 ReportIDs，但在 Task 中也放一份可以讓 Task 本身更獨立，
 	// 未來如果支援手動觸發單一報表，這樣的設計會更有彈性。
-	ReportIDs []string `json:"report_ids"`
+	ReportIDs []string  `json:"report_ids"`
 	CreatedAt time.Time `json:"created_at"`
+	// ReferenceTime 是報表的時間基準點，相對的時間範圍 (例如 "now-7d") 以它作為 now 計算。
+	// 一般任務與觸發時間相同；重寄時為原始紀錄的 TriggerTime，讓重新產生的報表涵蓋相同的區間。
+	ReferenceTime time.Time `json:"reference_time"`
+	// Timezone 是排程的 IANA 時區，報表的時間範圍在這個時區中計算
+	Timezone string `json:"timezone,omitempty"`
+	// Parameters 是排程的動態參數，產生報表時會套用到每個元素的 URL 上
+	Parameters map[string]string `json:"parameters,omitempty"`
+
+	// Attempt 是此任務已經重試的次數，第一次執行時為 0
+	Attempt int `json:"attempt"`
+	// MaxRetries 是失敗後最多可以重試的次數
+	MaxRetries int `json:"max_retries"`
+	// RetryDelay 是每次重試之間的等待時間
+	RetryDelay time.Duration `json:"retry_delay"`
+
+	// Receipt 是佇列在 Dequeue 時附加的內部憑據，Ack 時用來確認是哪一次取出，不會被序列化
+	Receipt string `json:"-"`
+}
+
+// NewScheduleTask 根據排程建立一個新的任務，並套用排程的重試策略
+func NewScheduleTask(id string, schedule *models.Schedule) *Task {
+	maxRetries, retryDelay := schedule.RetryPolicy()
+	now := time.Now()
+	return &Task{
+		ID:            id,
+		ScheduleID:    schedule.ID,
+		ReportIDs:     schedule.ReportIDs,
+		CreatedAt:     now,
+		ReferenceTime: now,
+		Timezone:      schedule.Timezone,
+		Parameters:    schedule.Parameters,
+		MaxRetries:    maxRetries,
+		RetryDelay:    retryDelay,
+	}
+}
+
+// Reference 回傳任務的時間基準點。舊版任務沒有 ReferenceTime 時使用建立時間，兩者都沒有時使用目前時間。
+func (t *Task) Reference() time.Time {
+	switch {
+	case !t.ReferenceTime.IsZero():
+		return t.ReferenceTime
+	case !t.CreatedAt.IsZero():
+		return t.CreatedAt
+	default:
+		return time.Now()
+	}
+}
+
+// CanRetry 表示任務失敗後是否還有剩餘的重試次數
+func (t *Task) CanRetry() bool {
+	return t.Attempt < t.MaxRetries
+}
+
+// Location 回傳任務的時區，未設定或無效時使用伺服器的本地時區
+func (t *Task) Location() *time.Location {
+	if t.Timezone == "" {
+		return time.Local
+	}
+	loc, err := time.LoadLocation(t.Timezone)
+	if err != nil {
+		return time.Local
+	}
+	return loc
 }
 
 // Queue 是任務佇列的介面，定義了排程器和工作者如何與佇列互動。
@@ -20,8 +86,35 @@ type Task struct {
 type Queue interface {
 	// Enqueue 將一個任務加入到佇列中
 	Enqueue(ctx context.Context, task *Task) error
+	// EnqueueAfter 在經過 delay 之後才讓任務可以被取出，用於失敗任務的延遲重試
+	EnqueueAfter(ctx context.Context, task *Task, delay time.Duration) error
 	// Dequeue 從佇列中取出一個任務。如果佇列是空的，這個方法應該會阻塞直到有新任務可用或 context 被取消。
 	Dequeue(ctx context.Context) (*Task, error)
+	// Ack 確認任務已處理完畢 (不論成功或失敗)，佇列可以將其永久移除。
+	// 持久化的佇列在任務被 Ack 之前會保留它，以便程式中斷後重新執行。
+	Ack(ctx context.Context, task *Task) error
+	// Release 歸還尚未處理完畢的任務的租約，讓它可以立即再次被取出，用於服務停止時中止的任務。
+	// 與 Ack 一樣以 Dequeue 時的憑據辨識，任務已被重新取出或重新加入佇列時不會有任何影響。
+	Release(ctx context.Context, task *Task) error
 	// Close 優雅地關閉佇列
 	Close()
 }
+
+// NewQueue 是任務佇列的工廠函式。
+// 它會根據設定檔中的 queue.type 來決定要回傳哪一種 Queue 實作。
+func NewQueue(cfg config.Config) (Queue, error) {
+	switch cfg.Queue.Type {
+	case "", "memory":
+		size := cfg.Queue.Size
+		if size <= 0 {
+			size = 100
+		}
+		return NewInMemoryQueue(size), nil
+	case "sqlite":
+		return NewSqliteQueue(cfg.Database.Path, cfg.Queue)
+	case "redis":
+		return NewRedisQueue(cfg.Queue)
+	default:
+		return nil, fmt.Errorf("不支援的佇列類型: %s", cfg.Queue.Type)
+	}
+}
@@ -73,3 +73,36 @@ func TestInMemoryQueue(t *testing.T) {
 		require.NotPanics(t, func() { q.Close() }, "closing an already closed queue should not panic")
 	})
 }
+
+func TestInMemoryQueue_EnqueueAfter(t *testing.T) {
+	t.Run("task becomes available after delay", func(t *testing.T) {
+		q := NewInMemoryQueue(10)
+		defer q.Close()
+
+		startTime := time.Now()
+		require.NoError(t, q.EnqueueAfter(context.Background(), &Task{ID: "delayed"}, 30*time.Millisecond))
+
+		taskOut, err := q.Dequeue(context.Background())
+		require.NoError(t, err)
+		require.Equal(t, "delayed", taskOut.ID)
+		require.GreaterOrEqual(t, time.Since(startTime), 25*time.Millisecond, "任務不應在延遲結束前被取出")
+	})
+
+	t.Run("zero delay enqueues immediately", func(t *testing.T) {
+		q := NewInMemoryQueue(10)
+		defer q.Close()
+
+		require.NoError(t, q.EnqueueAfter(context.Background(), &Task{ID: "now"}, 0))
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+		taskOut, err := q.Dequeue(ctx)
+		require.NoError(t, err)
+		require.Equal(t, "now", taskOut.ID)
+	})
+
+	t.Run("closed queue rejects delayed task", func(t *testing.T) {
+		q := NewInMemoryQueue(10)
+		q.Close()
+		require.Equal(t, ErrQueueClosed, q.EnqueueAfter(context.Background(), &Task{ID: "late"}, time.Millisecond))
+	})
+}
@@ -0,0 +1,273 @@
+package queue
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"report-scheduler/backend/internal/config"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+)
+
+// RedisQueue 是儲存在 Redis 中的可靠佇列 (reliable queue)。
+//
+// 任務依序存放在以下幾個 key 中：
+//   - ready: 可以被取出的任務內容 (list)
+//   - delayed: 尚未到達可執行時間的任務內容，以可執行時間為分數 (sorted set)
+//   - processing: 已被 Worker 取出但尚未 Ack 的任務憑據 (list)
+//   - payloads: processing 中每個憑據對應的任務內容 (hash)
+//   - leases: processing 中每個憑據，以租約到期時間為分數 (sorted set)
+//
+// 任務被取出時會原子性地從 ready 移到 processing，並以一個新的憑據記錄租約；
+// Worker 處理完畢後以該憑據呼叫 Ack 才會移除。租約過期仍未被 Ack 的任務
+// (例如 Worker 所在的程序中斷) 會在之後的 Dequeue 中被放回 ready 重新執行，
+// 因此多個伺服器可以共用同一個佇列。
+//
+// 所有 key 的前綴都以 {} 包住作為 hash tag，讓 Redis Cluster 把它們放在同一個 slot，
+// Lua script 才能在一次呼叫中同時操作這些 key。
+type RedisQueue struct {
+	client            *redis.Client
+	keys              redisQueueKeys
+	visibilityTimeout time.Duration
+	pollInterval      time.Duration
+
+	notify    chan struct{} // 本程序有新任務時喚醒等待中的 Dequeue
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+type redisQueueKeys struct {
+	ready, delayed, processing, payloads, leases string
+}
+
+func (k redisQueueKeys) all() []string {
+	return []string{k.ready, k.delayed, k.processing, k.payloads, k.leases}
+}
+
+// dequeueScript 先將到期的延遲任務與租約過期的任務放回 ready，再取出一個任務並記錄租約。
+// 每次最多處理 100 個到期的項目，剩下的留給之後的 Dequeue，避免單次 script 執行過久阻塞 Redis。
+// KEYS: ready, delayed, processing, payloads, leases；ARGV: 現在時間 (ms)、租約到期時間 (ms)、新的憑據
+var dequeueScript = redis.NewScript(`
+local now = tonumber(ARGV[1])
+local due = redis.call('ZRANGEBYSCORE', KEYS[2], '-inf', now, 'LIMIT', 0, 100)
+for _, payload in ipairs(due) do
+	redis.call('ZREM', KEYS[2], payload)
+	redis.call('LPUSH', KEYS[1], payload)
+end
+local expired = redis.call('ZRANGEBYSCORE', KEYS[5], '-inf', now, 'LIMIT', 0, 100)
+for _, receipt in ipairs(expired) do
+	local payload = redis.call('HGET', KEYS[4], receipt)
+	redis.call('LREM', KEYS[3], 1, receipt)
+	redis.call('HDEL', KEYS[4], receipt)
+	redis.call('ZREM', KEYS[5], receipt)
+	if payload then
+		redis.call('RPUSH', KEYS[1], payload)
+	end
+end
+local payload = redis.call('RPOP', KEYS[1])
+if not payload then
+	return false
+end
+redis.call('LPUSH', KEYS[3], ARGV[3])
+redis.call('HSET', KEYS[4], ARGV[3], payload)
+redis.call('ZADD', KEYS[5], ARGV[2], ARGV[3])
+return payload
+`)
+
+// ackScript 將憑據從 processing 移除並刪除其內容與租約。KEYS: processing, payloads, leases；ARGV: 憑據
+var ackScript = redis.NewScript(`
+redis.call('LREM', KEYS[1], 1, ARGV[1])
+redis.call('HDEL', KEYS[2], ARGV[1])
+redis.call('ZREM', KEYS[3], ARGV[1])
+return 1
+`)
+
+// releaseScript 將憑據對應的任務從 processing 移回 ready 的最前面，讓它成為下一個被取出的任務。
+// KEYS: processing, payloads, leases, ready；ARGV: 憑據
+var releaseScript = redis.NewScript(`
+local payload = redis.call('HGET', KEYS[2], ARGV[1])
+if not payload then
+	return 0
+end
+redis.call('LREM', KEYS[1], 1, ARGV[1])
+redis.call('HDEL', KEYS[2], ARGV[1])
+redis.call('ZREM', KEYS[3], ARGV[1])
+redis.call('RPUSH', KEYS[4], payload)
+return 1
+`)
+
+// NewRedisQueue 建立一個新的 RedisQueue 並確認可以連線到 Redis
+func NewRedisQueue(cfg config.QueueConfig) (*RedisQueue, error) {
+	client := redis.NewClient(&redis.Options{
+		Addr:     cfg.Redis.Addr,
+		Password: cfg.Redis.Password,
+		DB:       cfg.Redis.DB,
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := client.Ping(ctx).Err(); err != nil {
+		client.Close()
+		return nil, fmt.Errorf("無法連線到 Redis: %w", err)
+	}
+
+	prefix := cfg.Redis.KeyPrefix
+	if prefix == "" {
+		prefix = "report-scheduler:queue"
+	}
+	if !strings.Contains(prefix, "{") {
+		prefix = "{" + prefix + "}"
+	}
+	q := &RedisQueue{
+		client: client,
+		keys: redisQueueKeys{
+			ready:      prefix + ":ready",
+			delayed:    prefix + ":delayed",
+			processing: prefix + ":processing",
+			payloads:   prefix + ":payloads",
+			leases:     prefix + ":leases",
+		},
+		visibilityTimeout: cfg.VisibilityTimeout,
+		pollInterval:      cfg.PollInterval,
+		notify:            make(chan struct{}, 1),
+		done:              make(chan struct{}),
+	}
+	if q.visibilityTimeout <= 0 {
+		q.visibilityTimeout = config.DefaultVisibilityTimeout
+	}
+	if q.pollInterval <= 0 {
+		q.pollInterval = time.Second
+	}
+	return q, nil
+}
+
+// Enqueue 將任務加入佇列
+func (q *RedisQueue) Enqueue(ctx context.Context, task *Task) error {
+	return q.EnqueueAfter(ctx, task, 0)
+}
+
+// EnqueueAfter 將任務加入佇列，並在經過 delay 之後才能被取出
+func (q *RedisQueue) EnqueueAfter(ctx context.Context, task *Task, delay time.Duration) error {
+	if q.isClosed() {
+		return ErrQueueClosed
+	}
+
+	payload, err := json.Marshal(task)
+	if err != nil {
+		return fmt.Errorf("無法序列化任務: %w", err)
+	}
+
+	if delay > 0 {
+		availableAt := time.Now().Add(delay).UnixMilli()
+		return q.client.ZAdd(ctx, q.keys.delayed, redis.Z{Score: float64(availableAt), Member: string(payload)}).Err()
+	}
+	if err := q.client.LPush(ctx, q.keys.ready, string(payload)).Err(); err != nil {
+		return err
+	}
+	select {
+	case q.notify <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// Dequeue 取出一個可執行的任務並取得租約。沒有任務時會阻塞，直到有新任務、佇列關閉或 context 被取消。
+func (q *RedisQueue) Dequeue(ctx context.Context) (*Task, error) {
+	for {
+		if q.isClosed() {
+			return nil, ErrQueueClosed
+		}
+
+		task, err := q.tryDequeue(ctx)
+		if err != nil {
+			if q.isClosed() {
+				return nil, ErrQueueClosed
+			}
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			return nil, err
+		}
+		if task != nil {
+			return task, nil
+		}
+
+		timer := time.NewTimer(q.pollInterval)
+		select {
+		case <-q.notify:
+		case <-timer.C:
+		case <-q.done:
+			timer.Stop()
+			return nil, ErrQueueClosed
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		}
+		timer.Stop()
+	}
+}
+
+// tryDequeue 嘗試取得一個任務的租約，沒有可執行的任務時回傳 nil, nil
+func (q *RedisQueue) tryDequeue(ctx context.Context) (*Task, error) {
+	now := time.Now()
+	receipt := uuid.New().String()
+	payload, err := dequeueScript.Run(ctx, q.client, q.keys.all(), now.UnixMilli(), now.Add(q.visibilityTimeout).UnixMilli(), receipt).Text()
+	if err != nil {
+		if errors.Is(err, redis.Nil) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var task Task
+	if err := json.Unmarshal([]byte(payload), &task); err != nil {
+		// 無法解析的內容不可能被成功處理，直接移除以免一再被取出
+		q.ack(ctx, receipt)
+		return nil, fmt.Errorf("無法解析佇列中的任務: %w", err)
+	}
+	task.Receipt = receipt
+	return &task, nil
+}
+
+// Ack 確認任務已處理完畢並將其移除。
+// 重試時加入佇列的是一筆新的內容，因此不會被這次的 Ack 影響。
+func (q *RedisQueue) Ack(ctx context.Context, task *Task) error {
+	if task.Receipt == "" {
+		return nil
+	}
+	return q.ack(ctx, task.Receipt)
+}
+
+func (q *RedisQueue) ack(ctx context.Context, receipt string) error {
+	return ackScript.Run(ctx, q.client, []string{q.keys.processing, q.keys.payloads, q.keys.leases}, receipt).Err()
+}
+
+// Release 將任務放回 ready，讓它可以立即再次被取出。
+// 憑據已不存在時 (租約已過期並被重新取出) 不會有任何影響。
+func (q *RedisQueue) Release(ctx context.Context, task *Task) error {
+	if task.Receipt == "" {
+		return nil
+	}
+	return releaseScript.Run(ctx, q.client, []string{q.keys.processing, q.keys.payloads, q.keys.leases, q.keys.ready}, task.Receipt).Err()
+}
+
+// Close 關閉佇列與 Redis 連線。尚未完成的任務會保留在 Redis 中，租約過期後會被重新執行。
+func (q *RedisQueue) Close() {
+	q.closeOnce.Do(func() {
+		close(q.done)
+		q.client.Close()
+	})
+}
+
+func (q *RedisQueue) isClosed() bool {
+	select {
+	case <-q.done:
+		return true
+	default:
+		return false
+	}
+}
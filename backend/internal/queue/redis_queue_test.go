@@ -0,0 +1,143 @@
+package queue
+
+import (
+	"context"
+	"report-scheduler/backend/internal/config"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestRedisQueue(t *testing.T, mr *miniredis.Miniredis, visibility time.Duration) *RedisQueue {
+	q, err := NewRedisQueue(config.QueueConfig{
+		VisibilityTimeout: visibility,
+		PollInterval:      10 * time.Millisecond,
+		Redis:             config.RedisConfig{Addr: mr.Addr(), KeyPrefix: "test"},
+	})
+	require.NoError(t, err)
+	return q
+}
+
+func TestRedisQueue(t *testing.T) {
+	t.Run("enqueue, dequeue and ack", func(t *testing.T) {
+		mr := miniredis.RunT(t)
+		q := newTestRedisQueue(t, mr, time.Minute)
+		defer q.Close()
+
+		require.NoError(t, q.Enqueue(context.Background(), &Task{ID: "task-1", ReportIDs: []string{"rep-1"}, RetryDelay: time.Minute}))
+		require.NoError(t, q.Enqueue(context.Background(), &Task{ID: "task-2"}))
+
+		first, err := q.Dequeue(context.Background())
+		require.NoError(t, err)
+		require.Equal(t, "task-1", first.ID, "佇列應該是先進先出")
+		require.Equal(t, []string{"rep-1"}, first.ReportIDs)
+		require.Equal(t, time.Minute, first.RetryDelay)
+
+		processing, err := mr.List("{test}:processing")
+		require.NoError(t, err)
+		require.Len(t, processing, 1)
+		leases, err := mr.ZMembers("{test}:leases")
+		require.NoError(t, err)
+		require.Equal(t, []string{first.Receipt}, leases)
+
+		require.NoError(t, q.Ack(context.Background(), first))
+		require.False(t, mr.Exists("{test}:processing"))
+		require.False(t, mr.Exists("{test}:leases"))
+
+		second, err := q.Dequeue(context.Background())
+		require.NoError(t, err)
+		require.Equal(t, "task-2", second.ID)
+	})
+
+	t.Run("abandoned task is requeued after its lease expires", func(t *testing.T) {
+		mr := miniredis.RunT(t)
+		crashed := newTestRedisQueue(t, mr, 30*time.Millisecond)
+		require.NoError(t, crashed.Enqueue(context.Background(), &Task{ID: "task-3"}))
+		abandoned, err := crashed.Dequeue(context.Background())
+		require.NoError(t, err)
+		crashed.Close() // 模擬 Worker 所在的程序在處理中途結束
+
+		// 另一個伺服器稍後仍能取得這個任務
+		q := newTestRedisQueue(t, mr, time.Minute)
+		defer q.Close()
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		recovered, err := q.Dequeue(ctx)
+		require.NoError(t, err)
+		require.Equal(t, "task-3", recovered.ID)
+
+		// 過期的憑據不會刪除已被重新取出的任務
+		require.NoError(t, q.Ack(context.Background(), abandoned))
+		processing, err := mr.List("{test}:processing")
+		require.NoError(t, err)
+		require.Len(t, processing, 1)
+	})
+
+	t.Run("released task is dequeued next", func(t *testing.T) {
+		mr := miniredis.RunT(t)
+		q := newTestRedisQueue(t, mr, time.Hour)
+		defer q.Close()
+
+		require.NoError(t, q.Enqueue(context.Background(), &Task{ID: "task-6"}))
+		require.NoError(t, q.Enqueue(context.Background(), &Task{ID: "task-7"}))
+		aborted, err := q.Dequeue(context.Background())
+		require.NoError(t, err)
+		require.NoError(t, q.Release(context.Background(), aborted))
+		require.False(t, mr.Exists("{test}:processing"))
+		require.False(t, mr.Exists("{test}:leases"))
+
+		task, err := q.Dequeue(context.Background())
+		require.NoError(t, err)
+		require.Equal(t, "task-6", task.ID)
+	})
+
+	t.Run("delayed retry survives ack of the failed attempt", func(t *testing.T) {
+		mr := miniredis.RunT(t)
+		q := newTestRedisQueue(t, mr, time.Minute)
+		defer q.Close()
+
+		require.NoError(t, q.Enqueue(context.Background(), &Task{ID: "task-4", MaxRetries: 1}))
+		attempt, err := q.Dequeue(context.Background())
+		require.NoError(t, err)
+
+		startTime := time.Now()
+		retry := *attempt
+		retry.Attempt++
+		require.NoError(t, q.EnqueueAfter(context.Background(), &retry, 50*time.Millisecond))
+		require.NoError(t, q.Ack(context.Background(), attempt))
+
+		taskOut, err := q.Dequeue(context.Background())
+		require.NoError(t, err)
+		require.Equal(t, "task-4", taskOut.ID)
+		require.Equal(t, 1, taskOut.Attempt)
+		require.GreaterOrEqual(t, time.Since(startTime), 45*time.Millisecond)
+	})
+
+	t.Run("close unblocks dequeue", func(t *testing.T) {
+		mr := miniredis.RunT(t)
+		q := newTestRedisQueue(t, mr, time.Minute)
+
+		go func() {
+			time.Sleep(20 * time.Millisecond)
+			q.Close()
+		}()
+		_, err := q.Dequeue(context.Background())
+		require.Equal(t, ErrQueueClosed, err)
+		require.Equal(t, ErrQueueClosed, q.Enqueue(context.Background(), &Task{ID: "task-5"}))
+	})
+
+	t.Run("factory selects redis and reports connection errors", func(t *testing.T) {
+		mr := miniredis.RunT(t)
+		q, err := NewQueue(config.Config{Queue: config.QueueConfig{Type: "redis", Redis: config.RedisConfig{Addr: mr.Addr()}}})
+		require.NoError(t, err)
+		require.IsType(t, &RedisQueue{}, q)
+		q.Close()
+
+		addr := mr.Addr()
+		mr.Close()
+		_, err = NewQueue(config.Config{Queue: config.QueueConfig{Type: "redis", Redis: config.RedisConfig{Addr: addr}}})
+		require.Error(t, err)
+	})
+}
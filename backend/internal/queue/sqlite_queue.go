@@ -0,0 +1,256 @@
+package queue
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"report-scheduler/backend/internal/config"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	_ "github.com/mattn/go-sqlite3" // Import the sqlite3 driver
+)
+
+// SqliteQueue 是儲存在 SQLite 資料庫中的持久化佇列。
+//
+// 任務被 Dequeue 時不會被刪除，而是取得一段時間的租約 (visibility timeout)；
+// Worker 處理完畢後呼叫 Ack 才會真正刪除。租約過期仍未被 Ack 的任務會再次被取出，
+// 因此程式中斷時未完成的任務會在租約到期後重新執行。同一個資料庫可能由多個程序共用，
+// 每個租約都會記錄取得它的程序 (instanceID)；啟動時只會立即釋放自己上次留下的租約，
+// 其他程序仍持有的租約則等到到期。
+type SqliteQueue struct {
+	db                *sql.DB
+	instanceID        string
+	visibilityTimeout time.Duration
+	pollInterval      time.Duration
+
+	notify    chan struct{} // 有新任務時喚醒等待中的 Dequeue
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// NewSqliteQueue 建立一個新的 SqliteQueue
+func NewSqliteQueue(dbPath string, cfg config.QueueConfig) (*SqliteQueue, error) {
+	db, err := sql.Open("sqlite3", dbPath)
+	if err != nil {
+		return nil, err
+	}
+	// 佇列的操作都很短暫，使用單一連線可以避免同一個程序內的寫入互相鎖定
+	db.SetMaxOpenConns(1)
+	if _, err := db.Exec("PRAGMA busy_timeout = 5000"); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	q := &SqliteQueue{
+		db:                db,
+		instanceID:        cfg.InstanceID,
+		visibilityTimeout: cfg.VisibilityTimeout,
+		pollInterval:      cfg.PollInterval,
+		notify:            make(chan struct{}, 1),
+		done:              make(chan struct{}),
+	}
+	if q.visibilityTimeout <= 0 {
+		q.visibilityTimeout = config.DefaultVisibilityTimeout
+	}
+	if q.pollInterval <= 0 {
+		q.pollInterval = time.Second
+	}
+	if q.instanceID == "" {
+		if q.instanceID, err = os.Hostname(); err != nil {
+			db.Close()
+			return nil, fmt.Errorf("無法取得主機名稱作為佇列的 instance_id: %w", err)
+		}
+	}
+
+	if err := q.initSchema(); err != nil {
+		db.Close()
+		return nil, err
+	}
+	if err := q.releaseOwnLeases(); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return q, nil
+}
+
+// initSchema 建立佇列資料表 (如果不存在)
+func (q *SqliteQueue) initSchema() error {
+	schema := `
+	CREATE TABLE IF NOT EXISTS queue_tasks (
+		id TEXT PRIMARY KEY,
+		payload TEXT NOT NULL,
+		available_at INTEGER NOT NULL,
+		receipt TEXT,
+		leased_until INTEGER,
+		owner TEXT,
+		created_at INTEGER NOT NULL
+	);
+	CREATE INDEX IF NOT EXISTS idx_queue_tasks_available_at ON queue_tasks(available_at);
+	`
+	if _, err := q.db.Exec(schema); err != nil {
+		return err
+	}
+
+	// 舊版的資料表沒有 owner 欄位
+	var hasOwner int
+	if err := q.db.QueryRow(`SELECT COUNT(*) FROM pragma_table_info('queue_tasks') WHERE name = 'owner'`).Scan(&hasOwner); err != nil {
+		return err
+	}
+	if hasOwner == 0 {
+		if _, err := q.db.Exec(`ALTER TABLE queue_tasks ADD COLUMN owner TEXT`); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// releaseOwnLeases 釋放這個 instanceID 仍持有的租約。
+// 程序剛啟動時還沒有取出任何任務，這些租約只可能是上次中斷時留下的，對應的任務已經不會完成，
+// 因此可以立即重新執行，不必等到租約到期。
+func (q *SqliteQueue) releaseOwnLeases() error {
+	res, err := q.db.Exec(`UPDATE queue_tasks SET receipt = NULL, leased_until = NULL, owner = NULL
+		WHERE owner = ? AND receipt IS NOT NULL`, q.instanceID)
+	if err != nil {
+		return fmt.Errorf("無法釋放上次中斷的任務租約: %w", err)
+	}
+	if n, _ := res.RowsAffected(); n > 0 {
+		log.Printf("已釋放 %d 個上次中斷時未完成的佇列任務 (instance_id: %s)", n, q.instanceID)
+	}
+	return nil
+}
+
+// Enqueue 將任務加入佇列
+func (q *SqliteQueue) Enqueue(ctx context.Context, task *Task) error {
+	return q.EnqueueAfter(ctx, task, 0)
+}
+
+// EnqueueAfter 將任務加入佇列，並在經過 delay 之後才能被取出。
+// 如果相同 ID 的任務已存在 (例如重試)，會以新的內容取代並釋放其租約。
+func (q *SqliteQueue) EnqueueAfter(ctx context.Context, task *Task, delay time.Duration) error {
+	if q.isClosed() {
+		return ErrQueueClosed
+	}
+
+	payload, err := json.Marshal(task)
+	if err != nil {
+		return fmt.Errorf("無法序列化任務: %w", err)
+	}
+
+	now := time.Now()
+	query := `INSERT INTO queue_tasks (id, payload, available_at, receipt, leased_until, created_at)
+			  VALUES (?, ?, ?, NULL, NULL, ?)
+			  ON CONFLICT(id) DO UPDATE SET payload = excluded.payload, available_at = excluded.available_at, receipt = NULL, leased_until = NULL, owner = NULL`
+	if _, err := q.db.ExecContext(ctx, query, task.ID, string(payload), now.Add(delay).UnixMilli(), now.UnixMilli()); err != nil {
+		return err
+	}
+
+	if delay <= 0 {
+		select {
+		case q.notify <- struct{}{}:
+		default:
+		}
+	}
+	return nil
+}
+
+// Dequeue 取出一個可執行的任務並取得租約。沒有任務時會阻塞，直到有新任務、佇列關閉或 context 被取消。
+func (q *SqliteQueue) Dequeue(ctx context.Context) (*Task, error) {
+	for {
+		if q.isClosed() {
+			return nil, ErrQueueClosed
+		}
+
+		task, err := q.tryDequeue(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if task != nil {
+			return task, nil
+		}
+
+		timer := time.NewTimer(q.pollInterval)
+		select {
+		case <-q.notify:
+		case <-timer.C:
+		case <-q.done:
+			timer.Stop()
+			return nil, ErrQueueClosed
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		}
+		timer.Stop()
+	}
+}
+
+// tryDequeue 嘗試取得一個任務的租約，沒有可執行的任務時回傳 nil, nil
+func (q *SqliteQueue) tryDequeue(ctx context.Context) (*Task, error) {
+	now := time.Now().UnixMilli()
+	row := q.db.QueryRowContext(ctx, `SELECT id, payload FROM queue_tasks
+		WHERE available_at <= ? AND (receipt IS NULL OR leased_until <= ?)
+		ORDER BY available_at, created_at LIMIT 1`, now, now)
+
+	var id, payload string
+	if err := row.Scan(&id, &payload); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	// 以條件式更新取得租約，避免多個程序同時取得同一個任務
+	receipt := uuid.New().String()
+	res, err := q.db.ExecContext(ctx, `UPDATE queue_tasks SET receipt = ?, leased_until = ?, owner = ?
+		WHERE id = ? AND (receipt IS NULL OR leased_until <= ?)`,
+		receipt, time.Now().Add(q.visibilityTimeout).UnixMilli(), q.instanceID, id, now)
+	if err != nil {
+		return nil, err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		// 已被其他 Worker 取走，交給下一輪重試
+		return nil, nil
+	}
+
+	var task Task
+	if err := json.Unmarshal([]byte(payload), &task); err != nil {
+		return nil, fmt.Errorf("無法解析任務 %s: %w", id, err)
+	}
+	task.Receipt = receipt
+	return &task, nil
+}
+
+// Ack 確認任務已處理完畢並將其刪除。
+// 如果任務在處理期間已經被重新加入佇列 (例如排定重試)，其憑據會不同，因此不會被刪除。
+func (q *SqliteQueue) Ack(ctx context.Context, task *Task) error {
+	_, err := q.db.ExecContext(ctx, `DELETE FROM queue_tasks WHERE id = ? AND receipt = ?`, task.ID, task.Receipt)
+	return err
+}
+
+// Release 釋放任務的租約，讓它可以立即再次被取出。憑據不符時 (租約已過期並被重新取出) 不會有任何影響。
+func (q *SqliteQueue) Release(ctx context.Context, task *Task) error {
+	_, err := q.db.ExecContext(ctx, `UPDATE queue_tasks SET receipt = NULL, leased_until = NULL, owner = NULL
+		WHERE id = ? AND receipt = ?`, task.ID, task.Receipt)
+	return err
+}
+
+// Close 關閉佇列與資料庫連線。尚未完成的任務會保留在資料庫中，下次啟動時繼續執行。
+func (q *SqliteQueue) Close() {
+	q.closeOnce.Do(func() {
+		close(q.done)
+		q.db.Close()
+	})
+}
+
+func (q *SqliteQueue) isClosed() bool {
+	select {
+	case <-q.done:
+		return true
+	default:
+		return false
+	}
+}
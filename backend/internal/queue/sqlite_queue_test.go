@@ -0,0 +1,233 @@
+package queue
+
+import (
+	"context"
+	"path/filepath"
+	"report-scheduler/backend/internal/config"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func newTestSqliteQueue(t *testing.T, dbPath string, visibility time.Duration) *SqliteQueue {
+	return newTestSqliteQueueAs(t, dbPath, visibility, "instance-a")
+}
+
+func newTestSqliteQueueAs(t *testing.T, dbPath string, visibility time.Duration, instanceID string) *SqliteQueue {
+	q, err := NewSqliteQueue(dbPath, config.QueueConfig{
+		VisibilityTimeout: visibility,
+		PollInterval:      10 * time.Millisecond,
+		InstanceID:        instanceID,
+	})
+	require.NoError(t, err)
+	return q
+}
+
+func TestSqliteQueue(t *testing.T) {
+	t.Run("enqueue, dequeue and ack", func(t *testing.T) {
+		q := newTestSqliteQueue(t, filepath.Join(t.TempDir(), "queue.db"), time.Minute)
+		defer q.Close()
+
+		taskIn := &Task{ID: "task-1", ScheduleID: "sch-1", ReportIDs: []string{"rep-1"}, MaxRetries: 3, RetryDelay: time.Minute}
+		require.NoError(t, q.Enqueue(context.Background(), taskIn))
+
+		taskOut, err := q.Dequeue(context.Background())
+		require.NoError(t, err)
+		require.Equal(t, "task-1", taskOut.ID)
+		require.Equal(t, []string{"rep-1"}, taskOut.ReportIDs)
+		require.Equal(t, time.Minute, taskOut.RetryDelay)
+		require.NotEmpty(t, taskOut.Receipt)
+
+		// 租約期間不會被再次取出
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+		_, err = q.Dequeue(ctx)
+		require.Equal(t, context.DeadlineExceeded, err)
+
+		require.NoError(t, q.Ack(context.Background(), taskOut))
+		var count int
+		require.NoError(t, q.db.QueryRow("SELECT COUNT(*) FROM queue_tasks").Scan(&count))
+		require.Equal(t, 0, count)
+	})
+
+	t.Run("dequeue wakes up on enqueue", func(t *testing.T) {
+		q := newTestSqliteQueue(t, filepath.Join(t.TempDir(), "queue.db"), time.Minute)
+		defer q.Close()
+
+		go func() {
+			time.Sleep(20 * time.Millisecond)
+			q.Enqueue(context.Background(), &Task{ID: "task-2"})
+		}()
+
+		taskOut, err := q.Dequeue(context.Background())
+		require.NoError(t, err)
+		require.Equal(t, "task-2", taskOut.ID)
+	})
+
+	t.Run("expired lease is redelivered", func(t *testing.T) {
+		q := newTestSqliteQueue(t, filepath.Join(t.TempDir(), "queue.db"), 30*time.Millisecond)
+		defer q.Close()
+
+		require.NoError(t, q.Enqueue(context.Background(), &Task{ID: "task-3"}))
+		first, err := q.Dequeue(context.Background())
+		require.NoError(t, err)
+
+		second, err := q.Dequeue(context.Background())
+		require.NoError(t, err)
+		require.Equal(t, "task-3", second.ID)
+		require.NotEqual(t, first.Receipt, second.Receipt)
+
+		// 過期的憑據無法刪除已被重新取出的任務
+		require.NoError(t, q.Ack(context.Background(), first))
+		var count int
+		require.NoError(t, q.db.QueryRow("SELECT COUNT(*) FROM queue_tasks").Scan(&count))
+		require.Equal(t, 1, count)
+	})
+
+	t.Run("delayed task is not available early", func(t *testing.T) {
+		q := newTestSqliteQueue(t, filepath.Join(t.TempDir(), "queue.db"), time.Minute)
+		defer q.Close()
+
+		startTime := time.Now()
+		require.NoError(t, q.EnqueueAfter(context.Background(), &Task{ID: "task-4"}, 50*time.Millisecond))
+		taskOut, err := q.Dequeue(context.Background())
+		require.NoError(t, err)
+		require.Equal(t, "task-4", taskOut.ID)
+		require.GreaterOrEqual(t, time.Since(startTime), 45*time.Millisecond)
+	})
+
+	t.Run("retry re-enqueue survives ack of the failed attempt", func(t *testing.T) {
+		q := newTestSqliteQueue(t, filepath.Join(t.TempDir(), "queue.db"), time.Minute)
+		defer q.Close()
+
+		require.NoError(t, q.Enqueue(context.Background(), &Task{ID: "task-5", MaxRetries: 1}))
+		attempt, err := q.Dequeue(context.Background())
+		require.NoError(t, err)
+
+		retry := *attempt
+		retry.Attempt++
+		require.NoError(t, q.EnqueueAfter(context.Background(), &retry, 0))
+		require.NoError(t, q.Ack(context.Background(), attempt))
+
+		taskOut, err := q.Dequeue(context.Background())
+		require.NoError(t, err)
+		require.Equal(t, 1, taskOut.Attempt)
+	})
+
+	t.Run("released task is available again right away", func(t *testing.T) {
+		q := newTestSqliteQueue(t, filepath.Join(t.TempDir(), "queue.db"), time.Hour)
+		defer q.Close()
+
+		require.NoError(t, q.Enqueue(context.Background(), &Task{ID: "task-10"}))
+		aborted, err := q.Dequeue(context.Background())
+		require.NoError(t, err)
+		require.NoError(t, q.Release(context.Background(), aborted))
+
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+		task, err := q.Dequeue(ctx)
+		require.NoError(t, err)
+		require.Equal(t, "task-10", task.ID)
+
+		// 過期的憑據無法釋放已被重新取出的任務
+		require.NoError(t, q.Release(context.Background(), aborted))
+		ctx, cancel = context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+		_, err = q.Dequeue(ctx)
+		require.ErrorIs(t, err, context.DeadlineExceeded)
+	})
+
+	t.Run("own leases are released when the instance restarts", func(t *testing.T) {
+		dbPath := filepath.Join(t.TempDir(), "queue.db")
+		q := newTestSqliteQueue(t, dbPath, time.Hour)
+		require.NoError(t, q.Enqueue(context.Background(), &Task{ID: "task-6"}))
+		inFlight, err := q.Dequeue(context.Background())
+		require.NoError(t, err)
+		require.Equal(t, "task-6", inFlight.ID)
+		q.Close() // 模擬程式在處理中途結束
+
+		// 同一個 instance 重新啟動後，不必等到租約到期就能再次取出
+		restarted := newTestSqliteQueue(t, dbPath, time.Hour)
+		defer restarted.Close()
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+		task, err := restarted.Dequeue(ctx)
+		require.NoError(t, err)
+		require.Equal(t, "task-6", task.ID)
+		require.NotEqual(t, inFlight.Receipt, task.Receipt)
+	})
+
+	t.Run("in-flight tasks of another instance are recovered after their lease expires", func(t *testing.T) {
+		dbPath := filepath.Join(t.TempDir(), "queue.db")
+		q := newTestSqliteQueueAs(t, dbPath, 100*time.Millisecond, "instance-b")
+		require.NoError(t, q.Enqueue(context.Background(), &Task{ID: "task-6"}))
+		require.NoError(t, q.Enqueue(context.Background(), &Task{ID: "task-7"}))
+		inFlight, err := q.Dequeue(context.Background())
+		require.NoError(t, err)
+		require.Equal(t, "task-6", inFlight.ID)
+		q.Close() // 模擬另一個程序在處理中途結束且沒有再啟動
+
+		other := newTestSqliteQueue(t, dbPath, time.Hour)
+		defer other.Close()
+
+		// 租約尚未到期，只能先取得另一個任務
+		task, err := other.Dequeue(context.Background())
+		require.NoError(t, err)
+		require.Equal(t, "task-7", task.ID)
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		task, err = other.Dequeue(ctx)
+		require.NoError(t, err)
+		require.Equal(t, "task-6", task.ID)
+	})
+
+	t.Run("leases held by another instance are kept", func(t *testing.T) {
+		dbPath := filepath.Join(t.TempDir(), "queue.db")
+		running := newTestSqliteQueueAs(t, dbPath, time.Hour, "instance-b")
+		defer running.Close()
+		require.NoError(t, running.Enqueue(context.Background(), &Task{ID: "task-9"}))
+		_, err := running.Dequeue(context.Background())
+		require.NoError(t, err)
+
+		// 共用同一個資料庫的另一個程序啟動時，不應取走仍在執行中的任務
+		other := newTestSqliteQueue(t, dbPath, time.Hour)
+		defer other.Close()
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+		_, err = other.Dequeue(ctx)
+		require.ErrorIs(t, err, context.DeadlineExceeded)
+	})
+
+	t.Run("close unblocks dequeue and rejects enqueue", func(t *testing.T) {
+		q := newTestSqliteQueue(t, filepath.Join(t.TempDir(), "queue.db"), time.Minute)
+
+		go func() {
+			time.Sleep(20 * time.Millisecond)
+			q.Close()
+		}()
+		_, err := q.Dequeue(context.Background())
+		require.Equal(t, ErrQueueClosed, err)
+		require.Equal(t, ErrQueueClosed, q.Enqueue(context.Background(), &Task{ID: "task-8"}))
+		require.NotPanics(t, q.Close)
+	})
+}
+
+func TestNewQueue(t *testing.T) {
+	q, err := NewQueue(config.Config{})
+	require.NoError(t, err)
+	require.IsType(t, &InMemoryQueue{}, q)
+	q.Close()
+
+	q, err = NewQueue(config.Config{
+		Database: config.DBConfig{Path: filepath.Join(t.TempDir(), "queue.db")},
+		Queue:    config.QueueConfig{Type: "sqlite"},
+	})
+	require.NoError(t, err)
+	require.IsType(t, &SqliteQueue{}, q)
+	q.Close()
+
+	_, err = NewQueue(config.Config{Queue: config.QueueConfig{Type: "kafka"}})
+	require.Error(t, err)
+}
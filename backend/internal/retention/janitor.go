@@ -0,0 +1,217 @@
+// Package retention 依保留策略定期清除過期的執行紀錄、對應的報表檔案與已結束的任務紀錄 (規格 8.2)。
+package retention
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"report-scheduler/backend/internal/artifacts"
+	"report-scheduler/backend/internal/config"
+	"report-scheduler/backend/internal/models"
+	"report-scheduler/backend/internal/store"
+	"sync"
+	"time"
+)
+
+// 預設的保留策略
+const (
+	DefaultDays     = 90
+	DefaultInterval = time.Hour
+)
+
+// RemovedLog 描述一筆被清除 (或在 dry-run 時將被清除) 的執行紀錄
+type RemovedLog struct {
+	ID           string    `json:"id"`
+	ScheduleID   string    `json:"schedule_id"`
+	ScheduleName string    `json:"schedule_name"`
+	TriggerTime  time.Time `json:"trigger_time"`
+	Artifacts    []string  `json:"artifacts,omitempty"`
+}
+
+// Report 是一次清理的結果
+type Report struct {
+	DryRun           bool         `json:"dry_run"`
+	RunAt            time.Time    `json:"run_at"`
+	HistoryLogs      []RemovedLog `json:"history_logs"`
+	DeletedLogs      int          `json:"deleted_logs"`
+	DeletedArtifacts int          `json:"deleted_artifacts"`
+	DeletedTasks     int          `json:"deleted_tasks"`
+	Errors           []string     `json:"errors,omitempty"`
+}
+
+// Janitor 在背景定期清除超過保留天數的執行紀錄，並刪除紀錄對應的報表檔案
+type Janitor struct {
+	Store     store.Store
+	Artifacts artifacts.Store
+	// Days 是系統預設的保留天數，排程的 RetentionDays 會覆寫此值
+	Days     int
+	Interval time.Duration
+
+	// now 可以在測試中替換
+	now  func() time.Time
+	mu   sync.Mutex // 避免背景清理與 API 觸發的清理同時執行
+	stop chan struct{}
+	done chan struct{}
+}
+
+// NewJanitor 根據設定建立 Janitor，未設定的部分使用預設值
+func NewJanitor(s store.Store, as artifacts.Store, cfg config.RetentionConfig) *Janitor {
+	j := &Janitor{
+		Store:     s,
+		Artifacts: as,
+		Days:      DefaultDays,
+		Interval:  DefaultInterval,
+		now:       time.Now,
+	}
+	if cfg.Days > 0 {
+		j.Days = cfg.Days
+	}
+	if cfg.Interval > 0 {
+		j.Interval = cfg.Interval
+	}
+	return j
+}
+
+// Start 在背景啟動清理，啟動時會先執行一次，之後每隔 Interval 執行
+func (j *Janitor) Start() {
+	log.Printf("啟動資料保留清理服務 (保留 %d 天，每 %s 執行一次)...", j.Days, j.Interval)
+	j.stop = make(chan struct{})
+	j.done = make(chan struct{})
+	go func() {
+		defer close(j.done)
+		ticker := time.NewTicker(j.Interval)
+		defer ticker.Stop()
+		for {
+			j.runAndLog()
+			select {
+			case <-ticker.C:
+			case <-j.stop:
+				return
+			}
+		}
+	}()
+}
+
+// Stop 停止背景清理，並等待進行中的清理完成
+func (j *Janitor) Stop() {
+	if j.stop == nil {
+		return
+	}
+	close(j.stop)
+	<-j.done
+	log.Println("資料保留清理服務已停止")
+}
+
+func (j *Janitor) runAndLog() {
+	report, err := j.Run(context.Background(), false)
+	if err != nil {
+		log.Printf("錯誤：清理過期的執行紀錄失敗: %v", err)
+		return
+	}
+	if report.DeletedLogs > 0 || report.DeletedTasks > 0 || len(report.Errors) > 0 {
+		log.Printf("已清除 %d 筆過期的執行紀錄、%d 個報表檔案與 %d 筆任務紀錄", report.DeletedLogs, report.DeletedArtifacts, report.DeletedTasks)
+	}
+	for _, message := range report.Errors {
+		log.Printf("警告：%s", message)
+	}
+}
+
+// Run 執行一次清理。dryRun 為 true 時只回報會被清除的執行紀錄，不會實際刪除任何資料。
+// 報表檔案刪除失敗的紀錄會保留下來，待下次清理時重試，避免留下沒有紀錄可追蹤的檔案。
+func (j *Janitor) Run(ctx context.Context, dryRun bool) (*Report, error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	now := j.now()
+	report := &Report{DryRun: dryRun, RunAt: now, HistoryLogs: []RemovedLog{}}
+
+	schedules, err := j.Store.GetSchedules(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("無法獲取排程: %w", err)
+	}
+	// 排程已被刪除的紀錄使用系統預設的保留天數
+	days := make(map[string]int, len(schedules))
+	minDays := j.Days
+	for _, schedule := range schedules {
+		days[schedule.ID] = retentionDays(&schedule, j.Days)
+		if days[schedule.ID] < minDays {
+			minDays = days[schedule.ID]
+		}
+	}
+
+	logs, err := j.Store.GetHistoryLogsBefore(ctx, now.AddDate(0, 0, -minDays))
+	if err != nil {
+		return nil, fmt.Errorf("無法獲取過期的執行紀錄: %w", err)
+	}
+
+	var expiredIDs []string
+	for _, entry := range logs {
+		keep, ok := days[entry.ScheduleID]
+		if !ok {
+			keep = j.Days
+		}
+		if !entry.TriggerTime.Before(now.AddDate(0, 0, -keep)) {
+			continue
+		}
+
+		removed := RemovedLog{
+			ID:           entry.ID,
+			ScheduleID:   entry.ScheduleID,
+			ScheduleName: entry.ScheduleName,
+			TriggerTime:  entry.TriggerTime,
+			Artifacts:    artifacts.SplitKeys(entry.ReportURL),
+		}
+		if dryRun {
+			report.HistoryLogs = append(report.HistoryLogs, removed)
+			continue
+		}
+
+		deleted, err := j.deleteArtifacts(ctx, removed.Artifacts)
+		report.DeletedArtifacts += deleted
+		if err != nil {
+			report.Errors = append(report.Errors, fmt.Sprintf("執行紀錄 %s 的報表檔案刪除失敗，下次清理時重試: %v", entry.ID, err))
+			continue
+		}
+		report.HistoryLogs = append(report.HistoryLogs, removed)
+		expiredIDs = append(expiredIDs, entry.ID)
+	}
+
+	if err := j.Store.DeleteHistoryLogs(ctx, expiredIDs); err != nil {
+		return nil, fmt.Errorf("無法刪除過期的執行紀錄: %w", err)
+	}
+	report.DeletedLogs = len(expiredIDs)
+
+	if !dryRun {
+		// 任務紀錄只用來追蹤執行狀態，沒有對應的檔案，以系統預設的保留天數清除
+		deleted, err := j.Store.DeleteFinishedTasksBefore(ctx, now.AddDate(0, 0, -j.Days))
+		if err != nil {
+			return nil, fmt.Errorf("無法刪除過期的任務紀錄: %w", err)
+		}
+		report.DeletedTasks = deleted
+	}
+	return report, nil
+}
+
+// deleteArtifacts 刪除 keys 對應的報表檔案，回傳成功刪除的數量
+func (j *Janitor) deleteArtifacts(ctx context.Context, keys []string) (int, error) {
+	deleted := 0
+	for _, key := range keys {
+		// 舊版本留下的暫存檔路徑不是有效的 key，沒有檔案需要刪除
+		if artifacts.ValidateKey(key) != nil {
+			continue
+		}
+		if err := j.Artifacts.Delete(ctx, key); err != nil {
+			return deleted, err
+		}
+		deleted++
+	}
+	return deleted, nil
+}
+
+// retentionDays 回傳排程的保留天數，未設定時使用 defaultDays
+func retentionDays(s *models.Schedule, defaultDays int) int {
+	if s.RetentionDays != nil && *s.RetentionDays > 0 {
+		return *s.RetentionDays
+	}
+	return defaultDays
+}
@@ -0,0 +1,170 @@
+package retention
+
+import (
+	"context"
+	"errors"
+	"io"
+	"report-scheduler/backend/internal/artifacts"
+	"report-scheduler/backend/internal/config"
+	"report-scheduler/backend/internal/models"
+	"report-scheduler/backend/internal/store"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// failingStore 模擬無法刪除特定 key 的檔案儲存
+type failingStore struct {
+	artifacts.Store
+	failKey string
+}
+
+func (s *failingStore) Delete(ctx context.Context, key string) error {
+	if key == s.failKey {
+		return errors.New("storage unavailable")
+	}
+	return s.Store.Delete(ctx, key)
+}
+
+func TestJanitor_Run(t *testing.T) {
+	ctx := context.Background()
+	dbStore, err := store.NewStore(config.Config{Database: config.DBConfig{Type: "sqlite", Path: t.TempDir() + "/test.db"}})
+	require.NoError(t, err)
+	defer dbStore.Close()
+	localStore, err := artifacts.NewLocalStore(t.TempDir())
+	require.NoError(t, err)
+	artifactStore := &failingStore{Store: localStore, failKey: "reports/broken.pdf"}
+
+	now := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	shortRetention := 7
+	defaultSchedule := &models.Schedule{Name: "Default", CronSpec: "0 0 9 * * *", Timezone: "UTC"}
+	shortSchedule := &models.Schedule{Name: "Short", CronSpec: "0 0 9 * * *", Timezone: "UTC", RetentionDays: &shortRetention}
+	require.NoError(t, dbStore.CreateSchedule(ctx, defaultSchedule))
+	require.NoError(t, dbStore.CreateSchedule(ctx, shortSchedule))
+
+	addLog := func(scheduleID string, age time.Duration, keys ...string) *models.HistoryLog {
+		for _, key := range keys {
+			require.NoError(t, localStore.Put(ctx, key, strings.NewReader("data"), 4, ""))
+		}
+		entry := &models.HistoryLog{
+			ScheduleID:   scheduleID,
+			ScheduleName: "log",
+			TriggerTime:  now.Add(-age),
+			Status:       models.LogStatusSuccess,
+			ReportURL:    strings.Join(keys, ", "),
+		}
+		require.NoError(t, dbStore.CreateHistoryLog(ctx, entry))
+		return entry
+	}
+	day := 24 * time.Hour
+	oldDefault := addLog(defaultSchedule.ID, 91*day, "reports/a/1.pdf", "reports/a/1.csv")
+	recentDefault := addLog(defaultSchedule.ID, 30*day, "reports/b/1.pdf")
+	oldShort := addLog(shortSchedule.ID, 8*day, "reports/c/1.pdf")
+	recentShort := addLog(shortSchedule.ID, 6*day)
+	orphan := addLog("deleted-schedule", 100*day)
+	broken := addLog(defaultSchedule.ID, 120*day, "reports/broken.pdf")
+	// 以不同時區儲存的時間也要正確比較
+	localOld := addLog(defaultSchedule.ID, 0)
+	localOld.TriggerTime = now.Add(-95 * day).In(time.FixedZone("UTC+8", 8*3600))
+	require.NoError(t, dbStore.DeleteHistoryLogs(ctx, []string{localOld.ID}))
+	require.NoError(t, dbStore.CreateHistoryLog(ctx, localOld))
+
+	addTask := func(status models.TaskStatus, finishedAge time.Duration) *models.Task {
+		task := &models.Task{ScheduleID: defaultSchedule.ID, Status: status, QueuedAt: now.Add(-finishedAge - time.Hour)}
+		if status.IsFinal() {
+			finishedAt := now.Add(-finishedAge)
+			task.FinishedAt = &finishedAt
+		}
+		require.NoError(t, dbStore.CreateTask(ctx, task))
+		return task
+	}
+	oldTask := addTask(models.TaskStatusSucceeded, 91*day)
+	oldCancelledTask := addTask(models.TaskStatusCancelled, 100*day)
+	recentTask := addTask(models.TaskStatusFailed, 30*day)
+	// 仍在佇列中的任務即使推入很久也不會被清除
+	pendingTask := addTask(models.TaskStatusQueued, 120*day)
+
+	j := NewJanitor(dbStore, artifactStore, config.RetentionConfig{})
+	require.Equal(t, DefaultDays, j.Days)
+	j.now = func() time.Time { return now }
+
+	removedIDs := func(report *Report) []string {
+		var ids []string
+		for _, entry := range report.HistoryLogs {
+			ids = append(ids, entry.ID)
+		}
+		return ids
+	}
+
+	t.Run("dry run reports without deleting", func(t *testing.T) {
+		report, err := j.Run(ctx, true)
+		require.NoError(t, err)
+		require.True(t, report.DryRun)
+		require.ElementsMatch(t, []string{oldDefault.ID, oldShort.ID, orphan.ID, broken.ID, localOld.ID}, removedIDs(report))
+		require.Zero(t, report.DeletedLogs)
+		require.Zero(t, report.DeletedArtifacts)
+		require.Zero(t, report.DeletedTasks)
+		task, err := dbStore.GetTaskByID(ctx, oldTask.ID)
+		require.NoError(t, err)
+		require.NotNil(t, task)
+
+		_, _, err = localStore.Open(ctx, "reports/a/1.pdf")
+		require.NoError(t, err)
+		logs, err := dbStore.GetHistoryLogs(ctx, defaultSchedule.ID)
+		require.NoError(t, err)
+		require.Len(t, logs, 4)
+	})
+
+	t.Run("deletes expired logs and artifacts", func(t *testing.T) {
+		report, err := j.Run(ctx, false)
+		require.NoError(t, err)
+		require.ElementsMatch(t, []string{oldDefault.ID, oldShort.ID, orphan.ID, localOld.ID}, removedIDs(report))
+		require.Equal(t, 4, report.DeletedLogs)
+		require.Equal(t, 3, report.DeletedArtifacts)
+		require.Len(t, report.Errors, 1)
+		require.Contains(t, report.Errors[0], broken.ID)
+
+		for _, key := range []string{"reports/a/1.pdf", "reports/a/1.csv", "reports/c/1.pdf"} {
+			_, _, err := localStore.Open(ctx, key)
+			require.ErrorIs(t, err, artifacts.ErrNotFound, key)
+		}
+		r, _, err := localStore.Open(ctx, "reports/b/1.pdf")
+		require.NoError(t, err)
+		io.Copy(io.Discard, r)
+		r.Close()
+
+		// 檔案刪除失敗的紀錄會保留下來等待重試
+		remaining, err := dbStore.GetHistoryLogsBefore(ctx, now)
+		require.NoError(t, err)
+		var remainingIDs []string
+		for _, entry := range remaining {
+			remainingIDs = append(remainingIDs, entry.ID)
+		}
+		require.Equal(t, []string{broken.ID, recentDefault.ID, recentShort.ID}, remainingIDs)
+
+		// 已結束的任務紀錄以系統預設的保留天數清除
+		require.Equal(t, 2, report.DeletedTasks)
+		for _, task := range []*models.Task{oldTask, oldCancelledTask} {
+			record, err := dbStore.GetTaskByID(ctx, task.ID)
+			require.NoError(t, err)
+			require.Nil(t, record, task.ID)
+		}
+		for _, task := range []*models.Task{recentTask, pendingTask} {
+			record, err := dbStore.GetTaskByID(ctx, task.ID)
+			require.NoError(t, err)
+			require.NotNil(t, record, task.ID)
+		}
+	})
+}
+
+func TestNewJanitor(t *testing.T) {
+	j := NewJanitor(store.NewMockStore(), nil, config.RetentionConfig{Days: 30, Interval: time.Minute})
+	require.Equal(t, 30, j.Days)
+	require.Equal(t, time.Minute, j.Interval)
+
+	// 背景清理可以正常啟動與停止
+	j.Start()
+	j.Stop()
+}
@@ -2,9 +2,12 @@ package scheduler
 
 import (
 	"context"
+	"fmt"
 	"log"
+	"report-scheduler/backend/internal/models"
 	"report-scheduler/backend/internal/queue"
 	"report-scheduler/backend/internal/store"
+	"sync"
 	"time"
 
 	"github.com/google/uuid"
@@ -16,14 +19,18 @@ type Scheduler struct {
 	Store store.Store
 	Queue queue.Queue
 	cron  *cron.Cron
+
+	mu      sync.Mutex
+	entries map[string]cron.EntryID // 排程 ID 對應到 cron 的 Entry ID
 }
 
 // NewScheduler 建立一個新的 Scheduler 實例
 func NewScheduler(s store.Store, q queue.Queue) *Scheduler {
 	return &Scheduler{
-		Store: s,
-		Queue: q,
-		cron:  cron.New(cron.WithSeconds()),
+		Store:   s,
+		Queue:   q,
+		cron:    cron.New(cron.WithParser(specParser)),
+		entries: make(map[string]cron.EntryID),
 	}
 }
 
@@ -40,26 +47,8 @@ func (s *Scheduler) Start() error {
 
 	log.Printf("找到 %d 個排程準備加入...", len(schedules))
 	for _, schedule := range schedules {
-		if schedule.IsEnabled {
-			sch := schedule // 使用閉包捕獲 schedule 的副本
-			entryID, err := s.cron.AddFunc(sch.CronSpec, func() {
-				// 當 cron 任務觸發時，建立一個 Task 並將其推入佇列
-				task := &queue.Task{
-					ID:         uuid.New().String(),
-					ScheduleID: sch.ID,
-					ReportIDs:  sch.ReportIDs,
-					CreatedAt:  time.Now(),
-				}
-				log.Printf("觸發排程: %s (ID: %s), 正在將任務 %s 推入佇列...", sch.Name, sch.ID, task.ID)
-				if err := s.Queue.Enqueue(context.Background(), task); err != nil {
-					log.Printf("錯誤：無法將任務 %s 推入佇列: %v", task.ID, err)
-				}
-			})
-			if err != nil {
-				log.Printf("錯誤：無法新增排程 '%s' (ID: %s): %v", sch.Name, sch.ID, err)
-			} else {
-				log.Printf("成功新增排程: %s (ID: %s), Cron規格: '%s', Cron Entry ID: %d", sch.Name, sch.ID, sch.CronSpec, entryID)
-			}
+		if err := s.AddSchedule(schedule); err != nil {
+			log.Printf("錯誤：無法新增排程 '%s' (ID: %s): %v", schedule.Name, schedule.ID, err)
 		}
 	}
 
@@ -68,6 +57,113 @@ func (s *Scheduler) Start() error {
 	return nil
 }
 
+// AddSchedule 將排程註冊到 cron 中。停用的排程會被忽略。
+// 如果相同 ID 的排程已經註冊過，會回傳錯誤；要更新既有的排程請使用 ReplaceSchedule。
+func (s *Scheduler) AddSchedule(sch models.Schedule) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, exists := s.entries[sch.ID]; exists {
+		return fmt.Errorf("排程 %s 已經註冊", sch.ID)
+	}
+	return s.addLocked(sch)
+}
+
+// ReplaceSchedule 以新的設定取代已註冊的排程。
+// 如果新設定為停用，排程只會被移除而不會重新加入。
+func (s *Scheduler) ReplaceSchedule(sch models.Schedule) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.removeLocked(sch.ID)
+	return s.addLocked(sch)
+}
+
+// RemoveSchedule 從 cron 中移除指定 ID 的排程，如果排程不存在則不做任何事
+func (s *Scheduler) RemoveSchedule(scheduleID string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.removeLocked(scheduleID)
+}
+
+// EntryID 回傳排程對應的 cron Entry ID，第二個回傳值表示排程是否已註冊
+func (s *Scheduler) EntryID(scheduleID string) (cron.EntryID, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	id, ok := s.entries[scheduleID]
+	return id, ok
+}
+
+// addLocked 實際將排程加入 cron，呼叫前必須持有 s.mu
+func (s *Scheduler) addLocked(sch models.Schedule) error {
+	if !sch.IsEnabled {
+		return nil
+	}
+
+	loc, err := sch.Location()
+	if err != nil {
+		return err
+	}
+	cronSchedule, err := ParseSpec(sch.CronSpec, loc)
+	if err != nil {
+		return fmt.Errorf("無效的 Cron 規格 '%s': %w", sch.CronSpec, err)
+	}
+
+	entryID := s.cron.Schedule(cronSchedule, cron.FuncJob(func() {
+		// 當 cron 任務觸發時，建立一個 Task 並將其推入佇列
+		task := queue.NewScheduleTask(uuid.New().String(), &sch)
+		log.Printf("觸發排程: %s (ID: %s), 正在將任務 %s 推入佇列...", sch.Name, sch.ID, task.ID)
+		if err := s.Dispatch(context.Background(), task, sch.Name); err != nil {
+			log.Printf("錯誤：無法將任務 %s 推入佇列: %v", task.ID, err)
+		}
+	}))
+
+	s.entries[sch.ID] = entryID
+	log.Printf("成功新增排程: %s (ID: %s), Cron規格: '%s', 時區: %s, Cron Entry ID: %d", sch.Name, sch.ID, sch.CronSpec, loc, entryID)
+	return nil
+}
+
+// Dispatch 建立任務的 queued 紀錄後將任務推入佇列，讓任務在被 Worker 取出之前就能查詢到。
+// 推入佇列失敗時，任務紀錄會標記為失敗。
+func (s *Scheduler) Dispatch(ctx context.Context, task *queue.Task, scheduleName string) error {
+	record := &models.Task{
+		ID:           task.ID,
+		ScheduleID:   task.ScheduleID,
+		ScheduleName: scheduleName,
+		Status:       models.TaskStatusQueued,
+		Attempt:      task.Attempt,
+		QueuedAt:     task.CreatedAt,
+	}
+	if err := s.Store.CreateTask(ctx, record); err != nil {
+		return fmt.Errorf("無法建立任務紀錄: %w", err)
+	}
+
+	if err := s.Queue.Enqueue(ctx, task); err != nil {
+		now := time.Now()
+		record.Status = models.TaskStatusFailed
+		record.ErrorMessage = "無法推入佇列: " + err.Error()
+		record.FinishedAt = &now
+		if updateErr := s.Store.UpdateTask(context.WithoutCancel(ctx), record); updateErr != nil {
+			log.Printf("錯誤：無法更新任務 %s 的狀態: %v", task.ID, updateErr)
+		}
+		return err
+	}
+	return nil
+}
+
+// removeLocked 實際將排程從 cron 移除，呼叫前必須持有 s.mu
+func (s *Scheduler) removeLocked(scheduleID string) {
+	entryID, ok := s.entries[scheduleID]
+	if !ok {
+		return
+	}
+	s.cron.Remove(entryID)
+	delete(s.entries, scheduleID)
+	log.Printf("已移除排程 (ID: %s), Cron Entry ID: %d", scheduleID, entryID)
+}
+
 // Stop 停止排程器，並等待所有執行中的任務完成
 func (s *Scheduler) Stop() context.Context {
 	log.Println("正在停止排程器服務...")
@@ -67,3 +67,70 @@ func TestScheduler_EnqueuesTasks(t *testing.T) {
 	require.Error(t, err, "預期佇列中沒有第二個任務")
 	require.Equal(t, context.DeadlineExceeded, err)
 }
+
+func TestScheduler_HotReload(t *testing.T) {
+	mockStore := store.NewMockStore()
+	testQueue := queue.NewInMemoryQueue(10)
+	scheduler := NewScheduler(mockStore, testQueue)
+
+	require.NoError(t, scheduler.Start())
+	defer func() {
+		ctx := scheduler.Stop()
+		<-ctx.Done()
+		testQueue.Close()
+	}()
+
+	sch := models.Schedule{
+		ID:        "sch-hot",
+		Name:      "Hot Reload Schedule",
+		CronSpec:  "@every 1s",
+		IsEnabled: true,
+		ReportIDs: []string{"rep-1"},
+	}
+
+	t.Run("added schedule fires without restart", func(t *testing.T) {
+		require.NoError(t, scheduler.AddSchedule(sch))
+		_, ok := scheduler.EntryID(sch.ID)
+		require.True(t, ok)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		defer cancel()
+		task, err := testQueue.Dequeue(ctx)
+		require.NoError(t, err)
+		require.Equal(t, sch.ID, task.ScheduleID)
+	})
+
+	t.Run("adding the same schedule twice fails", func(t *testing.T) {
+		require.Error(t, scheduler.AddSchedule(sch))
+	})
+
+	t.Run("replace assigns a new entry", func(t *testing.T) {
+		oldID, _ := scheduler.EntryID(sch.ID)
+		updated := sch
+		updated.CronSpec = "@every 2s"
+		require.NoError(t, scheduler.ReplaceSchedule(updated))
+
+		newID, ok := scheduler.EntryID(sch.ID)
+		require.True(t, ok)
+		require.NotEqual(t, oldID, newID)
+		require.Len(t, scheduler.cron.Entries(), 1)
+	})
+
+	t.Run("disabling removes the entry", func(t *testing.T) {
+		disabled := sch
+		disabled.IsEnabled = false
+		require.NoError(t, scheduler.ReplaceSchedule(disabled))
+		_, ok := scheduler.EntryID(sch.ID)
+		require.False(t, ok)
+		require.Empty(t, scheduler.cron.Entries())
+	})
+
+	t.Run("remove is idempotent", func(t *testing.T) {
+		require.NoError(t, scheduler.ReplaceSchedule(sch))
+		scheduler.RemoveSchedule(sch.ID)
+		scheduler.RemoveSchedule(sch.ID)
+		_, ok := scheduler.EntryID(sch.ID)
+		require.False(t, ok)
+		require.Empty(t, scheduler.cron.Entries())
+	})
+}
@@ -0,0 +1,115 @@
+package scheduler
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/robfig/cron/v3"
+)
+
+// specParser 是排程器使用的 cron 表達式解析器。
+// 同時接受標準的 5 欄位格式 (例如 "0 9 * * 1-5")、含秒數的 6 欄位格式，
+// 以及 "@daily"、"@every 1h" 等描述符。
+var specParser = cron.NewParser(cron.SecondOptional | cron.Minute | cron.Hour | cron.Dom | cron.Month | cron.Dow | cron.Descriptor)
+
+// ParseSpec 解析 cron 表達式，並讓產生的排程在指定的時區中計算觸發時間。
+// "@every" 這類固定間隔的排程與時區無關，會直接回傳。
+func ParseSpec(spec string, loc *time.Location) (cron.Schedule, error) {
+	if strings.TrimSpace(spec) == "" {
+		return nil, errors.New("Cron 規格不可為空")
+	}
+	sched, err := specParser.Parse(spec)
+	if err != nil {
+		return nil, err
+	}
+	specSched, ok := sched.(*cron.SpecSchedule)
+	if !ok {
+		return sched, nil
+	}
+	// 表達式本身以 CRON_TZ= 指定的時區優先於排程設定的時區
+	if specSched.Location != time.Local {
+		loc = specSched.Location
+	}
+	// 以 UTC 計算「牆上時間」，再由 zonedSchedule 轉換回實際的時區，
+	// 如此才能統一處理日光節約時間 (DST) 的切換。
+	specSched.Location = time.UTC
+	return &zonedSchedule{inner: specSched, loc: loc}, nil
+}
+
+// NextRuns 回傳 from 之後的 count 次觸發時間，時間以 loc 時區表示
+func NextRuns(sched cron.Schedule, from time.Time, count int, loc *time.Location) []time.Time {
+	runs := make([]time.Time, 0, count)
+	for i := 0; i < count; i++ {
+		from = sched.Next(from)
+		if from.IsZero() {
+			break
+		}
+		runs = append(runs, from.In(loc))
+	}
+	return runs
+}
+
+// zonedSchedule 在指定時區的牆上時間 (wall clock) 上計算 cron 觸發時間。
+//
+// 日光節約時間切換時的行為是固定的：
+//   - 時鐘往前跳 (例如 02:00 直接跳到 03:00) 時，落在被跳過區間內的觸發時間
+//     會在跳躍結束的那一刻 (03:00) 執行一次，不會被略過。
+//   - 時鐘往回撥 (例如 01:00-02:00 出現兩次) 時，重複的牆上時間只會在
+//     第一次出現時執行一次。
+type zonedSchedule struct {
+	inner *cron.SpecSchedule
+	loc   *time.Location
+}
+
+// Next 實作 cron.Schedule 介面
+func (z *zonedSchedule) Next(t time.Time) time.Time {
+	wall := toWall(t.In(z.loc))
+	for {
+		nextWall := z.inner.Next(wall)
+		if nextWall.IsZero() {
+			return nextWall
+		}
+		next := fromWall(nextWall, z.loc)
+		if next.After(t) {
+			return next
+		}
+		// 例如重複的牆上時間第一次出現時已經執行過，或多個被跳過的時間對應到同一時刻
+		wall = nextWall
+	}
+}
+
+// toWall 將時間的年月日時分秒原封不動地搬到 UTC，代表其牆上時間
+func toWall(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), time.UTC)
+}
+
+// fromWall 將 UTC 表示的牆上時間轉換回 loc 中的實際時間點。
+// 不存在的牆上時間回傳時鐘跳躍結束的時刻；重複的牆上時間回傳較早的那一個。
+func fromWall(wall time.Time, loc *time.Location) time.Time {
+	t := time.Date(wall.Year(), wall.Month(), wall.Day(), wall.Hour(), wall.Minute(), wall.Second(), wall.Nanosecond(), loc)
+
+	if !toWall(t).Equal(wall) {
+		// 牆上時間落在被跳過的區間內，找出時鐘跳躍的那一刻
+		start, end := t.ZoneBounds()
+		if toWall(t).Before(wall) {
+			return end
+		}
+		return start
+	}
+
+	// 檢查同一個牆上時間是否在前一個時區區段中也出現過 (時鐘往回撥)
+	start, _ := t.ZoneBounds()
+	if start.IsZero() {
+		return t
+	}
+	_, prevOffset := start.Add(-time.Nanosecond).Zone()
+	_, curOffset := t.Zone()
+	if prevOffset > curOffset {
+		earlier := t.Add(-time.Duration(prevOffset-curOffset) * time.Second)
+		if earlier.Before(start) && toWall(earlier).Equal(wall) {
+			return earlier
+		}
+	}
+	return t
+}
@@ -0,0 +1,164 @@
+package scheduler
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func mustLoad(t *testing.T, name string) *time.Location {
+	loc, err := time.LoadLocation(name)
+	require.NoError(t, err)
+	return loc
+}
+
+// nextN 從 from 開始連續計算 n 次觸發時間
+func nextN(t *testing.T, spec string, loc *time.Location, from time.Time, n int) []time.Time {
+	sched, err := ParseSpec(spec, loc)
+	require.NoError(t, err)
+	var out []time.Time
+	for i := 0; i < n; i++ {
+		from = sched.Next(from)
+		out = append(out, from)
+	}
+	return out
+}
+
+func TestParseSpec_Timezone(t *testing.T) {
+	taipei := mustLoad(t, "Asia/Taipei")
+
+	t.Run("evaluated in schedule timezone", func(t *testing.T) {
+		got := nextN(t, "0 0 9 * * *", taipei, time.Date(2024, 3, 4, 0, 0, 0, 0, time.UTC), 1)
+		// 台北時間 09:00 等於 UTC 01:00
+		require.Equal(t, time.Date(2024, 3, 4, 1, 0, 0, 0, time.UTC), got[0].UTC())
+	})
+
+	t.Run("CRON_TZ prefix overrides schedule timezone", func(t *testing.T) {
+		got := nextN(t, "CRON_TZ=UTC 0 0 9 * * *", taipei, time.Date(2024, 3, 4, 0, 0, 0, 0, time.UTC), 1)
+		require.Equal(t, time.Date(2024, 3, 4, 9, 0, 0, 0, time.UTC), got[0].UTC())
+	})
+
+	t.Run("@every ignores timezone", func(t *testing.T) {
+		from := time.Date(2024, 3, 4, 0, 0, 0, 0, time.UTC)
+		got := nextN(t, "@every 1h", taipei, from, 1)
+		require.Equal(t, from.Add(time.Hour), got[0])
+	})
+
+	t.Run("invalid spec", func(t *testing.T) {
+		_, err := ParseSpec("not a cron", taipei)
+		require.Error(t, err)
+	})
+}
+
+func TestParseSpec_Formats(t *testing.T) {
+	from := time.Date(2024, 3, 4, 0, 0, 0, 0, time.UTC) // 星期一
+
+	valid := map[string]string{
+		"0 9 * * 1-5":    "2024-03-04T09:00:00Z", // 5 欄位
+		"30 0 9 * * 1-5": "2024-03-04T09:00:30Z", // 6 欄位 (含秒)
+		"@daily":         "2024-03-05T00:00:00Z",
+		"@hourly":        "2024-03-04T01:00:00Z",
+		"@every 90m":     "2024-03-04T01:30:00Z",
+	}
+	for spec, want := range valid {
+		got := nextN(t, spec, time.UTC, from, 1)
+		require.Equal(t, want, got[0].UTC().Format(time.RFC3339), spec)
+	}
+
+	for _, spec := range []string{"", "   ", "* * * *", "0 0 0 9 * * * *", "61 * * * *", "@sometimes", "0 9 * * MON-XYZ"} {
+		_, err := ParseSpec(spec, time.UTC)
+		require.Error(t, err, "spec %q 應該無效", spec)
+	}
+}
+
+func TestNextRuns(t *testing.T) {
+	taipei := mustLoad(t, "Asia/Taipei")
+	sched, err := ParseSpec("0 9 * * 1-5", taipei)
+	require.NoError(t, err)
+
+	// 2024-03-08 是星期五
+	runs := NextRuns(sched, time.Date(2024, 3, 8, 2, 0, 0, 0, time.UTC), 3, taipei)
+	require.Equal(t, []string{
+		"2024-03-11T09:00:00+08:00",
+		"2024-03-12T09:00:00+08:00",
+		"2024-03-13T09:00:00+08:00",
+	}, formatAll(runs))
+}
+
+func TestParseSpec_DST(t *testing.T) {
+	ny := mustLoad(t, "America/New_York")
+
+	t.Run("spring forward runs skipped time once at end of gap", func(t *testing.T) {
+		// 2024-03-10 02:00 EST 直接跳到 03:00 EDT，02:30 並不存在
+		got := nextN(t, "0 30 2 * * *", ny, time.Date(2024, 3, 8, 12, 0, 0, 0, ny), 3)
+		require.Equal(t, []string{
+			"2024-03-09T02:30:00-05:00",
+			"2024-03-10T03:00:00-04:00", // 在時鐘跳躍結束時執行
+			"2024-03-11T02:30:00-04:00",
+		}, formatAll(got))
+	})
+
+	t.Run("spring forward collapses multiple skipped times", func(t *testing.T) {
+		got := nextN(t, "0 */20 * * * *", ny, time.Date(2024, 3, 10, 1, 30, 0, 0, ny), 4)
+		require.Equal(t, []string{
+			"2024-03-10T01:40:00-05:00",
+			"2024-03-10T03:00:00-04:00", // 02:00、02:20、02:40 全部合併為一次
+			"2024-03-10T03:20:00-04:00",
+			"2024-03-10T03:40:00-04:00",
+		}, formatAll(got))
+	})
+
+	t.Run("fall back runs repeated time only once", func(t *testing.T) {
+		// 2024-11-03 01:00-02:00 出現兩次
+		got := nextN(t, "0 30 1 * * *", ny, time.Date(2024, 11, 1, 12, 0, 0, 0, ny), 3)
+		require.Equal(t, []string{
+			"2024-11-02T01:30:00-04:00",
+			"2024-11-03T01:30:00-04:00", // 只在第一次出現時執行
+			"2024-11-04T01:30:00-05:00",
+		}, formatAll(got))
+	})
+
+	t.Run("fall back does not fire again during repeated hour", func(t *testing.T) {
+		// 從第二次出現的 01:10 EST 開始計算，01:30 已在 EDT 時執行過
+		from := time.Date(2024, 11, 3, 6, 10, 0, 0, time.UTC).In(ny)
+		require.Equal(t, "2024-11-03T01:10:00-05:00", from.Format(time.RFC3339))
+		got := nextN(t, "0 30 1 * * *", ny, from, 1)
+		require.Equal(t, "2024-11-04T01:30:00-05:00", got[0].Format(time.RFC3339))
+	})
+
+	t.Run("fall back hourly job fires each real hour once", func(t *testing.T) {
+		got := nextN(t, "0 0 * * * *", ny, time.Date(2024, 11, 3, 4, 30, 0, 0, time.UTC), 3)
+		require.Equal(t, []string{
+			"2024-11-03T01:00:00-04:00",
+			"2024-11-03T02:00:00-05:00", // 01:00 EST 與 01:00 EDT 為相同牆上時間，只執行一次
+			"2024-11-03T03:00:00-05:00",
+		}, formatAll(got))
+	})
+
+	t.Run("fall back repeated wall times fire once across the whole day", func(t *testing.T) {
+		// 2024-10-27 倫敦 02:00 BST 回撥到 01:00 GMT，01:00-02:00 的每個牆上時間都出現兩次
+		london := mustLoad(t, "Europe/London")
+		sched, err := ParseSpec("*/15 1 * * *", london)
+		require.NoError(t, err)
+		runs := NextRuns(sched, time.Date(2024, 10, 26, 12, 0, 0, 0, london), 8, london)
+		require.Equal(t, []string{
+			"2024-10-27T01:00:00+01:00",
+			"2024-10-27T01:15:00+01:00",
+			"2024-10-27T01:30:00+01:00",
+			"2024-10-27T01:45:00+01:00", // 回撥後的 01:00-01:45 GMT 不再執行
+			"2024-10-28T01:00:00Z",
+			"2024-10-28T01:15:00Z",
+			"2024-10-28T01:30:00Z",
+			"2024-10-28T01:45:00Z",
+		}, formatAll(runs))
+	})
+}
+
+func formatAll(ts []time.Time) []string {
+	var out []string
+	for _, t := range ts {
+		out = append(out, t.Format(time.RFC3339))
+	}
+	return out
+}
@@ -0,0 +1,187 @@
+package secrets
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+)
+
+// fileFormatVersion 是加密檔案格式的版本，未來更換演算法時用來辨識舊檔案
+const fileFormatVersion = 1
+
+// encryptedFile 是憑證檔案在磁碟上的格式。
+// 所有憑證序列化為 JSON 後以 AES-256-GCM 整體加密，檔案本身只看得到版本與密文。
+type encryptedFile struct {
+	Version    int    `json:"version"`
+	Nonce      []byte `json:"nonce"`
+	Ciphertext []byte `json:"ciphertext"`
+}
+
+// FileSecretsManager 將憑證加密儲存在本機檔案中，以 DataSource 的 CredentialsRef 作為索引
+type FileSecretsManager struct {
+	path string
+	aead cipher.AEAD
+
+	mu    sync.RWMutex
+	creds map[string]Credentials
+}
+
+// NewFileSecretsManager 建立一個新的 FileSecretsManager 並讀取既有的憑證檔案，檔案不存在時視為沒有任何憑證。
+// 主金鑰經 SHA-256 轉換為 AES-256 金鑰，因此應使用夠長的隨機字串。
+func NewFileSecretsManager(path, masterKey string) (*FileSecretsManager, error) {
+	if path == "" {
+		return nil, fmt.Errorf("加密憑證檔案需要設定 path")
+	}
+	if masterKey == "" {
+		return nil, fmt.Errorf("加密憑證檔案需要設定主金鑰 (secrets.file.master_key 或環境變數 SECRETS_FILE_MASTER_KEY)")
+	}
+	key := sha256.Sum256([]byte(masterKey))
+	block, err := aes.NewCipher(key[:])
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+
+	m := &FileSecretsManager{path: path, aead: aead, creds: make(map[string]Credentials)}
+	if err := m.load(); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// load 讀取並解密憑證檔案
+func (m *FileSecretsManager) load() error {
+	data, err := os.ReadFile(m.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("無法讀取憑證檔案 %s: %w", m.path, err)
+	}
+
+	var file encryptedFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return fmt.Errorf("憑證檔案 %s 格式錯誤: %w", m.path, err)
+	}
+	if file.Version != fileFormatVersion {
+		return fmt.Errorf("不支援的憑證檔案版本: %d", file.Version)
+	}
+	plaintext, err := m.aead.Open(nil, file.Nonce, file.Ciphertext, nil)
+	if err != nil {
+		return fmt.Errorf("無法解密憑證檔案 %s，主金鑰可能不正確", m.path)
+	}
+	if err := json.Unmarshal(plaintext, &m.creds); err != nil {
+		return fmt.Errorf("憑證檔案 %s 內容格式錯誤: %w", m.path, err)
+	}
+	return nil
+}
+
+// save 加密並寫入憑證檔案。先寫入暫存檔再改名，避免寫到一半時損毀原本的檔案。
+func (m *FileSecretsManager) save() error {
+	plaintext, err := json.Marshal(m.creds)
+	if err != nil {
+		return err
+	}
+	nonce := make([]byte, m.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return err
+	}
+	data, err := json.Marshal(encryptedFile{
+		Version:    fileFormatVersion,
+		Nonce:      nonce,
+		Ciphertext: m.aead.Seal(nil, nonce, plaintext, nil),
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(m.path), 0700); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(m.path), ".secrets-*")
+	if err != nil {
+		return err
+	}
+	_, err = tmp.Write(data)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), m.path)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return fmt.Errorf("無法寫入憑證檔案 %s: %w", m.path, err)
+	}
+	return nil
+}
+
+// GetCredentials 實作 SecretsManager 介面
+func (m *FileSecretsManager) GetCredentials(ref string) (*Credentials, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	creds, ok := m.creds[ref]
+	if !ok {
+		return nil, fmt.Errorf("找不到對應的憑證: %s", ref)
+	}
+	return &creds, nil
+}
+
+// SetCredentials 新增或更新 ref 對應的憑證，並立即寫回檔案
+func (m *FileSecretsManager) SetCredentials(ref string, creds Credentials) error {
+	if ref == "" {
+		return fmt.Errorf("憑證引用路徑不可為空")
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	previous, existed := m.creds[ref]
+	m.creds[ref] = creds
+	if err := m.save(); err != nil {
+		if existed {
+			m.creds[ref] = previous
+		} else {
+			delete(m.creds, ref)
+		}
+		return err
+	}
+	return nil
+}
+
+// DeleteCredentials 刪除 ref 對應的憑證，並立即寫回檔案
+func (m *FileSecretsManager) DeleteCredentials(ref string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	previous, existed := m.creds[ref]
+	if !existed {
+		return nil
+	}
+	delete(m.creds, ref)
+	if err := m.save(); err != nil {
+		m.creds[ref] = previous
+		return err
+	}
+	return nil
+}
+
+// Refs 回傳所有已儲存憑證的引用路徑
+func (m *FileSecretsManager) Refs() []string {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	refs := make([]string, 0, len(m.creds))
+	for ref := range m.creds {
+		refs = append(refs, ref)
+	}
+	sort.Strings(refs)
+	return refs
+}
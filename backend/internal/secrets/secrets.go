@@ -1,10 +1,15 @@
 package secrets
 
+import (
+	"fmt"
+	"report-scheduler/backend/internal/config"
+)
+
 // Credentials 包含了連線到外部服務所需的認證資訊
 type Credentials struct {
-	Username string
-	Password string
-	Token    string
+	Username string `json:"username,omitempty"`
+	Password string `json:"password,omitempty"`
+	Token    string `json:"token,omitempty"`
 }
 
 // SecretsManager 是憑證管理的介面，符合 Factory Provider 模式
@@ -12,3 +17,17 @@ type SecretsManager interface {
 	// GetCredentials 根據一個引用路徑（例如 Vault 的路徑）來獲取憑證
 	GetCredentials(ref string) (*Credentials, error)
 }
+
+// NewSecretsManager 是憑證管理的工廠函式，根據設定檔中的 secrets.type 決定要回傳哪一種實作
+func NewSecretsManager(cfg config.Config) (SecretsManager, error) {
+	switch cfg.Secrets.Type {
+	case "", "mock":
+		return NewMockSecretsManager(), nil
+	case "file":
+		return NewFileSecretsManager(cfg.Secrets.File.Path, cfg.Secrets.File.MasterKey)
+	case "vault":
+		return NewVaultSecretsManager(cfg.Secrets.Vault)
+	default:
+		return nil, fmt.Errorf("不支援的憑證管理類型: %s", cfg.Secrets.Type)
+	}
+}
@@ -0,0 +1,148 @@
+package secrets
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"report-scheduler/backend/internal/config"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestFileSecretsManager(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "nested", "secrets.enc")
+
+	m, err := NewFileSecretsManager(path, "correct horse battery staple")
+	require.NoError(t, err)
+	_, err = m.GetCredentials("kv/report-scheduler/kibana-prod")
+	require.ErrorContains(t, err, "找不到對應的憑證")
+
+	require.NoError(t, m.SetCredentials("kv/report-scheduler/kibana-prod", Credentials{Token: "api-key-123"}))
+	require.NoError(t, m.SetCredentials("kv/report-scheduler/grafana", Credentials{Username: "admin", Password: "p@ss"}))
+	require.Equal(t, []string{"kv/report-scheduler/grafana", "kv/report-scheduler/kibana-prod"}, m.Refs())
+
+	// 檔案內容是加密過的
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	require.NotContains(t, string(data), "api-key-123")
+	require.NotContains(t, string(data), "kibana-prod")
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	require.Equal(t, os.FileMode(0600), info.Mode().Perm())
+
+	// 重新開啟後可以讀回憑證
+	reopened, err := NewFileSecretsManager(path, "correct horse battery staple")
+	require.NoError(t, err)
+	creds, err := reopened.GetCredentials("kv/report-scheduler/grafana")
+	require.NoError(t, err)
+	require.Equal(t, &Credentials{Username: "admin", Password: "p@ss"}, creds)
+
+	require.NoError(t, reopened.DeleteCredentials("kv/report-scheduler/grafana"))
+	reopened, err = NewFileSecretsManager(path, "correct horse battery staple")
+	require.NoError(t, err)
+	require.Equal(t, []string{"kv/report-scheduler/kibana-prod"}, reopened.Refs())
+
+	_, err = NewFileSecretsManager(path, "wrong key")
+	require.ErrorContains(t, err, "主金鑰可能不正確")
+	_, err = NewFileSecretsManager(path, "")
+	require.ErrorContains(t, err, "主金鑰")
+}
+
+// newFakeVault 建立一個模擬 Vault KV v2 API 的測試伺服器
+func newFakeVault(t *testing.T, secrets map[string]map[string]interface{}) *httptest.Server {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Vault-Token") != "root-token" {
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte(`{"errors":["permission denied"]}`))
+			return
+		}
+		if r.Method != http.MethodGet {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		data, ok := secrets[r.URL.Path]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"errors":[]}`))
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"data": map[string]interface{}{
+				"data":     data,
+				"metadata": map[string]interface{}{"version": 3},
+			},
+		})
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestVaultSecretsManager(t *testing.T) {
+	srv := newFakeVault(t, map[string]map[string]interface{}{
+		"/v1/kv/data/report-scheduler/kibana-prod": {"token": "kibana-api-key"},
+		"/v1/secret/data/grafana/prod":             {"username": "admin", "password": "s3cret", "extra": 1},
+		"/v1/kv/data/report-scheduler/empty":       {"note": "no credentials here"},
+	})
+
+	m, err := NewVaultSecretsManager(config.VaultConfig{Address: srv.URL + "/", Token: "root-token"})
+	require.NoError(t, err)
+
+	creds, err := m.GetCredentials("kv/report-scheduler/kibana-prod")
+	require.NoError(t, err)
+	require.Equal(t, &Credentials{Token: "kibana-api-key"}, creds)
+
+	_, err = m.GetCredentials("kv/report-scheduler/missing")
+	require.ErrorContains(t, err, "找不到憑證")
+	_, err = m.GetCredentials("kv/report-scheduler/empty")
+	require.ErrorContains(t, err, "沒有 username")
+	for _, ref := range []string{"", "kv", "kv/../sys/seal", "kv/a//b"} {
+		_, err = m.GetCredentials(ref)
+		require.ErrorContains(t, err, "無效的 Vault 憑證路徑", ref)
+	}
+
+	t.Run("configured mount", func(t *testing.T) {
+		m, err := NewVaultSecretsManager(config.VaultConfig{Address: srv.URL, Token: "root-token", Mount: "secret"})
+		require.NoError(t, err)
+		for _, ref := range []string{"grafana/prod", "secret/grafana/prod"} {
+			creds, err := m.GetCredentials(ref)
+			require.NoError(t, err, ref)
+			require.Equal(t, &Credentials{Username: "admin", Password: "s3cret"}, creds)
+		}
+	})
+
+	t.Run("bad token", func(t *testing.T) {
+		m, err := NewVaultSecretsManager(config.VaultConfig{Address: srv.URL, Token: "wrong"})
+		require.NoError(t, err)
+		_, err = m.GetCredentials("kv/report-scheduler/kibana-prod")
+		require.ErrorContains(t, err, "沒有權限")
+	})
+
+	t.Run("environment fallback", func(t *testing.T) {
+		t.Setenv("VAULT_ADDR", srv.URL)
+		t.Setenv("VAULT_TOKEN", "root-token")
+		m, err := NewVaultSecretsManager(config.VaultConfig{})
+		require.NoError(t, err)
+		_, err = m.GetCredentials("kv/report-scheduler/kibana-prod")
+		require.NoError(t, err)
+	})
+}
+
+func TestNewSecretsManager(t *testing.T) {
+	sm, err := NewSecretsManager(config.Config{})
+	require.NoError(t, err)
+	require.IsType(t, &MockSecretsManager{}, sm)
+
+	sm, err = NewSecretsManager(config.Config{Secrets: config.SecretsConfig{Type: "file", File: config.FileSecretsConfig{Path: filepath.Join(t.TempDir(), "s.enc"), MasterKey: "k"}}})
+	require.NoError(t, err)
+	require.IsType(t, &FileSecretsManager{}, sm)
+
+	sm, err = NewSecretsManager(config.Config{Secrets: config.SecretsConfig{Type: "vault", Vault: config.VaultConfig{Address: "http://vault:8200", Token: "t"}}})
+	require.NoError(t, err)
+	require.IsType(t, &VaultSecretsManager{}, sm)
+
+	_, err = NewSecretsManager(config.Config{Secrets: config.SecretsConfig{Type: "aws"}})
+	require.ErrorContains(t, err, "不支援的憑證管理類型")
+}
@@ -0,0 +1,127 @@
+package secrets
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"report-scheduler/backend/internal/config"
+	"strings"
+	"time"
+)
+
+// VaultSecretsManager 從 HashiCorp Vault 的 KV v2 secrets engine 讀取憑證。
+// 憑證資料中的 username、password 與 token 欄位會對應到 Credentials。
+type VaultSecretsManager struct {
+	cfg    config.VaultConfig
+	Client *http.Client
+}
+
+// NewVaultSecretsManager 建立一個新的 VaultSecretsManager，未設定的位址與 token 會使用 VAULT_ADDR 與 VAULT_TOKEN 環境變數
+func NewVaultSecretsManager(cfg config.VaultConfig) (*VaultSecretsManager, error) {
+	if cfg.Address == "" {
+		cfg.Address = os.Getenv("VAULT_ADDR")
+	}
+	if cfg.Token == "" {
+		cfg.Token = os.Getenv("VAULT_TOKEN")
+	}
+	if cfg.Address == "" || cfg.Token == "" {
+		return nil, fmt.Errorf("Vault 需要設定 address 與 token")
+	}
+	if _, err := url.Parse(cfg.Address); err != nil {
+		return nil, fmt.Errorf("無效的 Vault 位址 '%s': %w", cfg.Address, err)
+	}
+	cfg.Address = strings.TrimRight(cfg.Address, "/")
+	cfg.Mount = strings.Trim(cfg.Mount, "/")
+	return &VaultSecretsManager{cfg: cfg, Client: &http.Client{Timeout: 10 * time.Second}}, nil
+}
+
+// splitRef 將 CredentialsRef 拆成 KV 掛載路徑與 secret 路徑
+func (m *VaultSecretsManager) splitRef(ref string) (mount, secretPath string, err error) {
+	ref = strings.Trim(ref, "/")
+	if m.cfg.Mount != "" {
+		mount, secretPath = m.cfg.Mount, strings.TrimPrefix(ref, m.cfg.Mount+"/")
+	} else if i := strings.Index(ref, "/"); i > 0 {
+		mount, secretPath = ref[:i], ref[i+1:]
+	} else {
+		return "", "", fmt.Errorf("無效的 Vault 憑證路徑: %s", ref)
+	}
+	for _, segment := range strings.Split(secretPath, "/") {
+		if segment == "" || segment == "." || segment == ".." {
+			return "", "", fmt.Errorf("無效的 Vault 憑證路徑: %s", ref)
+		}
+	}
+	return mount, secretPath, nil
+}
+
+// GetCredentials 實作 SecretsManager 介面，讀取 KV v2 中最新版本的 secret
+func (m *VaultSecretsManager) GetCredentials(ref string) (*Credentials, error) {
+	mount, secretPath, err := m.splitRef(ref)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequest(http.MethodGet, m.cfg.Address+"/v1/"+escapePath(mount)+"/data/"+escapePath(secretPath), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("X-Vault-Token", m.cfg.Token)
+	if m.cfg.Namespace != "" {
+		req.Header.Set("X-Vault-Namespace", m.cfg.Namespace)
+	}
+
+	resp, err := m.Client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("連線到 Vault 失敗: %w", err)
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		return nil, fmt.Errorf("在 Vault 中找不到憑證: %s", ref)
+	case http.StatusForbidden:
+		return nil, fmt.Errorf("沒有權限讀取 Vault 憑證 %s，請確認 token 的 policy", ref)
+	default:
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return nil, fmt.Errorf("Vault 回應非預期的狀態: %d, body: %s", resp.StatusCode, string(body))
+	}
+
+	var secret struct {
+		Data struct {
+			Data map[string]interface{} `json:"data"`
+		} `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&secret); err != nil {
+		return nil, fmt.Errorf("無法解析 Vault 回應: %w", err)
+	}
+	if secret.Data.Data == nil {
+		return nil, fmt.Errorf("Vault 憑證 %s 已被刪除", ref)
+	}
+
+	str := func(key string) string {
+		if v, ok := secret.Data.Data[key].(string); ok {
+			return v
+		}
+		return ""
+	}
+	creds := &Credentials{Username: str("username"), Password: str("password"), Token: str("token")}
+	if creds.Token == "" {
+		creds.Token = str("api_key")
+	}
+	if *creds == (Credentials{}) {
+		return nil, fmt.Errorf("Vault 憑證 %s 沒有 username、password 或 token 欄位", ref)
+	}
+	return creds, nil
+}
+
+// escapePath 逐段編碼路徑，保留分隔用的 "/"
+func escapePath(p string) string {
+	segments := strings.Split(p, "/")
+	for i, segment := range segments {
+		segments[i] = url.PathEscape(segment)
+	}
+	return strings.Join(segments, "/")
+}
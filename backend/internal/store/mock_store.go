@@ -3,6 +3,7 @@ package store
 import (
 	"context"
 	"report-scheduler/backend/internal/models"
+	"time"
 )
 
 // MockStore is a configurable, in-memory implementation of the Store interface for testing.
@@ -120,3 +121,38 @@ func (s *MockStore) GetHistoryLogByID(ctx context.Context, id string) (*models.H
 	}
 	return nil, nil // Not found
 }
+func (s *MockStore) GetHistoryLogsBefore(ctx context.Context, before time.Time) ([]models.HistoryLog, error) {
+	if s.ErrToReturn != nil {
+		return nil, s.ErrToReturn
+	}
+	return []models.HistoryLog{}, nil
+}
+func (s *MockStore) DeleteHistoryLogs(ctx context.Context, ids []string) error {
+	return s.ErrToReturn
+}
+
+// --- Task Methods (Placeholders) ---
+func (s *MockStore) CreateTask(ctx context.Context, t *models.Task) error {
+	return s.ErrToReturn
+}
+func (s *MockStore) UpdateTask(ctx context.Context, t *models.Task) error {
+	return s.ErrToReturn
+}
+func (s *MockStore) CancelQueuedTask(ctx context.Context, id string, finishedAt time.Time) (bool, error) {
+	return false, s.ErrToReturn
+}
+func (s *MockStore) GetTaskByID(ctx context.Context, id string) (*models.Task, error) {
+	if s.ErrToReturn != nil {
+		return nil, s.ErrToReturn
+	}
+	return nil, nil // Not found
+}
+func (s *MockStore) GetTasks(ctx context.Context, filter models.TaskFilter) ([]models.Task, error) {
+	if s.ErrToReturn != nil {
+		return nil, s.ErrToReturn
+	}
+	return []models.Task{}, nil
+}
+func (s *MockStore) DeleteFinishedTasksBefore(ctx context.Context, before time.Time) (int, error) {
+	return 0, s.ErrToReturn
+}
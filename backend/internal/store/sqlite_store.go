@@ -3,8 +3,10 @@ package store
 import (
 	"context"
 	"database/sql"
+	"fmt"
 	"report-scheduler/backend/internal/config"
 	"report-scheduler/backend/internal/models"
+	"strings"
 	"time"
 
 	"github.com/google/uuid"
@@ -126,6 +128,8 @@ func (s *SqliteStore) initSchema() error {
 		datasource_id TEXT NOT NULL,
 		time_range TEXT NOT NULL,
 		elements TEXT,
+		cover_page BOOLEAN NOT NULL DEFAULT 0,
+		table_of_contents BOOLEAN NOT NULL DEFAULT 0,
 		created_at TIMESTAMP NOT NULL,
 		updated_at TIMESTAMP NOT NULL,
 		FOREIGN KEY(datasource_id) REFERENCES datasources(id)
@@ -145,7 +149,8 @@ func (s *SqliteStore) initSchema() error {
 		status TEXT NOT NULL,
 		error_message TEXT,
 		recipients TEXT,
-		report_url TEXT
+		report_url TEXT,
+		reference_time TIMESTAMP
 	);
 	`
 	if _, err := s.db.Exec(schema); err != nil {
@@ -163,11 +168,109 @@ func (s *SqliteStore) initSchema() error {
 		email_body TEXT,
 		report_ids TEXT,
 		is_enabled BOOLEAN NOT NULL,
+		max_retries INTEGER,
+		retry_delay_seconds INTEGER,
+		retention_days INTEGER,
+		parameters TEXT,
 		created_at TIMESTAMP NOT NULL,
 		updated_at TIMESTAMP NOT NULL
 	);
 	`
-	_, err := s.db.Exec(schema)
+	if _, err := s.db.Exec(schema); err != nil {
+		return err
+	}
+
+	schema = `
+	CREATE TABLE IF NOT EXISTS tasks (
+		id TEXT PRIMARY KEY,
+		schedule_id TEXT NOT NULL,
+		schedule_name TEXT,
+		status TEXT NOT NULL,
+		attempt INTEGER NOT NULL DEFAULT 0,
+		worker_id TEXT,
+		error_message TEXT,
+		queued_at TIMESTAMP NOT NULL,
+		started_at TIMESTAMP,
+		finished_at TIMESTAMP,
+		updated_at TIMESTAMP NOT NULL
+	);
+	CREATE INDEX IF NOT EXISTS idx_tasks_queued_at ON tasks (queued_at);
+	`
+	if _, err := s.db.Exec(schema); err != nil {
+		return err
+	}
+
+	schema = `
+	CREATE TABLE IF NOT EXISTS report_results (
+		id TEXT PRIMARY KEY,
+		history_log_id TEXT NOT NULL,
+		position INTEGER NOT NULL,
+		report_id TEXT NOT NULL,
+		report_name TEXT,
+		status TEXT NOT NULL,
+		error_message TEXT,
+		duration_ms INTEGER NOT NULL,
+		time_from TIMESTAMP,
+		time_to TIMESTAMP,
+		artifacts TEXT,
+		FOREIGN KEY(history_log_id) REFERENCES history_logs(id)
+	);
+	CREATE INDEX IF NOT EXISTS idx_report_results_history_log_id ON report_results (history_log_id);
+	`
+	if _, err := s.db.Exec(schema); err != nil {
+		return err
+	}
+
+	return s.migrateSchema()
+}
+
+// migrateSchema 為舊版本建立的資料庫補上後來新增的欄位
+func (s *SqliteStore) migrateSchema() error {
+	columns := []struct{ table, column, definition string }{
+		{"schedules", "max_retries", "INTEGER"},
+		{"schedules", "retry_delay_seconds", "INTEGER"},
+		{"report_definitions", "cover_page", "BOOLEAN NOT NULL DEFAULT 0"},
+		{"report_definitions", "table_of_contents", "BOOLEAN NOT NULL DEFAULT 0"},
+		{"schedules", "retention_days", "INTEGER"},
+		{"schedules", "parameters", "TEXT"},
+		{"history_logs", "reference_time", "TIMESTAMP"},
+	}
+	for _, c := range columns {
+		if err := s.addColumnIfMissing(c.table, c.column, c.definition); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// addColumnIfMissing 在資料表中沒有指定欄位時執行 ALTER TABLE 新增該欄位
+func (s *SqliteStore) addColumnIfMissing(table, column, definition string) error {
+	rows, err := s.db.Query(fmt.Sprintf("PRAGMA table_info(%s)", table))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			cid          int
+			name, ctype  string
+			notNull, pk  int
+			defaultValue sql.NullString
+		)
+		if err := rows.Scan(&cid, &name, &ctype, &notNull, &defaultValue, &pk); err != nil {
+			return err
+		}
+		if name == column {
+			return nil
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	rows.Close()
+
+	_, err = s.db.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column, definition))
 	return err
 }
 
@@ -235,32 +338,91 @@ func (s *SqliteStore) DeleteDataSource(ctx context.Context, id string) error {
 
 // --- HistoryLog Methods ---
 
+// CreateHistoryLog 在同一個交易中建立歷史紀錄與其每份報表的結果
 func (s *SqliteStore) CreateHistoryLog(ctx context.Context, log *models.HistoryLog) error {
 	log.ID = uuid.New().String()
-	query := `INSERT INTO history_logs (id, schedule_id, schedule_name, trigger_time, execution_duration_ms, status, error_message, recipients, report_url)
-			  VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
 
-	_, err := s.db.ExecContext(ctx, query, log.ID, log.ScheduleID, log.ScheduleName, log.TriggerTime, log.ExecutionDuration, log.Status, log.ErrorMessage, log.Recipients, log.ReportURL)
-	return err
+	query := `INSERT INTO history_logs (id, schedule_id, schedule_name, trigger_time, execution_duration_ms, status, error_message, recipients, report_url, reference_time)
+			  VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+	if _, err := tx.ExecContext(ctx, query, log.ID, log.ScheduleID, log.ScheduleName, log.TriggerTime, log.ExecutionDuration, log.Status, log.ErrorMessage, log.Recipients, log.ReportURL, log.ReferenceTime); err != nil {
+		return err
+	}
+
+	query = `INSERT INTO report_results (id, history_log_id, position, report_id, report_name, status, error_message, duration_ms, time_from, time_to, artifacts)
+			  VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+	for i := range log.Reports {
+		r := &log.Reports[i]
+		r.ID = uuid.New().String()
+		r.HistoryLogID = log.ID
+		if r.Artifacts == nil {
+			r.Artifacts = models.ReportArtifacts{}
+		}
+		if _, err := tx.ExecContext(ctx, query, r.ID, r.HistoryLogID, i, r.ReportID, r.ReportName, r.Status, r.ErrorMessage, r.Duration, r.TimeFrom, r.TimeTo, r.Artifacts); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// attachReportResults 讀取歷史紀錄的每份報表結果並填入 Reports
+func (s *SqliteStore) attachReportResults(ctx context.Context, logs []models.HistoryLog) error {
+	if len(logs) == 0 {
+		return nil
+	}
+	placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(logs)), ", ")
+	args := make([]interface{}, len(logs))
+	index := make(map[string]int, len(logs))
+	for i, log := range logs {
+		args[i] = log.ID
+		index[log.ID] = i
+	}
+	query := fmt.Sprintf(`SELECT id, history_log_id, report_id, report_name, status, error_message, duration_ms, time_from, time_to, artifacts
+			  FROM report_results WHERE history_log_id IN (%s) ORDER BY history_log_id, position`, placeholders)
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var r models.ReportResult
+		var reportName, errorMessage sql.NullString
+		if err := rows.Scan(&r.ID, &r.HistoryLogID, &r.ReportID, &reportName, &r.Status, &errorMessage, &r.Duration, &r.TimeFrom, &r.TimeTo, &r.Artifacts); err != nil {
+			return err
+		}
+		r.ReportName, r.ErrorMessage = reportName.String, errorMessage.String
+		i := index[r.HistoryLogID]
+		logs[i].Reports = append(logs[i].Reports, r)
+	}
+	return rows.Err()
 }
 
 func (s *SqliteStore) GetHistoryLogByID(ctx context.Context, id string) (*models.HistoryLog, error) {
-	query := `SELECT id, schedule_id, schedule_name, trigger_time, execution_duration_ms, status, error_message, recipients, report_url FROM history_logs WHERE id = ?`
+	query := `SELECT id, schedule_id, schedule_name, trigger_time, execution_duration_ms, status, error_message, recipients, report_url, reference_time FROM history_logs WHERE id = ?`
 	row := s.db.QueryRowContext(ctx, query, id)
 
 	var log models.HistoryLog
-	err := row.Scan(&log.ID, &log.ScheduleID, &log.ScheduleName, &log.TriggerTime, &log.ExecutionDuration, &log.Status, &log.ErrorMessage, &log.Recipients, &log.ReportURL)
+	err := row.Scan(&log.ID, &log.ScheduleID, &log.ScheduleName, &log.TriggerTime, &log.ExecutionDuration, &log.Status, &log.ErrorMessage, &log.Recipients, &log.ReportURL, &log.ReferenceTime)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return nil, nil // 找不到時回傳 nil, nil，讓 handler 處理 404
 		}
 		return nil, err
 	}
-	return &log, nil
+	logs := []models.HistoryLog{log}
+	if err := s.attachReportResults(ctx, logs); err != nil {
+		return nil, err
+	}
+	return &logs[0], nil
 }
 
 func (s *SqliteStore) GetHistoryLogs(ctx context.Context, scheduleID string) ([]models.HistoryLog, error) {
-	query := `SELECT id, schedule_id, schedule_name, trigger_time, execution_duration_ms, status, error_message, recipients, report_url FROM history_logs WHERE schedule_id = ? ORDER BY trigger_time DESC`
+	query := `SELECT id, schedule_id, schedule_name, trigger_time, execution_duration_ms, status, error_message, recipients, report_url, reference_time FROM history_logs WHERE schedule_id = ? ORDER BY trigger_time DESC`
 	rows, err := s.db.QueryContext(ctx, query, scheduleID)
 	if err != nil {
 		return nil, err
@@ -270,14 +432,165 @@ func (s *SqliteStore) GetHistoryLogs(ctx context.Context, scheduleID string) ([]
 	var logs []models.HistoryLog
 	for rows.Next() {
 		var log models.HistoryLog
-		if err := rows.Scan(&log.ID, &log.ScheduleID, &log.ScheduleName, &log.TriggerTime, &log.ExecutionDuration, &log.Status, &log.ErrorMessage, &log.Recipients, &log.ReportURL); err != nil {
+		if err := rows.Scan(&log.ID, &log.ScheduleID, &log.ScheduleName, &log.TriggerTime, &log.ExecutionDuration, &log.Status, &log.ErrorMessage, &log.Recipients, &log.ReportURL, &log.ReferenceTime); err != nil {
 			return nil, err
 		}
 		logs = append(logs, log)
 	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	rows.Close()
+	if err := s.attachReportResults(ctx, logs); err != nil {
+		return nil, err
+	}
 	return logs, nil
 }
 
+func (s *SqliteStore) GetHistoryLogsBefore(ctx context.Context, before time.Time) ([]models.HistoryLog, error) {
+	query := `SELECT id, schedule_id, schedule_name, trigger_time, execution_duration_ms, status, error_message, recipients, report_url, reference_time FROM history_logs WHERE julianday(trigger_time) < julianday(?) ORDER BY julianday(trigger_time) ASC`
+	rows, err := s.db.QueryContext(ctx, query, before)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var logs []models.HistoryLog
+	for rows.Next() {
+		var log models.HistoryLog
+		if err := rows.Scan(&log.ID, &log.ScheduleID, &log.ScheduleName, &log.TriggerTime, &log.ExecutionDuration, &log.Status, &log.ErrorMessage, &log.Recipients, &log.ReportURL, &log.ReferenceTime); err != nil {
+			return nil, err
+		}
+		logs = append(logs, log)
+	}
+	return logs, rows.Err()
+}
+
+func (s *SqliteStore) DeleteHistoryLogs(ctx context.Context, ids []string) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(ids)), ", ")
+	args := make([]interface{}, len(ids))
+	for i, id := range ids {
+		args[i] = id
+	}
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.ExecContext(ctx, fmt.Sprintf(`DELETE FROM report_results WHERE history_log_id IN (%s)`, placeholders), args...); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, fmt.Sprintf(`DELETE FROM history_logs WHERE id IN (%s)`, placeholders), args...); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// --- Task Methods ---
+
+const taskColumns = `id, schedule_id, schedule_name, status, attempt, worker_id, error_message, queued_at, started_at, finished_at, updated_at`
+
+// scanTask 讀取一筆以 taskColumns 順序查詢的任務
+func scanTask(row interface{ Scan(dest ...any) error }) (*models.Task, error) {
+	var t models.Task
+	var scheduleName, workerID, errorMessage sql.NullString
+	if err := row.Scan(&t.ID, &t.ScheduleID, &scheduleName, &t.Status, &t.Attempt, &workerID, &errorMessage, &t.QueuedAt, &t.StartedAt, &t.FinishedAt, &t.UpdatedAt); err != nil {
+		return nil, err
+	}
+	t.ScheduleName, t.WorkerID, t.ErrorMessage = scheduleName.String, workerID.String, errorMessage.String
+	return &t, nil
+}
+
+func (s *SqliteStore) CreateTask(ctx context.Context, t *models.Task) error {
+	if t.ID == "" {
+		t.ID = uuid.New().String()
+	}
+	if t.QueuedAt.IsZero() {
+		t.QueuedAt = time.Now()
+	}
+	t.UpdatedAt = time.Now()
+	query := `INSERT INTO tasks (` + taskColumns + `) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+	_, err := s.db.ExecContext(ctx, query, t.ID, t.ScheduleID, t.ScheduleName, t.Status, t.Attempt, t.WorkerID, t.ErrorMessage, t.QueuedAt, t.StartedAt, t.FinishedAt, t.UpdatedAt)
+	return err
+}
+
+func (s *SqliteStore) UpdateTask(ctx context.Context, t *models.Task) error {
+	t.UpdatedAt = time.Now()
+	query := `UPDATE tasks SET schedule_id = ?, schedule_name = ?, status = ?, attempt = ?, worker_id = ?, error_message = ?, queued_at = ?, started_at = ?, finished_at = ?, updated_at = ? WHERE id = ?`
+	_, err := s.db.ExecContext(ctx, query, t.ScheduleID, t.ScheduleName, t.Status, t.Attempt, t.WorkerID, t.ErrorMessage, t.QueuedAt, t.StartedAt, t.FinishedAt, t.UpdatedAt, t.ID)
+	return err
+}
+
+func (s *SqliteStore) CancelQueuedTask(ctx context.Context, id string, finishedAt time.Time) (bool, error) {
+	query := `UPDATE tasks SET status = ?, finished_at = ?, updated_at = ? WHERE id = ? AND status = ?`
+	res, err := s.db.ExecContext(ctx, query, models.TaskStatusCancelled, finishedAt, time.Now(), id, models.TaskStatusQueued)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+func (s *SqliteStore) GetTaskByID(ctx context.Context, id string) (*models.Task, error) {
+	row := s.db.QueryRowContext(ctx, `SELECT `+taskColumns+` FROM tasks WHERE id = ?`, id)
+	t, err := scanTask(row)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return t, err
+}
+
+func (s *SqliteStore) GetTasks(ctx context.Context, filter models.TaskFilter) ([]models.Task, error) {
+	var conditions []string
+	var args []interface{}
+	if filter.ScheduleID != "" {
+		conditions = append(conditions, "schedule_id = ?")
+		args = append(args, filter.ScheduleID)
+	}
+	if filter.Status != "" {
+		conditions = append(conditions, "status = ?")
+		args = append(args, filter.Status)
+	}
+	query := `SELECT ` + taskColumns + ` FROM tasks`
+	if len(conditions) > 0 {
+		query += " WHERE " + strings.Join(conditions, " AND ")
+	}
+	query += " ORDER BY julianday(queued_at) DESC"
+	if filter.Limit > 0 {
+		query += " LIMIT ?"
+		args = append(args, filter.Limit)
+	}
+
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var tasks []models.Task
+	for rows.Next() {
+		t, err := scanTask(rows)
+		if err != nil {
+			return nil, err
+		}
+		tasks = append(tasks, *t)
+	}
+	return tasks, rows.Err()
+}
+
+func (s *SqliteStore) DeleteFinishedTasksBefore(ctx context.Context, before time.Time) (int, error) {
+	query := `DELETE FROM tasks WHERE status IN (?, ?, ?) AND finished_at IS NOT NULL AND julianday(finished_at) < julianday(?)`
+	res, err := s.db.ExecContext(ctx, query, models.TaskStatusSucceeded, models.TaskStatusFailed, models.TaskStatusCancelled, before)
+	if err != nil {
+		return 0, err
+	}
+	n, err := res.RowsAffected()
+	return int(n), err
+}
+
 // Close 關閉資料庫連線
 func (s *SqliteStore) Close() error {
 	return s.db.Close()
@@ -290,15 +603,15 @@ func (s *SqliteStore) CreateSchedule(ctx context.Context, sc *models.Schedule) e
 	sc.CreatedAt = time.Now()
 	sc.UpdatedAt = time.Now()
 
-	query := `INSERT INTO schedules (id, name, cron_spec, timezone, recipients, email_subject, email_body, report_ids, is_enabled, created_at, updated_at)
-			  VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+	query := `INSERT INTO schedules (id, name, cron_spec, timezone, recipients, email_subject, email_body, report_ids, is_enabled, max_retries, retry_delay_seconds, retention_days, parameters, created_at, updated_at)
+			  VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
 
-	_, err := s.db.ExecContext(ctx, query, sc.ID, sc.Name, sc.CronSpec, sc.Timezone, sc.Recipients, sc.EmailSubject, sc.EmailBody, sc.ReportIDs, sc.IsEnabled, sc.CreatedAt, sc.UpdatedAt)
+	_, err := s.db.ExecContext(ctx, query, sc.ID, sc.Name, sc.CronSpec, sc.Timezone, sc.Recipients, sc.EmailSubject, sc.EmailBody, sc.ReportIDs, sc.IsEnabled, sc.MaxRetries, sc.RetryDelaySeconds, sc.RetentionDays, sc.Parameters, sc.CreatedAt, sc.UpdatedAt)
 	return err
 }
 
 func (s *SqliteStore) GetSchedules(ctx context.Context) ([]models.Schedule, error) {
-	query := `SELECT id, name, cron_spec, timezone, recipients, email_subject, email_body, report_ids, is_enabled, created_at, updated_at FROM schedules`
+	query := `SELECT id, name, cron_spec, timezone, recipients, email_subject, email_body, report_ids, is_enabled, max_retries, retry_delay_seconds, retention_days, parameters, created_at, updated_at FROM schedules`
 	rows, err := s.db.QueryContext(ctx, query)
 	if err != nil {
 		return nil, err
@@ -308,7 +621,7 @@ func (s *SqliteStore) GetSchedules(ctx context.Context) ([]models.Schedule, erro
 	var schedules []models.Schedule
 	for rows.Next() {
 		var sc models.Schedule
-		if err := rows.Scan(&sc.ID, &sc.Name, &sc.CronSpec, &sc.Timezone, &sc.Recipients, &sc.EmailSubject, &sc.EmailBody, &sc.ReportIDs, &sc.IsEnabled, &sc.CreatedAt, &sc.UpdatedAt); err != nil {
+		if err := rows.Scan(&sc.ID, &sc.Name, &sc.CronSpec, &sc.Timezone, &sc.Recipients, &sc.EmailSubject, &sc.EmailBody, &sc.ReportIDs, &sc.IsEnabled, &sc.MaxRetries, &sc.RetryDelaySeconds, &sc.RetentionDays, &sc.Parameters, &sc.CreatedAt, &sc.UpdatedAt); err != nil {
 			return nil, err
 		}
 		schedules = append(schedules, sc)
@@ -317,11 +630,11 @@ func (s *SqliteStore) GetSchedules(ctx context.Context) ([]models.Schedule, erro
 }
 
 func (s *SqliteStore) GetScheduleByID(ctx context.Context, id string) (*models.Schedule, error) {
-	query := `SELECT id, name, cron_spec, timezone, recipients, email_subject, email_body, report_ids, is_enabled, created_at, updated_at FROM schedules WHERE id = ?`
+	query := `SELECT id, name, cron_spec, timezone, recipients, email_subject, email_body, report_ids, is_enabled, max_retries, retry_delay_seconds, retention_days, parameters, created_at, updated_at FROM schedules WHERE id = ?`
 	row := s.db.QueryRowContext(ctx, query, id)
 
 	var sc models.Schedule
-	err := row.Scan(&sc.ID, &sc.Name, &sc.CronSpec, &sc.Timezone, &sc.Recipients, &sc.EmailSubject, &sc.EmailBody, &sc.ReportIDs, &sc.IsEnabled, &sc.CreatedAt, &sc.UpdatedAt)
+	err := row.Scan(&sc.ID, &sc.Name, &sc.CronSpec, &sc.Timezone, &sc.Recipients, &sc.EmailSubject, &sc.EmailBody, &sc.ReportIDs, &sc.IsEnabled, &sc.MaxRetries, &sc.RetryDelaySeconds, &sc.RetentionDays, &sc.Parameters, &sc.CreatedAt, &sc.UpdatedAt)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return nil, nil
@@ -333,8 +646,8 @@ func (s *SqliteStore) GetScheduleByID(ctx context.Context, id string) (*models.S
 
 func (s *SqliteStore) UpdateSchedule(ctx context.Context, id string, sc *models.Schedule) error {
 	sc.UpdatedAt = time.Now()
-	query := `UPDATE schedules SET name = ?, cron_spec = ?, timezone = ?, recipients = ?, email_subject = ?, email_body = ?, report_ids = ?, is_enabled = ?, updated_at = ? WHERE id = ?`
-	_, err := s.db.ExecContext(ctx, query, sc.Name, sc.CronSpec, sc.Timezone, sc.Recipients, sc.EmailSubject, sc.EmailBody, sc.ReportIDs, sc.IsEnabled, sc.UpdatedAt, id)
+	query := `UPDATE schedules SET name = ?, cron_spec = ?, timezone = ?, recipients = ?, email_subject = ?, email_body = ?, report_ids = ?, is_enabled = ?, max_retries = ?, retry_delay_seconds = ?, retention_days = ?, parameters = ?, updated_at = ? WHERE id = ?`
+	_, err := s.db.ExecContext(ctx, query, sc.Name, sc.CronSpec, sc.Timezone, sc.Recipients, sc.EmailSubject, sc.EmailBody, sc.ReportIDs, sc.IsEnabled, sc.MaxRetries, sc.RetryDelaySeconds, sc.RetentionDays, sc.Parameters, sc.UpdatedAt, id)
 	return err
 }
 
@@ -351,15 +664,15 @@ func (s *SqliteStore) CreateReportDefinition(ctx context.Context, rd *models.Rep
 	rd.CreatedAt = time.Now()
 	rd.UpdatedAt = time.Now()
 
-	query := `INSERT INTO report_definitions (id, name, description, datasource_id, time_range, elements, created_at, updated_at)
-			  VALUES (?, ?, ?, ?, ?, ?, ?, ?)`
+	query := `INSERT INTO report_definitions (id, name, description, datasource_id, time_range, elements, cover_page, table_of_contents, created_at, updated_at)
+			  VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
 
-	_, err := s.db.ExecContext(ctx, query, rd.ID, rd.Name, rd.Description, rd.DataSourceID, rd.TimeRange, rd.Elements, rd.CreatedAt, rd.UpdatedAt)
+	_, err := s.db.ExecContext(ctx, query, rd.ID, rd.Name, rd.Description, rd.DataSourceID, rd.TimeRange, rd.Elements, rd.CoverPage, rd.TableOfContents, rd.CreatedAt, rd.UpdatedAt)
 	return err
 }
 
 func (s *SqliteStore) GetReportDefinitions(ctx context.Context) ([]models.ReportDefinition, error) {
-	query := `SELECT id, name, description, datasource_id, time_range, elements, created_at, updated_at FROM report_definitions`
+	query := `SELECT id, name, description, datasource_id, time_range, elements, cover_page, table_of_contents, created_at, updated_at FROM report_definitions`
 	rows, err := s.db.QueryContext(ctx, query)
 	if err != nil {
 		return nil, err
@@ -369,7 +682,7 @@ func (s *SqliteStore) GetReportDefinitions(ctx context.Context) ([]models.Report
 	var reports []models.ReportDefinition
 	for rows.Next() {
 		var rd models.ReportDefinition
-		if err := rows.Scan(&rd.ID, &rd.Name, &rd.Description, &rd.DataSourceID, &rd.TimeRange, &rd.Elements, &rd.CreatedAt, &rd.UpdatedAt); err != nil {
+		if err := rows.Scan(&rd.ID, &rd.Name, &rd.Description, &rd.DataSourceID, &rd.TimeRange, &rd.Elements, &rd.CoverPage, &rd.TableOfContents, &rd.CreatedAt, &rd.UpdatedAt); err != nil {
 			return nil, err
 		}
 		reports = append(reports, rd)
@@ -378,11 +691,11 @@ func (s *SqliteStore) GetReportDefinitions(ctx context.Context) ([]models.Report
 }
 
 func (s *SqliteStore) GetReportDefinitionByID(ctx context.Context, id string) (*models.ReportDefinition, error) {
-	query := `SELECT id, name, description, datasource_id, time_range, elements, created_at, updated_at FROM report_definitions WHERE id = ?`
+	query := `SELECT id, name, description, datasource_id, time_range, elements, cover_page, table_of_contents, created_at, updated_at FROM report_definitions WHERE id = ?`
 	row := s.db.QueryRowContext(ctx, query, id)
 
 	var rd models.ReportDefinition
-	err := row.Scan(&rd.ID, &rd.Name, &rd.Description, &rd.DataSourceID, &rd.TimeRange, &rd.Elements, &rd.CreatedAt, &rd.UpdatedAt)
+	err := row.Scan(&rd.ID, &rd.Name, &rd.Description, &rd.DataSourceID, &rd.TimeRange, &rd.Elements, &rd.CoverPage, &rd.TableOfContents, &rd.CreatedAt, &rd.UpdatedAt)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return nil, nil
@@ -394,8 +707,8 @@ func (s *SqliteStore) GetReportDefinitionByID(ctx context.Context, id string) (*
 
 func (s *SqliteStore) UpdateReportDefinition(ctx context.Context, id string, rd *models.ReportDefinition) error {
 	rd.UpdatedAt = time.Now()
-	query := `UPDATE report_definitions SET name = ?, description = ?, datasource_id = ?, time_range = ?, elements = ?, updated_at = ? WHERE id = ?`
-	_, err := s.db.ExecContext(ctx, query, rd.Name, rd.Description, rd.DataSourceID, rd.TimeRange, rd.Elements, rd.UpdatedAt, id)
+	query := `UPDATE report_definitions SET name = ?, description = ?, datasource_id = ?, time_range = ?, elements = ?, cover_page = ?, table_of_contents = ?, updated_at = ? WHERE id = ?`
+	_, err := s.db.ExecContext(ctx, query, rd.Name, rd.Description, rd.DataSourceID, rd.TimeRange, rd.Elements, rd.CoverPage, rd.TableOfContents, rd.UpdatedAt, id)
 	return err
 }
 
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"report-scheduler/backend/internal/config"
 	"report-scheduler/backend/internal/models"
+	"time"
 )
 
 // Store 是我們資料存取層的介面。
@@ -40,6 +41,24 @@ type Store interface {
 	CreateHistoryLog(ctx context.Context, log *models.HistoryLog) error
 	GetHistoryLogs(ctx context.Context, scheduleID string) ([]models.HistoryLog, error)
 	GetHistoryLogByID(ctx context.Context, id string) (*models.HistoryLog, error)
+	// GetHistoryLogsBefore 返回所有排程中 trigger_time 早於 before 的歷史紀錄，依時間由舊到新排序
+	GetHistoryLogsBefore(ctx context.Context, before time.Time) ([]models.HistoryLog, error)
+	// DeleteHistoryLogs 根據 ID 刪除多筆歷史紀錄
+	DeleteHistoryLogs(ctx context.Context, ids []string) error
+
+	// --- Task Methods ---
+	// CreateTask 建立一筆任務紀錄，ID 由呼叫端指定 (與佇列中的任務 ID 相同)
+	CreateTask(ctx context.Context, t *models.Task) error
+	UpdateTask(ctx context.Context, t *models.Task) error
+	// CancelQueuedTask 以條件式更新將仍在佇列中 (queued) 的任務標記為已取消，
+	// 任務已被取出執行或已經結束時不會更新並返回 false
+	CancelQueuedTask(ctx context.Context, id string, finishedAt time.Time) (bool, error)
+	// GetTaskByID 根據 ID 返回任務紀錄，找不到時返回 nil, nil
+	GetTaskByID(ctx context.Context, id string) (*models.Task, error)
+	// GetTasks 返回符合篩選條件的任務，依推入佇列的時間由新到舊排序
+	GetTasks(ctx context.Context, filter models.TaskFilter) ([]models.Task, error)
+	// DeleteFinishedTasksBefore 刪除已經結束且 finished_at 早於 before 的任務紀錄，返回刪除的筆數
+	DeleteFinishedTasksBefore(ctx context.Context, before time.Time) (int, error)
 
 	// Close 關閉與資料庫的連線
 	Close() error
@@ -0,0 +1,317 @@
+// Package timerange 解析報表的時間範圍，語法與 Kibana / Grafana 的 date math 相容。
+//
+// 時間範圍可以是：
+//   - 單一運算式，例如 "now-7d" (從 7 天前到現在)。運算式帶有捨入時 (例如 "now-1M/M") 代表整個期間，
+//     起始時間向下捨入、結束時間向上捨入，也就是上個月的第一毫秒到最後一毫秒。
+//     注意 "now-7d/d" 因此只代表 7 天前的那一整天，而不是從 7 天前到現在；
+//     為了避免誤解，Validate 只接受捷徑形式的單一捨入運算式，其餘必須寫成起訖時間。
+//   - 以 " to " 或 "," 分隔的起訖時間，例如 "now-1w/w to now/w"、"2024-05-01,2024-05-31||/d"。
+//     起始時間向下捨入，結束時間向上捨入。
+//   - 常用的捷徑，例如 "today"、"yesterday"、"last month"。
+//
+// 運算式由錨點與任意個運算組成。錨點是 now，或是絕對時間 (必須以 "||" 與後面的運算分隔)；
+// 運算為 "+<n><單位>"、"-<n><單位>" 或 "/<單位>"，單位為 y、M、w、d、h (H)、m、s。
+// 捨入與沒有時區的絕對時間都以指定的時區計算，一週從星期一開始。
+package timerange
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// shortcuts 是常用期間的別名，對應到 Kibana 快速選單中的同名選項
+var shortcuts = map[string]string{
+	"today":      "now/d",
+	"yesterday":  "now-1d/d",
+	"this week":  "now/w",
+	"last week":  "now-1w/w",
+	"this month": "now/M",
+	"last month": "now-1M/M",
+	"this year":  "now/y",
+	"last year":  "now-1y/y",
+}
+
+// 沒有時區的絕對時間可以使用的格式
+var absoluteLayouts = []string{
+	"2006-01-02T15:04:05.999999999",
+	"2006-01-02T15:04:05",
+	"2006-01-02T15:04",
+	"2006-01-02 15:04:05",
+	"2006-01-02 15:04",
+	"2006-01-02",
+	"2006-01",
+	"2006",
+}
+
+// Validate 檢查時間範圍的語法以及起始時間是否早於結束時間，空字串代表不限制時間。
+// 帶有捨入的單一運算式 (例如 "now-7d/d") 只涵蓋捨入後的單一期間，必須改寫為明確的起訖時間，
+// 對應到整個期間的捷徑 (例如 "yesterday") 則不受此限制。
+func Validate(expr string) error {
+	if strings.TrimSpace(expr) == "" {
+		return nil
+	}
+	if _, _, err := Resolve(expr, time.Now(), time.UTC); err != nil {
+		return err
+	}
+
+	normalized := strings.Join(strings.Fields(expr), " ")
+	if _, ok := lookupShortcut(normalized); ok {
+		return nil
+	}
+	if _, _, isPair := splitRange(normalized); isPair {
+		return nil
+	}
+	if single, err := parse(normalized); err == nil && single.rounded() {
+		return fmt.Errorf("帶有捨入的時間範圍 '%s' 只代表捨入後的那一整個期間，而不是到現在為止；"+
+			"請明確寫出起訖時間，例如 '%s to now' 或 '%s to %s'", normalized, normalized, normalized, normalized)
+	}
+	return nil
+}
+
+// lookupShortcut 回傳捷徑對應的運算式，比對時不分大小寫並允許以底線代替空白
+func lookupShortcut(expr string) (string, bool) {
+	alias, ok := shortcuts[strings.ToLower(strings.ReplaceAll(expr, "_", " "))]
+	return alias, ok
+}
+
+// Resolve 以 now 為基準、在 loc 時區中將時間範圍解析為絕對的起訖時間
+func Resolve(expr string, now time.Time, loc *time.Location) (from, to time.Time, err error) {
+	if loc == nil {
+		loc = time.Local
+	}
+	now = now.In(loc)
+
+	normalized := strings.Join(strings.Fields(expr), " ")
+	if alias, ok := lookupShortcut(normalized); ok {
+		normalized = alias
+	}
+	if normalized == "" {
+		return time.Time{}, time.Time{}, fmt.Errorf("時間範圍不可為空")
+	}
+
+	fromExpr, toExpr, isPair := splitRange(normalized)
+	if !isPair {
+		single, err := parse(normalized)
+		if err != nil {
+			return time.Time{}, time.Time{}, err
+		}
+		from = single.evaluate(now, loc, false)
+		to = now
+		if single.rounded() {
+			to = single.evaluate(now, loc, true)
+		}
+	} else {
+		fromMath, err := parse(fromExpr)
+		if err != nil {
+			return time.Time{}, time.Time{}, err
+		}
+		toMath, err := parse(toExpr)
+		if err != nil {
+			return time.Time{}, time.Time{}, err
+		}
+		from = fromMath.evaluate(now, loc, false)
+		to = toMath.evaluate(now, loc, true)
+	}
+
+	if from.After(to) {
+		return time.Time{}, time.Time{}, fmt.Errorf("時間範圍 '%s' 的起始時間 (%s) 晚於結束時間 (%s)",
+			expr, from.Format(time.RFC3339), to.Format(time.RFC3339))
+	}
+	return from, to, nil
+}
+
+// splitRange 將 "<from> to <to>" 或 "<from>,<to>" 拆成起訖兩個運算式
+func splitRange(expr string) (from, to string, ok bool) {
+	for _, sep := range []string{" to ", ","} {
+		if from, to, ok := strings.Cut(expr, sep); ok {
+			return strings.TrimSpace(from), strings.TrimSpace(to), true
+		}
+	}
+	return "", "", false
+}
+
+// operation 是運算式中的一個加減或捨入
+type operation struct {
+	op     byte // '+'、'-' 或 '/'
+	amount int
+	unit   byte
+}
+
+// mathExpr 是解析後的 date math 運算式
+type mathExpr struct {
+	// anchor 為 nil 時代表 now
+	anchor *anchorTime
+	ops    []operation
+}
+
+// anchorTime 是絕對時間的錨點；hasZone 為 false 時以指定的時區解讀
+type anchorTime struct {
+	t       time.Time
+	hasZone bool
+}
+
+// parse 解析單一運算式
+func parse(expr string) (*mathExpr, error) {
+	m := &mathExpr{}
+	rest := expr
+	switch {
+	case strings.HasPrefix(expr, "now"):
+		rest = expr[len("now"):]
+	default:
+		absolute, ops, hasOps := strings.Cut(expr, "||")
+		anchor, err := parseAbsolute(absolute)
+		if err != nil {
+			return nil, fmt.Errorf("無效的時間 '%s': %w", expr, err)
+		}
+		m.anchor = anchor
+		rest = ops
+		if !hasOps {
+			rest = ""
+		}
+	}
+
+	for i := 0; i < len(rest); {
+		op := rest[i]
+		if op != '+' && op != '-' && op != '/' {
+			return nil, fmt.Errorf("無效的時間運算式 '%s': 位置 %d 應為 +、- 或 /", expr, i)
+		}
+		i++
+
+		amount := 1
+		if op != '/' {
+			start := i
+			for i < len(rest) && rest[i] >= '0' && rest[i] <= '9' {
+				i++
+			}
+			if i > start {
+				n, err := strconv.Atoi(rest[start:i])
+				if err != nil {
+					return nil, fmt.Errorf("無效的時間運算式 '%s': %w", expr, err)
+				}
+				amount = n
+			}
+		}
+
+		if i >= len(rest) {
+			return nil, fmt.Errorf("無效的時間運算式 '%s': 缺少時間單位", expr)
+		}
+		unit := rest[i]
+		if unit == 'H' {
+			unit = 'h'
+		}
+		if !strings.ContainsRune("yMwdhms", rune(unit)) {
+			return nil, fmt.Errorf("無效的時間運算式 '%s': 不支援的時間單位 '%c'", expr, rest[i])
+		}
+		i++
+		m.ops = append(m.ops, operation{op: op, amount: amount, unit: unit})
+	}
+	return m, nil
+}
+
+// parseAbsolute 解析絕對時間：RFC 3339、沒有時區的 ISO 8601 日期時間，或以毫秒表示的 Unix 時間
+func parseAbsolute(value string) (*anchorTime, error) {
+	if t, err := time.Parse(time.RFC3339Nano, value); err == nil {
+		return &anchorTime{t: t, hasZone: true}, nil
+	}
+	if len(value) > 4 && strings.Trim(value, "0123456789") == "" {
+		ms, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		return &anchorTime{t: time.UnixMilli(ms), hasZone: true}, nil
+	}
+	for _, layout := range absoluteLayouts {
+		if t, err := time.Parse(layout, value); err == nil {
+			return &anchorTime{t: t}, nil
+		}
+	}
+	return nil, fmt.Errorf("無法辨識的時間格式")
+}
+
+// rounded 表示運算式是否包含捨入
+func (m *mathExpr) rounded() bool {
+	for _, op := range m.ops {
+		if op.op == '/' {
+			return true
+		}
+	}
+	return false
+}
+
+// evaluate 計算運算式的時間；roundUp 為 true 時捨入會取該期間的最後一毫秒
+func (m *mathExpr) evaluate(now time.Time, loc *time.Location, roundUp bool) time.Time {
+	t := now
+	if m.anchor != nil {
+		t = m.anchor.t.In(loc)
+		if !m.anchor.hasZone {
+			a := m.anchor.t
+			t = time.Date(a.Year(), a.Month(), a.Day(), a.Hour(), a.Minute(), a.Second(), a.Nanosecond(), loc)
+		}
+	}
+
+	for _, op := range m.ops {
+		switch op.op {
+		case '+':
+			t = add(t, op.unit, op.amount)
+		case '-':
+			t = add(t, op.unit, -op.amount)
+		case '/':
+			t = floor(t, op.unit)
+			if roundUp {
+				t = add(t, op.unit, 1).Add(-time.Millisecond)
+			}
+		}
+	}
+	return t
+}
+
+// add 將時間加上 amount 個單位，加減月份時超過月底的日期會停在該月最後一天 (例如 3/31 減一個月為 2/29)
+func add(t time.Time, unit byte, amount int) time.Time {
+	switch unit {
+	case 'y':
+		return addMonths(t, amount*12)
+	case 'M':
+		return addMonths(t, amount)
+	case 'w':
+		return t.AddDate(0, 0, amount*7)
+	case 'd':
+		return t.AddDate(0, 0, amount)
+	case 'h':
+		return t.Add(time.Duration(amount) * time.Hour)
+	case 'm':
+		return t.Add(time.Duration(amount) * time.Minute)
+	default:
+		return t.Add(time.Duration(amount) * time.Second)
+	}
+}
+
+func addMonths(t time.Time, months int) time.Time {
+	first := time.Date(t.Year(), t.Month()+time.Month(months), 1, t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), t.Location())
+	lastDay := first.AddDate(0, 1, -1).Day()
+	return time.Date(first.Year(), first.Month(), min(t.Day(), lastDay), t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), t.Location())
+}
+
+// floor 將時間向下捨入到單位的開頭
+func floor(t time.Time, unit byte) time.Time {
+	y, mon, d := t.Date()
+	loc := t.Location()
+	switch unit {
+	case 'y':
+		return time.Date(y, time.January, 1, 0, 0, 0, 0, loc)
+	case 'M':
+		return time.Date(y, mon, 1, 0, 0, 0, 0, loc)
+	case 'w':
+		offset := (int(t.Weekday()) + 6) % 7
+		return time.Date(y, mon, d-offset, 0, 0, 0, 0, loc)
+	case 'd':
+		return time.Date(y, mon, d, 0, 0, 0, 0, loc)
+	case 'h':
+		return time.Date(y, mon, d, t.Hour(), 0, 0, 0, loc)
+	case 'm':
+		return time.Date(y, mon, d, t.Hour(), t.Minute(), 0, 0, loc)
+	default:
+		return time.Date(y, mon, d, t.Hour(), t.Minute(), t.Second(), 0, loc)
+	}
+}
@@ -0,0 +1,95 @@
+package timerange
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestResolve(t *testing.T) {
+	taipei, err := time.LoadLocation("Asia/Taipei")
+	require.NoError(t, err)
+	// 2024-03-31 (星期日) 10:20:30.5 台北時間
+	now := time.Date(2024, 3, 31, 10, 20, 30, 500_000_000, taipei)
+	at := func(year int, month time.Month, day, hour, min, sec, ms int) time.Time {
+		return time.Date(year, month, day, hour, min, sec, ms*1_000_000, taipei)
+	}
+
+	testCases := []struct {
+		expr     string
+		from, to time.Time
+	}{
+		{"now-7d", at(2024, 3, 24, 10, 20, 30, 500), now},
+		{"now-24h", at(2024, 3, 30, 10, 20, 30, 500), now},
+		{"now-15m", at(2024, 3, 31, 10, 5, 30, 500), now},
+		{"now-30s", at(2024, 3, 31, 10, 20, 0, 500), now},
+		{"now-2w", at(2024, 3, 17, 10, 20, 30, 500), now},
+		{"now-1y", at(2023, 3, 31, 10, 20, 30, 500), now},
+		{"now-1H", at(2024, 3, 31, 9, 20, 30, 500), now},
+		// 3/31 減一個月停在 2/29
+		{"now-1M", at(2024, 2, 29, 10, 20, 30, 500), now},
+		{"now-1M/M", at(2024, 2, 1, 0, 0, 0, 0), at(2024, 2, 29, 23, 59, 59, 999)},
+		{"now/d", at(2024, 3, 31, 0, 0, 0, 0), at(2024, 3, 31, 23, 59, 59, 999)},
+		{"now/w", at(2024, 3, 25, 0, 0, 0, 0), at(2024, 3, 31, 23, 59, 59, 999)},
+		{"now/y", at(2024, 1, 1, 0, 0, 0, 0), at(2024, 12, 31, 23, 59, 59, 999)},
+		{"now/h", at(2024, 3, 31, 10, 0, 0, 0), at(2024, 3, 31, 10, 59, 59, 999)},
+		{"last month", at(2024, 2, 1, 0, 0, 0, 0), at(2024, 2, 29, 23, 59, 59, 999)},
+		{"Last_Week", at(2024, 3, 18, 0, 0, 0, 0), at(2024, 3, 24, 23, 59, 59, 999)},
+		{"yesterday", at(2024, 3, 30, 0, 0, 0, 0), at(2024, 3, 30, 23, 59, 59, 999)},
+		{"now-7d/d to now-1d/d", at(2024, 3, 24, 0, 0, 0, 0), at(2024, 3, 30, 23, 59, 59, 999)},
+		{"now/w,now", at(2024, 3, 25, 0, 0, 0, 0), now},
+		{"now-d", at(2024, 3, 30, 10, 20, 30, 500), now},
+		{"now+1d/d-1d", at(2024, 3, 31, 0, 0, 0, 0), at(2024, 3, 31, 23, 59, 59, 999)},
+		// 沒有時區的絕對時間以指定的時區解讀
+		{"2024-03-01 to 2024-03-15", at(2024, 3, 1, 0, 0, 0, 0), at(2024, 3, 15, 0, 0, 0, 0)},
+		{"2024-03-01T08:30 to 2024-03-15||/d", at(2024, 3, 1, 8, 30, 0, 0), at(2024, 3, 15, 23, 59, 59, 999)},
+		{"2024-03-01||+1M/M", at(2024, 4, 1, 0, 0, 0, 0), at(2024, 4, 30, 23, 59, 59, 999)},
+		{"2024-03-01T00:00:00Z to 2024-03-02T00:00:00Z", time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC), time.Date(2024, 3, 2, 0, 0, 0, 0, time.UTC)},
+		{"1709251200000,now", time.UnixMilli(1709251200000), now},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.expr, func(t *testing.T) {
+			from, to, err := Resolve(tc.expr, now, taipei)
+			require.NoError(t, err)
+			require.True(t, tc.from.Equal(from), "from: want %s, got %s", tc.from, from)
+			require.True(t, tc.to.Equal(to), "to: want %s, got %s", tc.to, to)
+		})
+	}
+
+	t.Run("rounding uses the given timezone", func(t *testing.T) {
+		// 台北時間 3/31 的凌晨在 UTC 仍是 3/30
+		early := time.Date(2024, 3, 30, 17, 0, 0, 0, time.UTC)
+		from, _, err := Resolve("now/d", early, taipei)
+		require.NoError(t, err)
+		require.True(t, at(2024, 3, 31, 0, 0, 0, 0).Equal(from))
+
+		from, _, err = Resolve("now/d", early, time.UTC)
+		require.NoError(t, err)
+		require.True(t, time.Date(2024, 3, 30, 0, 0, 0, 0, time.UTC).Equal(from))
+	})
+}
+
+func TestValidate(t *testing.T) {
+	for _, expr := range []string{"", " ", "now-7d", "this year", "Last_Month", "2024-01-01 to now", "now-7d/d to now", "now-1M/M to now-1M/M"} {
+		require.NoError(t, Validate(expr), expr)
+	}
+
+	invalid := map[string]string{
+		"now-7x":               "不支援的時間單位",
+		"now-":                 "缺少時間單位",
+		"now*2d":               "應為 +、- 或 /",
+		"last fortnight":       "無效的時間",
+		"2024-13-01":           "無效的時間",
+		"2024-01-01+1d":        "無效的時間",
+		"now to now-1d":        "晚於結束時間",
+		"now-1d to yesterday!": "無效的時間",
+		// 單一的捨入運算式只涵蓋一個期間，必須明確寫出起訖時間
+		"now-7d/d": "'now-7d/d to now' 或 'now-7d/d to now-7d/d'",
+		"now-1M/M": "只代表捨入後的那一整個期間",
+	}
+	for expr, message := range invalid {
+		require.ErrorContains(t, Validate(expr), message, expr)
+	}
+}
@@ -0,0 +1,64 @@
+package worker
+
+import (
+	"context"
+	"report-scheduler/backend/internal/config"
+	"sync"
+)
+
+// DataSourceLimiter caps how many reports may be generated against the same data source at once,
+// so that a large worker pool does not flood a single Kibana or Grafana instance.
+type DataSourceLimiter struct {
+	defaultLimit int
+	limits       map[string]int
+
+	mu   sync.Mutex
+	sems map[string]chan struct{}
+}
+
+// NewDataSourceLimiter creates a limiter from the worker config.
+// A limit of 0 (or below) means the data source is not limited.
+func NewDataSourceLimiter(cfg config.WorkerConfig) *DataSourceLimiter {
+	return &DataSourceLimiter{
+		defaultLimit: cfg.DataSourceConcurrency,
+		limits:       cfg.DataSourceLimits,
+		sems:         make(map[string]chan struct{}),
+	}
+}
+
+// Acquire blocks until a slot for the data source is free or ctx is done.
+// The returned release function must be called once the work is finished.
+func (l *DataSourceLimiter) Acquire(ctx context.Context, dataSourceID string) (release func(), err error) {
+	sem := l.semaphore(dataSourceID)
+	if sem == nil {
+		return func() {}, nil
+	}
+
+	select {
+	case sem <- struct{}{}:
+		var once sync.Once
+		return func() { once.Do(func() { <-sem }) }, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// semaphore returns the semaphore for the data source, or nil if it is unlimited.
+func (l *DataSourceLimiter) semaphore(dataSourceID string) chan struct{} {
+	limit := l.defaultLimit
+	if override, ok := l.limits[dataSourceID]; ok {
+		limit = override
+	}
+	if limit <= 0 {
+		return nil
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	sem, ok := l.sems[dataSourceID]
+	if !ok {
+		sem = make(chan struct{}, limit)
+		l.sems[dataSourceID] = sem
+	}
+	return sem
+}
@@ -2,55 +2,136 @@ package worker
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"log"
+	"os"
+	"report-scheduler/backend/internal/config"
+	"report-scheduler/backend/internal/models"
 	"report-scheduler/backend/internal/queue"
+	"report-scheduler/backend/internal/store"
 	"sync"
 	"time"
 )
 
 // ProcessFunc is a function that processes a task.
-type ProcessFunc func(task *queue.Task) error
+// ctx is done when the run exceeds the worker's TaskTimeout, when the task is cancelled through
+// Worker.Cancel, or when a shutdown runs out of time; long-running work should stop promptly then.
+// Returning an error marks the attempt as failed; the worker re-enqueues the task
+// with the task's retry delay while retries remain, unless the error wraps ErrNoRetry.
+type ProcessFunc func(ctx context.Context, task *queue.Task) error
 
-// Worker pulls tasks from a queue and executes them.
+// ErrNoRetry can be wrapped by a ProcessFunc error to indicate that retrying the task is pointless
+// (e.g. the schedule has been deleted).
+var ErrNoRetry = errors.New("task is not retryable")
+
+// ErrTaskCancelled is the cause of a task context cancelled through Worker.Cancel.
+// Cancelled tasks are never retried.
+var ErrTaskCancelled = errors.New("task was cancelled")
+
+// ErrShuttingDown is the cause of the task contexts that Stop aborts once ShutdownTimeout has passed.
+// Such a run is not an attempt: the task is neither acknowledged nor retried. Its lease is released
+// so that a durable queue hands it out again right away, to another instance or after a restart.
+var ErrShuttingDown = errors.New("worker is shutting down")
+
+// defaultAbortGrace bounds how long Stop waits for aborted tasks to unwind.
+const defaultAbortGrace = 10 * time.Second
+
+// cancelledTTL bounds how long a cancellation is remembered for a task that has not been dequeued yet.
+const cancelledTTL = 24 * time.Hour
+
+// Worker pulls tasks from a queue and executes them with a pool of goroutines.
 type Worker struct {
 	Queue       queue.Queue
 	ProcessFunc ProcessFunc
+	// Concurrency is the number of goroutines pulling from the queue. Values below 1 mean 1.
+	Concurrency int
+	// ShutdownTimeout bounds how long Stop waits for in-flight tasks. Zero means wait until they finish.
+	ShutdownTimeout time.Duration
+	// TaskTimeout is the deadline for a single run of ProcessFunc. Zero means no deadline.
+	TaskTimeout time.Duration
+	// Store records the lifecycle of every task (see models.Task). Nil disables tracking.
+	Store store.Store
+	// Name identifies this process in the worker IDs recorded on tasks. It defaults to the host name.
+	Name string
 
 	wg         sync.WaitGroup
 	stop       chan struct{}
 	cancelFunc context.CancelFunc // To cancel operations like Dequeue
+	// tasksCtx is the parent of every task context. It outlives cancelFunc so that
+	// in-flight tasks keep running during a graceful shutdown until abortTasks is called.
+	tasksCtx   context.Context
+	abortTasks context.CancelCauseFunc
+	// abortGrace is how long Stop keeps waiting after aborting the remaining tasks, so that they can
+	// record their outcome before the caller closes the queue and the store.
+	abortGrace time.Duration
+
+	mu        sync.Mutex
+	inFlight  map[int]string                     // worker id -> task id currently being processed
+	running   map[string]context.CancelCauseFunc // task id -> cancels the context of its current run
+	cancelled map[string]time.Time               // task id -> when it was cancelled before being dequeued
 }
 
-// NewWorker creates a new Worker instance.
+// NewWorker creates a new Worker instance with a single goroutine.
 func NewWorker(q queue.Queue, fn ProcessFunc) *Worker {
+	tasksCtx, abortTasks := context.WithCancelCause(context.Background())
+	name, err := os.Hostname()
+	if err != nil || name == "" {
+		name = "worker"
+	}
 	return &Worker{
 		Queue:       q,
 		ProcessFunc: fn,
+		Concurrency: 1,
+		Name:        name,
 		stop:        make(chan struct{}),
+		tasksCtx:    tasksCtx,
+		abortTasks:  abortTasks,
+		abortGrace:  defaultAbortGrace,
+		inFlight:    make(map[int]string),
+		running:     make(map[string]context.CancelCauseFunc),
+		cancelled:   make(map[string]time.Time),
+	}
+}
+
+// NewWorkerPool creates a Worker whose size, shutdown deadline and per-run deadline come from the config.
+func NewWorkerPool(q queue.Queue, fn ProcessFunc, cfg config.WorkerConfig) *Worker {
+	w := NewWorker(q, fn)
+	if cfg.Concurrency > 1 {
+		w.Concurrency = cfg.Concurrency
 	}
+	w.ShutdownTimeout = cfg.ShutdownTimeout
+	w.TaskTimeout = cfg.TaskTimeout
+	return w
 }
 
-// Start begins the worker's main loop in a new goroutine.
+// Start begins the worker loops in new goroutines.
 func (w *Worker) Start() {
-	log.Println("啟動 Worker 服務...")
+	size := w.Concurrency
+	if size < 1 {
+		size = 1
+	}
+	log.Printf("啟動 Worker 服務 (共 %d 個 Worker)...", size)
 
 	// Create a context that can be cancelled by the Stop method.
 	ctx, cancel := context.WithCancel(context.Background())
 	w.cancelFunc = cancel
 
-	w.wg.Add(1)
-	go w.run(ctx)
+	for id := 1; id <= size; id++ {
+		w.wg.Add(1)
+		go w.run(ctx, id)
+	}
 }
 
-// run is the main loop for the worker.
-func (w *Worker) run(ctx context.Context) {
+// run is the main loop for a single worker goroutine.
+func (w *Worker) run(ctx context.Context, id int) {
 	defer w.wg.Done()
-	log.Println("Worker run 迴圈已啟動")
+	log.Printf("Worker #%d run 迴圈已啟動", id)
 	for {
 		// Prioritize the stop signal.
 		select {
 		case <-w.stop:
-			log.Println("Worker 收到停止信號，正在退出 run 迴圈...")
+			log.Printf("Worker #%d 收到停止信號，正在退出 run 迴圈...", id)
 			return
 		default:
 			// Non-blocking check for the stop signal.
@@ -61,7 +142,7 @@ func (w *Worker) run(ctx context.Context) {
 		if err != nil {
 			// If the context was cancelled, it's part of a graceful shutdown.
 			if err == context.Canceled || err == queue.ErrQueueClosed {
-				log.Println("Worker Dequeue 被中斷或佇列已關閉，正在停止...")
+				log.Printf("Worker #%d Dequeue 被中斷或佇列已關閉，正在停止...", id)
 				return
 			}
 			log.Printf("錯誤：Worker 無法從佇列中取出任務: %v", err)
@@ -70,20 +151,240 @@ func (w *Worker) run(ctx context.Context) {
 		}
 
 		// Process the task.
-		log.Printf("Worker 開始處理任務: %s (來自排程 ID: %s)", task.ID, task.ScheduleID)
-		if err := w.ProcessFunc(task); err != nil {
-			log.Printf("錯誤：處理任務 %s 失敗: %v", task.ID, err)
+		if w.process(id, task) {
+			// Acknowledge only after processing (and scheduling any retry) so that a crash
+			// mid-task leaves it in a durable queue to be picked up again.
+			w.ack(task)
 		} else {
-			log.Printf("Worker 完成處理任務: %s", task.ID)
+			w.release(task)
 		}
 	}
 }
 
-// Stop gracefully stops the worker.
-func (w *Worker) Stop() {
+// process runs ProcessFunc with a context of its own and schedules a retry if it fails.
+// It returns false if the run was aborted by a shutdown, in which case the task must not be
+// acknowledged but released so that a durable queue hands it out again.
+func (w *Worker) process(id int, task *queue.Task) bool {
+	ctx, cancel := context.WithCancelCause(w.tasksCtx)
+	defer cancel(nil)
+	if w.TaskTimeout > 0 {
+		var cancelTimeout context.CancelFunc
+		ctx, cancelTimeout = context.WithTimeout(ctx, w.TaskTimeout)
+		defer cancelTimeout()
+	}
+
+	// A task can be cancelled while it waits in the queue, either on this worker or,
+	// with a shared queue, through the task record on another instance.
+	if !w.begin(id, task.ID, cancel) {
+		log.Printf("Worker #%d 略過已取消的任務: %s", id, task.ID)
+		w.finish(task, models.TaskStatusCancelled, nil)
+		return true
+	}
+	defer w.end(id)
+	if w.cancelledInStore(task.ID) {
+		log.Printf("Worker #%d 略過已取消的任務: %s", id, task.ID)
+		return true
+	}
+
+	log.Printf("Worker #%d 開始處理任務: %s (來自排程 ID: %s)", id, task.ID, task.ScheduleID)
+	startedAt := time.Now()
+	workerID := fmt.Sprintf("%s#%d", w.Name, id)
+	w.track(task, func(record *models.Task) {
+		record.Status = models.TaskStatusRunning
+		record.Attempt = task.Attempt
+		record.WorkerID = workerID
+		record.ErrorMessage = ""
+		record.StartedAt = &startedAt
+		record.FinishedAt = nil
+	})
+
+	err := w.ProcessFunc(ctx, task)
+	switch {
+	case err == nil:
+		log.Printf("Worker #%d 完成處理任務: %s", id, task.ID)
+		w.finish(task, models.TaskStatusSucceeded, nil)
+	case errors.Is(context.Cause(ctx), ErrTaskCancelled):
+		log.Printf("任務 %s 已被取消: %v", task.ID, err)
+		w.finish(task, models.TaskStatusCancelled, err)
+	case errors.Is(context.Cause(ctx), ErrShuttingDown):
+		log.Printf("任務 %s 因服務停止而中止，將歸還佇列並再次執行: %v", task.ID, err)
+		w.requeued(task, task.Attempt, err)
+		return false
+	default:
+		log.Printf("錯誤：處理任務 %s 失敗 (第 %d 次嘗試): %v", task.ID, task.Attempt+1, err)
+		if w.retry(task, err) {
+			w.requeued(task, task.Attempt+1, err)
+		} else {
+			w.finish(task, models.TaskStatusFailed, err)
+		}
+	}
+	return true
+}
+
+// track applies update to the persisted record of a task and saves it. A record is created
+// for tasks that were enqueued without one. Tracking errors are only logged so that they
+// never affect processing.
+func (w *Worker) track(task *queue.Task, update func(record *models.Task)) {
+	if w.Store == nil {
+		return
+	}
+	// A background context is used so that the final state is saved even when the run was cancelled.
+	ctx := context.Background()
+	record, err := w.Store.GetTaskByID(ctx, task.ID)
+	if err == nil {
+		if record == nil {
+			record = &models.Task{ID: task.ID, ScheduleID: task.ScheduleID, QueuedAt: task.CreatedAt}
+			update(record)
+			err = w.Store.CreateTask(ctx, record)
+		} else {
+			update(record)
+			err = w.Store.UpdateTask(ctx, record)
+		}
+	}
+	if err != nil {
+		log.Printf("錯誤：無法更新任務 %s 的狀態: %v", task.ID, err)
+	}
+}
+
+// finish records that a task has reached a final status.
+func (w *Worker) finish(task *queue.Task, status models.TaskStatus, err error) {
+	finishedAt := time.Now()
+	w.track(task, func(record *models.Task) {
+		record.Status = status
+		record.FinishedAt = &finishedAt
+		if err != nil {
+			record.ErrorMessage = err.Error()
+		}
+	})
+}
+
+// requeued records that a task is waiting in the queue again for the given attempt.
+func (w *Worker) requeued(task *queue.Task, attempt int, err error) {
+	w.track(task, func(record *models.Task) {
+		record.Status = models.TaskStatusQueued
+		record.Attempt = attempt
+		record.ErrorMessage = err.Error()
+	})
+}
+
+// cancelledInStore reports whether the task record has been marked as cancelled.
+func (w *Worker) cancelledInStore(taskID string) bool {
+	if w.Store == nil {
+		return false
+	}
+	record, err := w.Store.GetTaskByID(context.Background(), taskID)
+	return err == nil && record != nil && record.Status == models.TaskStatusCancelled
+}
+
+// ack acknowledges a task. A background context is used because the run context
+// is already cancelled during shutdown.
+func (w *Worker) ack(task *queue.Task) {
+	if err := w.Queue.Ack(context.Background(), task); err != nil {
+		log.Printf("錯誤：無法確認任務 %s 已完成: %v", task.ID, err)
+	}
+}
+
+// release gives the lease of an aborted task back to the queue. Like ack, it uses a background
+// context because the run context is already cancelled.
+func (w *Worker) release(task *queue.Task) {
+	if err := w.Queue.Release(context.Background(), task); err != nil {
+		log.Printf("錯誤：無法歸還中止的任務 %s: %v", task.ID, err)
+	}
+}
+
+// retry re-enqueues a failed task after its retry delay if it still has retries left.
+// It reports whether the task was re-enqueued.
+func (w *Worker) retry(task *queue.Task, err error) bool {
+	if errors.Is(err, ErrNoRetry) || !task.CanRetry() {
+		log.Printf("任務 %s 不再重試", task.ID)
+		return false
+	}
+
+	next := *task
+	next.Attempt++
+	log.Printf("任務 %s 將在 %s 後進行第 %d/%d 次重試", task.ID, task.RetryDelay, next.Attempt, task.MaxRetries)
+	if err := w.Queue.EnqueueAfter(context.Background(), &next, task.RetryDelay); err != nil {
+		log.Printf("錯誤：無法將任務 %s 重新加入佇列: %v", task.ID, err)
+		return false
+	}
+	return true
+}
+
+// begin marks a task as in flight on worker goroutine id. It returns false without doing so
+// if the task was cancelled before it was dequeued; the check and the registration happen
+// under the same lock so that a concurrent Cancel either sees the task running or is seen here.
+func (w *Worker) begin(id int, taskID string, cancel context.CancelCauseFunc) bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if _, ok := w.cancelled[taskID]; ok {
+		delete(w.cancelled, taskID)
+		return false
+	}
+	w.inFlight[id] = taskID
+	w.running[taskID] = cancel
+	return true
+}
+
+// end clears the task in flight on worker goroutine id.
+func (w *Worker) end(id int) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	delete(w.running, w.inFlight[id])
+	delete(w.inFlight, id)
+}
+
+// Cancel aborts a task that is being processed by this worker: its context is cancelled and it is
+// not retried. It reports whether the task was running here; otherwise nothing is changed.
+func (w *Worker) Cancel(taskID string) bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if cancel, ok := w.running[taskID]; ok {
+		cancel(ErrTaskCancelled)
+		return true
+	}
+	return false
+}
+
+// CancelQueued drops a task that has been cancelled while waiting in the queue, which also covers
+// a pending retry. It must only be called once the cancellation has taken effect (e.g. the task
+// record was marked as cancelled). The task is remembered and skipped when it is dequeued; if it
+// has already been dequeued by this worker in the meantime, its run is cancelled instead.
+func (w *Worker) CancelQueued(taskID string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if cancel, ok := w.running[taskID]; ok {
+		cancel(ErrTaskCancelled)
+		return
+	}
+
+	now := time.Now()
+	for id, at := range w.cancelled {
+		if now.Sub(at) > cancelledTTL {
+			delete(w.cancelled, id)
+		}
+	}
+	w.cancelled[taskID] = now
+}
+
+// InFlight returns the IDs of the tasks currently being processed.
+func (w *Worker) InFlight() []string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	ids := make([]string, 0, len(w.inFlight))
+	for _, taskID := range w.inFlight {
+		ids = append(ids, taskID)
+	}
+	return ids
+}
+
+// Stop gracefully stops the worker. No new tasks are dequeued, and in-flight tasks are given
+// up to ShutdownTimeout to finish. It returns false if the deadline passed first; the contexts of
+// the remaining tasks are then cancelled and given a short grace period to unwind. They are released
+// instead of acknowledged, so a durable queue hands them out again.
+func (w *Worker) Stop() bool {
 	log.Println("正在發送停止信號給 Worker...")
 
-	// Signal the run loop to stop trying to dequeue more tasks.
+	// Signal the run loops to stop trying to dequeue more tasks.
 	close(w.stop)
 
 	// Cancel any blocking operations (like Dequeue).
@@ -91,7 +392,35 @@ func (w *Worker) Stop() {
 		w.cancelFunc()
 	}
 
-	// Wait for the run goroutine to finish.
-	w.wg.Wait()
-	log.Println("Worker 服務已優雅停止")
+	// Wait for the run goroutines to finish.
+	done := make(chan struct{})
+	go func() {
+		w.wg.Wait()
+		close(done)
+	}()
+
+	var timeout <-chan time.Time
+	if w.ShutdownTimeout > 0 {
+		timer := time.NewTimer(w.ShutdownTimeout)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
+	select {
+	case <-done:
+		log.Println("Worker 服務已優雅停止")
+		return true
+	case <-timeout:
+		log.Printf("警告：等待 Worker 超過 %s，中止仍未完成的任務: %v", w.ShutdownTimeout, w.InFlight())
+		w.abortTasks(ErrShuttingDown)
+	}
+
+	grace := time.NewTimer(w.abortGrace)
+	defer grace.Stop()
+	select {
+	case <-done:
+	case <-grace.C:
+		log.Printf("警告：中止後 %s 內仍有任務未結束: %v", w.abortGrace, w.InFlight())
+	}
+	return false
 }
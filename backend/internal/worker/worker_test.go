@@ -0,0 +1,378 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"report-scheduler/backend/internal/config"
+	"report-scheduler/backend/internal/models"
+	"report-scheduler/backend/internal/queue"
+	"report-scheduler/backend/internal/store"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// attemptRecorder 記錄每次 ProcessFunc 被呼叫時任務的 Attempt 值
+type attemptRecorder struct {
+	mu       sync.Mutex
+	attempts []int
+}
+
+func (r *attemptRecorder) record(task *queue.Task) int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.attempts = append(r.attempts, task.Attempt)
+	return len(r.attempts)
+}
+
+func (r *attemptRecorder) get() []int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]int(nil), r.attempts...)
+}
+
+func TestWorker_RetriesFailedTasks(t *testing.T) {
+	t.Run("retries until success", func(t *testing.T) {
+		q := queue.NewInMemoryQueue(10)
+		defer q.Close()
+
+		rec := &attemptRecorder{}
+		w := NewWorker(q, func(ctx context.Context, task *queue.Task) error {
+			if rec.record(task) < 3 {
+				return errors.New("暫時性錯誤")
+			}
+			return nil
+		})
+		w.Start()
+		defer w.Stop()
+
+		require.NoError(t, q.Enqueue(t.Context(), &queue.Task{ID: "t-1", MaxRetries: 3, RetryDelay: 10 * time.Millisecond}))
+		require.Eventually(t, func() bool { return len(rec.get()) == 3 }, time.Second, 5*time.Millisecond)
+		time.Sleep(50 * time.Millisecond)
+		require.Equal(t, []int{0, 1, 2}, rec.get(), "成功後不應再重試")
+	})
+
+	t.Run("stops after max retries", func(t *testing.T) {
+		q := queue.NewInMemoryQueue(10)
+		defer q.Close()
+
+		rec := &attemptRecorder{}
+		w := NewWorker(q, func(ctx context.Context, task *queue.Task) error {
+			rec.record(task)
+			return errors.New("永久失敗")
+		})
+		w.Start()
+		defer w.Stop()
+
+		require.NoError(t, q.Enqueue(t.Context(), &queue.Task{ID: "t-2", MaxRetries: 2, RetryDelay: 5 * time.Millisecond}))
+		require.Eventually(t, func() bool { return len(rec.get()) == 3 }, time.Second, 5*time.Millisecond)
+		time.Sleep(50 * time.Millisecond)
+		require.Equal(t, []int{0, 1, 2}, rec.get())
+	})
+
+	t.Run("ErrNoRetry is not retried", func(t *testing.T) {
+		q := queue.NewInMemoryQueue(10)
+		defer q.Close()
+
+		rec := &attemptRecorder{}
+		w := NewWorker(q, func(ctx context.Context, task *queue.Task) error {
+			rec.record(task)
+			return fmt.Errorf("%w: 排程已刪除", ErrNoRetry)
+		})
+		w.Start()
+		defer w.Stop()
+
+		require.NoError(t, q.Enqueue(t.Context(), &queue.Task{ID: "t-3", MaxRetries: 3, RetryDelay: time.Millisecond}))
+		require.Eventually(t, func() bool { return len(rec.get()) == 1 }, time.Second, 5*time.Millisecond)
+		time.Sleep(50 * time.Millisecond)
+		require.Equal(t, []int{0}, rec.get())
+	})
+}
+
+func TestWorkerPool(t *testing.T) {
+	t.Run("processes tasks concurrently", func(t *testing.T) {
+		q := queue.NewInMemoryQueue(10)
+		defer q.Close()
+
+		var running, maxRunning atomic.Int32
+		release := make(chan struct{})
+		w := NewWorkerPool(q, func(ctx context.Context, task *queue.Task) error {
+			n := running.Add(1)
+			defer running.Add(-1)
+			for {
+				m := maxRunning.Load()
+				if n <= m || maxRunning.CompareAndSwap(m, n) {
+					break
+				}
+			}
+			<-release
+			return nil
+		}, config.WorkerConfig{Concurrency: 3})
+		w.Start()
+		defer w.Stop()
+
+		for i := 0; i < 3; i++ {
+			require.NoError(t, q.Enqueue(t.Context(), &queue.Task{ID: fmt.Sprintf("t-%d", i)}))
+		}
+		require.Eventually(t, func() bool { return running.Load() == 3 }, time.Second, 5*time.Millisecond)
+		require.Len(t, w.InFlight(), 3)
+		close(release)
+		require.Eventually(t, func() bool { return running.Load() == 0 }, time.Second, 5*time.Millisecond)
+		require.Equal(t, int32(3), maxRunning.Load())
+	})
+
+	t.Run("stop waits for in-flight tasks", func(t *testing.T) {
+		q := queue.NewInMemoryQueue(10)
+		defer q.Close()
+
+		var finished atomic.Bool
+		started := make(chan struct{})
+		w := NewWorkerPool(q, func(ctx context.Context, task *queue.Task) error {
+			close(started)
+			time.Sleep(50 * time.Millisecond)
+			finished.Store(true)
+			return nil
+		}, config.WorkerConfig{Concurrency: 2, ShutdownTimeout: time.Second})
+		w.Start()
+
+		require.NoError(t, q.Enqueue(t.Context(), &queue.Task{ID: "t-slow"}))
+		<-started
+		require.True(t, w.Stop())
+		require.True(t, finished.Load(), "Stop 應該等待進行中的任務完成")
+	})
+
+	t.Run("stop gives up after the shutdown timeout", func(t *testing.T) {
+		q := queue.NewInMemoryQueue(10)
+		defer q.Close()
+
+		started := make(chan struct{})
+		block := make(chan struct{})
+		defer close(block)
+		w := NewWorkerPool(q, func(ctx context.Context, task *queue.Task) error {
+			close(started)
+			<-block
+			return nil
+		}, config.WorkerConfig{ShutdownTimeout: 20 * time.Millisecond})
+		w.abortGrace = 20 * time.Millisecond
+		w.Start()
+
+		require.NoError(t, q.Enqueue(t.Context(), &queue.Task{ID: "t-stuck"}))
+		<-started
+		startTime := time.Now()
+		require.False(t, w.Stop())
+		require.Less(t, time.Since(startTime), time.Second)
+		require.Equal(t, []string{"t-stuck"}, w.InFlight())
+	})
+}
+
+func TestDataSourceLimiter(t *testing.T) {
+	limiter := NewDataSourceLimiter(config.WorkerConfig{
+		DataSourceConcurrency: 1,
+		DataSourceLimits:      map[string]int{"ds-wide": 2, "ds-free": 0},
+	})
+
+	release, err := limiter.Acquire(t.Context(), "ds-1")
+	require.NoError(t, err)
+
+	// 同一個資料來源已達上限，第二次取得會被阻塞直到 context 逾時
+	ctx, cancel := context.WithTimeout(t.Context(), 20*time.Millisecond)
+	defer cancel()
+	_, err = limiter.Acquire(ctx, "ds-1")
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+
+	// 其他資料來源不受影響
+	releaseOther, err := limiter.Acquire(t.Context(), "ds-2")
+	require.NoError(t, err)
+	releaseOther()
+
+	release()
+	release() // 重複釋放不應影響計數
+	release, err = limiter.Acquire(t.Context(), "ds-1")
+	require.NoError(t, err)
+	release()
+
+	// 個別覆寫的上限
+	for i := 0; i < 2; i++ {
+		_, err := limiter.Acquire(t.Context(), "ds-wide")
+		require.NoError(t, err)
+	}
+	for i := 0; i < 10; i++ {
+		_, err := limiter.Acquire(t.Context(), "ds-free")
+		require.NoError(t, err)
+	}
+}
+
+func TestWorker_Cancel(t *testing.T) {
+	t.Run("cancels a running task without retrying", func(t *testing.T) {
+		q := queue.NewInMemoryQueue(10)
+		defer q.Close()
+
+		rec := &attemptRecorder{}
+		started := make(chan struct{}, 1)
+		causes := make(chan error, 1)
+		w := NewWorker(q, func(ctx context.Context, task *queue.Task) error {
+			rec.record(task)
+			started <- struct{}{}
+			<-ctx.Done()
+			causes <- context.Cause(ctx)
+			return ctx.Err()
+		})
+		w.Start()
+		defer w.Stop()
+
+		require.NoError(t, q.Enqueue(t.Context(), &queue.Task{ID: "t-running", MaxRetries: 3, RetryDelay: time.Millisecond}))
+		<-started
+		require.True(t, w.Cancel("t-running"))
+		require.ErrorIs(t, <-causes, ErrTaskCancelled)
+		require.Eventually(t, func() bool { return len(w.InFlight()) == 0 }, time.Second, 5*time.Millisecond)
+		time.Sleep(50 * time.Millisecond)
+		require.Equal(t, []int{0}, rec.get(), "取消的任務不應重試")
+	})
+
+	t.Run("drops a queued task", func(t *testing.T) {
+		q := queue.NewInMemoryQueue(10)
+		defer q.Close()
+
+		var processed sync.Map
+		w := NewWorker(q, func(ctx context.Context, task *queue.Task) error {
+			processed.Store(task.ID, true)
+			return nil
+		})
+
+		w.CancelQueued("t-queued")
+		// 只取消執行中任務的 Cancel 不會影響之後才被取出的任務
+		require.False(t, w.Cancel("t-later"))
+		require.NoError(t, q.Enqueue(t.Context(), &queue.Task{ID: "t-queued"}))
+		require.NoError(t, q.Enqueue(t.Context(), &queue.Task{ID: "t-later"}))
+		require.NoError(t, q.Enqueue(t.Context(), &queue.Task{ID: "t-next"}))
+		w.Start()
+		defer w.Stop()
+
+		require.Eventually(t, func() bool { _, ok := processed.Load("t-next"); return ok }, time.Second, 5*time.Millisecond)
+		_, ok := processed.Load("t-queued")
+		require.False(t, ok)
+		_, ok = processed.Load("t-later")
+		require.True(t, ok)
+	})
+
+	t.Run("run deadline", func(t *testing.T) {
+		q := queue.NewInMemoryQueue(10)
+		defer q.Close()
+
+		errs := make(chan error, 1)
+		w := NewWorkerPool(q, func(ctx context.Context, task *queue.Task) error {
+			<-ctx.Done()
+			errs <- ctx.Err()
+			return ctx.Err()
+		}, config.WorkerConfig{TaskTimeout: 20 * time.Millisecond})
+		w.Start()
+		defer w.Stop()
+
+		require.NoError(t, q.Enqueue(t.Context(), &queue.Task{ID: "t-slow"}))
+		select {
+		case err := <-errs:
+			require.ErrorIs(t, err, context.DeadlineExceeded)
+		case <-time.After(time.Second):
+			t.Fatal("任務沒有在期限到達時被中止")
+		}
+	})
+
+	t.Run("stop aborts tasks after the shutdown timeout", func(t *testing.T) {
+		q := queue.NewInMemoryQueue(10)
+		defer q.Close()
+
+		started := make(chan struct{})
+		causes := make(chan error, 1)
+		var unwound atomic.Bool
+		w := NewWorkerPool(q, func(ctx context.Context, task *queue.Task) error {
+			close(started)
+			<-ctx.Done()
+			causes <- context.Cause(ctx)
+			// 模擬中止後仍需要一點時間寫入結果
+			time.Sleep(50 * time.Millisecond)
+			unwound.Store(true)
+			return ctx.Err()
+		}, config.WorkerConfig{ShutdownTimeout: 20 * time.Millisecond})
+		w.Start()
+
+		require.NoError(t, q.Enqueue(t.Context(), &queue.Task{ID: "t-aborted"}))
+		<-started
+		require.False(t, w.Stop())
+		require.ErrorIs(t, <-causes, ErrShuttingDown)
+		require.True(t, unwound.Load(), "Stop 應該等待被中止的任務結束後才返回")
+		require.Empty(t, w.InFlight())
+	})
+
+	t.Run("aborted task is released to a durable queue", func(t *testing.T) {
+		dbPath := t.TempDir() + "/queue.db"
+		q, err := queue.NewSqliteQueue(dbPath, config.QueueConfig{VisibilityTimeout: time.Hour, PollInterval: 10 * time.Millisecond, InstanceID: "stopping"})
+		require.NoError(t, err)
+		defer q.Close()
+
+		started := make(chan struct{})
+		w := NewWorkerPool(q, func(ctx context.Context, task *queue.Task) error {
+			close(started)
+			<-ctx.Done()
+			return ctx.Err()
+		}, config.WorkerConfig{ShutdownTimeout: 20 * time.Millisecond})
+		w.Start()
+
+		require.NoError(t, q.Enqueue(t.Context(), &queue.Task{ID: "t-released"}))
+		<-started
+		require.False(t, w.Stop())
+
+		// 另一個程序不必等到租約到期就能取得被中止的任務
+		other, err := queue.NewSqliteQueue(dbPath, config.QueueConfig{VisibilityTimeout: time.Hour, PollInterval: 10 * time.Millisecond, InstanceID: "other"})
+		require.NoError(t, err)
+		defer other.Close()
+		ctx, cancel := context.WithTimeout(t.Context(), 100*time.Millisecond)
+		defer cancel()
+		task, err := other.Dequeue(ctx)
+		require.NoError(t, err)
+		require.Equal(t, "t-released", task.ID)
+	})
+}
+
+func TestWorker_TracksTaskLifecycle(t *testing.T) {
+	dbStore, err := store.NewStore(config.Config{Database: config.DBConfig{Type: "sqlite", Path: t.TempDir() + "/test.db"}})
+	require.NoError(t, err)
+	defer dbStore.Close()
+	q := queue.NewInMemoryQueue(10)
+	defer q.Close()
+
+	rec := &attemptRecorder{}
+	w := NewWorker(q, func(ctx context.Context, task *queue.Task) error {
+		rec.record(task)
+		return errors.New("暫時性錯誤")
+	})
+	w.Store = dbStore
+	w.Name = "test-host"
+	w.Start()
+	defer w.Stop()
+
+	// 沒有經過 Scheduler.Dispatch 推入的任務也會建立紀錄
+	require.NoError(t, q.Enqueue(t.Context(), &queue.Task{ID: "t-tracked", ScheduleID: "s-1", MaxRetries: 1, RetryDelay: 100 * time.Millisecond, CreatedAt: time.Now()}))
+
+	// 第一次失敗後回到 queued 等待重試
+	require.Eventually(t, func() bool {
+		record, err := dbStore.GetTaskByID(t.Context(), "t-tracked")
+		return err == nil && record != nil && record.Status == models.TaskStatusQueued && record.Attempt == 1
+	}, time.Second, 2*time.Millisecond)
+
+	var record *models.Task
+	require.Eventually(t, func() bool {
+		record, err = dbStore.GetTaskByID(t.Context(), "t-tracked")
+		return err == nil && record.Status == models.TaskStatusFailed
+	}, time.Second, 5*time.Millisecond)
+	require.Equal(t, []int{0, 1}, rec.get())
+	require.Equal(t, 1, record.Attempt)
+	require.Equal(t, "test-host#1", record.WorkerID)
+	require.Equal(t, "暫時性錯誤", record.ErrorMessage)
+	require.Equal(t, "s-1", record.ScheduleID)
+	require.NotNil(t, record.StartedAt)
+	require.NotNil(t, record.FinishedAt)
+}
@@ -17,13 +17,33 @@ export interface DataSource {
     updated_at: string;
 }
 
+export type ElementType = 'dashboard' | 'visualization' | 'lens' | 'saved_search' | 'panel';
+
 // 對應後端的 models.AvailableElement
 export interface AvailableElement {
     id: string;
-    type: 'dashboard' | 'visualization' | 'saved_search';
+    type: ElementType;
     title: string;
 }
 
+// 搜尋可用元素的條件，對應後端 /elements 的查詢參數
+export interface ElementQuery {
+    q?: string;
+    type?: ElementType[];
+    space?: string; // Kibana space
+    page?: number;
+    per_page?: number; // 最多 100
+    refresh?: boolean; // 略過後端的快取
+}
+
+// 對應後端的 discovery.Page
+export interface ElementPage {
+    elements: AvailableElement[];
+    total: number;
+    page: number;
+    per_page: number;
+}
+
 // 獲取所有資料來源
 export const getDataSources = (): Promise<DataSource[]> => {
     return apiClient.get('/datasources');
@@ -88,7 +108,10 @@ export const validateDataSource = (id: string): Promise<{ status: string; messag
     return apiClient.post(`/datasources/${id}/validate`);
 };
 
-// 獲取指定資料來源下的可用元素
-export const getDataSourceElements = (dataSourceId: string): Promise<AvailableElement[]> => {
-    return apiClient.get(`/datasources/${dataSourceId}/elements`);
+// 從指定資料來源即時搜尋可用元素，結果會分頁回傳
+export const getDataSourceElements = (dataSourceId: string, query: ElementQuery = {}): Promise<ElementPage> => {
+    const { type, ...rest } = query;
+    return apiClient.get(`/datasources/${dataSourceId}/elements`, {
+        params: { ...rest, type: type && type.length > 0 ? type.join(',') : undefined },
+    });
 };
@@ -2,17 +2,42 @@ import apiClient from './client';
 import { MOCK_ENABLED } from './mockConfig';
 import { mockHistoryLogs } from './mockData';
 
+// 對應後端的 models.ReportArtifact，一份報表可能產生多個檔案
+export interface ReportArtifact {
+  key: string;
+  mime_type: string;
+  size: number; // bytes
+  link?: string; // 有期限的簽章下載連結
+}
+
+// 對應後端的 models.ReportResult，記錄一次執行中單一報表的結果
+export interface ReportResult {
+  id: string;
+  history_log_id: string;
+  report_id: string;
+  report_name: string;
+  status: 'success' | 'failed';
+  error_message?: string;
+  duration_ms: number;
+  time_from?: string; // ISO 8601 date string
+  time_to?: string;
+  artifacts: ReportArtifact[];
+}
+
 // 根據 specs.md 和後端 models 定義 HistoryLog 的 TypeScript 型別
 export interface HistoryLog {
   id: string;
   schedule_id: string;
   schedule_name: string;
   trigger_time: string; // ISO 8601 date string
+  reference_time?: string; // 解析報表時間範圍時使用的時間基準
   execution_duration_ms: number;
-  status: 'success' | 'error' | 'retrying';
+  status: 'success' | 'error' | 'failed' | 'retrying' | 'partial';
   error_message?: string;
   recipients: string; // JSON string
   report_url?: string;
+  report_links?: string[]; // 有期限的簽章下載連結
+  reports?: ReportResult[]; // 每份報表的結果
   key?: string; // antd table 需要的 key
 }
 
@@ -4,7 +4,7 @@ import { mockReportDefinitions } from './mockData';
 // 對應後端的 models.ReportElement
 export interface ReportElement {
     id: string;
-    type: 'dashboard' | 'visualization' | 'saved_search';
+    type: 'dashboard' | 'visualization' | 'lens' | 'saved_search' | 'panel';
     title: string;
     order: number;
 }
@@ -15,6 +15,7 @@ export interface ReportDefinition {
     name: string;
     description?: string;
     datasource_id: string;
+    space?: string; // Kibana space
     time_range: string;
     elements: ReportElement[];
     created_at: string;
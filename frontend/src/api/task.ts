@@ -0,0 +1,75 @@
+import apiClient from './client';
+import { MOCK_ENABLED } from './mockConfig';
+
+export type TaskStatus = 'queued' | 'running' | 'succeeded' | 'failed' | 'cancelled';
+
+// 對應後端的 models.Task
+export interface Task {
+  id: string;
+  schedule_id: string;
+  schedule_name: string;
+  status: TaskStatus;
+  attempt: number;
+  worker_id?: string;
+  error_message?: string;
+  queued_at: string; // ISO 8601 date string
+  started_at?: string;
+  finished_at?: string;
+  updated_at: string;
+}
+
+export interface TaskQuery {
+  schedule_id?: string;
+  status?: TaskStatus;
+  limit?: number;
+}
+
+// 任務是否已經結束，不會再改變狀態
+export const isTaskFinished = (task: Task): boolean =>
+  task.status === 'succeeded' || task.status === 'failed' || task.status === 'cancelled';
+
+// 獲取任務列表，依推入佇列的時間由新到舊排序
+export const getTasks = (query: TaskQuery = {}): Promise<Task[]> => {
+  if (import.meta.env.DEV && MOCK_ENABLED) {
+    console.log('%c MOCKING API: getTasks', 'color: #00b300', query);
+    return new Promise(resolve => setTimeout(() => resolve([]), 300));
+  }
+  return apiClient.get('/tasks', { params: query });
+};
+
+// 根據 ID 獲取單一任務的狀態
+export const getTask = (id: string): Promise<Task> => {
+  if (import.meta.env.DEV && MOCK_ENABLED) {
+    console.log(`%c MOCKING API: getTask (id: ${id})`, 'color: #00b300');
+    const now = new Date().toISOString();
+    return new Promise(resolve => setTimeout(() => resolve({
+      id, schedule_id: '', schedule_name: '', status: 'succeeded', attempt: 0, queued_at: now, finished_at: now, updated_at: now,
+    }), 300));
+  }
+  return apiClient.get(`/tasks/${id}`);
+};
+
+// 取消尚在佇列中或執行中的任務
+export const cancelTask = (id: string): Promise<{ task_id: string; status: string }> => {
+  if (import.meta.env.DEV && MOCK_ENABLED) {
+    console.log(`%c MOCKING API: cancelTask (id: ${id})`, 'color: #00b300');
+    return new Promise(resolve => setTimeout(() => resolve({ task_id: id, status: 'cancelled' }), 300));
+  }
+  return apiClient.post(`/tasks/${id}/cancel`);
+};
+
+/**
+ * 輪詢任務直到結束
+ * @param id - 任務 ID
+ * @param intervalMs - 輪詢間隔 (毫秒)
+ * @returns A promise that resolves to the finished task.
+ */
+export const waitForTask = async (id: string, intervalMs = 2000): Promise<Task> => {
+  for (;;) {
+    const task = await getTask(id);
+    if (isTaskFinished(task)) {
+      return task;
+    }
+    await new Promise(resolve => setTimeout(resolve, intervalMs));
+  }
+};
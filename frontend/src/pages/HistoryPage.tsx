@@ -2,10 +2,63 @@ import React, { useState, useEffect, useCallback } from 'react';
 import { Table, Tag, Space, Button, Modal, message, Descriptions, Spin, Alert, Typography } from 'antd';
 import { useParams, Link } from 'react-router-dom';
 import { getHistoryByScheduleId, resendHistory } from '../api/history';
-import type { HistoryLog } from '../api/history';
+import type { HistoryLog, ReportResult, ReportArtifact } from '../api/history';
 
 const { Title } = Typography;
 
+// 部分成功或等待重試的紀錄以警告色顯示
+const statusColor = (status: string) => {
+    switch (status) {
+        case 'success':
+            return 'success';
+        case 'partial':
+        case 'retrying':
+            return 'warning';
+        default:
+            return 'error';
+    }
+};
+
+const formatSize = (bytes: number) => {
+    if (bytes < 1024) return `${bytes} B`;
+    if (bytes < 1024 * 1024) return `${(bytes / 1024).toFixed(1)} KB`;
+    return `${(bytes / 1024 / 1024).toFixed(1)} MB`;
+};
+
+const reportColumns = [
+    { title: '報表', dataIndex: 'report_name', key: 'report_name', render: (name: string, result: ReportResult) => name || result.report_id },
+    {
+        title: '狀態',
+        dataIndex: 'status',
+        key: 'status',
+        render: (status: string, result: ReportResult) => (
+            <>
+                <Tag color={statusColor(status)}>{status.toUpperCase()}</Tag>
+                {result.error_message && <div>{result.error_message}</div>}
+            </>
+        ),
+    },
+    {
+        title: '時間範圍',
+        key: 'time_range',
+        render: (_: any, result: ReportResult) => result.time_from && result.time_to
+            ? `${new Date(result.time_from).toLocaleString()} ~ ${new Date(result.time_to).toLocaleString()}`
+            : '-',
+    },
+    { title: '耗時', dataIndex: 'duration_ms', key: 'duration_ms', render: (ms: number) => `${(ms / 1000).toFixed(2)}s` },
+    {
+        title: '檔案',
+        dataIndex: 'artifacts',
+        key: 'artifacts',
+        render: (artifacts: ReportArtifact[]) => artifacts.length === 0 ? '-' : artifacts.map(artifact => (
+            <div key={artifact.key}>
+                <a href={artifact.link} target="_blank" rel="noreferrer">{artifact.key.split('/').pop()}</a>
+                {` (${artifact.mime_type}, ${formatSize(artifact.size)})`}
+            </div>
+        )),
+    },
+];
+
 const HistoryPage: React.FC = () => {
     const { scheduleId } = useParams<{ scheduleId: string }>();
     const [historyData, setHistoryData] = useState<HistoryLog[]>([]);
@@ -74,10 +127,7 @@ const HistoryPage: React.FC = () => {
             title: '狀態',
             dataIndex: 'status',
             key: 'status',
-            render: (status: string) => {
-                let color = status === 'success' ? 'success' : 'error';
-                return <Tag color={color}>{status.toUpperCase()}</Tag>;
-            }
+            render: (status: string) => <Tag color={statusColor(status)}>{status.toUpperCase()}</Tag>
         },
         {
             title: '操作',
@@ -116,6 +166,7 @@ const HistoryPage: React.FC = () => {
             <Table columns={historyColumns} dataSource={historyData} rowKey="id" />
             <Modal
                 title="執行紀錄詳情"
+                width={900}
                 open={isDetailModalVisible}
                 onCancel={handleDetailModalClose}
                 footer={[
@@ -130,16 +181,25 @@ const HistoryPage: React.FC = () => {
                         <Descriptions.Item label="觸發時間">{new Date(selectedRecord.trigger_time).toLocaleString()}</Descriptions.Item>
                         <Descriptions.Item label="執行耗時">{`${(selectedRecord.execution_duration_ms / 1000).toFixed(2)}s`}</Descriptions.Item>
                         <Descriptions.Item label="狀態">
-                            <Tag color={selectedRecord.status === 'success' ? 'success' : 'error'}>
+                            <Tag color={statusColor(selectedRecord.status)}>
                                 {selectedRecord.status.toUpperCase()}
                             </Tag>
                         </Descriptions.Item>
                         <Descriptions.Item label="收件者">{selectedRecord.recipients}</Descriptions.Item>
-                        {selectedRecord.status === 'error' && (
+                        {selectedRecord.error_message && (
                              <Descriptions.Item label="錯誤訊息">{selectedRecord.error_message}</Descriptions.Item>
                         )}
-                        {selectedRecord.report_url && (
-                             <Descriptions.Item label="報表連結"><a>{selectedRecord.report_url}</a></Descriptions.Item>
+                        {selectedRecord.report_links && selectedRecord.report_links.length > 0 && (
+                             <Descriptions.Item label="報表連結">
+                                {selectedRecord.report_links.map((link, index) => (
+                                    <div key={link}><a href={link} target="_blank" rel="noreferrer">{selectedRecord.report_url?.split(', ')[index] ?? link}</a></div>
+                                ))}
+                             </Descriptions.Item>
+                        )}
+                        {selectedRecord.reports && selectedRecord.reports.length > 0 && (
+                             <Descriptions.Item label="報表結果">
+                                <Table columns={reportColumns} dataSource={selectedRecord.reports} rowKey="id" pagination={false} size="small" />
+                             </Descriptions.Item>
                         )}
                     </Descriptions>
                 )}
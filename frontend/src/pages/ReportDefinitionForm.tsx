@@ -8,11 +8,28 @@ import update from 'immutability-helper';
 import { getReportDefinitionById, createReportDefinition, updateReportDefinition } from '../api/report';
 import type { ReportElement } from '../api/report';
 import { getDataSources, getDataSourceElements } from '../api/dataSource';
-import type { DataSource, AvailableElement } from '../api/dataSource';
+import type { DataSource, AvailableElement, ElementType } from '../api/dataSource';
 
 const { Title } = Typography;
 const { Option } = Select;
 
+// 一次最多載入的可選項目數量，超過時請使用者輸入關鍵字縮小範圍
+const ELEMENTS_PER_PAGE = 100;
+
+// 各資料來源可以加入報表的元素類型
+const elementTypeOptions: Record<DataSource['type'], { value: ElementType; label: string }[]> = {
+    kibana: [
+        { value: 'dashboard', label: '儀表板' },
+        { value: 'visualization', label: '視覺化' },
+        { value: 'lens', label: 'Lens' },
+        { value: 'saved_search', label: '已儲存的搜尋' },
+    ],
+    grafana: [
+        { value: 'dashboard', label: '儀表板' },
+        { value: 'panel', label: '面板' },
+    ],
+};
+
 // Draggable Item Component
 const type = 'DraggableItem';
 interface DraggableItemProps {
@@ -56,13 +73,20 @@ const ReportDefinitionForm: React.FC = () => {
 
     const [dataSources, setDataSources] = useState<DataSource[]>([]);
     const [availableElements, setAvailableElements] = useState<AvailableElement[]>([]);
+    const [elementsTotal, setElementsTotal] = useState(0);
     const [elementsLoading, setElementsLoading] = useState(false);
+    const [elementSearch, setElementSearch] = useState('');
+    const [elementTypes, setElementTypes] = useState<ElementType[]>([]);
     const [targetKeys, setTargetKeys] = useState<string[]>([]);
+    // 已選項目可能不在目前的搜尋結果中，另外保留它們的資訊
+    const [selectedElements, setSelectedElements] = useState<Record<string, AvailableElement>>({});
 
     const [loading, setLoading] = useState(false);
     const [isSaving, setIsSaving] = useState(false);
 
     const selectedDataSourceId = Form.useWatch('datasource_id', form);
+    const selectedSpace = Form.useWatch('space', form);
+    const selectedDataSource = dataSources.find(ds => ds.id === selectedDataSourceId);
 
     // Fetch Data Sources
     useEffect(() => {
@@ -101,6 +125,7 @@ const ReportDefinitionForm: React.FC = () => {
                 }
 
                 setTargetKeys(data.elements.map(el => el.id));
+                setSelectedElements(Object.fromEntries(data.elements.map(el => [el.id, { id: el.id, type: el.type, title: el.title }])));
                 setLoading(false);
             }).catch(error => {
                 console.error("Failed to fetch report definition", error);
@@ -109,22 +134,47 @@ const ReportDefinitionForm: React.FC = () => {
         }
     }, [id, form]);
 
-    // Fetch available elements when data source changes
-    useEffect(() => {
-        if (selectedDataSourceId) {
-            setElementsLoading(true);
-            getDataSourceElements(selectedDataSourceId).then(elements => {
-                setAvailableElements(elements || []);
-            }).catch(() => {
-                message.error('無法獲取可選項目列表');
-                setAvailableElements([]);
-            }).finally(() => {
-                setElementsLoading(false);
-            });
-        } else {
+    // 資料來源或搜尋條件改變時，重新搜尋可用元素
+    const fetchElements = useCallback((refresh = false) => {
+        if (!selectedDataSourceId) {
             setAvailableElements([]);
+            setElementsTotal(0);
+            return;
         }
-    }, [selectedDataSourceId]);
+        setElementsLoading(true);
+        getDataSourceElements(selectedDataSourceId, {
+            q: elementSearch || undefined,
+            type: elementTypes,
+            space: selectedSpace || undefined,
+            per_page: ELEMENTS_PER_PAGE,
+            refresh: refresh || undefined,
+        }).then(page => {
+            setAvailableElements(page?.elements || []);
+            setElementsTotal(page?.total || 0);
+        }).catch(() => {
+            message.error('無法獲取可選項目列表');
+            setAvailableElements([]);
+            setElementsTotal(0);
+        }).finally(() => {
+            setElementsLoading(false);
+        });
+    }, [selectedDataSourceId, elementSearch, elementTypes, selectedSpace]);
+
+    useEffect(() => {
+        fetchElements();
+    }, [fetchElements]);
+
+    const findElement = (key: string) => selectedElements[key] || availableElements.find(el => el.id === key);
+
+    const onTransferChange = (newTargetKeys: string[]) => {
+        const next: Record<string, AvailableElement> = {};
+        newTargetKeys.forEach(key => {
+            const item = findElement(key);
+            if (item) next[key] = item;
+        });
+        setSelectedElements(next);
+        setTargetKeys(newTargetKeys);
+    };
 
     const onSave = async () => {
         try {
@@ -132,7 +182,7 @@ const ReportDefinitionForm: React.FC = () => {
             setIsSaving(true);
 
             const elements: ReportElement[] = targetKeys.map((key, index) => {
-                const item = availableElements.find(el => el.id === key);
+                const item = findElement(key);
                 return {
                     id: key,
                     type: item?.type || 'dashboard',
@@ -168,7 +218,11 @@ const ReportDefinitionForm: React.FC = () => {
         );
     }, [targetKeys]);
 
-    const transferDataSource = availableElements.map(el => ({ ...el, key: el.id }));
+    // 已選但不在目前搜尋結果中的項目也要放進 Transfer，右側清單才會顯示它們
+    const transferDataSource = [
+        ...availableElements,
+        ...Object.values(selectedElements).filter(el => !availableElements.some(item => item.id === el.id)),
+    ].map(el => ({ ...el, key: el.id }));
 
     return (
         <DndProvider backend={HTML5Backend}>
@@ -179,7 +233,7 @@ const ReportDefinitionForm: React.FC = () => {
                     <Input placeholder="例如：每日網站流量分析報表" />
                 </Form.Item>
                 <Form.Item label="選擇資料來源" name="datasource_id" rules={[{ required: true, message: '請選擇一個資料來源' }]}>
-                    <Select placeholder="選擇一個已驗證的資料來源" disabled={!!id}>
+                    <Select placeholder="選擇一個已驗證的資料來源" disabled={!!id} onChange={() => setElementTypes([])}>
                         {dataSources.map(ds => (
                             <Option key={ds.id} value={ds.id} disabled={ds.status !== 'verified'}>
                                 {ds.name} ({ds.type.toUpperCase()})
@@ -187,6 +241,11 @@ const ReportDefinitionForm: React.FC = () => {
                         ))}
                     </Select>
                 </Form.Item>
+                {selectedDataSource?.type === 'kibana' && (
+                    <Form.Item label="Kibana Space" name="space" tooltip="留空表示 default space">
+                        <Input placeholder="default" />
+                    </Form.Item>
+                )}
                 <Form.Item label="時間範圍">
                     <Space.Compact>
                         <Form.Item name={['time_range_quick']} noStyle>
@@ -224,11 +283,34 @@ const ReportDefinitionForm: React.FC = () => {
                 <Divider />
                 <Title level={4}>挑選報表元素</Title>
                 <p>請從左側選擇您想包含在此報表中的儀表板或圖表，並可拖曳右側項目進行排序。</p>
+                <Space style={{ marginBottom: 16 }} wrap>
+                    <Input.Search
+                        placeholder="依標題搜尋"
+                        allowClear
+                        style={{ width: 300 }}
+                        onSearch={value => setElementSearch(value.trim())}
+                    />
+                    <Select
+                        mode="multiple"
+                        allowClear
+                        placeholder="所有類型"
+                        style={{ minWidth: 240 }}
+                        value={elementTypes}
+                        onChange={(values: ElementType[]) => setElementTypes(values)}
+                        options={selectedDataSource ? elementTypeOptions[selectedDataSource.type] : []}
+                    />
+                    <Button onClick={() => fetchElements(true)} disabled={!selectedDataSourceId}>重新整理</Button>
+                    {elementsTotal > availableElements.length && (
+                        <Typography.Text type="secondary">
+                            共 {elementsTotal} 筆，僅顯示前 {availableElements.length} 筆，請輸入關鍵字縮小範圍
+                        </Typography.Text>
+                    )}
+                </Space>
                 <Transfer
                     dataSource={transferDataSource}
                     titles={['可選項目', '已選項目']}
                     targetKeys={targetKeys}
-                    onChange={(newTargetKeys) => setTargetKeys(newTargetKeys as string[])}
+                    onChange={(newTargetKeys) => onTransferChange(newTargetKeys as string[])}
                     render={item => item.title}
                     listStyle={{ width: '100%', height: 300 }}
                 >
@@ -249,7 +331,7 @@ const ReportDefinitionForm: React.FC = () => {
                         return (
                             <div style={{ height: '100%', overflow: 'auto' }}>
                                 {targetKeys.map((key, index) => {
-                                    const item = availableElements.find(i => i.id === key);
+                                    const item = findElement(key);
                                     return item ? <DraggableItem key={key} index={index} id={key} text={item.title} moveItem={moveItem} /> : null;
                                 })}
                             </div>
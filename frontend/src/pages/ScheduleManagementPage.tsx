@@ -3,6 +3,7 @@ import { Button, Modal, Form, Input, Switch, message, Table, Space, Select, Typo
 import { useNavigate } from 'react-router-dom';
 import { getSchedules, createSchedule, updateSchedule, deleteSchedule, triggerSchedule } from '../api/schedule';
 import type { Schedule } from '../api/schedule';
+import { waitForTask } from '../api/task';
 import { getReportDefinitions } from '../api/report';
 import type { ReportDefinition } from '../api/report';
 
@@ -95,7 +96,13 @@ const ScheduleManagementPage: React.FC = () => {
         message.loading({ content: `正在觸發排程 "${record.name}"...`, key: record.id });
         try {
             const result = await triggerSchedule(record.id);
-            message.success({ content: `${result.message} (Task ID: ${result.task_id})`, key: record.id, duration: 3 });
+            message.loading({ content: `${result.message}，等待任務完成... (Task ID: ${result.task_id})`, key: record.id, duration: 0 });
+            const task = await waitForTask(result.task_id);
+            if (task.status === 'succeeded') {
+                message.success({ content: `排程 "${record.name}" 執行完成`, key: record.id, duration: 3 });
+            } else {
+                message.error({ content: `排程 "${record.name}" 執行${task.status === 'cancelled' ? '已取消' : '失敗'}${task.error_message ? `：${task.error_message}` : ''}`, key: record.id, duration: 5 });
+            }
         } catch {
             message.error({ content: `觸發失敗`, key: record.id, duration: 2 });
         }